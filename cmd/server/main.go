@@ -2,14 +2,11 @@ package main
 
 import (
 	"context"
-	"net/http"
 	"os"
 	"os/signal"
-	"strings"
 	"syscall"
 	"time"
 
-	"github.com/golang-jwt/jwt/v5"
 	//"github.com/jackc/pgx/v5/pgxpool"
 	"github.com/labstack/echo/v4"
 	"github.com/labstack/echo/v4/middleware"
@@ -19,44 +16,13 @@ import (
 
 	"github.com/SrabanMondal/SecureStore/internal/config"
 	"github.com/SrabanMondal/SecureStore/internal/handler"
+	"github.com/SrabanMondal/SecureStore/internal/models"
 	"github.com/SrabanMondal/SecureStore/internal/repository"
 	"github.com/SrabanMondal/SecureStore/internal/services"
+	"github.com/SrabanMondal/SecureStore/internal/storage"
 	"github.com/SrabanMondal/SecureStore/internal/utils"
 )
 
-func JWTMiddleware(secret string) echo.MiddlewareFunc {
-	return func(next echo.HandlerFunc) echo.HandlerFunc {
-		return func(c echo.Context) error {
-			authHeader := c.Request().Header.Get("Authorization")
-			if authHeader == "" {
-				return c.JSON(http.StatusUnauthorized, echo.Map{"error": "missing token"})
-			}
-
-			parts := strings.Split(authHeader, " ")
-			if len(parts) != 2 || strings.ToLower(parts[0]) != "bearer" {
-				return c.JSON(http.StatusUnauthorized, echo.Map{"error": "invalid token format"})
-			}
-
-			tokenStr := parts[1]
-			claims := jwt.MapClaims{}
-			token, err := jwt.ParseWithClaims(tokenStr, claims, func(token *jwt.Token) (interface{}, error) {
-				return []byte(secret), nil
-			})
-			if err != nil || !token.Valid {
-				return c.JSON(http.StatusUnauthorized, echo.Map{"error": "invalid or expired token"})
-			}
-
-			userID, ok := claims["user_id"].(string)
-			if !ok {
-				return c.JSON(http.StatusUnauthorized, echo.Map{"error": "invalid token payload"})
-			}
-
-			c.Set("userID", userID)
-			return next(c)
-		}
-	}
-}
-
 func main() {
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
@@ -66,43 +32,178 @@ func main() {
 	defer cfg.DB.Close()
 
 	userRepo := repositories.NewUserRepository(cfg.DB)
-	fileRepo := repositories.NewFileRepository(cfg.DB)
+	fileRepo := repositories.NewFileRepository(cfg.DB, cfg.Names)
 	shareRepo := repositories.NewShareRepository(cfg.DB)
+	uploadRepo := repositories.NewMultipartRepository(cfg.DB)
+	tusRepo := repositories.NewTusRepository(cfg.DB, cfg.Names)
+	folderRepo := repositories.NewFolderRepository(cfg.DB, cfg.Names)
+	rotationRepo := repositories.NewKeyRotationRepository(cfg.DB)
+	tokenRepo := repositories.NewTokenRepository(cfg.DB)
 
-	authSvc := services.NewAuthService(userRepo, cfg.JWTKey, 24 * time.Hour)
-	fileSvc := services.NewFileService(fileRepo, cfg.Minio, "uploads", cfg.FileKey)
+	userKeySvc := services.NewUserKeyService(userRepo, cfg.KMS)
+	tokenSvc := services.NewTokenService(tokenRepo, userRepo, cfg.JWT,
+		time.Duration(cfg.AccessTokenTTL)*time.Minute,
+		time.Duration(cfg.RefreshTokenTTL)*24*time.Hour)
+	mfaSvc := services.NewMFAService(userRepo, cfg.KMS, cfg.MFAIssuer)
+	authSvc := services.NewAuthService(userRepo, userKeySvc, tokenSvc, mfaSvc)
+	accountSvc := services.NewAccountService(userRepo, cfg.Mailer, cfg.FrontendURL, cfg.RequireVerifiedEmail)
+	oidcSvc := services.NewOIDCService(userRepo, authSvc, cfg.OIDC)
+	fileSvc := services.NewFileService(fileRepo, uploadRepo, cfg.Storage, cfg.KMS, userKeySvc, cfg.AllowUnboundObjects, services.RetentionPolicy{
+		KeepVersions:    cfg.VersionKeep,
+		KeepVersionsFor: time.Duration(cfg.VersionKeepDays) * 24 * time.Hour,
+		TrashFor:        time.Duration(cfg.TrashRetentionDays) * 24 * time.Hour,
+	})
 	shareSvc := services.NewShareService(shareRepo, fileRepo, fileSvc)
+	tusSvc := services.NewTusService(tusRepo, fileSvc)
+	folderSvc := services.NewFolderService(folderRepo)
+	rotationSvc := services.NewKeyRotationService(rotationRepo, fileSvc, mfaSvc)
+	adminSvc := services.NewAdminService(userRepo, fileRepo, shareRepo, authSvc)
 
-	authHandler := handlers.NewAuthHandler(authSvc)
+	authHandler := handlers.NewAuthHandler(authSvc, accountSvc)
+	userHandler := handlers.NewUserHandler(authSvc, userKeySvc)
 	fileHandler := handlers.NewFileHandler(fileSvc, fileRepo)
+	multipartHandler := handlers.NewMultipartHandler(fileSvc)
+	tusHandler := handlers.NewTusHandler(tusSvc)
 	shareHandler := handlers.NewShareHandler(shareSvc)
+	folderHandler := handlers.NewFolderHandler(folderSvc)
+	adminHandler := handlers.NewAdminHandler(rotationSvc, adminSvc)
+	tokenHandler := handlers.NewTokenHandler(tokenSvc)
+	oidcHandler := handlers.NewOIDCHandler(oidcSvc)
 
 	e := echo.New()
 	e.Use(middleware.Logger())
 	e.Use(middleware.Recover())
+	e.Use(handlers.KeyPassphrase)
 
+	e.GET("/.well-known/jwks.json", authHandler.JWKS)
 	e.POST("/api/register", authHandler.Register)
 	e.POST("/api/login", authHandler.Login)
+	e.POST("/api/login/mfa", authHandler.LoginMFA)
+	e.POST("/api/token/refresh", authHandler.Refresh)
+	e.POST("/api/password/forgot", authHandler.ForgotPassword)
+	e.POST("/api/password/reset", authHandler.ResetPassword)
+	e.POST("/api/email/verify", authHandler.VerifyEmail)
+	e.GET("/api/oidc/providers", oidcHandler.Providers)
+	e.GET("/api/oidc/:provider/login", oidcHandler.Login)
+	e.POST("/api/oidc/:provider/callback", oidcHandler.Callback)
 
 	api := e.Group("/api")
-	api.Use(JWTMiddleware(cfg.JWTKey))
+	api.Use(handlers.JWTMiddleware(tokenSvc))
 
-	api.POST("/files/presigned", fileHandler.UploadPresigned)
-	api.POST("/files/encrypted", fileHandler.UploadEncrypted)
-	api.POST("/files/:id/finalize", fileHandler.FinalizeUpload)
-	api.GET("/files/:id/download", fileHandler.Download)
-	api.DELETE("/files/:id", fileHandler.Delete)
-	api.GET("/files", fileHandler.ListFiles)
+	// personal access tokens reach only routes within their scopes; login
+	// sessions reach everything
+	session := handlers.SessionOnly
+	read := handlers.RequireScope(models.ScopeFilesRead)
+	write := handlers.RequireScope(models.ScopeFilesWrite)
+	shares := handlers.RequireScope(models.ScopeSharesManage)
 
-	api.POST("/shares", shareHandler.CreateShareLink)
-	api.DELETE("/shares/:id",shareHandler.DeleteLink)
+	api.POST("/logout", authHandler.Logout, session)
+	api.POST("/email/verify/send", authHandler.SendVerification, session)
+
+	// no-op unless REQUIRE_VERIFIED_EMAIL is set
+	verified := handlers.VerifiedEmail(accountSvc)
+
+	api.POST("/files/presigned", fileHandler.UploadPresigned, write, verified)
+	api.POST("/files/encrypted", fileHandler.UploadEncrypted, write, verified)
+	api.POST("/files/client", fileHandler.UploadClient, write, verified)
+	api.POST("/files/:id/finalize", fileHandler.FinalizeUpload, write)
+	api.GET("/files/:id/download", fileHandler.Download, read)
+	api.GET("/files/:id/versions", fileHandler.ListVersions, read)
+	api.GET("/files/:id/versions/:version/download", fileHandler.DownloadVersion, read)
+	api.POST("/files/:id/versions/:version/restore", fileHandler.RestoreVersion, write)
+	api.DELETE("/files/:id", fileHandler.Delete, write)
+	api.POST("/files/:id/restore", fileHandler.Restore, write)
+	api.GET("/files", fileHandler.ListFiles, read)
+
+	api.GET("/me/usage", fileHandler.Usage, read)
+	api.GET("/me/key", userHandler.GetKey, session)
+	api.PUT("/me/key/passphrase", userHandler.SetPassphrase, session)
+	api.DELETE("/me", userHandler.DeleteAccount, session)
+	api.GET("/me/mfa", userHandler.GetMFA, session)
+	api.POST("/me/mfa/totp", userHandler.EnrollTOTP, session)
+	api.POST("/me/mfa/totp/confirm", userHandler.ConfirmTOTP, session)
+	api.DELETE("/me/mfa", userHandler.DisableMFA, session)
+	api.POST("/oidc/:provider/reauth", oidcHandler.Reauth, session)
+	api.POST("/oidc/:provider/reauth/callback", oidcHandler.ReauthCallback, session)
+	api.GET("/me/tokens", tokenHandler.List, session)
+	api.POST("/me/tokens", tokenHandler.Create, session)
+	api.DELETE("/me/tokens/:id", tokenHandler.Revoke, session)
+
+	api.GET("/trash", fileHandler.ListTrash, read)
+	api.DELETE("/trash", fileHandler.EmptyTrash, write)
+
+	api.POST("/folders", folderHandler.Create, write)
+	api.GET("/folders/:id/children", folderHandler.Children, read)
+	api.POST("/folders/:id/rename", folderHandler.Rename, write)
+	api.POST("/folders/:id/move", folderHandler.Move, write)
+	api.DELETE("/folders/:id", folderHandler.Delete, write)
+
+	api.POST("/files/multipart", multipartHandler.Initiate, write, verified)
+	api.GET("/files/multipart/:id", multipartHandler.Status, write)
+	api.POST("/files/multipart/:id/parts", multipartHandler.PartURLs, write)
+	api.POST("/files/multipart/:id/complete", multipartHandler.Complete, write)
+	api.DELETE("/files/multipart/:id", multipartHandler.Abort, write)
+
+	e.OPTIONS("/api/tus", tusHandler.Options)
+	e.OPTIONS("/api/tus/", tusHandler.Options)
+	e.OPTIONS("/api/tus/:id", tusHandler.Options)
+	tus := api.Group("/tus", handlers.TusResumable, write)
+	tus.POST("", tusHandler.Create, verified)
+	tus.POST("/", tusHandler.Create, verified)
+	tus.HEAD("/:id", tusHandler.Head)
+	tus.PATCH("/:id", tusHandler.Patch)
+	tus.DELETE("/:id", tusHandler.Terminate)
+
+	api.POST("/shares", shareHandler.CreateShareLink, shares)
+	api.DELETE("/shares/:id",shareHandler.DeleteLink, shares)
 	e.GET("/api/shares/:token", shareHandler.AccessShareLink)        
 	e.POST("/api/shares/:token/validate", shareHandler.ValidatePassword)
 
+	// auditors may look, only admins may change anything
+	audit := api.Group("/admin", session, handlers.RequireRole(models.RoleAdmin, models.RoleAuditor))
+	audit.GET("/users", adminHandler.ListUsers)
+	audit.GET("/users/:id", adminHandler.GetUser)
+	audit.GET("/usage", adminHandler.Usage)
+	audit.GET("/shares", adminHandler.ListShares)
+	audit.GET("/keys", adminHandler.ListKeys)
+	audit.GET("/keys/rotations", adminHandler.ListRotations)
+	audit.GET("/keys/rotations/:id", adminHandler.GetRotation)
+
+	admin := api.Group("/admin", session, handlers.RequireRole(models.RoleAdmin))
+	admin.PUT("/users/:id/role", adminHandler.SetRole)
+	admin.POST("/users/:id/suspend", adminHandler.SuspendUser)
+	admin.POST("/users/:id/unsuspend", adminHandler.UnsuspendUser)
+	admin.DELETE("/users/:id", adminHandler.DeleteUser)
+	admin.DELETE("/users/:id/shares", adminHandler.RevokeUserShares)
+	admin.DELETE("/files/:id", adminHandler.DeleteFile)
+	admin.DELETE("/shares/:id", adminHandler.RevokeShare)
+	admin.POST("/keys/rotations", adminHandler.StartRotation)
+	admin.POST("/keys/rotations/:id/resume", adminHandler.ResumeRotation)
+
+	if local, ok := cfg.Storage.(*storage.LocalBackend); ok {
+		storageHandler := handlers.NewStorageHandler(local)
+		e.PUT("/api/storage/*", storageHandler.Upload)
+		e.GET("/api/storage/*", storageHandler.Download)
+	}
+
+	if err := adminSvc.GrantAdmins(ctx, cfg.AdminEmails); err != nil {
+		utils.Error.Err(err).Msg("failed to grant admin roles")
+		os.Exit(1)
+	}
+
+	// lookups only match sealed names, so existing ones are converted before
+	// the server takes requests
+	if n, err := fileRepo.SealNames(ctx); err != nil {
+		utils.Error.Err(err).Msg("failed to encrypt file names")
+		os.Exit(1)
+	} else if n > 0 {
+		utils.Info.Info().Int("users", n).Msg("encrypted file names")
+	}
+
 	utils.Info.Info().Msgf("Server running on %s", cfg.AppPort)
 	//e.Logger.Fatal(e.Start(cfg.AppPort))
 
-	startBackgroundJobs(ctx, fileSvc, shareRepo)
+	startBackgroundJobs(ctx, authSvc, accountSvc, oidcSvc, fileSvc, shareRepo, tusSvc, rotationSvc)
 
 	quit := make(chan os.Signal, 1)
 	signal.Notify(quit, os.Interrupt, syscall.SIGTERM)
@@ -125,7 +226,7 @@ func main() {
 	}
 }
 
-func startBackgroundJobs(ctx context.Context, fileSvc *services.FileService, shareRepo *repositories.ShareRepository) {
+func startBackgroundJobs(ctx context.Context, authSvc *services.AuthService, accountSvc *services.AccountService, oidcSvc *services.OIDCService, fileSvc *services.FileService, shareRepo *repositories.ShareRepository, tusSvc *services.TusService, rotationSvc *services.KeyRotationService) {
 	go func() {
 		ticker := time.NewTicker(10 * time.Minute)
 		defer ticker.Stop()
@@ -138,6 +239,21 @@ func startBackgroundJobs(ctx context.Context, fileSvc *services.FileService, sha
 				if err := fileSvc.CleanupDeletedFiles(ctx); err != nil {
 					utils.Error.Err(err).Msg("cleanup deleted files failed")
 				}
+				if err := authSvc.PurgeDeletedUsers(ctx); err != nil {
+					utils.Error.Err(err).Msg("purge deleted users failed")
+				}
+				if err := authSvc.Tokens.DeleteExpiredTokens(ctx); err != nil {
+					utils.Error.Err(err).Msg("expired token cleanup failed")
+				}
+				if err := authSvc.MFA.DeleteExpiredChallenges(ctx); err != nil {
+					utils.Error.Err(err).Msg("expired mfa challenge cleanup failed")
+				}
+				if err := accountSvc.DeleteExpiredTokens(ctx); err != nil {
+					utils.Error.Err(err).Msg("expired user token cleanup failed")
+				}
+				if err := oidcSvc.DeleteExpiredStates(ctx); err != nil {
+					utils.Error.Err(err).Msg("expired oidc state cleanup failed")
+				}
 			}
 		}
 	}()
@@ -173,4 +289,90 @@ func startBackgroundJobs(ctx context.Context, fileSvc *services.FileService, sha
 			}
 		}
 	}()
+
+	go func() {
+		ticker := time.NewTicker(time.Hour)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				utils.Info.Info().Msg("expired tus upload cleanup job stopped")
+				return
+			case <-ticker.C:
+				if err := tusSvc.ExpireUploads(ctx); err != nil {
+					utils.Error.Err(err).Msg("expired tus upload cleanup failed")
+				}
+			}
+		}
+	}()
+
+	go func() {
+		ticker := time.NewTicker(time.Hour)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				utils.Info.Info().Msg("file version pruning job stopped")
+				return
+			case <-ticker.C:
+				if err := fileSvc.PruneVersions(ctx); err != nil {
+					utils.Error.Err(err).Msg("file version pruning failed")
+				}
+			}
+		}
+	}()
+
+	go func() {
+		ticker := time.NewTicker(24 * time.Hour)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				utils.Info.Info().Msg("usage recompute job stopped")
+				return
+			case <-ticker.C:
+				if err := fileSvc.RecomputeUsage(ctx); err != nil {
+					utils.Error.Err(err).Msg("usage recompute failed")
+				}
+			}
+		}
+	}()
+
+	go func() {
+		ticker := time.NewTicker(time.Hour)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				utils.Info.Info().Msg("object binding job stopped")
+				return
+			case <-ticker.C:
+				if err := fileSvc.BindObjects(ctx); err != nil {
+					utils.Error.Err(err).Msg("object binding failed")
+				}
+				if err := fileSvc.RenameObjects(ctx); err != nil {
+					utils.Error.Err(err).Msg("object renaming failed")
+				}
+			}
+		}
+	}()
+
+	// key rotations resume from their saved cursor, so this also picks up
+	// jobs interrupted by a restart
+	go func() {
+		ticker := time.NewTicker(time.Minute)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				utils.Info.Info().Msg("key rotation job stopped")
+				return
+			case <-ticker.C:
+			case <-rotationSvc.Wake():
+			}
+			if err := rotationSvc.Run(ctx); err != nil {
+				utils.Error.Err(err).Msg("key rotation run failed")
+			}
+		}
+	}()
 }
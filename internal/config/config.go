@@ -2,10 +2,16 @@ package config
 
 import (
 	"context"
+	"encoding/base64"
 	"os"
 	"strconv"
+	"strings"
 	"time"
 
+	"github.com/SrabanMondal/SecureStore/internal/kms"
+	"github.com/SrabanMondal/SecureStore/internal/mailer"
+	"github.com/SrabanMondal/SecureStore/internal/oidc"
+	"github.com/SrabanMondal/SecureStore/internal/storage"
 	"github.com/SrabanMondal/SecureStore/internal/utils"
 
 	"github.com/jackc/pgx/v5/pgxpool"
@@ -16,10 +22,49 @@ import (
 
 type Config struct {
 	DB      *pgxpool.Pool
-	Minio   *minio.Client
+	Storage storage.Backend
 	JWTKey  string
 	AppPort string
-	FileKey []byte
+
+	// signing keys, issuer and audience of access tokens
+	JWT utils.JWTSettings
+
+	// lifetime of access tokens in minutes and of refresh tokens in days
+	AccessTokenTTL  int
+	RefreshTokenTTL int
+
+	// issuer shown in authenticator apps for TOTP enrollments
+	MFAIssuer string
+
+	// sends password reset and verification mails linking to FrontendURL
+	Mailer      mailer.Mailer
+	FrontendURL string
+	// keeps users whose email is unverified from uploading
+	RequireVerifiedEmail bool
+
+	// OpenID Connect providers users may sign in with
+	OIDC []oidc.Config
+
+	// holds the key encryption keys; new data keys are wrapped with its
+	// active one
+	KMS kms.Provider
+
+	// users given the admin role at startup, to bootstrap the admin API
+	AdminEmails []string
+
+	// lets downloads decrypt objects not yet bound to their file
+	AllowUnboundObjects bool
+
+	// encrypts file and folder names at rest when NAME_ENC_KEY is set
+	Names *utils.NameCipher
+
+	// old file versions beyond this many (counting the current one) or
+	// older than this many days are pruned; 0 disables a limit
+	VersionKeep     int
+	VersionKeepDays int
+
+	// trashed files are purged after this many days
+	TrashRetentionDays int
 }
 
 func LoadConfig(ctx context.Context) *Config {
@@ -48,27 +93,19 @@ func LoadConfig(ctx context.Context) *Config {
 		os.Exit(1)
 	}
 
-	// ========== MINIO ==========
-	minioEndpoint := os.Getenv("MINIO_ENDPOINT")
-	minioAccessKey := os.Getenv("MINIO_ACCESS_KEY")
-	minioSecretKey := os.Getenv("MINIO_SECRET_KEY")
-	minioSSL, _ := strconv.ParseBool(os.Getenv("MINIO_USE_SSL"))
-
-	minioClient, err := minio.New(minioEndpoint, &minio.Options{
-		Creds:  credentials.NewStaticV4(minioAccessKey, minioSecretKey, ""),
-		Secure: minioSSL,
-	})
-	if err != nil {
-		utils.Error.Error().Err(err).Msg("Failed to connect to MinIO")
-		os.Exit(1)
-	}
-
 	// ========== JWT ==========
 	jwtKey := os.Getenv("JWT_SECRET")
 	if jwtKey == "" {
 		utils.Error.Error().Msg("JWT_SECRET not set in env")
 		os.Exit(1)
 	}
+	jwtSettings := utils.JWTSettings{
+		Keys:     loadSigningKeys(),
+		Issuer:   envString("JWT_ISSUER", "securestore"),
+		Audience: envString("JWT_AUDIENCE", "securestore"),
+	}
+	accessTokenTTL := envInt("ACCESS_TOKEN_TTL_MINUTES", 15)
+	refreshTokenTTL := envInt("REFRESH_TOKEN_TTL_DAYS", 30)
 
 	// ========== APP PORT ==========
 	appPort := os.Getenv("APP_PORT")
@@ -76,14 +113,302 @@ func LoadConfig(ctx context.Context) *Config {
 		appPort = "8080"
 	}
 	appPort = ":"+appPort
+
+	// ========== STORAGE ==========
+	store := loadStorage(jwtKey, appPort)
+
+	// ========== VERSION RETENTION ==========
+	versionKeep := envInt("FILE_VERSIONS_KEEP", 10)
+	versionKeepDays := envInt("FILE_VERSIONS_KEEP_DAYS", 0)
+	trashRetentionDays := envInt("TRASH_RETENTION_DAYS", 30)
+
+	// ========== KMS ==========
+	keyProvider := loadKMS()
+
+	// ========== NAME ENCRYPTION ==========
+	names := loadNameCipher()
+
+	// ========== MAIL ==========
+	mail := loadMailer()
+
+	// ========== OIDC ==========
+	oidcProviders := loadOIDC()
+
 	utils.Info.Info().Msg("Config loaded successfully")
 
-	fileKey := utils.LoadKey()
 	return &Config{
 		DB:      dbpool,
-		Minio:   minioClient,
+		Storage: store,
 		JWTKey:  jwtKey,
 		AppPort: appPort,
-		FileKey: fileKey,
+
+		JWT:             jwtSettings,
+		AccessTokenTTL:  accessTokenTTL,
+		RefreshTokenTTL: refreshTokenTTL,
+		MFAIssuer:       envString("MFA_ISSUER", "SecureStore"),
+
+		Mailer:               mail,
+		FrontendURL:          envString("FRONTEND_URL", "http://localhost:3000"),
+		RequireVerifiedEmail: envBool("REQUIRE_VERIFIED_EMAIL", false),
+		OIDC:                 oidcProviders,
+
+		KMS:         keyProvider,
+		AdminEmails: envList("ADMIN_EMAILS"),
+
+		AllowUnboundObjects: envBool("ALLOW_UNBOUND_OBJECTS", true),
+		Names:               names,
+
+		VersionKeep:     versionKeep,
+		VersionKeepDays: versionKeepDays,
+
+		TrashRetentionDays: trashRetentionDays,
+	}
+}
+
+func envString(name, def string) string {
+	if v := os.Getenv(name); v != "" {
+		return v
+	}
+	return def
+}
+
+// envList splits a comma separated variable, dropping empty items.
+func envList(name string) []string {
+	var list []string
+	for _, v := range strings.Split(os.Getenv(name), ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			list = append(list, v)
+		}
+	}
+	return list
+}
+
+func envInt(name string, def int) int {
+	v := os.Getenv(name)
+	if v == "" {
+		return def
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n < 0 {
+		utils.Error.Error().Str(name, v).Msg("invalid integer in env")
+		os.Exit(1)
+	}
+	return n
+}
+
+func envBool(name string, def bool) bool {
+	v := os.Getenv(name)
+	if v == "" {
+		return def
+	}
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		utils.Error.Error().Str(name, v).Msg("invalid boolean in env")
+		os.Exit(1)
+	}
+	return b
+}
+
+func loadSigningKeys() *utils.SigningKeys {
+	if path := os.Getenv("JWT_KEYFILE"); path != "" {
+		keys, err := utils.LoadSigningKeyfile(path)
+		if err != nil {
+			utils.Error.Error().Err(err).Msg("Failed to load JWT_KEYFILE")
+			os.Exit(1)
+		}
+		return keys
+	}
+
+	utils.Warn.Warn().Msg("JWT_KEYFILE not set, signing with a temporary key; tokens won't survive a restart or verify on other instances")
+	keys, err := utils.GenerateSigningKeys()
+	if err != nil {
+		utils.Error.Error().Err(err).Msg("Failed to generate JWT signing key")
+		os.Exit(1)
+	}
+	return keys
+}
+
+func loadMailer() mailer.Mailer {
+	kind := os.Getenv("MAILER")
+	switch kind {
+	case "", "log":
+		utils.Warn.Warn().Msg("Mail is not sent, only logged; set MAILER=smtp in production")
+		m, err := mailer.NewLogMailer(os.Getenv("MAIL_DIR"))
+		if err != nil {
+			utils.Error.Error().Err(err).Msg("Failed to init MAIL_DIR")
+			os.Exit(1)
+		}
+		return m
+
+	case "smtp":
+		host := os.Getenv("SMTP_HOST")
+		from := os.Getenv("MAIL_FROM")
+		if host == "" || from == "" {
+			utils.Error.Error().Msg("SMTP_HOST and MAIL_FROM are required for the smtp mailer")
+			os.Exit(1)
+		}
+		return mailer.NewSMTPMailer(host, envInt("SMTP_PORT", 587), os.Getenv("SMTP_USERNAME"), os.Getenv("SMTP_PASSWORD"), from)
+
+	default:
+		utils.Error.Error().Str("mailer", kind).Msg("Unknown MAILER")
+		os.Exit(1)
+		return nil
+	}
+}
+
+// loadOIDC reads the providers named in OIDC_PROVIDERS, each configured by
+// OIDC_<NAME>_* variables.
+func loadOIDC() []oidc.Config {
+	var providers []oidc.Config
+	seen := map[string]bool{}
+	for _, name := range strings.Split(os.Getenv("OIDC_PROVIDERS"), ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" {
+			continue
+		}
+		if !validProviderName(name) || seen[name] {
+			utils.Error.Error().Str("provider", name).Msg("Invalid or duplicate name in OIDC_PROVIDERS")
+			os.Exit(1)
+		}
+		seen[name] = true
+
+		prefix := "OIDC_" + strings.ToUpper(strings.ReplaceAll(name, "-", "_")) + "_"
+		cfg := oidc.Config{
+			Name:          name,
+			Issuer:        os.Getenv(prefix + "ISSUER"),
+			ClientID:      os.Getenv(prefix + "CLIENT_ID"),
+			ClientSecret:  os.Getenv(prefix + "CLIENT_SECRET"),
+			RedirectURL:   os.Getenv(prefix + "REDIRECT_URL"),
+			Scopes:        strings.Fields(envString(prefix+"SCOPES", "openid email profile")),
+			AutoProvision: envBool(prefix+"AUTO_PROVISION", false),
+			LinkExisting:  envBool(prefix+"LINK_EXISTING", true),
+		}
+		for _, d := range strings.Split(os.Getenv(prefix+"ALLOWED_DOMAINS"), ",") {
+			if d = strings.ToLower(strings.TrimSpace(d)); d != "" {
+				cfg.AllowedDomains = append(cfg.AllowedDomains, d)
+			}
+		}
+		if cfg.Issuer == "" || cfg.ClientID == "" || cfg.RedirectURL == "" {
+			utils.Error.Error().Str("provider", name).Msg(prefix + "ISSUER, " + prefix + "CLIENT_ID and " + prefix + "REDIRECT_URL are required")
+			os.Exit(1)
+		}
+		providers = append(providers, cfg)
+	}
+	return providers
+}
+
+func validProviderName(name string) bool {
+	for _, c := range name {
+		if (c < 'a' || c > 'z') && (c < '0' || c > '9') && c != '-' && c != '_' {
+			return false
+		}
+	}
+	return true
+}
+
+func loadNameCipher() *utils.NameCipher {
+	keyB64 := os.Getenv("NAME_ENC_KEY")
+	if keyB64 == "" {
+		return nil
+	}
+	key, err := base64.StdEncoding.DecodeString(keyB64)
+	if err != nil {
+		utils.Error.Error().Err(err).Msg("NAME_ENC_KEY is not valid base64")
+		os.Exit(1)
+	}
+	names, err := utils.NewNameCipher(key)
+	if err != nil {
+		utils.Error.Error().Err(err).Msg("Invalid NAME_ENC_KEY")
+		os.Exit(1)
+	}
+	return names
+}
+
+func loadKMS() kms.Provider {
+	provider := os.Getenv("KMS_PROVIDER")
+	switch provider {
+	case "", "local":
+		if path := os.Getenv("KMS_KEYFILE"); path != "" {
+			ring, err := kms.LoadKeyfile(path)
+			if err != nil {
+				utils.Error.Error().Err(err).Msg("Failed to load KMS_KEYFILE")
+				os.Exit(1)
+			}
+			return kms.NewLocalProvider(ring)
+		}
+		return kms.NewLocalProvider(utils.LoadKeyring())
+
+	case "vault":
+		addr := os.Getenv("VAULT_ADDR")
+		key := os.Getenv("VAULT_TRANSIT_KEY")
+		if addr == "" || key == "" {
+			utils.Error.Error().Msg("VAULT_ADDR and VAULT_TRANSIT_KEY are required for the vault KMS provider")
+			os.Exit(1)
+		}
+		vault := kms.NewVaultProvider(addr, os.Getenv("VAULT_TOKEN"), os.Getenv("VAULT_TRANSIT_MOUNT"), key,
+			strings.Split(os.Getenv("VAULT_TRANSIT_KEYS"), ",")...)
+		vault.Namespace = os.Getenv("VAULT_NAMESPACE")
+		return vault
+
+	default:
+		utils.Error.Error().Str("provider", provider).Msg("Unknown KMS_PROVIDER")
+		os.Exit(1)
+		return nil
+	}
+}
+
+func loadStorage(jwtKey, appPort string) storage.Backend {
+	backend := os.Getenv("STORAGE_BACKEND")
+	switch backend {
+	case "", "minio":
+		minioEndpoint := os.Getenv("MINIO_ENDPOINT")
+		minioAccessKey := os.Getenv("MINIO_ACCESS_KEY")
+		minioSecretKey := os.Getenv("MINIO_SECRET_KEY")
+		minioSSL, _ := strconv.ParseBool(os.Getenv("MINIO_USE_SSL"))
+		bucket := os.Getenv("MINIO_BUCKET")
+		if bucket == "" {
+			bucket = "uploads"
+		}
+
+		minioClient, err := minio.New(minioEndpoint, &minio.Options{
+			Creds:  credentials.NewStaticV4(minioAccessKey, minioSecretKey, ""),
+			Secure: minioSSL,
+		})
+		if err != nil {
+			utils.Error.Error().Err(err).Msg("Failed to connect to MinIO")
+			os.Exit(1)
+		}
+		return storage.NewMinioBackend(minioClient, bucket)
+
+	case "local":
+		dir := os.Getenv("STORAGE_LOCAL_DIR")
+		if dir == "" {
+			dir = "./data"
+		}
+		baseURL := os.Getenv("PUBLIC_BASE_URL")
+		if baseURL == "" {
+			baseURL = "http://localhost" + appPort
+		}
+		signingKey := os.Getenv("STORAGE_SIGNING_KEY")
+		if signingKey == "" {
+			utils.Warn.Warn().Msg("STORAGE_SIGNING_KEY not set, using JWT_SECRET")
+			signingKey = jwtKey
+		}
+
+		local, err := storage.NewLocalBackend(dir, baseURL, []byte(signingKey))
+		if err != nil {
+			utils.Error.Error().Err(err).Msg("Failed to init local storage")
+			os.Exit(1)
+		}
+		return local
+
+	case "memory":
+		utils.Warn.Warn().Msg("Using in-memory storage, objects are lost on restart")
+		return storage.NewMemoryBackend()
+
+	default:
+		utils.Error.Error().Str("backend", backend).Msg("Unknown STORAGE_BACKEND")
+		os.Exit(1)
+		return nil
 	}
 }
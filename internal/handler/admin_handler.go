@@ -0,0 +1,207 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/labstack/echo/v4"
+
+	"github.com/SrabanMondal/SecureStore/internal/services"
+)
+
+type AdminHandler struct {
+	RotationSvc *services.KeyRotationService
+	AdminSvc    *services.AdminService
+}
+
+func NewAdminHandler(rotationSvc *services.KeyRotationService, adminSvc *services.AdminService) *AdminHandler {
+	return &AdminHandler{RotationSvc: rotationSvc, AdminSvc: adminSvc}
+}
+
+// adminError answers failed admin actions, and anything else with 500.
+func adminError(c echo.Context, err error) error {
+	switch {
+	case errors.Is(err, services.ErrUserNotFound), errors.Is(err, services.ErrFileNotFound),
+		errors.Is(err, services.ErrShareNotFound):
+		return c.JSON(http.StatusNotFound, echo.Map{"error": err.Error()})
+	case errors.Is(err, services.ErrInvalidRole):
+		return c.JSON(http.StatusBadRequest, echo.Map{"error": err.Error()})
+	case errors.Is(err, services.ErrSelfAdminAction):
+		return c.JSON(http.StatusConflict, echo.Map{"error": err.Error()})
+	default:
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": err.Error()})
+	}
+}
+
+func rotationError(c echo.Context, err error) error {
+	switch {
+	case errors.Is(err, services.ErrRotationNotFound):
+		return c.JSON(http.StatusNotFound, echo.Map{"error": err.Error()})
+	case errors.Is(err, services.ErrUnknownKey), errors.Is(err, services.ErrSameKey):
+		return c.JSON(http.StatusBadRequest, echo.Map{"error": err.Error()})
+	case errors.Is(err, services.ErrRotationRunning), errors.Is(err, services.ErrRotationDone):
+		return c.JSON(http.StatusConflict, echo.Map{"error": err.Error()})
+	default:
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": err.Error()})
+	}
+}
+
+func (h *AdminHandler) ListKeys(c echo.Context) error {
+	keys, err := h.RotationSvc.Keys(c.Request().Context())
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": err.Error()})
+	}
+	unbound, err := h.RotationSvc.FileSvc.FileRepo.CountUnboundVersions(c.Request().Context())
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": err.Error()})
+	}
+	return c.JSON(http.StatusOK, echo.Map{"keys": keys, "unbound_versions": unbound})
+}
+
+func (h *AdminHandler) StartRotation(c echo.Context) error {
+	req := struct {
+		FromKeyID string `json:"from_key_id"`
+		ToKeyID   string `json:"to_key_id"`
+	}{}
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, echo.Map{"error": "invalid request"})
+	}
+
+	job, err := h.RotationSvc.Start(c.Request().Context(), req.FromKeyID, req.ToKeyID)
+	if err != nil {
+		return rotationError(c, err)
+	}
+	return c.JSON(http.StatusAccepted, echo.Map{"rotation": job})
+}
+
+func (h *AdminHandler) ListRotations(c echo.Context) error {
+	jobs, err := h.RotationSvc.List(c.Request().Context())
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": err.Error()})
+	}
+	return c.JSON(http.StatusOK, echo.Map{"rotations": jobs})
+}
+
+func (h *AdminHandler) GetRotation(c echo.Context) error {
+	job, err := h.RotationSvc.Get(c.Request().Context(), c.Param("id"))
+	if err != nil {
+		return rotationError(c, err)
+	}
+	return c.JSON(http.StatusOK, echo.Map{"rotation": job})
+}
+
+func (h *AdminHandler) ResumeRotation(c echo.Context) error {
+	job, err := h.RotationSvc.Resume(c.Request().Context(), c.Param("id"))
+	if err != nil {
+		return rotationError(c, err)
+	}
+	return c.JSON(http.StatusAccepted, echo.Map{"rotation": job})
+}
+
+func (h *AdminHandler) ListUsers(c echo.Context) error {
+	limit, _ := strconv.Atoi(c.QueryParam("limit"))
+	offset, _ := strconv.Atoi(c.QueryParam("offset"))
+
+	page, err := h.AdminSvc.ListUsers(c.Request().Context(), limit, offset)
+	if err != nil {
+		return adminError(c, err)
+	}
+	return c.JSON(http.StatusOK, page)
+}
+
+func (h *AdminHandler) GetUser(c echo.Context) error {
+	user, err := h.AdminSvc.GetUser(c.Request().Context(), c.Param("id"))
+	if err != nil {
+		return adminError(c, err)
+	}
+	return c.JSON(http.StatusOK, echo.Map{"user": user})
+}
+
+func (h *AdminHandler) SetRole(c echo.Context) error {
+	req := struct {
+		Role string `json:"role"`
+	}{}
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, echo.Map{"error": "invalid request"})
+	}
+
+	adminID := c.Get("userID").(string)
+	if err := h.AdminSvc.SetRole(c.Request().Context(), adminID, c.Param("id"), req.Role); err != nil {
+		return adminError(c, err)
+	}
+	return c.NoContent(http.StatusNoContent)
+}
+
+func (h *AdminHandler) SuspendUser(c echo.Context) error {
+	adminID := c.Get("userID").(string)
+	if err := h.AdminSvc.SetSuspended(c.Request().Context(), adminID, c.Param("id"), true); err != nil {
+		return adminError(c, err)
+	}
+	return c.NoContent(http.StatusNoContent)
+}
+
+func (h *AdminHandler) UnsuspendUser(c echo.Context) error {
+	adminID := c.Get("userID").(string)
+	if err := h.AdminSvc.SetSuspended(c.Request().Context(), adminID, c.Param("id"), false); err != nil {
+		return adminError(c, err)
+	}
+	return c.NoContent(http.StatusNoContent)
+}
+
+// DeleteUser deletes an account and crypto-shreds it by destroying its key.
+func (h *AdminHandler) DeleteUser(c echo.Context) error {
+	adminID := c.Get("userID").(string)
+	if err := h.AdminSvc.DeleteUser(c.Request().Context(), adminID, c.Param("id")); err != nil {
+		return adminError(c, err)
+	}
+	return c.NoContent(http.StatusNoContent)
+}
+
+func (h *AdminHandler) Usage(c echo.Context) error {
+	report, err := h.AdminSvc.Usage(c.Request().Context())
+	if err != nil {
+		return adminError(c, err)
+	}
+	return c.JSON(http.StatusOK, report)
+}
+
+// DeleteFile deletes any user's file at once, without going through the
+// trash.
+func (h *AdminHandler) DeleteFile(c echo.Context) error {
+	adminID := c.Get("userID").(string)
+	if err := h.AdminSvc.ForceDeleteFile(c.Request().Context(), adminID, c.Param("id")); err != nil {
+		return adminError(c, err)
+	}
+	return c.NoContent(http.StatusNoContent)
+}
+
+// ListShares lists share links across the system, or only those to the
+// files of the user_id query parameter.
+func (h *AdminHandler) ListShares(c echo.Context) error {
+	limit, _ := strconv.Atoi(c.QueryParam("limit"))
+	offset, _ := strconv.Atoi(c.QueryParam("offset"))
+
+	page, err := h.AdminSvc.ListShares(c.Request().Context(), c.QueryParam("user_id"), limit, offset)
+	if err != nil {
+		return adminError(c, err)
+	}
+	return c.JSON(http.StatusOK, page)
+}
+
+func (h *AdminHandler) RevokeShare(c echo.Context) error {
+	adminID := c.Get("userID").(string)
+	if err := h.AdminSvc.RevokeShare(c.Request().Context(), adminID, c.Param("id")); err != nil {
+		return adminError(c, err)
+	}
+	return c.NoContent(http.StatusNoContent)
+}
+
+func (h *AdminHandler) RevokeUserShares(c echo.Context) error {
+	adminID := c.Get("userID").(string)
+	n, err := h.AdminSvc.RevokeUserShares(c.Request().Context(), adminID, c.Param("id"))
+	if err != nil {
+		return adminError(c, err)
+	}
+	return c.JSON(http.StatusOK, echo.Map{"revoked": n})
+}
@@ -1,18 +1,38 @@
 package handlers
 
 import (
+	"errors"
 	"net/http"
 
 	"github.com/labstack/echo/v4"
 	"github.com/SrabanMondal/SecureStore/internal/services"
+	"github.com/SrabanMondal/SecureStore/internal/utils"
 )
 
 type AuthHandler struct {
 	AuthService *services.AuthService
+	Accounts    *services.AccountService
 }
 
-func NewAuthHandler(authService *services.AuthService) *AuthHandler {
-	return &AuthHandler{AuthService: authService}
+func NewAuthHandler(authService *services.AuthService, accounts *services.AccountService) *AuthHandler {
+	return &AuthHandler{AuthService: authService, Accounts: accounts}
+}
+
+// accountError answers failures of the mailed token flows, and anything else
+// with 500.
+func accountError(c echo.Context, err error) error {
+	switch {
+	case errors.Is(err, services.ErrInvalidUserToken), errors.Is(err, services.ErrEmptyPassword):
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	case errors.Is(err, services.ErrEmailVerified):
+		return c.JSON(http.StatusConflict, map[string]string{"error": err.Error()})
+	case errors.Is(err, services.ErrMailThrottled):
+		return c.JSON(http.StatusTooManyRequests, map[string]string{"error": err.Error()})
+	case errors.Is(err, services.ErrUserNotFound):
+		return c.JSON(http.StatusNotFound, map[string]string{"error": err.Error()})
+	default:
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	}
 }
 
 func (h *AuthHandler) Register(c echo.Context) error {
@@ -20,14 +40,20 @@ func (h *AuthHandler) Register(c echo.Context) error {
 		Username string `json:"username"`
 		Email    string `json:"email"`
 		Password string `json:"password"`
+		// optional; protects the account's key with a passphrase
+		KeyPassphrase string `json:"key_passphrase"`
 	}
 	if err := c.Bind(&req); err != nil {
 		return c.JSON(http.StatusBadRequest, map[string]string{"error": "invalid request"})
 	}
-	user, err := h.AuthService.Register(c.Request().Context(), req.Username, req.Email, req.Password)
+	user, err := h.AuthService.Register(c.Request().Context(), req.Username, req.Email, req.Password, req.KeyPassphrase)
 	if err != nil {
 		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
 	}
+	// the account stands without it; a new link can be asked for later
+	if err := h.Accounts.SendVerification(c.Request().Context(), user.ID); err != nil {
+		utils.Warn.Warn().Err(err).Str("user_id", user.ID).Msg("failed to send verification mail")
+	}
 
 	return c.JSON(http.StatusCreated, map[string]any{
 		"id":       user.ID,
@@ -36,6 +62,8 @@ func (h *AuthHandler) Register(c echo.Context) error {
 	})
 }
 
+// Login checks the credentials and returns a token pair, or, for accounts
+// with MFA, an mfa_token to complete the login with at LoginMFA.
 func (h *AuthHandler) Login(c echo.Context) error {
 	var req struct {
 		Email    string `json:"email"`
@@ -45,10 +73,154 @@ func (h *AuthHandler) Login(c echo.Context) error {
 		return c.JSON(http.StatusBadRequest, map[string]string{"error": "invalid request"})
 	}
 
-	token, err := h.AuthService.Login(c.Request().Context(), req.Email, req.Password)
-	if err != nil {
+	result, err := h.AuthService.Login(c.Request().Context(), req.Email, req.Password)
+	if errors.Is(err, services.ErrInvalidCredentials) {
 		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "invalid credentials"})
 	}
+	if errors.Is(err, services.ErrAccountSuspended) {
+		return c.JSON(http.StatusForbidden, map[string]string{"error": err.Error()})
+	}
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "login failed"})
+	}
+
+	return c.JSON(http.StatusOK, result)
+}
+
+// LoginMFA completes a login with the mfa_token from Login and a code from
+// the user's authenticator or one of their recovery codes.
+func (h *AuthHandler) LoginMFA(c echo.Context) error {
+	var req struct {
+		MFAToken string `json:"mfa_token"`
+		Code     string `json:"code"`
+	}
+	if err := c.Bind(&req); err != nil || req.MFAToken == "" || req.Code == "" {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "mfa_token and code are required"})
+	}
+
+	tokens, err := h.AuthService.LoginMFA(c.Request().Context(), req.MFAToken, req.Code)
+	if errors.Is(err, services.ErrInvalidMFAChallenge) || errors.Is(err, services.ErrInvalidMFACode) {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": err.Error()})
+	}
+	if errors.Is(err, services.ErrAccountSuspended) {
+		return c.JSON(http.StatusForbidden, map[string]string{"error": err.Error()})
+	}
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "login failed"})
+	}
+
+	return c.JSON(http.StatusOK, tokens)
+}
+
+// JWKS publishes the public keys access tokens are signed with, for other
+// services to verify them.
+func (h *AuthHandler) JWKS(c echo.Context) error {
+	c.Response().Header().Set("Cache-Control", "public, max-age=300")
+	return c.JSON(http.StatusOK, h.AuthService.Tokens.JWKS())
+}
+
+// Refresh swaps a refresh token for a new access and refresh token. A
+// refresh token works once; reusing one ends its session.
+func (h *AuthHandler) Refresh(c echo.Context) error {
+	var req struct {
+		RefreshToken string `json:"refresh_token"`
+	}
+	if err := c.Bind(&req); err != nil || req.RefreshToken == "" {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "refresh_token is required"})
+	}
+
+	tokens, err := h.AuthService.Tokens.Refresh(c.Request().Context(), req.RefreshToken)
+	if errors.Is(err, services.ErrInvalidRefreshToken) || errors.Is(err, services.ErrRefreshTokenReused) {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": err.Error()})
+	}
+	if errors.Is(err, services.ErrAccountSuspended) {
+		return c.JSON(http.StatusForbidden, map[string]string{"error": err.Error()})
+	}
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "refresh failed"})
+	}
+
+	return c.JSON(http.StatusOK, tokens)
+}
+
+// Logout revokes the access token of the request along with the session of
+// refresh_token, or every session of the user with all.
+func (h *AuthHandler) Logout(c echo.Context) error {
+	claims := c.Get("tokenClaims").(*utils.AccessClaims)
+
+	var req struct {
+		RefreshToken string `json:"refresh_token"`
+		All          bool   `json:"all"`
+	}
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "invalid request"})
+	}
+
+	err := h.AuthService.Tokens.Logout(c.Request().Context(), claims, req.RefreshToken, req.All)
+	if errors.Is(err, services.ErrInvalidRefreshToken) {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "logout failed"})
+	}
+	return c.NoContent(http.StatusNoContent)
+}
+
+// ForgotPassword mails a reset link. It answers 202 whether or not the
+// address has an account.
+func (h *AuthHandler) ForgotPassword(c echo.Context) error {
+	var req struct {
+		Email string `json:"email"`
+	}
+	if err := c.Bind(&req); err != nil || req.Email == "" {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "email is required"})
+	}
+
+	if err := h.Accounts.ForgotPassword(c.Request().Context(), req.Email); err != nil {
+		return accountError(c, err)
+	}
+	return c.JSON(http.StatusAccepted, map[string]string{"message": "if the address has an account, a reset link is on its way"})
+}
+
+// ResetPassword sets a new password with the token from the reset link and
+// signs the user out everywhere.
+func (h *AuthHandler) ResetPassword(c echo.Context) error {
+	var req struct {
+		Token    string `json:"token"`
+		Password string `json:"password"`
+	}
+	if err := c.Bind(&req); err != nil || req.Token == "" {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "token is required"})
+	}
+
+	if err := h.Accounts.ResetPassword(c.Request().Context(), req.Token, req.Password); err != nil {
+		return accountError(c, err)
+	}
+	return c.NoContent(http.StatusNoContent)
+}
 
-	return c.JSON(http.StatusOK, map[string]string{"token": token})
+// VerifyEmail confirms the address with the token from the verification
+// link.
+func (h *AuthHandler) VerifyEmail(c echo.Context) error {
+	var req struct {
+		Token string `json:"token"`
+	}
+	if err := c.Bind(&req); err != nil || req.Token == "" {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "token is required"})
+	}
+
+	if err := h.Accounts.VerifyEmail(c.Request().Context(), req.Token); err != nil {
+		return accountError(c, err)
+	}
+	return c.NoContent(http.StatusNoContent)
+}
+
+// SendVerification mails the caller a new verification link.
+func (h *AuthHandler) SendVerification(c echo.Context) error {
+	userID := c.Get("userID").(string)
+
+	if err := h.Accounts.SendVerification(c.Request().Context(), userID); err != nil {
+		return accountError(c, err)
+	}
+	return c.NoContent(http.StatusAccepted)
 }
@@ -1,15 +1,17 @@
 package handlers
 
 import (
-	"context"
+	"errors"
 	//"io"
 	"net/http"
+	"strconv"
 
 	"github.com/labstack/echo/v4"
 
 	"github.com/SrabanMondal/SecureStore/internal/services"
 	"github.com/SrabanMondal/SecureStore/internal/repository"
-	//"github.com/SrabanMondal/SecureStore/internal/models"
+	"github.com/SrabanMondal/SecureStore/internal/utils"
+	"github.com/SrabanMondal/SecureStore/internal/models"
 )
 
 type FileHandler struct {
@@ -25,6 +27,21 @@ func NewFileHandler(fs *services.FileService, repo *repositories.FileRepository)
 }
 
 
+func uploadError(c echo.Context, err error) error {
+	switch {
+	case errors.Is(err, utils.ErrInvalidPath), errors.Is(err, services.ErrInvalidSize), errors.Is(err, services.ErrInvalidClientKey):
+		return c.JSON(http.StatusBadRequest, echo.Map{"error": err.Error()})
+	case errors.Is(err, services.ErrFileBusy), errors.Is(err, services.ErrPathConflict):
+		return c.JSON(http.StatusConflict, echo.Map{"error": err.Error()})
+	case errors.Is(err, services.ErrFileTooLarge):
+		return c.JSON(http.StatusRequestEntityTooLarge, echo.Map{"error": err.Error()})
+	case errors.Is(err, services.ErrQuotaExceeded):
+		return c.JSON(http.StatusInsufficientStorage, echo.Map{"error": err.Error()})
+	default:
+		return keyError(c, err)
+	}
+}
+
 func (h *FileHandler) UploadPresigned(c echo.Context) error {
 	userID := c.Get("userID").(string)
 
@@ -36,25 +53,75 @@ func (h *FileHandler) UploadPresigned(c echo.Context) error {
 		return c.JSON(http.StatusBadRequest, echo.Map{"error": "invalid request"})
 	}
 
-	url, file, err := h.FileService.GeneratePresignedUpload(context.Background(), userID, req.FilePath, req.Size)
+	url, file, version, err := h.FileService.GeneratePresignedUpload(c.Request().Context(), userID, req.FilePath, req.Size)
 	if err != nil {
-		return c.JSON(http.StatusInternalServerError, echo.Map{"error": err.Error()})
+		return uploadError(c, err)
+	}
+
+	return c.JSON(http.StatusOK, echo.Map{
+		"upload_url": url,
+		"file_id":    file.ID,
+		"version":    version.Version,
+	})
+}
+
+// UploadClient presigns the upload of a file the client has encrypted
+// itself. The server never sees the plaintext or the file key, only the
+// ciphertext and client_key, which it stores for the client to download.
+func (h *FileHandler) UploadClient(c echo.Context) error {
+	userID := c.Get("userID").(string)
+
+	req := struct {
+		FilePath  string           `json:"file_path"`
+		Size      int64            `json:"size"`
+		ClientKey models.ClientKey `json:"client_key"`
+	}{}
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, echo.Map{"error": "invalid request"})
+	}
+
+	url, file, version, err := h.FileService.GenerateClientUpload(c.Request().Context(), userID, req.FilePath, req.Size, req.ClientKey)
+	if err != nil {
+		return uploadError(c, err)
 	}
 
 	return c.JSON(http.StatusOK, echo.Map{
 		"upload_url": url,
 		"file_id":    file.ID,
+		"version":    version.Version,
 	})
 }
 
 func (h *FileHandler) FinalizeUpload(c echo.Context) error {
+	userID := c.Get("userID").(string)
 	fileID := c.Param("id")
 
-	if err := h.FileService.MarkUploaded(context.Background(), fileID); err != nil {
-		return c.JSON(http.StatusInternalServerError, echo.Map{"error": err.Error()})
+	req := struct {
+		Version int    `json:"version"`
+		SHA256  string `json:"sha256"`
+		CRC32C  string `json:"crc32c"`
+	}{}
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, echo.Map{"error": "invalid request"})
 	}
 
-	return c.JSON(http.StatusOK, echo.Map{"status": "uploaded"})
+	file, err := h.FileService.FinalizeUpload(c.Request().Context(), userID, fileID, req.Version, req.SHA256, req.CRC32C)
+	if err != nil {
+		switch {
+		case errors.Is(err, services.ErrFileNotFound), errors.Is(err, services.ErrVersionNotFound):
+			return c.JSON(http.StatusNotFound, echo.Map{"error": err.Error()})
+		case errors.Is(err, services.ErrFileNotPending), errors.Is(err, services.ErrObjectMissing), errors.Is(err, services.ErrFileBusy):
+			return c.JSON(http.StatusConflict, echo.Map{"error": err.Error()})
+		case errors.Is(err, services.ErrSizeMismatch):
+			return c.JSON(http.StatusUnprocessableEntity, echo.Map{"error": err.Error(), "code": "size_mismatch"})
+		case errors.Is(err, services.ErrChecksumMismatch):
+			return c.JSON(http.StatusUnprocessableEntity, echo.Map{"error": err.Error(), "code": "checksum_mismatch"})
+		default:
+			return c.JSON(http.StatusInternalServerError, echo.Map{"error": err.Error()})
+		}
+	}
+
+	return c.JSON(http.StatusOK, echo.Map{"status": "uploaded", "file": file})
 }
 
 func (h *FileHandler) UploadEncrypted(c echo.Context) error {
@@ -72,52 +139,189 @@ func (h *FileHandler) UploadEncrypted(c echo.Context) error {
 	}
 	defer src.Close()
 
-	if err := h.FileService.UploadEncrypted(context.Background(), userID, filePath, src, fileHeader.Size); err != nil {
-		return c.JSON(http.StatusInternalServerError, echo.Map{"error": err.Error()})
+	if err := h.FileService.UploadEncrypted(c.Request().Context(), userID, filePath, src, fileHeader.Size); err != nil {
+		return uploadError(c, err)
 	}
 
 	return c.JSON(http.StatusOK, echo.Map{"status": "uploaded"})
 }
 
 func (h *FileHandler) Download(c echo.Context) error {
-	fileID := c.Param("id")
+	userID := c.Get("userID").(string)
 
-	file, err := h.FileRepo.GetFileByID(context.Background(), fileID)
+	file, err := h.FileService.DownloadableFile(c.Request().Context(), userID, c.Param("id"))
 	if err != nil {
 		return c.JSON(http.StatusNotFound, echo.Map{"error": "file not found"})
 	}
 
 	if file.IsEncrypted {
-		data, err := h.FileService.DownloadDecrypt(context.Background(), file)
-		if err != nil {
-			return c.JSON(http.StatusInternalServerError, echo.Map{"error": err.Error()})
-		}
-		return c.Blob(http.StatusOK, "application/octet-stream", data)
+		return serveDecrypted(c, h.FileService, file)
 	}
 
-	url, err := h.FileService.GetDownloadURL(context.Background(), file)
+	url, err := h.FileService.GetDownloadURL(c.Request().Context(), file)
 	if err != nil {
 		return c.JSON(http.StatusInternalServerError, echo.Map{"error": err.Error()})
 	}
+	if file.EncryptionMode == models.EncryptionClient {
+		return c.JSON(http.StatusOK, echo.Map{
+			"download_url":    url,
+			"encryption_mode": file.EncryptionMode,
+			"client_key":      file.ClientKey,
+		})
+	}
 	return c.Redirect(http.StatusFound, url)
 }
 
+func versionError(c echo.Context, err error) error {
+	switch {
+	case errors.Is(err, services.ErrFileNotFound), errors.Is(err, services.ErrVersionNotFound):
+		return c.JSON(http.StatusNotFound, echo.Map{"error": err.Error()})
+	case errors.Is(err, services.ErrVersionNotUploaded), errors.Is(err, services.ErrFileBusy):
+		return c.JSON(http.StatusConflict, echo.Map{"error": err.Error()})
+	default:
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": err.Error()})
+	}
+}
+
+func (h *FileHandler) ListVersions(c echo.Context) error {
+	userID := c.Get("userID").(string)
+
+	versions, err := h.FileService.ListVersions(c.Request().Context(), userID, c.Param("id"))
+	if err != nil {
+		return versionError(c, err)
+	}
+
+	return c.JSON(http.StatusOK, echo.Map{"versions": versions})
+}
+
+func (h *FileHandler) DownloadVersion(c echo.Context) error {
+	userID := c.Get("userID").(string)
+
+	version, err := strconv.Atoi(c.Param("version"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, echo.Map{"error": "invalid version"})
+	}
+
+	file, err := h.FileService.VersionFile(c.Request().Context(), userID, c.Param("id"), version)
+	if err != nil {
+		return versionError(c, err)
+	}
+
+	if file.IsEncrypted {
+		return serveDecrypted(c, h.FileService, file)
+	}
+
+	url, err := h.FileService.GetDownloadURL(c.Request().Context(), file)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": err.Error()})
+	}
+	if file.EncryptionMode == models.EncryptionClient {
+		return c.JSON(http.StatusOK, echo.Map{
+			"download_url":    url,
+			"encryption_mode": file.EncryptionMode,
+			"client_key":      file.ClientKey,
+		})
+	}
+	return c.Redirect(http.StatusFound, url)
+}
+
+func (h *FileHandler) RestoreVersion(c echo.Context) error {
+	userID := c.Get("userID").(string)
+
+	version, err := strconv.Atoi(c.Param("version"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, echo.Map{"error": "invalid version"})
+	}
+
+	file, err := h.FileService.RestoreVersion(c.Request().Context(), userID, c.Param("id"), version)
+	if err != nil {
+		return versionError(c, err)
+	}
+
+	return c.JSON(http.StatusOK, echo.Map{"status": "restored", "file": file})
+}
+
 func (h *FileHandler) Delete(c echo.Context) error {
+	userID := c.Get("userID").(string)
 	fileID := c.Param("id")
 
-	if err := h.FileService.DeleteFile(context.Background(), fileID); err != nil {
+	file, err := h.FileService.DeleteFile(c.Request().Context(), userID, fileID)
+	if errors.Is(err, services.ErrFileNotFound) {
+		return c.JSON(http.StatusNotFound, echo.Map{"error": err.Error()})
+	}
+	if err != nil {
 		return c.JSON(http.StatusInternalServerError, echo.Map{"error": err.Error()})
 	}
 
-	return c.JSON(http.StatusOK, echo.Map{"status": "deleted"})
+	// "trashed", or "deleting" when the file is gone for good
+	return c.JSON(http.StatusOK, echo.Map{"status": file.Status})
+}
+
+func (h *FileHandler) ListTrash(c echo.Context) error {
+	userID := c.Get("userID").(string)
+
+	files, err := h.FileService.ListTrash(c.Request().Context(), userID)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": err.Error()})
+	}
+
+	return c.JSON(http.StatusOK, echo.Map{
+		"files":          files,
+		"retention_days": int(h.FileService.Retention.TrashFor.Hours() / 24),
+	})
+}
+
+func (h *FileHandler) Restore(c echo.Context) error {
+	userID := c.Get("userID").(string)
+
+	file, err := h.FileService.RestoreFile(c.Request().Context(), userID, c.Param("id"))
+	if err != nil {
+		switch {
+		case errors.Is(err, services.ErrFileNotFound):
+			return c.JSON(http.StatusNotFound, echo.Map{"error": err.Error()})
+		case errors.Is(err, services.ErrFileNotTrashed), errors.Is(err, services.ErrPathConflict):
+			return c.JSON(http.StatusConflict, echo.Map{"error": err.Error()})
+		default:
+			return c.JSON(http.StatusInternalServerError, echo.Map{"error": err.Error()})
+		}
+	}
+
+	return c.JSON(http.StatusOK, echo.Map{"status": "restored", "file": file})
+}
+
+func (h *FileHandler) EmptyTrash(c echo.Context) error {
+	userID := c.Get("userID").(string)
+
+	n, err := h.FileService.EmptyTrash(c.Request().Context(), userID)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": err.Error()})
+	}
+
+	return c.JSON(http.StatusOK, echo.Map{"status": "emptied", "files": n})
 }
 
 func (h* FileHandler) ListFiles(c echo.Context) error {
 	userID := c.Get("userID").(string)
-	files, err := h.FileRepo.ListFilesByUser(context.Background(), userID)
+	files, err := h.FileRepo.ListFilesByUser(c.Request().Context(), userID)
 	if(err!=nil){
 		return c.JSON(http.StatusInternalServerError, echo.Map{"error": err.Error()})
 	}
 
 	return c.JSON(http.StatusOK, echo.Map{"files":files})
-}
\ No newline at end of file
+}
+
+func (h *FileHandler) Usage(c echo.Context) error {
+	userID := c.Get("userID").(string)
+
+	usage, err := h.FileService.Usage(c.Request().Context(), userID)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": err.Error()})
+	}
+
+	return c.JSON(http.StatusOK, echo.Map{
+		"used_bytes":      usage.UsedBytes,
+		"quota_bytes":     usage.QuotaBytes,
+		"available_bytes": usage.Available(),
+		"updated_at":      usage.UpdatedAt,
+	})
+}
@@ -0,0 +1,128 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/labstack/echo/v4"
+
+	"github.com/SrabanMondal/SecureStore/internal/services"
+	"github.com/SrabanMondal/SecureStore/internal/utils"
+)
+
+// rootFolderID addresses the top level in folder routes
+const rootFolderID = "root"
+
+type FolderHandler struct {
+	FolderSvc *services.FolderService
+}
+
+func NewFolderHandler(folderSvc *services.FolderService) *FolderHandler {
+	return &FolderHandler{FolderSvc: folderSvc}
+}
+
+func folderError(c echo.Context, err error) error {
+	switch {
+	case errors.Is(err, services.ErrFolderNotFound):
+		return c.JSON(http.StatusNotFound, echo.Map{"error": err.Error()})
+	case errors.Is(err, utils.ErrInvalidPath), errors.Is(err, services.ErrInvalidMove):
+		return c.JSON(http.StatusBadRequest, echo.Map{"error": err.Error()})
+	case errors.Is(err, services.ErrPathConflict):
+		return c.JSON(http.StatusConflict, echo.Map{"error": err.Error()})
+	default:
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": err.Error()})
+	}
+}
+
+// parentID maps the parent_id of a request body to a folder id, nil meaning
+// the root.
+func parentID(id *string) *string {
+	if id == nil || *id == "" || *id == rootFolderID {
+		return nil
+	}
+	return id
+}
+
+func (h *FolderHandler) Create(c echo.Context) error {
+	userID := c.Get("userID").(string)
+
+	req := struct {
+		Name     string  `json:"name"`
+		ParentID *string `json:"parent_id"`
+	}{}
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, echo.Map{"error": "invalid request"})
+	}
+
+	folder, err := h.FolderSvc.CreateFolder(c.Request().Context(), userID, parentID(req.ParentID), req.Name)
+	if err != nil {
+		return folderError(c, err)
+	}
+
+	return c.JSON(http.StatusCreated, echo.Map{"folder": folder})
+}
+
+func (h *FolderHandler) Children(c echo.Context) error {
+	userID := c.Get("userID").(string)
+
+	id := c.Param("id")
+	if id == rootFolderID {
+		id = ""
+	}
+	limit, _ := strconv.Atoi(c.QueryParam("limit"))
+	offset, _ := strconv.Atoi(c.QueryParam("offset"))
+
+	listing, err := h.FolderSvc.Children(c.Request().Context(), userID, id, limit, offset)
+	if err != nil {
+		return folderError(c, err)
+	}
+
+	return c.JSON(http.StatusOK, listing)
+}
+
+func (h *FolderHandler) Rename(c echo.Context) error {
+	userID := c.Get("userID").(string)
+
+	req := struct {
+		Name string `json:"name"`
+	}{}
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, echo.Map{"error": "invalid request"})
+	}
+
+	folder, err := h.FolderSvc.RenameFolder(c.Request().Context(), userID, c.Param("id"), req.Name)
+	if err != nil {
+		return folderError(c, err)
+	}
+
+	return c.JSON(http.StatusOK, echo.Map{"folder": folder})
+}
+
+func (h *FolderHandler) Move(c echo.Context) error {
+	userID := c.Get("userID").(string)
+
+	req := struct {
+		ParentID *string `json:"parent_id"`
+	}{}
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, echo.Map{"error": "invalid request"})
+	}
+
+	folder, err := h.FolderSvc.MoveFolder(c.Request().Context(), userID, c.Param("id"), parentID(req.ParentID))
+	if err != nil {
+		return folderError(c, err)
+	}
+
+	return c.JSON(http.StatusOK, echo.Map{"folder": folder})
+}
+
+func (h *FolderHandler) Delete(c echo.Context) error {
+	userID := c.Get("userID").(string)
+
+	if err := h.FolderSvc.DeleteFolder(c.Request().Context(), userID, c.Param("id")); err != nil {
+		return folderError(c, err)
+	}
+
+	return c.JSON(http.StatusOK, echo.Map{"status": "deleted"})
+}
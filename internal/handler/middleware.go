@@ -0,0 +1,116 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+
+	"github.com/SrabanMondal/SecureStore/internal/models"
+	"github.com/SrabanMondal/SecureStore/internal/services"
+	"github.com/SrabanMondal/SecureStore/internal/utils"
+)
+
+// JWTMiddleware admits requests with a valid, unrevoked access token or
+// personal access token in the Authorization header and sets userID on the
+// context, along with tokenClaims for access tokens or personalToken for
+// personal access tokens.
+func JWTMiddleware(tokens *services.TokenService) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			authHeader := c.Request().Header.Get("Authorization")
+			if authHeader == "" {
+				return c.JSON(http.StatusUnauthorized, echo.Map{"error": "missing token"})
+			}
+
+			parts := strings.Split(authHeader, " ")
+			if len(parts) != 2 || strings.ToLower(parts[0]) != "bearer" {
+				return c.JSON(http.StatusUnauthorized, echo.Map{"error": "invalid token format"})
+			}
+
+			if strings.HasPrefix(parts[1], models.PersonalTokenPrefix) {
+				t, err := tokens.AuthenticatePersonal(c.Request().Context(), parts[1])
+				if errors.Is(err, services.ErrAccountSuspended) {
+					return c.JSON(http.StatusForbidden, echo.Map{"error": err.Error()})
+				}
+				if err != nil {
+					return c.JSON(http.StatusUnauthorized, echo.Map{"error": "invalid or expired token"})
+				}
+				c.Set("userID", t.UserID)
+				c.Set("personalToken", t)
+				return next(c)
+			}
+
+			claims, err := tokens.Authenticate(c.Request().Context(), parts[1])
+			if errors.Is(err, services.ErrAccountSuspended) {
+				return c.JSON(http.StatusForbidden, echo.Map{"error": err.Error()})
+			}
+			if errors.Is(err, services.ErrTokenRevoked) {
+				return c.JSON(http.StatusUnauthorized, echo.Map{"error": err.Error()})
+			}
+			if err != nil {
+				return c.JSON(http.StatusUnauthorized, echo.Map{"error": "invalid or expired token"})
+			}
+
+			c.Set("userID", claims.UserID)
+			c.Set("tokenClaims", claims)
+			return next(c)
+		}
+	}
+}
+
+// VerifiedEmail refuses callers whose email address is unverified, when the
+// accounts service requires verification.
+func VerifiedEmail(accounts *services.AccountService) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			err := accounts.CheckUpload(c.Request().Context(), c.Get("userID").(string))
+			switch {
+			case errors.Is(err, services.ErrEmailNotVerified), errors.Is(err, services.ErrUserNotFound):
+				return c.JSON(http.StatusForbidden, echo.Map{"error": err.Error()})
+			case err != nil:
+				return c.JSON(http.StatusInternalServerError, echo.Map{"error": err.Error()})
+			}
+			return next(c)
+		}
+	}
+}
+
+// RequireScope limits personal access tokens to routes within their scopes.
+// Login sessions are not limited.
+func RequireScope(scope string) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			if t, ok := c.Get("personalToken").(*models.PersonalAccessToken); ok && !t.HasScope(scope) {
+				return c.JSON(http.StatusForbidden, echo.Map{"error": "token lacks the " + scope + " scope"})
+			}
+			return next(c)
+		}
+	}
+}
+
+// SessionOnly keeps personal access tokens out of account management.
+func SessionOnly(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		if _, ok := c.Get("personalToken").(*models.PersonalAccessToken); ok {
+			return c.JSON(http.StatusForbidden, echo.Map{"error": "this route needs a login session, not a personal access token"})
+		}
+		return next(c)
+	}
+}
+
+// RequireRole admits login sessions whose user has one of roles.
+// Personal access tokens are refused, whatever their user's role.
+func RequireRole(roles ...string) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			claims, ok := c.Get("tokenClaims").(*utils.AccessClaims)
+			if !ok || !slices.Contains(roles, claims.Role) {
+				return c.JSON(http.StatusForbidden, echo.Map{"error": "insufficient role"})
+			}
+			return next(c)
+		}
+	}
+}
@@ -0,0 +1,67 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+
+	"github.com/SrabanMondal/SecureStore/internal/models"
+	"github.com/SrabanMondal/SecureStore/internal/utils"
+)
+
+// serve runs handler behind mw for a request whose context holds what
+// JWTMiddleware would have set, and returns the response status.
+func serve(mw echo.MiddlewareFunc, set map[string]any) int {
+	e := echo.New()
+	rec := httptest.NewRecorder()
+	c := e.NewContext(httptest.NewRequest(http.MethodGet, "/", nil), rec)
+	for k, v := range set {
+		c.Set(k, v)
+	}
+	mw(func(c echo.Context) error {
+		return c.NoContent(http.StatusOK)
+	})(c)
+	return rec.Code
+}
+
+func session(role string) map[string]any {
+	return map[string]any{"userID": "user-1", "tokenClaims": &utils.AccessClaims{UserID: "user-1", Role: role}}
+}
+
+func personalToken() map[string]any {
+	return map[string]any{"userID": "user-1", "personalToken": &models.PersonalAccessToken{UserID: "user-1"}}
+}
+
+func TestRequireRole(t *testing.T) {
+	mw := RequireRole(models.RoleAdmin, models.RoleAuditor)
+	for _, tc := range []struct {
+		name string
+		set  map[string]any
+		want int
+	}{
+		{"admin", session(models.RoleAdmin), http.StatusOK},
+		{"auditor", session(models.RoleAuditor), http.StatusOK},
+		{"user", session(models.RoleUser), http.StatusForbidden},
+		{"personal access token", personalToken(), http.StatusForbidden},
+		{"no token", nil, http.StatusForbidden},
+	} {
+		if got := serve(mw, tc.set); got != tc.want {
+			t.Errorf("%s: status %d, want %d", tc.name, got, tc.want)
+		}
+	}
+
+	if got := serve(RequireRole(models.RoleAdmin), session(models.RoleAuditor)); got != http.StatusForbidden {
+		t.Errorf("auditor on an admin route: status %d, want %d", got, http.StatusForbidden)
+	}
+}
+
+func TestSessionOnly(t *testing.T) {
+	if got := serve(SessionOnly, session(models.RoleUser)); got != http.StatusOK {
+		t.Errorf("login session: status %d, want %d", got, http.StatusOK)
+	}
+	if got := serve(SessionOnly, personalToken()); got != http.StatusForbidden {
+		t.Errorf("personal access token: status %d, want %d", got, http.StatusForbidden)
+	}
+}
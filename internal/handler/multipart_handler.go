@@ -0,0 +1,143 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"sort"
+
+	"github.com/labstack/echo/v4"
+
+	"github.com/SrabanMondal/SecureStore/internal/models"
+	"github.com/SrabanMondal/SecureStore/internal/services"
+	"github.com/SrabanMondal/SecureStore/internal/storage"
+	"github.com/SrabanMondal/SecureStore/internal/utils"
+)
+
+type MultipartHandler struct {
+	FileService *services.FileService
+}
+
+func NewMultipartHandler(fs *services.FileService) *MultipartHandler {
+	return &MultipartHandler{FileService: fs}
+}
+
+func multipartError(c echo.Context, err error) error {
+	switch {
+	case errors.Is(err, services.ErrUploadNotFound):
+		return c.JSON(http.StatusNotFound, echo.Map{"error": err.Error()})
+	case errors.Is(err, services.ErrInvalidPart), errors.Is(err, services.ErrIncompleteUpload),
+		errors.Is(err, services.ErrInvalidSize), errors.Is(err, utils.ErrInvalidPath), errors.Is(err, services.ErrInvalidClientKey):
+		return c.JSON(http.StatusBadRequest, echo.Map{"error": err.Error()})
+	case errors.Is(err, services.ErrFileBusy), errors.Is(err, services.ErrPathConflict):
+		return c.JSON(http.StatusConflict, echo.Map{"error": err.Error()})
+	case errors.Is(err, services.ErrFileTooLarge):
+		return c.JSON(http.StatusRequestEntityTooLarge, echo.Map{"error": err.Error()})
+	case errors.Is(err, services.ErrQuotaExceeded):
+		return c.JSON(http.StatusInsufficientStorage, echo.Map{"error": err.Error()})
+	case errors.Is(err, services.ErrMultipartUnsupported):
+		return c.JSON(http.StatusNotImplemented, echo.Map{"error": err.Error()})
+	default:
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": err.Error()})
+	}
+}
+
+func (h *MultipartHandler) Initiate(c echo.Context) error {
+	userID := c.Get("userID").(string)
+
+	req := struct {
+		FilePath  string            `json:"file_path"`
+		Size      int64             `json:"size"`
+		PartSize  int64             `json:"part_size"`
+		ClientKey *models.ClientKey `json:"client_key"`
+	}{}
+	if err := c.Bind(&req); err != nil || req.FilePath == "" {
+		return c.JSON(http.StatusBadRequest, echo.Map{"error": "invalid request"})
+	}
+
+	upload, err := h.FileService.InitiateMultipart(c.Request().Context(), userID, req.FilePath, req.Size, req.PartSize, req.ClientKey)
+	if err != nil {
+		return multipartError(c, err)
+	}
+
+	return c.JSON(http.StatusCreated, echo.Map{
+		"upload_id":  upload.ID,
+		"file_id":    upload.FileID,
+		"version":    upload.Version,
+		"part_size":  upload.PartSize,
+		"part_count": upload.PartCount(),
+	})
+}
+
+func (h *MultipartHandler) PartURLs(c echo.Context) error {
+	userID := c.Get("userID").(string)
+
+	req := struct {
+		PartNumbers []int `json:"part_numbers"`
+	}{}
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, echo.Map{"error": "invalid request"})
+	}
+
+	urls, err := h.FileService.MultipartPartURLs(c.Request().Context(), userID, c.Param("id"), req.PartNumbers)
+	if err != nil {
+		return multipartError(c, err)
+	}
+
+	numbers := make([]int, 0, len(urls))
+	for n := range urls {
+		numbers = append(numbers, n)
+	}
+	sort.Ints(numbers)
+
+	parts := make([]echo.Map, 0, len(urls))
+	for _, n := range numbers {
+		parts = append(parts, echo.Map{"part_number": n, "url": urls[n]})
+	}
+	return c.JSON(http.StatusOK, echo.Map{"parts": parts})
+}
+
+func (h *MultipartHandler) Status(c echo.Context) error {
+	userID := c.Get("userID").(string)
+
+	upload, parts, err := h.FileService.MultipartStatus(c.Request().Context(), userID, c.Param("id"))
+	if err != nil {
+		return multipartError(c, err)
+	}
+
+	return c.JSON(http.StatusOK, echo.Map{
+		"upload_id":  upload.ID,
+		"file_id":    upload.FileID,
+		"size":       upload.Size,
+		"part_size":  upload.PartSize,
+		"part_count": upload.PartCount(),
+		"parts":      parts,
+	})
+}
+
+func (h *MultipartHandler) Complete(c echo.Context) error {
+	userID := c.Get("userID").(string)
+
+	req := struct {
+		Parts []storage.Part `json:"parts"`
+	}{}
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, echo.Map{"error": "invalid request"})
+	}
+
+	upload, err := h.FileService.CompleteMultipart(c.Request().Context(), userID, c.Param("id"), req.Parts)
+	if err != nil {
+		return multipartError(c, err)
+	}
+
+	return c.JSON(http.StatusOK, echo.Map{"status": "uploaded", "file_id": upload.FileID, "version": upload.Version})
+}
+
+func (h *MultipartHandler) Abort(c echo.Context) error {
+	userID := c.Get("userID").(string)
+
+	if err := h.FileService.AbortMultipart(c.Request().Context(), userID, c.Param("id")); err != nil {
+		return multipartError(c, err)
+	}
+
+	return c.JSON(http.StatusOK, echo.Map{"status": "aborted"})
+}
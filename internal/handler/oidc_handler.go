@@ -0,0 +1,145 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+
+	"github.com/SrabanMondal/SecureStore/internal/oidc"
+	"github.com/SrabanMondal/SecureStore/internal/services"
+)
+
+// oidcBindingCookie holds the binding of the login this browser started, so
+// only this browser can complete it.
+const oidcBindingCookie = "oidc_binding"
+
+type OIDCHandler struct {
+	OIDC *services.OIDCService
+}
+
+func NewOIDCHandler(oidcSvc *services.OIDCService) *OIDCHandler {
+	return &OIDCHandler{OIDC: oidcSvc}
+}
+
+// oidcError answers failed OpenID Connect logins, and anything else with 500.
+func oidcError(c echo.Context, err error) error {
+	switch {
+	case errors.Is(err, services.ErrUnknownProvider):
+		return c.JSON(http.StatusNotFound, echo.Map{"error": err.Error()})
+	case errors.Is(err, services.ErrInvalidOIDCState):
+		return c.JSON(http.StatusBadRequest, echo.Map{"error": err.Error()})
+	case errors.Is(err, oidc.ErrExchange), errors.Is(err, oidc.ErrInvalidIDToken):
+		return c.JSON(http.StatusUnauthorized, echo.Map{"error": "the identity provider did not confirm the login"})
+	case errors.Is(err, services.ErrOIDCStaleLogin):
+		return c.JSON(http.StatusUnauthorized, echo.Map{"error": err.Error()})
+	case errors.Is(err, oidc.ErrDiscovery):
+		return c.JSON(http.StatusBadGateway, echo.Map{"error": "the identity provider is unavailable"})
+	case errors.Is(err, services.ErrOIDCEmailNotVerified), errors.Is(err, services.ErrOIDCDomainNotAllowed),
+		errors.Is(err, services.ErrOIDCNoAccount), errors.Is(err, services.ErrAccountSuspended),
+		errors.Is(err, services.ErrOIDCOtherAccount):
+		return c.JSON(http.StatusForbidden, echo.Map{"error": err.Error()})
+	case errors.Is(err, services.ErrOIDCAccountExists), errors.Is(err, services.ErrOIDCAccountUnverified):
+		return c.JSON(http.StatusConflict, echo.Map{"error": err.Error()})
+	default:
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "login failed"})
+	}
+}
+
+func (h *OIDCHandler) Providers(c echo.Context) error {
+	return c.JSON(http.StatusOK, echo.Map{"providers": h.OIDC.ProviderNames()})
+}
+
+// Login sends the browser to the provider to sign in. The provider returns
+// it to the configured redirect URL with a code and state for Callback.
+func (h *OIDCHandler) Login(c echo.Context) error {
+	url, binding, err := h.OIDC.LoginURL(c.Request().Context(), c.Param("provider"))
+	if err != nil {
+		return oidcError(c, err)
+	}
+	setBindingCookie(c, binding, int(services.OIDCStateTTL.Seconds()))
+	return c.Redirect(http.StatusFound, url)
+}
+
+// setBindingCookie sets the login binding, or clears it when maxAge is
+// negative. It is sent back only to the OIDC routes, and not with requests
+// other sites make.
+func setBindingCookie(c echo.Context, binding string, maxAge int) {
+	c.SetCookie(&http.Cookie{
+		Name:     oidcBindingCookie,
+		Value:    binding,
+		Path:     "/api/oidc",
+		MaxAge:   maxAge,
+		HttpOnly: true,
+		Secure:   c.Scheme() == "https",
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// Callback completes the login with the code and state the provider
+// redirected back with, answering like Login on /api/login. It must come
+// from the browser Login set the binding cookie in.
+func (h *OIDCHandler) Callback(c echo.Context) error {
+	var req struct {
+		Code  string `json:"code"`
+		State string `json:"state"`
+	}
+	if err := c.Bind(&req); err != nil || req.Code == "" || req.State == "" {
+		return c.JSON(http.StatusBadRequest, echo.Map{"error": "code and state are required"})
+	}
+
+	// a login without the cookie was not started by this browser
+	var binding string
+	if cookie, err := c.Cookie(oidcBindingCookie); err == nil {
+		binding = cookie.Value
+	}
+	setBindingCookie(c, "", -1)
+
+	result, err := h.OIDC.Callback(c.Request().Context(), c.Param("provider"), req.Code, req.State, binding)
+	if err != nil {
+		return oidcError(c, err)
+	}
+	return c.JSON(http.StatusOK, result)
+}
+
+// Reauth starts a sign-in at the provider for the caller to confirm who they
+// are, as users without a password must before deleting their account or
+// turning off MFA. It answers with the URL to send the browser to; the
+// provider returns it to the redirect URL as for a login, and the code and
+// state go to ReauthCallback instead of Callback.
+func (h *OIDCHandler) Reauth(c echo.Context) error {
+	userID := c.Get("userID").(string)
+
+	url, binding, err := h.OIDC.ReauthURL(c.Request().Context(), c.Param("provider"), userID)
+	if err != nil {
+		return oidcError(c, err)
+	}
+	setBindingCookie(c, binding, int(services.OIDCStateTTL.Seconds()))
+	return c.JSON(http.StatusOK, echo.Map{"url": url})
+}
+
+// ReauthCallback completes a sign-in started by Reauth, from the same
+// browser and as the same user.
+func (h *OIDCHandler) ReauthCallback(c echo.Context) error {
+	userID := c.Get("userID").(string)
+
+	var req struct {
+		Code  string `json:"code"`
+		State string `json:"state"`
+	}
+	if err := c.Bind(&req); err != nil || req.Code == "" || req.State == "" {
+		return c.JSON(http.StatusBadRequest, echo.Map{"error": "code and state are required"})
+	}
+
+	var binding string
+	if cookie, err := c.Cookie(oidcBindingCookie); err == nil {
+		binding = cookie.Value
+	}
+	setBindingCookie(c, "", -1)
+
+	err := h.OIDC.Reauthenticate(c.Request().Context(), userID, c.Param("provider"), req.Code, req.State, binding)
+	if err != nil {
+		return oidcError(c, err)
+	}
+	return c.NoContent(http.StatusNoContent)
+}
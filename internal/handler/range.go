@@ -0,0 +1,142 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+
+	"github.com/SrabanMondal/SecureStore/internal/models"
+	"github.com/SrabanMondal/SecureStore/internal/services"
+)
+
+var errRangeNotSatisfiable = errors.New("range not satisfiable")
+
+type byteRange struct {
+	start  int64
+	length int64
+}
+
+// parseRange parses a single "bytes=" range against size. ok is false when the
+// header should be ignored and the full content served (absent, malformed or
+// multiple ranges).
+func parseRange(header string, size int64) (r byteRange, ok bool, err error) {
+	const prefix = "bytes="
+	if header == "" || !strings.HasPrefix(header, prefix) {
+		return r, false, nil
+	}
+	spec := strings.TrimSpace(header[len(prefix):])
+	if strings.Contains(spec, ",") {
+		return r, false, nil
+	}
+
+	startStr, endStr, found := strings.Cut(spec, "-")
+	if !found {
+		return r, false, nil
+	}
+	startStr, endStr = strings.TrimSpace(startStr), strings.TrimSpace(endStr)
+
+	if startStr == "" {
+		// suffix range: last N bytes
+		n, perr := strconv.ParseInt(endStr, 10, 64)
+		if perr != nil || n < 0 {
+			return r, false, nil
+		}
+		if n == 0 || size == 0 {
+			return r, true, errRangeNotSatisfiable
+		}
+		if n > size {
+			n = size
+		}
+		return byteRange{start: size - n, length: n}, true, nil
+	}
+
+	start, perr := strconv.ParseInt(startStr, 10, 64)
+	if perr != nil || start < 0 {
+		return r, false, nil
+	}
+	if start >= size {
+		return r, true, errRangeNotSatisfiable
+	}
+
+	end := size - 1
+	if endStr != "" {
+		e, perr := strconv.ParseInt(endStr, 10, 64)
+		if perr != nil || e < start {
+			return r, false, nil
+		}
+		if e < end {
+			end = e
+		}
+	}
+	return byteRange{start: start, length: end - start + 1}, true, nil
+}
+
+// ifRangeMatches reports whether a Range header may be honoured given the
+// If-Range precondition, which holds either a strong ETag or an HTTP date.
+func ifRangeMatches(ifRange, etag string, lastModified time.Time) bool {
+	if ifRange == "" {
+		return true
+	}
+	if strings.HasPrefix(ifRange, `"`) {
+		return ifRange == etag
+	}
+	t, err := http.ParseTime(ifRange)
+	if err != nil {
+		return false
+	}
+	return !lastModified.Truncate(time.Second).After(t)
+}
+
+// serveDecrypted streams an encrypted file to the client, answering GET range
+// requests with 206 and only fetching the chunks that cover the range.
+func serveDecrypted(c echo.Context, fileSvc *services.FileService, file *models.File) error {
+	ctx := c.Request().Context()
+	req := c.Request()
+	res := c.Response()
+
+	obj, err := fileSvc.StatDecrypted(ctx, file)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": err.Error()})
+	}
+
+	etag := `"` + strings.Trim(obj.ETag, `"`) + `"`
+	res.Header().Set("Accept-Ranges", "bytes")
+	res.Header().Set("ETag", etag)
+	res.Header().Set("Last-Modified", obj.LastModified.UTC().Format(http.TimeFormat))
+
+	rng, partial := byteRange{length: obj.Size}, false
+	if req.Method == http.MethodGet && ifRangeMatches(req.Header.Get("If-Range"), etag, obj.LastModified) {
+		r, ok, err := parseRange(req.Header.Get("Range"), obj.Size)
+		if err != nil {
+			res.Header().Set("Content-Range", fmt.Sprintf("bytes */%d", obj.Size))
+			return c.JSON(http.StatusRequestedRangeNotSatisfiable, echo.Map{"error": err.Error()})
+		}
+		if ok {
+			rng, partial = r, true
+		}
+	}
+
+	status := http.StatusOK
+	var body io.ReadCloser
+	if partial {
+		body, err = fileSvc.DownloadDecryptRange(ctx, file, obj, rng.start, rng.length)
+		status = http.StatusPartialContent
+		res.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", rng.start, rng.start+rng.length-1, obj.Size))
+	} else {
+		body, err = fileSvc.DownloadDecrypt(ctx, file)
+	}
+	if err != nil {
+		res.Header().Del("Content-Range")
+		return keyError(c, err)
+	}
+	defer body.Close()
+
+	res.Header().Set("Content-Length", strconv.FormatInt(rng.length, 10))
+	return c.Stream(status, "application/octet-stream", body)
+}
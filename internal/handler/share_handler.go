@@ -1,12 +1,13 @@
 package handlers
 
 import (
-	"context"
+	"errors"
 	"net/http"
 	"time"
 
 	"github.com/labstack/echo/v4"
 
+	"github.com/SrabanMondal/SecureStore/internal/models"
 	"github.com/SrabanMondal/SecureStore/internal/services"
 	"github.com/SrabanMondal/SecureStore/internal/utils"
 )
@@ -20,6 +21,8 @@ func NewShareHandler(shareSvc *services.ShareService) *ShareHandler {
 }
 
 func (h *ShareHandler) CreateShareLink(c echo.Context) error {
+	userID := c.Get("userID").(string)
+
 	type reqBody struct {
 		FileID   string `json:"file_id" validate:"required"`
 		Expiry   int    `json:"expiry_hours" validate:"required,min=1"`
@@ -32,13 +35,17 @@ func (h *ShareHandler) CreateShareLink(c echo.Context) error {
 	}
 
 	ctx := c.Request().Context()
-	share, err := h.ShareSvc.CreateShareLink(ctx, body.FileID, time.Duration(body.Expiry)*time.Hour, body.Password)
+	share, err := h.ShareSvc.CreateShareLink(ctx, userID, body.FileID, time.Duration(body.Expiry)*time.Hour, body.Password)
+	if errors.Is(err, services.ErrFileNotFound) {
+		return c.JSON(http.StatusNotFound, echo.Map{"error": err.Error()})
+	}
 	if err != nil {
 		utils.Error.Err(err).Msg("create share link failed")
 		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "could not create share link"})
 	}
 
 	return c.JSON(http.StatusOK, echo.Map{
+		"id":         share.ID,
 		"token":      share.ShareToken,
 		"expires_at": share.ExpiresAt,
 	})
@@ -56,15 +63,30 @@ func (h *ShareHandler) AccessShareLink(c echo.Context) error {
 		return c.JSON(http.StatusForbidden, echo.Map{"error": err.Error()})
 	}
 
-	content, err := h.ShareSvc.GetDownloadContent(ctx, file)
+	return h.sendFile(c, file)
+}
+
+func (h *ShareHandler) sendFile(c echo.Context, file *models.File) error {
+	if file.IsEncrypted {
+		return serveDecrypted(c, h.ShareSvc.FileSvc, file)
+	}
+
+	content, err := h.ShareSvc.GetDownloadContent(c.Request().Context(), file)
 	if err != nil {
 		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "download failed"})
 	}
 
 	switch v := content.(type) {
-	case []byte:
-		return c.Blob(http.StatusOK, "application/octet-stream", v)
 	case string:
+		if file.EncryptionMode == models.EncryptionClient {
+			// the file key is in the link's URL fragment, never sent here;
+			// the recipient only needs the IV and algorithm to decrypt
+			return c.JSON(http.StatusOK, echo.Map{
+				"download_url":    v,
+				"encryption_mode": file.EncryptionMode,
+				"client_key":      echo.Map{"iv": file.ClientKey.IV, "algorithm": file.ClientKey.Algorithm},
+			})
+		}
 		return c.JSON(http.StatusOK, echo.Map{"download_url": v})
 	default:
 		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "unexpected content type"})
@@ -88,24 +110,15 @@ func (h *ShareHandler) ValidatePassword(c echo.Context) error {
 		return c.JSON(http.StatusForbidden, echo.Map{"error": err.Error()})
 	}
 
-	content, err := h.ShareSvc.GetDownloadContent(ctx, file)
-	if err != nil {
-		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "download failed"})
-	}
-
-	switch v := content.(type) {
-	case []byte:
-		return c.Blob(http.StatusOK, "application/octet-stream", v)
-	case string:
-		return c.JSON(http.StatusOK, echo.Map{"download_url": v})
-	default:
-		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "unexpected content type"})
-	}
+	return h.sendFile(c, file)
 }
 
 func (h* ShareHandler) DeleteLink(c echo.Context) error {
-	token := c.Param("id")
-	err:= h.ShareSvc.DeleteLink(context.Background(),token)
+	userID := c.Get("userID").(string)
+	err:= h.ShareSvc.DeleteLink(c.Request().Context(), userID, c.Param("id"))
+	if errors.Is(err, services.ErrShareNotFound) {
+		return c.JSON(http.StatusNotFound, echo.Map{"error": err.Error()})
+	}
 	if err!=nil{
 		return c.JSON(http.StatusInternalServerError, echo.Map{"error": err.Error()})
 	}
@@ -0,0 +1,85 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+
+	"github.com/labstack/echo/v4"
+
+	"github.com/SrabanMondal/SecureStore/internal/storage"
+)
+
+// StorageHandler serves presigned URLs issued by the local filesystem backend.
+type StorageHandler struct {
+	Local *storage.LocalBackend
+}
+
+func NewStorageHandler(local *storage.LocalBackend) *StorageHandler {
+	return &StorageHandler{Local: local}
+}
+
+func (h *StorageHandler) verify(c echo.Context) (string, error) {
+	key, err := url.PathUnescape(c.Param("*"))
+	if err != nil {
+		return "", err
+	}
+	err = h.Local.Verify(c.Request().Method, key, c.QueryParams())
+	return key, err
+}
+
+func (h *StorageHandler) Upload(c echo.Context) error {
+	key, err := h.verify(c)
+	if err != nil {
+		return c.JSON(http.StatusForbidden, echo.Map{"error": err.Error()})
+	}
+
+	req := c.Request()
+	if uploadID := c.QueryParam("upload_id"); uploadID != "" {
+		partNumber, err := strconv.Atoi(c.QueryParam("part_number"))
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, echo.Map{"error": "invalid part number"})
+		}
+		part, err := h.Local.UploadPart(req.Context(), key, uploadID, partNumber, req.Body, req.ContentLength)
+		if errors.Is(err, storage.ErrUploadNotFound) {
+			return c.JSON(http.StatusNotFound, echo.Map{"error": err.Error()})
+		}
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, echo.Map{"error": err.Error()})
+		}
+		c.Response().Header().Set("ETag", `"`+part.ETag+`"`)
+		return c.NoContent(http.StatusOK)
+	}
+
+	if err := h.Local.Put(req.Context(), key, req.Body, req.ContentLength, req.Header.Get("Content-Type")); err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": err.Error()})
+	}
+
+	return c.NoContent(http.StatusOK)
+}
+
+func (h *StorageHandler) Download(c echo.Context) error {
+	key, err := h.verify(c)
+	if err != nil {
+		return c.JSON(http.StatusForbidden, echo.Map{"error": err.Error()})
+	}
+
+	f, err := h.Local.Open(key)
+	if errors.Is(err, storage.ErrNotFound) {
+		return c.JSON(http.StatusNotFound, echo.Map{"error": "object not found"})
+	}
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": err.Error()})
+	}
+	defer f.Close()
+
+	fi, err := f.Stat()
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": err.Error()})
+	}
+
+	http.ServeContent(c.Response(), c.Request(), path.Base(key), fi.ModTime(), f)
+	return nil
+}
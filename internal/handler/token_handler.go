@@ -0,0 +1,73 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/labstack/echo/v4"
+
+	"github.com/SrabanMondal/SecureStore/internal/services"
+)
+
+type TokenHandler struct {
+	Tokens *services.TokenService
+}
+
+func NewTokenHandler(tokens *services.TokenService) *TokenHandler {
+	return &TokenHandler{Tokens: tokens}
+}
+
+func (h *TokenHandler) List(c echo.Context) error {
+	userID := c.Get("userID").(string)
+
+	tokens, err := h.Tokens.ListPersonalTokens(c.Request().Context(), userID)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": err.Error()})
+	}
+	return c.JSON(http.StatusOK, tokens)
+}
+
+// Create issues a personal access token. The token is in the response only
+// this once.
+func (h *TokenHandler) Create(c echo.Context) error {
+	userID := c.Get("userID").(string)
+
+	req := struct {
+		Name   string   `json:"name"`
+		Scopes []string `json:"scopes"`
+		// optional; the token never expires without it
+		ExpiresInDays int `json:"expires_in_days"`
+	}{}
+	if err := c.Bind(&req); err != nil || req.ExpiresInDays < 0 {
+		return c.JSON(http.StatusBadRequest, echo.Map{"error": "invalid request"})
+	}
+	var expiresAt *time.Time
+	if req.ExpiresInDays > 0 {
+		t := time.Now().AddDate(0, 0, req.ExpiresInDays)
+		expiresAt = &t
+	}
+
+	token, err := h.Tokens.CreatePersonalToken(c.Request().Context(), userID, req.Name, req.Scopes, expiresAt)
+	switch {
+	case errors.Is(err, services.ErrInvalidTokenName), errors.Is(err, services.ErrInvalidScope),
+		errors.Is(err, services.ErrInvalidTokenExpiry):
+		return c.JSON(http.StatusBadRequest, echo.Map{"error": err.Error()})
+	case err != nil:
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": err.Error()})
+	}
+	return c.JSON(http.StatusCreated, token)
+}
+
+func (h *TokenHandler) Revoke(c echo.Context) error {
+	userID := c.Get("userID").(string)
+
+	err := h.Tokens.RevokePersonalToken(c.Request().Context(), userID, c.Param("id"))
+	if errors.Is(err, services.ErrTokenNotFound) {
+		return c.JSON(http.StatusNotFound, echo.Map{"error": err.Error()})
+	}
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": err.Error()})
+	}
+	return c.NoContent(http.StatusNoContent)
+}
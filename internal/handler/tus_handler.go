@@ -0,0 +1,203 @@
+package handlers
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+
+	"github.com/SrabanMondal/SecureStore/internal/models"
+	"github.com/SrabanMondal/SecureStore/internal/services"
+	"github.com/SrabanMondal/SecureStore/internal/utils"
+)
+
+const (
+	tusVersion    = "1.0.0"
+	tusExtensions = "creation,termination,checksum,expiration"
+
+	// not in net/http; defined by the tus checksum extension
+	statusChecksumMismatch = 460
+)
+
+// TusHandler implements the tus 1.0.0 resumable upload protocol.
+type TusHandler struct {
+	TusSvc *services.TusService
+}
+
+func NewTusHandler(tusSvc *services.TusService) *TusHandler {
+	return &TusHandler{TusSvc: tusSvc}
+}
+
+// TusResumable sets Tus-Resumable on every response and rejects requests
+// speaking another protocol version. OPTIONS is exempt as the spec requires.
+func TusResumable(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		c.Response().Header().Set("Tus-Resumable", tusVersion)
+		if c.Request().Method != http.MethodOptions && c.Request().Header.Get("Tus-Resumable") != tusVersion {
+			c.Response().Header().Set("Tus-Version", tusVersion)
+			return c.NoContent(http.StatusPreconditionFailed)
+		}
+		return next(c)
+	}
+}
+
+func tusError(c echo.Context, err error) error {
+	switch {
+	case errors.Is(err, services.ErrUploadNotFound):
+		return c.String(http.StatusNotFound, err.Error())
+	case errors.Is(err, services.ErrUploadExpired):
+		return c.String(http.StatusGone, err.Error())
+	case errors.Is(err, services.ErrOffsetMismatch), errors.Is(err, services.ErrUploadFinished),
+		errors.Is(err, services.ErrFileBusy), errors.Is(err, services.ErrPathConflict):
+		return c.String(http.StatusConflict, err.Error())
+	case errors.Is(err, services.ErrChecksumMismatch):
+		return c.String(statusChecksumMismatch, err.Error())
+	case errors.Is(err, services.ErrUploadTooLarge), errors.Is(err, services.ErrInvalidSize),
+		errors.Is(err, services.ErrFileTooLarge):
+		return c.String(http.StatusRequestEntityTooLarge, err.Error())
+	case errors.Is(err, services.ErrQuotaExceeded):
+		return c.String(http.StatusInsufficientStorage, err.Error())
+	case errors.Is(err, services.ErrUnsupportedAlgo), errors.Is(err, utils.ErrInvalidPath):
+		return c.String(http.StatusBadRequest, err.Error())
+	case errors.Is(err, services.ErrPassphraseRequired):
+		return c.String(http.StatusLocked, err.Error())
+	case errors.Is(err, services.ErrWrongPassphrase):
+		return c.String(http.StatusForbidden, err.Error())
+	case errors.Is(err, services.ErrKeyDestroyed):
+		return c.String(http.StatusGone, err.Error())
+	default:
+		return c.String(http.StatusInternalServerError, err.Error())
+	}
+}
+
+// parseMetadata decodes an Upload-Metadata header: comma separated
+// "key base64value" pairs, where the value may be omitted.
+func parseMetadata(header string) (map[string]string, error) {
+	meta := map[string]string{}
+	if strings.TrimSpace(header) == "" {
+		return meta, nil
+	}
+	for _, pair := range strings.Split(header, ",") {
+		key, val, _ := strings.Cut(strings.TrimSpace(pair), " ")
+		if key == "" {
+			return nil, errors.New("invalid Upload-Metadata")
+		}
+		decoded, err := base64.StdEncoding.DecodeString(val)
+		if err != nil {
+			return nil, fmt.Errorf("invalid Upload-Metadata value for %q", key)
+		}
+		meta[key] = string(decoded)
+	}
+	return meta, nil
+}
+
+func setUploadHeaders(c echo.Context, upload *models.TusUpload) {
+	h := c.Response().Header()
+	h.Set("Upload-Offset", strconv.FormatInt(upload.Offset, 10))
+	if upload.FileID == nil {
+		h.Set("Upload-Expires", upload.ExpiresAt.UTC().Format(http.TimeFormat))
+	}
+}
+
+func (h *TusHandler) Options(c echo.Context) error {
+	hdr := c.Response().Header()
+	hdr.Set("Tus-Resumable", tusVersion)
+	hdr.Set("Tus-Version", tusVersion)
+	hdr.Set("Tus-Extension", tusExtensions)
+	hdr.Set("Tus-Max-Size", strconv.FormatInt(services.TusMaxSize, 10))
+	hdr.Set("Tus-Checksum-Algorithm", services.TusChecksumAlgs)
+	return c.NoContent(http.StatusNoContent)
+}
+
+func (h *TusHandler) Create(c echo.Context) error {
+	userID := c.Get("userID").(string)
+	req := c.Request()
+
+	length, err := strconv.ParseInt(req.Header.Get("Upload-Length"), 10, 64)
+	if err != nil || length < 0 {
+		return c.String(http.StatusBadRequest, "missing or invalid Upload-Length")
+	}
+
+	rawMeta := req.Header.Get("Upload-Metadata")
+	meta, err := parseMetadata(rawMeta)
+	if err != nil {
+		return c.String(http.StatusBadRequest, err.Error())
+	}
+	filePath := meta["file_path"]
+	if filePath == "" {
+		filePath = meta["filename"]
+	}
+	if filePath == "" {
+		return c.String(http.StatusBadRequest, "Upload-Metadata must include file_path or filename")
+	}
+	encrypted, _ := strconv.ParseBool(meta["encrypted"])
+
+	upload, err := h.TusSvc.Create(req.Context(), userID, filePath, length, rawMeta, encrypted)
+	if err != nil {
+		return tusError(c, err)
+	}
+
+	c.Response().Header().Set("Location", "/api/tus/"+upload.ID)
+	setUploadHeaders(c, upload)
+	return c.NoContent(http.StatusCreated)
+}
+
+func (h *TusHandler) Head(c echo.Context) error {
+	userID := c.Get("userID").(string)
+
+	upload, err := h.TusSvc.Get(c.Request().Context(), userID, c.Param("id"))
+	if err != nil {
+		return tusError(c, err)
+	}
+
+	hdr := c.Response().Header()
+	hdr.Set("Cache-Control", "no-store")
+	hdr.Set("Upload-Length", strconv.FormatInt(upload.Length, 10))
+	if upload.Metadata != "" {
+		hdr.Set("Upload-Metadata", upload.Metadata)
+	}
+	setUploadHeaders(c, upload)
+	return c.NoContent(http.StatusOK)
+}
+
+func (h *TusHandler) Patch(c echo.Context) error {
+	userID := c.Get("userID").(string)
+	req := c.Request()
+
+	if req.Header.Get("Content-Type") != "application/offset+octet-stream" {
+		return c.String(http.StatusUnsupportedMediaType, "Content-Type must be application/offset+octet-stream")
+	}
+	offset, err := strconv.ParseInt(req.Header.Get("Upload-Offset"), 10, 64)
+	if err != nil || offset < 0 {
+		return c.String(http.StatusBadRequest, "missing or invalid Upload-Offset")
+	}
+
+	var checksum *services.Checksum
+	if hdr := req.Header.Get("Upload-Checksum"); hdr != "" {
+		checksum, err = services.ParseChecksum(hdr)
+		if err != nil {
+			return c.String(http.StatusBadRequest, err.Error())
+		}
+	}
+
+	upload, err := h.TusSvc.WriteChunk(req.Context(), userID, c.Param("id"), offset, req.ContentLength, req.Body, checksum)
+	if err != nil {
+		return tusError(c, err)
+	}
+
+	setUploadHeaders(c, upload)
+	return c.NoContent(http.StatusNoContent)
+}
+
+func (h *TusHandler) Terminate(c echo.Context) error {
+	userID := c.Get("userID").(string)
+
+	if err := h.TusSvc.Terminate(c.Request().Context(), userID, c.Param("id")); err != nil {
+		return tusError(c, err)
+	}
+	return c.NoContent(http.StatusNoContent)
+}
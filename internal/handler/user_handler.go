@@ -0,0 +1,189 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+
+	"github.com/SrabanMondal/SecureStore/internal/services"
+)
+
+// KeyPassphrase hands the X-Key-Passphrase header to the services through the
+// request context, for accounts whose key is passphrase protected.
+func KeyPassphrase(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		if p := c.Request().Header.Get("X-Key-Passphrase"); p != "" {
+			req := c.Request()
+			c.SetRequest(req.WithContext(services.WithPassphrase(req.Context(), p)))
+		}
+		return next(c)
+	}
+}
+
+// keyError answers failures to open a user's key, and anything else with 500.
+func keyError(c echo.Context, err error) error {
+	switch {
+	case errors.Is(err, services.ErrPassphraseRequired):
+		return c.JSON(http.StatusLocked, echo.Map{"error": err.Error()})
+	case errors.Is(err, services.ErrWrongPassphrase):
+		return c.JSON(http.StatusForbidden, echo.Map{"error": err.Error()})
+	case errors.Is(err, services.ErrKeyDestroyed):
+		return c.JSON(http.StatusGone, echo.Map{"error": err.Error()})
+	case errors.Is(err, services.ErrWeakPassphrase):
+		return c.JSON(http.StatusBadRequest, echo.Map{"error": err.Error()})
+	default:
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": err.Error()})
+	}
+}
+
+type UserHandler struct {
+	AuthService *services.AuthService
+	UserKeys    *services.UserKeyService
+}
+
+func NewUserHandler(authService *services.AuthService, userKeys *services.UserKeyService) *UserHandler {
+	return &UserHandler{AuthService: authService, UserKeys: userKeys}
+}
+
+func (h *UserHandler) GetKey(c echo.Context) error {
+	userID := c.Get("userID").(string)
+
+	key, err := h.UserKeys.Key(c.Request().Context(), userID)
+	if err != nil {
+		return keyError(c, err)
+	}
+	return c.JSON(http.StatusOK, echo.Map{
+		"passphrase_protected": key.PassphraseProtected(),
+		"created_at":           key.CreatedAt,
+		"updated_at":           key.UpdatedAt,
+	})
+}
+
+// SetPassphrase adds, changes or, with an empty new_passphrase, removes the
+// passphrase protecting the caller's key.
+func (h *UserHandler) SetPassphrase(c echo.Context) error {
+	userID := c.Get("userID").(string)
+
+	req := struct {
+		CurrentPassphrase string `json:"current_passphrase"`
+		NewPassphrase     string `json:"new_passphrase"`
+	}{}
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, echo.Map{"error": "invalid request"})
+	}
+
+	key, err := h.UserKeys.SetPassphrase(c.Request().Context(), userID, req.CurrentPassphrase, req.NewPassphrase)
+	if err != nil {
+		return keyError(c, err)
+	}
+	return c.JSON(http.StatusOK, echo.Map{
+		"passphrase_protected": key.PassphraseProtected(),
+		"updated_at":           key.UpdatedAt,
+	})
+}
+
+// DeleteAccount deletes the caller's account after confirming their password,
+// or for users without one, that they have just signed in again at their
+// identity provider.
+// Their key is destroyed at once, so their files cannot be decrypted again.
+func (h *UserHandler) DeleteAccount(c echo.Context) error {
+	userID := c.Get("userID").(string)
+
+	req := struct {
+		Password string `json:"password"`
+	}{}
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, echo.Map{"error": "invalid request"})
+	}
+
+	err := h.AuthService.DeleteAccount(c.Request().Context(), userID, req.Password)
+	if errors.Is(err, services.ErrInvalidCredentials) {
+		return c.JSON(http.StatusUnauthorized, echo.Map{"error": "invalid credentials"})
+	}
+	if errors.Is(err, services.ErrReauthRequired) {
+		return c.JSON(http.StatusUnauthorized, echo.Map{"error": err.Error(), "code": "reauth_required"})
+	}
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": err.Error()})
+	}
+	return c.NoContent(http.StatusNoContent)
+}
+
+// mfaError answers MFA failures, and anything else with 500.
+func mfaError(c echo.Context, err error) error {
+	switch {
+	case errors.Is(err, services.ErrInvalidCredentials), errors.Is(err, services.ErrInvalidMFACode):
+		return c.JSON(http.StatusUnauthorized, echo.Map{"error": err.Error()})
+	case errors.Is(err, services.ErrReauthRequired):
+		return c.JSON(http.StatusUnauthorized, echo.Map{"error": err.Error(), "code": "reauth_required"})
+	case errors.Is(err, services.ErrMFAEnabled), errors.Is(err, services.ErrMFANotEnabled),
+		errors.Is(err, services.ErrMFANotEnrolled):
+		return c.JSON(http.StatusConflict, echo.Map{"error": err.Error()})
+	case errors.Is(err, services.ErrUserNotFound):
+		return c.JSON(http.StatusNotFound, echo.Map{"error": err.Error()})
+	default:
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": err.Error()})
+	}
+}
+
+func (h *UserHandler) GetMFA(c echo.Context) error {
+	userID := c.Get("userID").(string)
+
+	status, err := h.AuthService.MFA.Status(c.Request().Context(), userID)
+	if err != nil {
+		return mfaError(c, err)
+	}
+	return c.JSON(http.StatusOK, status)
+}
+
+// EnrollTOTP starts TOTP enrollment and returns the secret and its otpauth
+// URI. MFA is enabled only once ConfirmTOTP sees a code from it.
+func (h *UserHandler) EnrollTOTP(c echo.Context) error {
+	userID := c.Get("userID").(string)
+
+	enrollment, err := h.AuthService.MFA.Enroll(c.Request().Context(), userID)
+	if err != nil {
+		return mfaError(c, err)
+	}
+	return c.JSON(http.StatusOK, enrollment)
+}
+
+// ConfirmTOTP enables MFA and returns the recovery codes, the only time they
+// are shown.
+func (h *UserHandler) ConfirmTOTP(c echo.Context) error {
+	userID := c.Get("userID").(string)
+
+	req := struct {
+		Code string `json:"code"`
+	}{}
+	if err := c.Bind(&req); err != nil || req.Code == "" {
+		return c.JSON(http.StatusBadRequest, echo.Map{"error": "code is required"})
+	}
+
+	codes, err := h.AuthService.MFA.Confirm(c.Request().Context(), userID, req.Code)
+	if err != nil {
+		return mfaError(c, err)
+	}
+	return c.JSON(http.StatusOK, echo.Map{"enabled": true, "recovery_codes": codes})
+}
+
+// DisableMFA turns MFA off after confirming the caller's password (or fresh
+// sign-in at their identity provider, as for DeleteAccount) and a current or
+// recovery code.
+func (h *UserHandler) DisableMFA(c echo.Context) error {
+	userID := c.Get("userID").(string)
+
+	req := struct {
+		Password string `json:"password"`
+		Code     string `json:"code"`
+	}{}
+	if err := c.Bind(&req); err != nil || req.Code == "" {
+		return c.JSON(http.StatusBadRequest, echo.Map{"error": "password and code are required"})
+	}
+
+	if err := h.AuthService.DisableMFA(c.Request().Context(), userID, req.Password, req.Code); err != nil {
+		return mfaError(c, err)
+	}
+	return c.NoContent(http.StatusNoContent)
+}
@@ -0,0 +1,45 @@
+// Package kms wraps and unwraps per-object data keys with key encryption keys
+// that live in a key management service, so the service never needs the
+// master keys themselves.
+package kms
+
+import (
+	"context"
+	"errors"
+)
+
+var (
+	ErrUnknownKey = errors.New("unknown key encryption key")
+	ErrDecrypt    = errors.New("failed to unwrap data key")
+)
+
+// DataKey is a freshly generated data key, in the clear for immediate use and
+// wrapped for storage.
+type DataKey struct {
+	KeyID      string
+	Plaintext  []byte
+	Ciphertext []byte
+}
+
+// Provider generates and wraps data keys, and wraps keys generated by the
+// caller such as the per-user key encryption keys above them.
+type Provider interface {
+	// GenerateDataKey returns a new 256-bit data key wrapped under keyID.
+	GenerateDataKey(ctx context.Context, keyID string) (*DataKey, error)
+	// Encrypt wraps a data key under keyID.
+	Encrypt(ctx context.Context, keyID string, plaintext []byte) ([]byte, error)
+	// Decrypt unwraps a data key that keyID wrapped.
+	Decrypt(ctx context.Context, keyID string, ciphertext []byte) ([]byte, error)
+
+	ActiveKeyID() string
+	KeyIDs() []string
+	HasKey(keyID string) bool
+	// Algorithm names the wrapping scheme, recorded next to each wrapped key.
+	Algorithm() string
+}
+
+// LegacyKeyer is implemented by providers that hold the key objects were
+// sealed with directly, before data keys existed.
+type LegacyKeyer interface {
+	LegacyKey() []byte
+}
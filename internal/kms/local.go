@@ -0,0 +1,124 @@
+package kms
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/SrabanMondal/SecureStore/internal/utils"
+)
+
+// LocalProvider wraps data keys with AES-256-GCM under keys held in process,
+// loaded from the environment or a keyfile.
+type LocalProvider struct {
+	ring *utils.Keyring
+}
+
+func NewLocalProvider(ring *utils.Keyring) *LocalProvider {
+	return &LocalProvider{ring: ring}
+}
+
+// keyfile is the JSON layout read by LoadKeyfile. The first key is the
+// legacy key.
+type keyfile struct {
+	Active string `json:"active"`
+	Keys   []struct {
+		ID  string `json:"id"`
+		Key string `json:"key"`
+	} `json:"keys"`
+}
+
+// LoadKeyfile reads a keyring from a JSON file such as
+//
+//	{"active": "v2", "keys": [{"id": "v1", "key": "<base64>"}, {"id": "v2", "key": "<base64>"}]}
+//
+// The active key defaults to the last one listed.
+func LoadKeyfile(path string) (*utils.Keyring, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var kf keyfile
+	if err := json.Unmarshal(raw, &kf); err != nil {
+		return nil, fmt.Errorf("invalid keyfile %s: %w", path, err)
+	}
+	if len(kf.Keys) == 0 {
+		return nil, fmt.Errorf("keyfile %s has no keys", path)
+	}
+
+	ring := utils.NewKeyring()
+	for _, k := range kf.Keys {
+		key, err := base64.StdEncoding.DecodeString(k.Key)
+		if err != nil {
+			return nil, fmt.Errorf("invalid base64 for key %s: %w", k.ID, err)
+		}
+		if err := ring.Add(k.ID, key); err != nil {
+			return nil, err
+		}
+	}
+
+	active := kf.Active
+	if active == "" {
+		active = kf.Keys[len(kf.Keys)-1].ID
+	}
+	if err := ring.SetActive(active); err != nil {
+		return nil, err
+	}
+	return ring, nil
+}
+
+func (p *LocalProvider) GenerateDataKey(ctx context.Context, keyID string) (*DataKey, error) {
+	key, err := utils.NewDataKey()
+	if err != nil {
+		return nil, err
+	}
+	wrapped, err := p.Encrypt(ctx, keyID, key)
+	if err != nil {
+		return nil, err
+	}
+	return &DataKey{KeyID: keyID, Plaintext: key, Ciphertext: wrapped}, nil
+}
+
+func (p *LocalProvider) Encrypt(_ context.Context, keyID string, plaintext []byte) ([]byte, error) {
+	kek, ok := p.ring.Key(keyID)
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownKey, keyID)
+	}
+	return utils.WrapKey(kek, plaintext)
+}
+
+func (p *LocalProvider) Decrypt(_ context.Context, keyID string, ciphertext []byte) ([]byte, error) {
+	kek, ok := p.ring.Key(keyID)
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownKey, keyID)
+	}
+	key, err := utils.UnwrapKey(kek, ciphertext)
+	if err != nil {
+		return nil, ErrDecrypt
+	}
+	return key, nil
+}
+
+func (p *LocalProvider) ActiveKeyID() string {
+	id, _ := p.ring.Active()
+	return id
+}
+
+func (p *LocalProvider) KeyIDs() []string {
+	return p.ring.IDs()
+}
+
+func (p *LocalProvider) HasKey(keyID string) bool {
+	_, ok := p.ring.Key(keyID)
+	return ok
+}
+
+func (p *LocalProvider) Algorithm() string {
+	return utils.KeyWrapAES256GCM
+}
+
+func (p *LocalProvider) LegacyKey() []byte {
+	return p.ring.Legacy()
+}
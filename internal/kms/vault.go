@@ -0,0 +1,169 @@
+package kms
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// VaultTransitAlgorithm marks data keys wrapped by a Vault transit key; the
+// stored ciphertext is Vault's own "vault:vN:..." string.
+const VaultTransitAlgorithm = "vault-transit"
+
+// VaultProvider wraps data keys with the transit secrets engine of HashiCorp
+// Vault, or anything speaking the same HTTP API. Key IDs are transit key
+// names; Vault tracks key versions inside the ciphertext.
+type VaultProvider struct {
+	Addr      string
+	Token     string
+	Namespace string
+	Mount     string
+	Client    *http.Client
+
+	active string
+	keys   []string
+}
+
+// NewVaultProvider talks to the transit engine mounted at mount on addr.
+// active is the key new data keys are wrapped with; others are additional
+// key names that may still unwrap older data keys.
+func NewVaultProvider(addr, token, mount, active string, others ...string) *VaultProvider {
+	if mount == "" {
+		mount = "transit"
+	}
+	keys := []string{active}
+	for _, k := range others {
+		if k != "" && k != active {
+			keys = append(keys, k)
+		}
+	}
+	return &VaultProvider{
+		Addr:   strings.TrimRight(addr, "/"),
+		Token:  token,
+		Mount:  strings.Trim(mount, "/"),
+		Client: &http.Client{Timeout: 10 * time.Second},
+		active: active,
+		keys:   keys,
+	}
+}
+
+type vaultResponse struct {
+	Data struct {
+		Plaintext  string `json:"plaintext"`
+		Ciphertext string `json:"ciphertext"`
+	} `json:"data"`
+	Errors []string `json:"errors"`
+}
+
+func (p *VaultProvider) call(ctx context.Context, op, keyID string, body any) (*vaultResponse, error) {
+	if !p.HasKey(keyID) {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownKey, keyID)
+	}
+	payload, err := json.Marshal(body)
+	if err != nil {
+		return nil, err
+	}
+
+	endpoint := fmt.Sprintf("%s/v1/%s/%s/%s", p.Addr, p.Mount, op, url.PathEscape(keyID))
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(payload))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Vault-Token", p.Token)
+	if p.Namespace != "" {
+		req.Header.Set("X-Vault-Namespace", p.Namespace)
+	}
+
+	resp, err := p.Client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var out vaultResponse
+	raw, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return nil, err
+	}
+	if len(raw) > 0 {
+		if err := json.Unmarshal(raw, &out); err != nil {
+			return nil, fmt.Errorf("vault %s: invalid response: %w", op, err)
+		}
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("vault %s: %s: %s", op, resp.Status, strings.Join(out.Errors, "; "))
+	}
+	return &out, nil
+}
+
+// GenerateDataKey has Vault generate the key, returned both in the clear and
+// wrapped under keyID.
+func (p *VaultProvider) GenerateDataKey(ctx context.Context, keyID string) (*DataKey, error) {
+	out, err := p.call(ctx, "datakey/plaintext", keyID, map[string]any{"bits": 256})
+	if err != nil {
+		return nil, err
+	}
+	key, err := base64.StdEncoding.DecodeString(out.Data.Plaintext)
+	if err != nil || len(key) != 32 || out.Data.Ciphertext == "" {
+		return nil, fmt.Errorf("vault datakey: malformed data key")
+	}
+	return &DataKey{KeyID: keyID, Plaintext: key, Ciphertext: []byte(out.Data.Ciphertext)}, nil
+}
+
+func (p *VaultProvider) Encrypt(ctx context.Context, keyID string, plaintext []byte) ([]byte, error) {
+	out, err := p.call(ctx, "encrypt", keyID, map[string]any{
+		"plaintext": base64.StdEncoding.EncodeToString(plaintext),
+	})
+	if err != nil {
+		return nil, err
+	}
+	if out.Data.Ciphertext == "" {
+		return nil, fmt.Errorf("vault encrypt: empty ciphertext")
+	}
+	return []byte(out.Data.Ciphertext), nil
+}
+
+func (p *VaultProvider) Decrypt(ctx context.Context, keyID string, ciphertext []byte) ([]byte, error) {
+	out, err := p.call(ctx, "decrypt", keyID, map[string]any{"ciphertext": string(ciphertext)})
+	if errors.Is(err, ErrUnknownKey) {
+		return nil, err
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrDecrypt, err)
+	}
+	key, err := base64.StdEncoding.DecodeString(out.Data.Plaintext)
+	if err != nil {
+		return nil, ErrDecrypt
+	}
+	return key, nil
+}
+
+func (p *VaultProvider) ActiveKeyID() string {
+	return p.active
+}
+
+func (p *VaultProvider) KeyIDs() []string {
+	return append([]string(nil), p.keys...)
+}
+
+func (p *VaultProvider) HasKey(keyID string) bool {
+	for _, k := range p.keys {
+		if k == keyID {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *VaultProvider) Algorithm() string {
+	return VaultTransitAlgorithm
+}
@@ -0,0 +1,329 @@
+package kms
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+)
+
+const testVaultToken = "test-token"
+
+// transitStandIn serves the encrypt, decrypt and datakey endpoints of
+// Vault's transit engine. Keys have versions like Vault's; ciphertexts name the version
+// that sealed them.
+type transitStandIn struct {
+	mount string
+
+	mu        sync.Mutex
+	keys      map[string][][]byte
+	requests  int
+	namespace string
+}
+
+func newTransitStandIn(t *testing.T, mount string, keys ...string) (*transitStandIn, *httptest.Server) {
+	v := &transitStandIn{mount: mount, keys: map[string][][]byte{}}
+	for _, k := range keys {
+		v.rotate(k)
+	}
+	srv := httptest.NewServer(v)
+	t.Cleanup(srv.Close)
+	return v, srv
+}
+
+// rotate adds a new version to key, which then seals everything new.
+func (v *transitStandIn) rotate(key string) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	k := make([]byte, 32)
+	rand.Read(k)
+	v.keys[key] = append(v.keys[key], k)
+}
+
+func (v *transitStandIn) fail(w http.ResponseWriter, status int, msg string) {
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string][]string{"errors": {msg}})
+}
+
+func (v *transitStandIn) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.requests++
+	v.namespace = r.Header.Get("X-Vault-Namespace")
+
+	if r.Header.Get("X-Vault-Token") != testVaultToken {
+		v.fail(w, http.StatusForbidden, "permission denied")
+		return
+	}
+	route := strings.TrimPrefix(r.URL.Path, "/v1/"+v.mount+"/")
+	if name, ok := strings.CutPrefix(route, "datakey/plaintext/"); ok {
+		route = "datakey/" + name
+	}
+	op, name, ok := strings.Cut(route, "/")
+	if r.Method != http.MethodPost || !ok || (op != "encrypt" && op != "decrypt" && op != "datakey") {
+		v.fail(w, http.StatusNotFound, "no handler for route")
+		return
+	}
+	versions := v.keys[name]
+	if len(versions) == 0 {
+		v.fail(w, http.StatusBadRequest, "encryption key not found")
+		return
+	}
+	var req struct {
+		Plaintext  string `json:"plaintext"`
+		Ciphertext string `json:"ciphertext"`
+		Bits       int    `json:"bits"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		v.fail(w, http.StatusBadRequest, "invalid request")
+		return
+	}
+
+	seal := func(plain []byte) string {
+		gcm := transitGCM(versions[len(versions)-1])
+		nonce := make([]byte, gcm.NonceSize())
+		rand.Read(nonce)
+		sealed := gcm.Seal(nonce, nonce, plain, nil)
+		return fmt.Sprintf("vault:v%d:%s", len(versions), base64.StdEncoding.EncodeToString(sealed))
+	}
+
+	var data map[string]string
+	switch op {
+	case "datakey":
+		if req.Bits == 0 {
+			req.Bits = 256
+		}
+		if req.Bits != 128 && req.Bits != 256 && req.Bits != 512 {
+			v.fail(w, http.StatusBadRequest, "invalid bits value")
+			return
+		}
+		key := make([]byte, req.Bits/8)
+		rand.Read(key)
+		data = map[string]string{"plaintext": base64.StdEncoding.EncodeToString(key), "ciphertext": seal(key)}
+	case "encrypt":
+		plain, err := base64.StdEncoding.DecodeString(req.Plaintext)
+		if err != nil {
+			v.fail(w, http.StatusBadRequest, "plaintext is not base64")
+			return
+		}
+		data = map[string]string{"ciphertext": seal(plain)}
+	default:
+		parts := strings.SplitN(req.Ciphertext, ":", 3)
+		n := 0
+		if len(parts) == 3 && parts[0] == "vault" && strings.HasPrefix(parts[1], "v") {
+			n, _ = strconv.Atoi(parts[1][1:])
+		}
+		raw, err := base64.StdEncoding.DecodeString(parts[len(parts)-1])
+		if n < 1 || n > len(versions) || err != nil {
+			v.fail(w, http.StatusBadRequest, "invalid ciphertext")
+			return
+		}
+		gcm := transitGCM(versions[n-1])
+		if len(raw) < gcm.NonceSize() {
+			v.fail(w, http.StatusBadRequest, "invalid ciphertext")
+			return
+		}
+		plain, err := gcm.Open(nil, raw[:gcm.NonceSize()], raw[gcm.NonceSize():], nil)
+		if err != nil {
+			v.fail(w, http.StatusBadRequest, "cipher: message authentication failed")
+			return
+		}
+		data = map[string]string{"plaintext": base64.StdEncoding.EncodeToString(plain)}
+	}
+	json.NewEncoder(w).Encode(map[string]any{"data": data})
+}
+
+func transitGCM(key []byte) cipher.AEAD {
+	block, _ := aes.NewCipher(key)
+	gcm, _ := cipher.NewGCM(block)
+	return gcm
+}
+
+func TestVaultEncryptDecrypt(t *testing.T) {
+	v, srv := newTransitStandIn(t, "kms", "files")
+	p := NewVaultProvider(srv.URL+"/", testVaultToken, "/kms/", "files")
+	p.Namespace = "team-a"
+	ctx := context.Background()
+
+	dataKey := make([]byte, 32)
+	rand.Read(dataKey)
+	wrapped, err := p.Encrypt(ctx, "files", dataKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.HasPrefix(wrapped, []byte("vault:v1:")) {
+		t.Errorf("ciphertext %q is not Vault's", wrapped)
+	}
+	if v.namespace != "team-a" {
+		t.Errorf("namespace header = %q", v.namespace)
+	}
+
+	got, err := p.Decrypt(ctx, "files", wrapped)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, dataKey) {
+		t.Error("decrypted key differs")
+	}
+	if p.Algorithm() != VaultTransitAlgorithm || p.ActiveKeyID() != "files" {
+		t.Errorf("algorithm %q, active key %q", p.Algorithm(), p.ActiveKeyID())
+	}
+}
+
+func TestVaultGenerateDataKey(t *testing.T) {
+	v, srv := newTransitStandIn(t, "transit", "files", "files-2024")
+	p := NewVaultProvider(srv.URL, testVaultToken, "", "files", "files-2024")
+	ctx := context.Background()
+
+	for _, keyID := range []string{"files", "files-2024"} {
+		dk, err := p.GenerateDataKey(ctx, keyID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if dk.KeyID != keyID || len(dk.Plaintext) != 32 || !bytes.HasPrefix(dk.Ciphertext, []byte("vault:v1:")) {
+			t.Errorf("data key under %s = %s, %d bytes, %q", keyID, dk.KeyID, len(dk.Plaintext), dk.Ciphertext)
+		}
+		got, err := p.Decrypt(ctx, keyID, dk.Ciphertext)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, dk.Plaintext) {
+			t.Errorf("unwrapped data key under %s differs", keyID)
+		}
+	}
+
+	first, err := p.GenerateDataKey(ctx, "files")
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := p.GenerateDataKey(ctx, "files")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Equal(first.Plaintext, second.Plaintext) {
+		t.Error("two data keys are the same")
+	}
+
+	before := v.requests
+	if _, err := p.GenerateDataKey(ctx, "other"); !errors.Is(err, ErrUnknownKey) {
+		t.Errorf("GenerateDataKey under an unconfigured key = %v, want ErrUnknownKey", err)
+	}
+	if v.requests != before {
+		t.Error("unknown key was sent to Vault")
+	}
+}
+
+func TestVaultKeyVersions(t *testing.T) {
+	v, srv := newTransitStandIn(t, "transit", "files", "files-2024")
+	p := NewVaultProvider(srv.URL, testVaultToken, "", "files", "files-2024", "")
+	ctx := context.Background()
+
+	if ids := p.KeyIDs(); len(ids) != 2 || ids[0] != "files" || ids[1] != "files-2024" {
+		t.Fatalf("key ids = %v", ids)
+	}
+
+	old, err := p.Encrypt(ctx, "files", []byte("first"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	v.rotate("files")
+	current, err := p.Encrypt(ctx, "files", []byte("second"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.HasPrefix(current, []byte("vault:v2:")) {
+		t.Errorf("ciphertext after rotation = %q, want version 2", current)
+	}
+	for want, c := range map[string][]byte{"first": old, "second": current} {
+		got, err := p.Decrypt(ctx, "files", c)
+		if err != nil || string(got) != want {
+			t.Errorf("decrypt %q = %q, %v", c, got, err)
+		}
+	}
+
+	// an older key name still unwraps what it wrapped
+	legacy, err := p.Encrypt(ctx, "files-2024", []byte("legacy"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err := p.Decrypt(ctx, "files-2024", legacy); err != nil || string(got) != "legacy" {
+		t.Errorf("decrypt under older key = %q, %v", got, err)
+	}
+}
+
+func TestVaultErrors(t *testing.T) {
+	v, srv := newTransitStandIn(t, "transit", "files")
+	ctx := context.Background()
+
+	p := NewVaultProvider(srv.URL, testVaultToken, "", "files", "retired")
+	wrapped, err := p.Encrypt(ctx, "files", []byte("key"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("key not configured", func(t *testing.T) {
+		before := v.requests
+		if _, err := p.Encrypt(ctx, "other", []byte("key")); !errors.Is(err, ErrUnknownKey) {
+			t.Errorf("Encrypt = %v, want ErrUnknownKey", err)
+		}
+		// as with the local provider, so callers can tell it from a failed unwrap
+		if _, err := p.Decrypt(ctx, "other", wrapped); !errors.Is(err, ErrUnknownKey) {
+			t.Errorf("Decrypt = %v, want ErrUnknownKey", err)
+		}
+		if v.requests != before {
+			t.Error("unknown key was sent to Vault")
+		}
+	})
+
+	t.Run("key unknown to vault", func(t *testing.T) {
+		_, err := p.Encrypt(ctx, "retired", []byte("key"))
+		if err == nil || !strings.Contains(err.Error(), "encryption key not found") || !strings.Contains(err.Error(), "400") {
+			t.Errorf("Encrypt = %v, want Vault's 400 error", err)
+		}
+	})
+
+	t.Run("permission denied", func(t *testing.T) {
+		denied := NewVaultProvider(srv.URL, "wrong", "", "files")
+		_, err := denied.Encrypt(ctx, "files", []byte("key"))
+		if err == nil || !strings.Contains(err.Error(), "permission denied") {
+			t.Errorf("Encrypt = %v, want permission denied", err)
+		}
+		if _, err := denied.Decrypt(ctx, "files", wrapped); !errors.Is(err, ErrDecrypt) {
+			t.Errorf("Decrypt = %v, want ErrDecrypt", err)
+		}
+	})
+
+	t.Run("tampered ciphertext", func(t *testing.T) {
+		tampered := bytes.Clone(wrapped)
+		tampered[len(tampered)-2] ^= 'A' ^ 'B'
+		if _, err := p.Decrypt(ctx, "files", tampered); !errors.Is(err, ErrDecrypt) {
+			t.Errorf("Decrypt = %v, want ErrDecrypt", err)
+		}
+		if _, err := p.Decrypt(ctx, "files", []byte("vault:v9:"+base64.StdEncoding.EncodeToString(make([]byte, 40)))); !errors.Is(err, ErrDecrypt) {
+			t.Errorf("Decrypt of unknown version = %v, want ErrDecrypt", err)
+		}
+	})
+
+	t.Run("not vault", func(t *testing.T) {
+		proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusBadGateway)
+			w.Write([]byte("<html>bad gateway</html>"))
+		}))
+		defer proxy.Close()
+		_, err := NewVaultProvider(proxy.URL, testVaultToken, "", "files").Encrypt(ctx, "files", []byte("key"))
+		if err == nil || !strings.Contains(err.Error(), "invalid response") {
+			t.Errorf("Encrypt = %v, want invalid response", err)
+		}
+	})
+}
@@ -0,0 +1,39 @@
+package mailer
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/SrabanMondal/SecureStore/internal/utils"
+)
+
+// LogMailer stands in for a mail server in local development and tests. It
+// writes each message to a file in Dir, or to the log when Dir is empty.
+// Messages carry live reset and verification links, so keep it out of
+// production.
+type LogMailer struct {
+	Dir string
+}
+
+func NewLogMailer(dir string) (*LogMailer, error) {
+	if dir != "" {
+		if err := os.MkdirAll(dir, 0o700); err != nil {
+			return nil, err
+		}
+	}
+	return &LogMailer{Dir: dir}, nil
+}
+
+func (m *LogMailer) Send(_ context.Context, msg Message) error {
+	if m.Dir == "" {
+		utils.Info.Info().Str("to", msg.To).Str("subject", msg.Subject).Str("body", msg.Body).Msg("mail")
+		return nil
+	}
+
+	name := fmt.Sprintf("%d.eml", time.Now().UnixNano())
+	content := fmt.Sprintf("To: %s\nSubject: %s\n\n%s\n", msg.To, msg.Subject, msg.Body)
+	return os.WriteFile(filepath.Join(m.Dir, name), []byte(content), 0o600)
+}
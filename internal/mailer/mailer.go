@@ -0,0 +1,16 @@
+// Package mailer sends the emails the service needs, such as password reset
+// and address verification links, through a pluggable transport.
+package mailer
+
+import "context"
+
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+type Mailer interface {
+	// Send delivers msg as a plain text email.
+	Send(ctx context.Context, msg Message) error
+}
@@ -0,0 +1,10 @@
+package models
+
+// DataKey is the key an encrypted object was sealed with, itself wrapped by
+// the key encryption key KeyID. An empty KeyID marks a legacy object sealed
+// directly with the master key.
+type DataKey struct {
+	WrappedKey   []byte `json:"-" db:"wrapped_key"`
+	KeyID        string `json:"-" db:"key_id"`
+	KeyAlgorithm string `json:"-" db:"key_algorithm"`
+}
@@ -0,0 +1,19 @@
+package models
+
+// Encryption modes of a file and its versions. Server encrypted objects are
+// sealed under a data key the server holds (see DataKey); client encrypted
+// ones arrive as ciphertext the server cannot open.
+const (
+	EncryptionNone   = "none"
+	EncryptionServer = "server"
+	EncryptionClient = "client"
+)
+
+// ClientKey is what the client that encrypted a file needs to decrypt it
+// again: the file key wrapped by a key only the client holds, the IV and the
+// algorithm. The server stores it as given.
+type ClientKey struct {
+	WrappedKey string `json:"wrapped_key" db:"client_wrapped_key"`
+	IV         string `json:"iv" db:"client_iv"`
+	Algorithm  string `json:"algorithm" db:"client_algorithm"`
+}
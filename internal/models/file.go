@@ -6,6 +6,7 @@ type File struct {
 	ID          string     `json:"id" db:"id"`
 	UserID      string     `json:"user_id" db:"user_id"`
 	FilePath    string     `json:"file_path" db:"file_path"`
+	FolderID    *string    `json:"folder_id" db:"folder_id"`
 	Size        int64      `json:"size" db:"size"`
 	IsEncrypted bool       `json:"is_encrypted" db:"is_encrypted"`
 	StorageKey  string     `json:"storage_key" db:"storage_key"`
@@ -13,4 +14,16 @@ type File struct {
 
 	Status     string     `json:"status" db:"status"`          
 	UploadedAt *time.Time `json:"uploaded_at" db:"uploaded_at"`
+	TrashedAt  *time.Time `json:"trashed_at,omitempty" db:"trashed_at"`
+
+	CurrentVersion int    `json:"current_version" db:"current_version"`
+	ContentType    string `json:"content_type" db:"content_type"`
+	ChecksumSHA256 string `json:"checksum_sha256,omitempty" db:"checksum_sha256"`
+	ChecksumCRC32C string `json:"checksum_crc32c,omitempty" db:"checksum_crc32c"`
+
+	// none, server or client; IsEncrypted is set for server only
+	EncryptionMode string    `json:"encryption_mode" db:"encryption_mode"`
+	ClientKey      ClientKey `json:"client_key,omitzero"`
+
+	DataKey
 }
@@ -0,0 +1,25 @@
+package models
+
+import "time"
+
+type FileVersion struct {
+	ID             string     `json:"id" db:"id"`
+	FileID         string     `json:"file_id" db:"file_id"`
+	Version        int        `json:"version" db:"version"`
+	StorageKey     string     `json:"-" db:"storage_key"`
+	Size           int64      `json:"size" db:"size"`
+	ObjectSize     int64      `json:"-" db:"object_size"`
+	IsEncrypted    bool       `json:"is_encrypted" db:"is_encrypted"`
+	EncryptionMode string     `json:"encryption_mode" db:"encryption_mode"`
+	ClientKey      ClientKey  `json:"client_key,omitzero"`
+	ContentType    string     `json:"content_type" db:"content_type"`
+	ChecksumSHA256 string     `json:"checksum_sha256,omitempty" db:"checksum_sha256"`
+	ChecksumCRC32C string     `json:"checksum_crc32c,omitempty" db:"checksum_crc32c"`
+	Status         string     `json:"status" db:"status"`
+	CreatedAt      time.Time  `json:"created_at" db:"created_at"`
+	UploadedAt     *time.Time `json:"uploaded_at" db:"uploaded_at"`
+
+	DataKey
+
+	IsCurrent bool `json:"is_current" db:"-"`
+}
@@ -0,0 +1,19 @@
+package models
+
+import "time"
+
+type Folder struct {
+	ID        string    `json:"id" db:"id"`
+	UserID    string    `json:"user_id" db:"user_id"`
+	ParentID  *string   `json:"parent_id" db:"parent_id"`
+	Name      string    `json:"name" db:"name"`
+	Path      string    `json:"path" db:"path"`
+	CreatedAt time.Time `json:"created_at" db:"created_at"`
+	UpdatedAt time.Time `json:"updated_at" db:"updated_at"`
+}
+
+// Breadcrumb is one ancestor on the way from the root to a folder.
+type Breadcrumb struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
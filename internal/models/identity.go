@@ -0,0 +1,24 @@
+package models
+
+import "time"
+
+// UserIdentity links an account at an OpenID Connect provider to a user.
+type UserIdentity struct {
+	Provider    string     `json:"provider" db:"provider"`
+	Subject     string     `json:"subject" db:"subject"`
+	UserID      string     `json:"-" db:"user_id"`
+	Email       string     `json:"email" db:"email"`
+	CreatedAt   time.Time  `json:"created_at" db:"created_at"`
+	LastLoginAt *time.Time `json:"last_login_at,omitempty" db:"last_login_at"`
+}
+
+// OIDCState is a login sent to a provider and not yet returned. BindingHash
+// ties it to the browser that started it. ReauthUserID is set when a
+// signed-in user started it to confirm who they are.
+type OIDCState struct {
+	Provider     string  `db:"provider"`
+	Nonce        string  `db:"nonce"`
+	CodeVerifier string  `db:"code_verifier"`
+	BindingHash  []byte  `db:"binding_hash"`
+	ReauthUserID *string `db:"reauth_user_id"`
+}
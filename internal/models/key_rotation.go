@@ -0,0 +1,18 @@
+package models
+
+import "time"
+
+type KeyRotation struct {
+	ID          string     `json:"id" db:"id"`
+	FromKeyID   string     `json:"from_key_id" db:"from_key_id"`
+	ToKeyID     string     `json:"to_key_id" db:"to_key_id"`
+	Status      string     `json:"status" db:"status"`
+	Total       int64      `json:"total" db:"total"`
+	Processed   int64      `json:"processed" db:"processed"`
+	Failed      int64      `json:"failed" db:"failed"`
+	Cursor      *string    `json:"-" db:"cursor"`
+	Error       string     `json:"error,omitempty" db:"error"`
+	CreatedAt   time.Time  `json:"created_at" db:"created_at"`
+	UpdatedAt   time.Time  `json:"updated_at" db:"updated_at"`
+	CompletedAt *time.Time `json:"completed_at,omitempty" db:"completed_at"`
+}
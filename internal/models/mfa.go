@@ -0,0 +1,14 @@
+package models
+
+import "time"
+
+// MFA is a user's TOTP enrollment. DataKey holds the TOTP secret, wrapped by
+// a master key. EnabledAt stays nil until a first code has been confirmed.
+type MFA struct {
+	UserID    string     `json:"-" db:"user_id"`
+	EnabledAt *time.Time `json:"enabled_at" db:"enabled_at"`
+	LastStep  int64      `json:"-" db:"last_step"`
+	CreatedAt time.Time  `json:"created_at" db:"created_at"`
+
+	DataKey
+}
@@ -0,0 +1,27 @@
+package models
+
+import "time"
+
+type MultipartUpload struct {
+	ID         string    `json:"id" db:"id"`
+	FileID     string    `json:"file_id" db:"file_id"`
+	Version    int       `json:"version" db:"version"`
+	UserID     string    `json:"user_id" db:"user_id"`
+	UploadID   string    `json:"-" db:"upload_id"`
+	StorageKey string    `json:"-" db:"storage_key"`
+	Size       int64     `json:"size" db:"size"`
+	PartSize   int64     `json:"part_size" db:"part_size"`
+	CreatedAt  time.Time `json:"created_at" db:"created_at"`
+	UpdatedAt  time.Time `json:"updated_at" db:"updated_at"`
+}
+
+func (u *MultipartUpload) PartCount() int {
+	return int((u.Size + u.PartSize - 1) / u.PartSize)
+}
+
+type MultipartPart struct {
+	PartNumber int       `json:"part_number" db:"part_number"`
+	ETag       string    `json:"etag" db:"etag"`
+	Size       int64     `json:"size" db:"size"`
+	UploadedAt time.Time `json:"uploaded_at" db:"uploaded_at"`
+}
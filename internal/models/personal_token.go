@@ -0,0 +1,40 @@
+package models
+
+import "time"
+
+// PersonalTokenPrefix starts every personal access token, telling them apart
+// from JWTs.
+const PersonalTokenPrefix = "ssp_"
+
+// Scopes a personal access token can be granted.
+const (
+	ScopeFilesRead    = "files:read"
+	ScopeFilesWrite   = "files:write"
+	ScopeSharesManage = "shares:manage"
+)
+
+var Scopes = []string{ScopeFilesRead, ScopeFilesWrite, ScopeSharesManage}
+
+// PersonalAccessToken is a named, revocable token acting for its user within
+// Scopes. Only a hash of the token is kept.
+type PersonalAccessToken struct {
+	ID         string     `json:"id" db:"id"`
+	UserID     string     `json:"-" db:"user_id"`
+	Name       string     `json:"name" db:"name"`
+	TokenHash  []byte     `json:"-" db:"token_hash"`
+	Prefix     string     `json:"prefix" db:"prefix"`
+	Scopes     []string   `json:"scopes" db:"scopes"`
+	ExpiresAt  *time.Time `json:"expires_at" db:"expires_at"`
+	LastUsedAt *time.Time `json:"last_used_at" db:"last_used_at"`
+	CreatedAt  time.Time  `json:"created_at" db:"created_at"`
+	RevokedAt  *time.Time `json:"revoked_at,omitempty" db:"revoked_at"`
+}
+
+func (t *PersonalAccessToken) HasScope(scope string) bool {
+	for _, s := range t.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
@@ -5,7 +5,9 @@ import "time"
 type ShareLink struct {
 	ID           string     `json:"id" db:"id"`
 	FileID       string     `json:"file_id" db:"file_id"`
-	ShareToken   string     `json:"share_token" db:"share_token"`
+	ShareToken   string     `json:"share_token,omitempty" db:"share_token"`
+	// owner of the file, set when links are listed for admins
+	UserID       string     `json:"user_id,omitempty" db:"user_id"`
 	ExpiresAt    time.Time  `json:"expires_at" db:"expires_at"`
 	PasswordHash string    `json:"-" db:"password_hash"`
 	CreatedAt    time.Time  `json:"created_at" db:"created_at"`
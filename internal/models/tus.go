@@ -0,0 +1,26 @@
+package models
+
+import "time"
+
+type TusUpload struct {
+	ID          string    `json:"id" db:"id"`
+	UserID      string    `json:"user_id" db:"user_id"`
+	FilePath    string    `json:"file_path" db:"file_path"`
+	Length      int64     `json:"upload_length" db:"upload_length"`
+	Offset      int64     `json:"upload_offset" db:"upload_offset"`
+	Metadata    string    `json:"metadata" db:"metadata"`
+	IsEncrypted bool      `json:"is_encrypted" db:"is_encrypted"`
+	FileID      *string   `json:"file_id" db:"file_id"`
+	ExpiresAt   time.Time `json:"expires_at" db:"expires_at"`
+	CreatedAt   time.Time `json:"created_at" db:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at" db:"updated_at"`
+
+	DataKey
+}
+
+type TusSegment struct {
+	UploadID   string `json:"upload_id" db:"upload_id"`
+	Offset     int64  `json:"segment_offset" db:"segment_offset"`
+	Size       int64  `json:"size" db:"size"`
+	StorageKey string `json:"storage_key" db:"storage_key"`
+}
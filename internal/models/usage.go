@@ -0,0 +1,26 @@
+package models
+
+import "time"
+
+type Usage struct {
+	UserID     string     `json:"user_id" db:"user_id"`
+	UsedBytes  int64      `json:"used_bytes" db:"used_bytes"`
+	QuotaBytes int64      `json:"quota_bytes" db:"quota_bytes"`
+	UpdatedAt  *time.Time `json:"updated_at" db:"updated_at"`
+}
+
+func (u *Usage) Available() int64 {
+	if u.UsedBytes >= u.QuotaBytes {
+		return 0
+	}
+	return u.QuotaBytes - u.UsedBytes
+}
+
+// SystemUsage is storage summed over every user.
+type SystemUsage struct {
+	Users        int64 `json:"users"`
+	Files        int64 `json:"files"`
+	TrashedFiles int64 `json:"trashed_files"`
+	Versions     int64 `json:"versions"`
+	UsedBytes    int64 `json:"used_bytes"`
+}
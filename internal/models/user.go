@@ -2,10 +2,32 @@ package models
 
 import "time"
 
+// Roles a user can have. Every user can manage their own data; the other
+// roles reach the admin API.
+const (
+	RoleUser    = "user"
+	RoleAdmin   = "admin"
+	RoleAuditor = "auditor"
+)
+
+var Roles = []string{RoleUser, RoleAdmin, RoleAuditor}
+
+// User is an account. PasswordHash is empty for users provisioned through an
+// identity provider who have not set a password.
 type User struct {
-	ID           string    `json:"id" db:"id"`
-	Username     string    `json:"username" db:"username"`
-	Email        string    `json:"email" db:"email"`
-	PasswordHash string    `json:"-" db:"password_hash"`
-	CreatedAt    time.Time `json:"created_at" db:"created_at"`
+	ID           string     `json:"id" db:"id"`
+	Username     string     `json:"username" db:"username"`
+	Email        string     `json:"email" db:"email"`
+	PasswordHash string     `json:"-" db:"password_hash"`
+	Role         string     `json:"role" db:"role"`
+	SuspendedAt  *time.Time `json:"suspended_at,omitempty" db:"suspended_at"`
+	CreatedAt    time.Time  `json:"created_at" db:"created_at"`
+}
+
+// UserSummary is a user as the admin API shows them.
+type UserSummary struct {
+	User
+	EmailVerified bool  `json:"email_verified"`
+	UsedBytes     int64 `json:"used_bytes"`
+	QuotaBytes    int64 `json:"quota_bytes"`
 }
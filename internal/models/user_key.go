@@ -0,0 +1,23 @@
+package models
+
+import "time"
+
+// UserKey is a user's key encryption key, wrapped by the master key named in
+// DataKey. A passphrase protected key is sealed under a key derived from the
+// passphrase before being wrapped.
+type UserKey struct {
+	UserID string `json:"-" db:"user_id"`
+	DataKey
+
+	PassphraseSalt []byte `json:"-" db:"passphrase_salt"`
+	KDFTime        uint32 `json:"-" db:"kdf_time"`
+	KDFMemory      uint32 `json:"-" db:"kdf_memory"`
+	KDFThreads     uint8  `json:"-" db:"kdf_threads"`
+
+	CreatedAt time.Time `json:"created_at" db:"created_at"`
+	UpdatedAt time.Time `json:"updated_at" db:"updated_at"`
+}
+
+func (k *UserKey) PassphraseProtected() bool {
+	return len(k.PassphraseSalt) > 0
+}
@@ -0,0 +1,152 @@
+package oidc
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// clock skew tolerated between us and the provider
+const leeway = time.Minute
+
+// algorithms accepted on ID tokens; never none or the HMAC ones, which would
+// make the client secret a signing key
+var supportedAlgs = []string{"RS256", "RS384", "RS512", "PS256", "PS384", "PS512", "ES256", "ES384", "ES512", "EdDSA"}
+
+// Claims are the parts of a verified ID token used to sign a user in.
+// AuthTime is zero unless the provider said when the user signed in.
+type Claims struct {
+	Subject           string
+	Email             string
+	EmailVerified     bool
+	Name              string
+	PreferredUsername string
+	AuthTime          time.Time
+}
+
+type idTokenClaims struct {
+	jwt.RegisteredClaims
+	Nonce             string           `json:"nonce"`
+	AuthorizedParty   string           `json:"azp"`
+	Email             string           `json:"email"`
+	EmailVerified     json.RawMessage  `json:"email_verified"`
+	Name              string           `json:"name"`
+	PreferredUsername string           `json:"preferred_username"`
+	AuthTime          *jwt.NumericDate `json:"auth_time"`
+}
+
+// VerifyIDToken checks raw's signature against the provider's keys, that it
+// was issued by the provider to this client and is current, and that it
+// carries nonce.
+func (p *Provider) VerifyIDToken(ctx context.Context, raw, nonce string) (*Claims, error) {
+	m, err := p.discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+	algs := supportedAlgs
+	if len(m.SigningAlgs) > 0 {
+		algs = slices.DeleteFunc(slices.Clone(m.SigningAlgs), func(a string) bool {
+			return !slices.Contains(supportedAlgs, a)
+		})
+	}
+
+	var c idTokenClaims
+	_, err = jwt.ParseWithClaims(raw, &c, func(t *jwt.Token) (any, error) {
+		kid, _ := t.Header["kid"].(string)
+		k, err := p.key(ctx, kid)
+		if err != nil {
+			return nil, err
+		}
+		alg := t.Method.Alg()
+		if k.alg != "" && k.alg != alg {
+			return nil, fmt.Errorf("key %q is for %s, not %s", kid, k.alg, alg)
+		}
+		if !keyFits(alg, k) {
+			return nil, fmt.Errorf("key %q cannot verify %s", kid, alg)
+		}
+		return k.key, nil
+	},
+		jwt.WithValidMethods(algs),
+		jwt.WithIssuer(p.Issuer),
+		jwt.WithAudience(p.ClientID),
+		jwt.WithExpirationRequired(),
+		jwt.WithIssuedAt(),
+		jwt.WithLeeway(leeway),
+	)
+	if errors.Is(err, ErrDiscovery) {
+		return nil, err
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidIDToken, err)
+	}
+
+	if c.Subject == "" {
+		return nil, fmt.Errorf("%w: no subject", ErrInvalidIDToken)
+	}
+	if c.IssuedAt == nil {
+		return nil, fmt.Errorf("%w: no issue time", ErrInvalidIDToken)
+	}
+	if len(c.Audience) > 1 && c.AuthorizedParty == "" {
+		return nil, fmt.Errorf("%w: multiple audiences without azp", ErrInvalidIDToken)
+	}
+	if c.AuthorizedParty != "" && c.AuthorizedParty != p.ClientID {
+		return nil, fmt.Errorf("%w: issued to %q", ErrInvalidIDToken, c.AuthorizedParty)
+	}
+	if subtle.ConstantTimeCompare([]byte(c.Nonce), []byte(nonce)) != 1 || nonce == "" {
+		return nil, fmt.Errorf("%w: nonce mismatch", ErrInvalidIDToken)
+	}
+
+	claims := &Claims{
+		Subject:           c.Subject,
+		Email:             c.Email,
+		EmailVerified:     parseBool(c.EmailVerified),
+		Name:              c.Name,
+		PreferredUsername: c.PreferredUsername,
+	}
+	if c.AuthTime != nil {
+		if c.AuthTime.After(time.Now().Add(leeway)) {
+			return nil, fmt.Errorf("%w: signed in in the future", ErrInvalidIDToken)
+		}
+		claims.AuthTime = c.AuthTime.Time
+	}
+	return claims, nil
+}
+
+// keyFits reports whether k is the kind of key alg verifies with.
+func keyFits(alg string, k *publicKey) bool {
+	switch alg[:2] {
+	case "RS", "PS":
+		_, ok := k.key.(*rsa.PublicKey)
+		return ok
+	case "ES":
+		_, ok := k.key.(*ecdsa.PublicKey)
+		return ok
+	case "Ed":
+		_, ok := k.key.(ed25519.PublicKey)
+		return ok
+	}
+	return false
+}
+
+// parseBool reads a boolean claim some providers send as a string.
+func parseBool(raw json.RawMessage) bool {
+	var b bool
+	if json.Unmarshal(raw, &b) == nil {
+		return b
+	}
+	var s string
+	if json.Unmarshal(raw, &s) == nil {
+		b, _ = strconv.ParseBool(s)
+	}
+	return b
+}
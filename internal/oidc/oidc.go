@@ -0,0 +1,204 @@
+// Package oidc is an OpenID Connect relying party for the authorization code
+// flow with PKCE: it discovers a provider's endpoints, redeems codes and
+// verifies ID tokens against the provider's published keys.
+package oidc
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// how long a discovery document is used before it is fetched again
+const discoveryTTL = time.Hour
+
+var (
+	ErrDiscovery      = errors.New("oidc discovery failed")
+	ErrExchange       = errors.New("oidc code exchange failed")
+	ErrInvalidIDToken = errors.New("invalid id token")
+)
+
+// Config describes one identity provider.
+type Config struct {
+	// short name used in routes, e.g. "corp"
+	Name         string
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	RedirectURL  string
+	Scopes       []string
+
+	// create an account for a verified email no user has yet
+	AutoProvision bool
+	// sign in to an existing account with the same verified email
+	LinkExisting bool
+	// if set, only emails in these domains may sign in
+	AllowedDomains []string
+}
+
+// Provider talks to one identity provider. Its discovery document and keys
+// are fetched on first use and cached.
+type Provider struct {
+	Config
+	Client *http.Client
+
+	mu       sync.Mutex
+	meta     *metadata
+	metaAt   time.Time
+	keys     map[string]*publicKey
+	keysAt   time.Time
+	keysFrom string
+}
+
+func NewProvider(cfg Config) *Provider {
+	cfg.Issuer = strings.TrimRight(cfg.Issuer, "/")
+	if len(cfg.Scopes) == 0 {
+		cfg.Scopes = []string{"openid", "email", "profile"}
+	}
+	return &Provider{Config: cfg, Client: &http.Client{Timeout: 10 * time.Second}}
+}
+
+type metadata struct {
+	Issuer                string   `json:"issuer"`
+	AuthorizationEndpoint string   `json:"authorization_endpoint"`
+	TokenEndpoint         string   `json:"token_endpoint"`
+	JWKSURI               string   `json:"jwks_uri"`
+	SigningAlgs           []string `json:"id_token_signing_alg_values_supported"`
+}
+
+func (p *Provider) getJSON(ctx context.Context, endpoint string, out any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	resp, err := p.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s: %s", endpoint, resp.Status)
+	}
+	return json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(out)
+}
+
+func (p *Provider) discover(ctx context.Context) (*metadata, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.meta != nil && time.Since(p.metaAt) < discoveryTTL {
+		return p.meta, nil
+	}
+
+	var m metadata
+	if err := p.getJSON(ctx, p.Issuer+"/.well-known/openid-configuration", &m); err != nil {
+		return nil, fmt.Errorf("%w: %s: %v", ErrDiscovery, p.Name, err)
+	}
+	if strings.TrimRight(m.Issuer, "/") != p.Issuer {
+		return nil, fmt.Errorf("%w: %s: issuer %q does not match %q", ErrDiscovery, p.Name, m.Issuer, p.Issuer)
+	}
+	if m.AuthorizationEndpoint == "" || m.TokenEndpoint == "" || m.JWKSURI == "" {
+		return nil, fmt.Errorf("%w: %s: incomplete discovery document", ErrDiscovery, p.Name)
+	}
+	p.meta, p.metaAt = &m, time.Now()
+	return p.meta, nil
+}
+
+// NewPKCE returns a code verifier and its S256 challenge.
+func NewPKCE() (verifier, challenge string, err error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", "", err
+	}
+	verifier = base64.RawURLEncoding.EncodeToString(b)
+	sum := sha256.Sum256([]byte(verifier))
+	return verifier, base64.RawURLEncoding.EncodeToString(sum[:]), nil
+}
+
+// AuthCodeURL returns where to send the user to sign in. With reauth the
+// provider is asked to have the user sign in again even if they still have a
+// session there, and to report when they did as auth_time.
+func (p *Provider) AuthCodeURL(ctx context.Context, state, nonce, challenge string, reauth bool) (string, error) {
+	m, err := p.discover(ctx)
+	if err != nil {
+		return "", err
+	}
+	q := url.Values{}
+	q.Set("response_type", "code")
+	q.Set("client_id", p.ClientID)
+	q.Set("redirect_uri", p.RedirectURL)
+	q.Set("scope", strings.Join(p.Scopes, " "))
+	q.Set("state", state)
+	q.Set("nonce", nonce)
+	q.Set("code_challenge", challenge)
+	q.Set("code_challenge_method", "S256")
+	if reauth {
+		q.Set("prompt", "login")
+		q.Set("max_age", "0")
+	}
+
+	sep := "?"
+	if strings.Contains(m.AuthorizationEndpoint, "?") {
+		sep = "&"
+	}
+	return m.AuthorizationEndpoint + sep + q.Encode(), nil
+}
+
+// Exchange redeems an authorization code and returns the raw ID token.
+func (p *Provider) Exchange(ctx context.Context, code, verifier string) (string, error) {
+	m, err := p.discover(ctx)
+	if err != nil {
+		return "", err
+	}
+	form := url.Values{}
+	form.Set("grant_type", "authorization_code")
+	form.Set("code", code)
+	form.Set("redirect_uri", p.RedirectURL)
+	form.Set("code_verifier", verifier)
+	if p.ClientSecret == "" {
+		// public client
+		form.Set("client_id", p.ClientID)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, m.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if p.ClientSecret != "" {
+		req.SetBasicAuth(url.QueryEscape(p.ClientID), url.QueryEscape(p.ClientSecret))
+	}
+
+	resp, err := p.Client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", ErrExchange, err)
+	}
+	defer resp.Body.Close()
+
+	var out struct {
+		IDToken          string `json:"id_token"`
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&out); err != nil {
+		return "", fmt.Errorf("%w: %s: invalid response: %v", ErrExchange, resp.Status, err)
+	}
+	if resp.StatusCode != http.StatusOK || out.Error != "" {
+		return "", fmt.Errorf("%w: %s: %s %s", ErrExchange, resp.Status, out.Error, out.ErrorDescription)
+	}
+	if out.IDToken == "" {
+		return "", fmt.Errorf("%w: no id_token in response", ErrExchange)
+	}
+	return out.IDToken, nil
+}
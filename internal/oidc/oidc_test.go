@@ -0,0 +1,206 @@
+package oidc
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/SrabanMondal/SecureStore/internal/oidc/oidctest"
+)
+
+func newTestProvider(t *testing.T) (*Provider, *oidctest.Provider) {
+	idp := oidctest.New(t)
+	return NewProvider(Config{
+		Name:        "test",
+		Issuer:      idp.URL + "/",
+		ClientID:    "securestore",
+		RedirectURL: "https://securestore.example/callback",
+	}), idp
+}
+
+// signIn runs the provider side of a login as id and returns the code and
+// the PKCE verifier to redeem it with.
+func signIn(t *testing.T, p *Provider, idp *oidctest.Provider, nonce string, id oidctest.Identity) (code, verifier string) {
+	t.Helper()
+	verifier, challenge, err := NewPKCE()
+	if err != nil {
+		t.Fatal(err)
+	}
+	authURL, err := p.AuthCodeURL(context.Background(), "state", nonce, challenge, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	code, state := idp.Login(t, authURL, id)
+	if state != "state" {
+		t.Fatalf("state = %q", state)
+	}
+	return code, verifier
+}
+
+func TestLogin(t *testing.T) {
+	p, idp := newTestProvider(t)
+	ctx := context.Background()
+
+	code, verifier := signIn(t, p, idp, "nonce", oidctest.Identity{
+		Subject: "sub-1", Email: "alice@example.com", EmailVerified: true, PreferredUsername: "alice",
+	})
+	raw, err := p.Exchange(ctx, code, verifier)
+	if err != nil {
+		t.Fatal(err)
+	}
+	claims, err := p.VerifyIDToken(ctx, raw, "nonce")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := Claims{Subject: "sub-1", Email: "alice@example.com", EmailVerified: true, PreferredUsername: "alice"}
+	if *claims != want {
+		t.Errorf("claims = %+v, want %+v", *claims, want)
+	}
+
+	// a code is redeemed once
+	if _, err := p.Exchange(ctx, code, verifier); !errors.Is(err, ErrExchange) {
+		t.Errorf("second exchange = %v, want ErrExchange", err)
+	}
+}
+
+func TestAuthCodeURL(t *testing.T) {
+	p, _ := newTestProvider(t)
+	for _, reauth := range []bool{false, true} {
+		authURL, err := p.AuthCodeURL(context.Background(), "state", "nonce", "challenge", reauth)
+		if err != nil {
+			t.Fatal(err)
+		}
+		u, err := url.Parse(authURL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		q := u.Query()
+		want := map[string]string{
+			"response_type":         "code",
+			"client_id":             "securestore",
+			"redirect_uri":          "https://securestore.example/callback",
+			"scope":                 "openid email profile",
+			"state":                 "state",
+			"nonce":                 "nonce",
+			"code_challenge":        "challenge",
+			"code_challenge_method": "S256",
+			"prompt":                "",
+			"max_age":               "",
+		}
+		if reauth {
+			want["prompt"], want["max_age"] = "login", "0"
+		}
+		for k, want := range want {
+			if q.Get(k) != want {
+				t.Errorf("reauth %v: %s = %q, want %q", reauth, k, q.Get(k), want)
+			}
+		}
+	}
+}
+
+func TestAuthTime(t *testing.T) {
+	p, idp := newTestProvider(t)
+	ctx := context.Background()
+	claims := func(reauth bool, id oidctest.Identity) (*Claims, error) {
+		verifier, challenge, err := NewPKCE()
+		if err != nil {
+			t.Fatal(err)
+		}
+		authURL, err := p.AuthCodeURL(ctx, "state", "nonce", challenge, reauth)
+		if err != nil {
+			t.Fatal(err)
+		}
+		code, _ := idp.Login(t, authURL, id)
+		raw, err := p.Exchange(ctx, code, verifier)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return p.VerifyIDToken(ctx, raw, "nonce")
+	}
+
+	c, err := claims(true, oidctest.Identity{Subject: "sub-1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if since := time.Since(c.AuthTime); since < 0 || since > time.Minute {
+		t.Errorf("auth_time of a fresh sign-in = %v", c.AuthTime)
+	}
+
+	earlier := time.Now().Add(-time.Hour).Truncate(time.Second)
+	if c, err := claims(false, oidctest.Identity{Subject: "sub-1", AuthTime: earlier}); err != nil || !c.AuthTime.Equal(earlier) {
+		t.Errorf("auth_time = %v, %v, want %v", c, err, earlier)
+	}
+	if c, err := claims(false, oidctest.Identity{Subject: "sub-1"}); err != nil || !c.AuthTime.IsZero() {
+		t.Errorf("auth_time without max_age = %v, %v, want none", c, err)
+	}
+	if _, err := claims(false, oidctest.Identity{Subject: "sub-1", AuthTime: time.Now().Add(time.Hour)}); !errors.Is(err, ErrInvalidIDToken) {
+		t.Errorf("auth_time in the future = %v, want ErrInvalidIDToken", err)
+	}
+}
+
+func TestExchangeWrongVerifier(t *testing.T) {
+	p, idp := newTestProvider(t)
+	code, _ := signIn(t, p, idp, "nonce", oidctest.Identity{Subject: "sub-1"})
+
+	other, _, err := NewPKCE()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := p.Exchange(context.Background(), code, other); !errors.Is(err, ErrExchange) {
+		t.Errorf("Exchange = %v, want ErrExchange", err)
+	}
+}
+
+func TestVerifyIDTokenRejects(t *testing.T) {
+	p, idp := newTestProvider(t)
+	ctx := context.Background()
+	token := func(id oidctest.Identity) string {
+		code, verifier := signIn(t, p, idp, "nonce", id)
+		raw, err := p.Exchange(ctx, code, verifier)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return raw
+	}
+
+	t.Run("nonce mismatch", func(t *testing.T) {
+		raw := token(oidctest.Identity{Subject: "sub-1", Nonce: "replayed"})
+		if _, err := p.VerifyIDToken(ctx, raw, "nonce"); !errors.Is(err, ErrInvalidIDToken) {
+			t.Errorf("VerifyIDToken = %v, want ErrInvalidIDToken", err)
+		}
+	})
+
+	t.Run("other audience", func(t *testing.T) {
+		raw := token(oidctest.Identity{Subject: "sub-1"})
+		other := NewProvider(Config{Name: "other", Issuer: idp.URL, ClientID: "other-client"})
+		if _, err := other.VerifyIDToken(ctx, raw, "nonce"); !errors.Is(err, ErrInvalidIDToken) {
+			t.Errorf("VerifyIDToken = %v, want ErrInvalidIDToken", err)
+		}
+	})
+
+	t.Run("tampered", func(t *testing.T) {
+		raw := []byte(token(oidctest.Identity{Subject: "sub-1"}))
+		raw[len(raw)-3] ^= 1
+		if _, err := p.VerifyIDToken(ctx, string(raw), "nonce"); !errors.Is(err, ErrInvalidIDToken) {
+			t.Errorf("VerifyIDToken = %v, want ErrInvalidIDToken", err)
+		}
+	})
+
+	t.Run("no subject", func(t *testing.T) {
+		raw := token(oidctest.Identity{})
+		if _, err := p.VerifyIDToken(ctx, raw, "nonce"); !errors.Is(err, ErrInvalidIDToken) {
+			t.Errorf("VerifyIDToken = %v, want ErrInvalidIDToken", err)
+		}
+	})
+}
+
+func TestDiscoveryFails(t *testing.T) {
+	idp := oidctest.New(t)
+	// no discovery document under this issuer
+	p := NewProvider(Config{Name: "test", Issuer: idp.URL + "/tenant", ClientID: "securestore"})
+	if _, err := p.AuthCodeURL(context.Background(), "state", "nonce", "challenge", false); !errors.Is(err, ErrDiscovery) {
+		t.Errorf("AuthCodeURL = %v, want ErrDiscovery", err)
+	}
+}
@@ -0,0 +1,189 @@
+// Package oidctest is a stand-in OpenID Connect provider for tests. It serves
+// discovery, a key set and a token endpoint, and signs in whoever a test
+// says is at the other end of an authorization URL.
+package oidctest
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const keyID = "test-key"
+
+// Identity is the account that signs in at the provider.
+type Identity struct {
+	Subject           string
+	Email             string
+	EmailVerified     bool
+	PreferredUsername string
+	// Nonce replaces the nonce of the authorization request in the ID token
+	// when set
+	Nonce string
+	// AuthTime is when the account last signed in at the provider. The ID
+	// token reports it as auth_time when set, or as now when the request
+	// asked for max_age.
+	AuthTime time.Time
+}
+
+// login is an authorization code waiting to be redeemed.
+type login struct {
+	Identity
+	clientID    string
+	redirectURI string
+	challenge   string
+}
+
+// Provider is the stand-in. Its URL is the issuer.
+type Provider struct {
+	*httptest.Server
+	key ed25519.PrivateKey
+
+	mu     sync.Mutex
+	logins map[string]*login
+}
+
+// New starts a provider that is closed when the test ends.
+func New(t testing.TB) *Provider {
+	t.Helper()
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := &Provider{key: key, logins: map[string]*login{}}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /.well-known/openid-configuration", p.discovery)
+	mux.HandleFunc("GET /jwks", p.jwks)
+	mux.HandleFunc("POST /token", p.token)
+	p.Server = httptest.NewServer(mux)
+	t.Cleanup(p.Close)
+	return p
+}
+
+// Login signs id in at authURL, as returned by AuthCodeURL, and returns the
+// code and state the provider redirects back with.
+func (p *Provider) Login(t testing.TB, authURL string, id Identity) (code, state string) {
+	t.Helper()
+	u, err := url.Parse(authURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	q := u.Query()
+	if q.Get("code_challenge_method") != "S256" {
+		t.Fatalf("authorization request without an S256 challenge: %s", authURL)
+	}
+	if id.Nonce == "" {
+		id.Nonce = q.Get("nonce")
+	}
+	if id.AuthTime.IsZero() && q.Has("max_age") {
+		id.AuthTime = time.Now()
+	}
+
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		t.Fatal(err)
+	}
+	code = base64.RawURLEncoding.EncodeToString(b)
+	p.mu.Lock()
+	p.logins[code] = &login{
+		Identity:    id,
+		clientID:    q.Get("client_id"),
+		redirectURI: q.Get("redirect_uri"),
+		challenge:   q.Get("code_challenge"),
+	}
+	p.mu.Unlock()
+	return code, q.Get("state")
+}
+
+func (p *Provider) discovery(w http.ResponseWriter, r *http.Request) {
+	json.NewEncoder(w).Encode(map[string]any{
+		"issuer":                                p.URL,
+		"authorization_endpoint":                p.URL + "/authorize",
+		"token_endpoint":                        p.URL + "/token",
+		"jwks_uri":                              p.URL + "/jwks",
+		"id_token_signing_alg_values_supported": []string{"EdDSA"},
+	})
+}
+
+func (p *Provider) jwks(w http.ResponseWriter, r *http.Request) {
+	json.NewEncoder(w).Encode(map[string]any{"keys": []map[string]string{{
+		"kty": "OKP",
+		"crv": "Ed25519",
+		"kid": keyID,
+		"use": "sig",
+		"alg": "EdDSA",
+		"x":   base64.RawURLEncoding.EncodeToString(p.key.Public().(ed25519.PublicKey)),
+	}}})
+}
+
+func (p *Provider) token(w http.ResponseWriter, r *http.Request) {
+	fail := func(msg string) {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant", "error_description": msg})
+	}
+	if err := r.ParseForm(); err != nil || r.PostForm.Get("grant_type") != "authorization_code" {
+		fail("bad request")
+		return
+	}
+	clientID := r.PostForm.Get("client_id")
+	if id, _, ok := r.BasicAuth(); ok {
+		clientID, _ = url.QueryUnescape(id)
+	}
+
+	p.mu.Lock()
+	l, ok := p.logins[r.PostForm.Get("code")]
+	// codes are single use
+	delete(p.logins, r.PostForm.Get("code"))
+	p.mu.Unlock()
+	switch {
+	case !ok:
+		fail("unknown code")
+		return
+	case clientID != l.clientID:
+		fail("code issued to another client")
+		return
+	case r.PostForm.Get("redirect_uri") != l.redirectURI:
+		fail("redirect_uri mismatch")
+		return
+	}
+	sum := sha256.Sum256([]byte(r.PostForm.Get("code_verifier")))
+	if base64.RawURLEncoding.EncodeToString(sum[:]) != l.challenge {
+		fail("code_verifier does not match the challenge")
+		return
+	}
+
+	now := time.Now()
+	claims := jwt.MapClaims{
+		"iss":                p.URL,
+		"aud":                l.clientID,
+		"sub":                l.Subject,
+		"iat":                now.Unix(),
+		"exp":                now.Add(5 * time.Minute).Unix(),
+		"nonce":              l.Nonce,
+		"email":              l.Email,
+		"email_verified":     l.EmailVerified,
+		"preferred_username": l.PreferredUsername,
+	}
+	if !l.AuthTime.IsZero() {
+		claims["auth_time"] = l.AuthTime.Unix()
+	}
+	token := jwt.NewWithClaims(jwt.SigningMethodEdDSA, claims)
+	token.Header["kid"] = keyID
+	raw, err := token.SignedString(p.key)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]string{"id_token": raw, "token_type": "Bearer"})
+}
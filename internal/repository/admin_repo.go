@@ -0,0 +1,142 @@
+package repositories
+
+import (
+	"context"
+	"errors"
+
+	"github.com/jackc/pgx/v5"
+
+	"github.com/SrabanMondal/SecureStore/internal/models"
+	"github.com/SrabanMondal/SecureStore/internal/utils"
+)
+
+const userSummaryQuery = `
+	SELECT u.id, u.username, u.email, COALESCE(u.password_hash, ''), u.role, u.suspended_at, u.created_at,
+		u.email_verified_at IS NOT NULL, COALESCE(q.used_bytes, 0), COALESCE(q.quota_bytes, s.default_quota_bytes)
+	FROM users u CROSS JOIN quota_settings s LEFT JOIN user_quotas q ON q.user_id = u.id
+	WHERE u.deleted_at IS NULL`
+
+func scanUserSummary(row interface{ Scan(...any) error }, u *models.UserSummary) error {
+	return row.Scan(&u.ID, &u.Username, &u.Email, &u.PasswordHash, &u.Role, &u.SuspendedAt, &u.CreatedAt,
+		&u.EmailVerified, &u.UsedBytes, &u.QuotaBytes)
+}
+
+// ListUsers pages through live users, oldest first, or by storage used when
+// byUsage is set.
+func (r *UserRepository) ListUsers(ctx context.Context, byUsage bool, limit, offset int) ([]models.UserSummary, error) {
+	order := ` ORDER BY u.created_at, u.id`
+	if byUsage {
+		order = ` ORDER BY COALESCE(q.used_bytes, 0) DESC, u.id`
+	}
+	rows, err := r.DB.Query(ctx, userSummaryQuery+order+` LIMIT $1 OFFSET $2`, limit, offset)
+	if err != nil {
+		utils.Error.Err(err).Msg("failed to list users")
+		return nil, err
+	}
+	defer rows.Close()
+
+	list := []models.UserSummary{}
+	for rows.Next() {
+		var u models.UserSummary
+		if err := scanUserSummary(rows, &u); err != nil {
+			return nil, err
+		}
+		list = append(list, u)
+	}
+	return list, rows.Err()
+}
+
+func (r *UserRepository) GetUserSummary(ctx context.Context, id string) (*models.UserSummary, error) {
+	var u models.UserSummary
+	err := scanUserSummary(r.DB.QueryRow(ctx, userSummaryQuery+` AND u.id=$1`, id), &u)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		utils.Error.Err(err).Str("id", id).Msg("failed to get user")
+		return nil, err
+	}
+	return &u, nil
+}
+
+func (r *UserRepository) SetUserRole(ctx context.Context, id, role string) error {
+	tag, err := r.DB.Exec(ctx, `UPDATE users SET role=$2 WHERE id=$1 AND deleted_at IS NULL`, id, role)
+	if err != nil {
+		utils.Error.Err(err).Str("id", id).Msg("failed to set user role")
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// GrantRoleByEmail gives role to the live users with these emails, ignoring
+// case, and returns how many there were.
+func (r *UserRepository) GrantRoleByEmail(ctx context.Context, emails []string, role string) (int64, error) {
+	tag, err := r.DB.Exec(ctx, `UPDATE users SET role=$2 WHERE lower(email) IN (SELECT lower(e) FROM unnest($1::text[]) e) AND deleted_at IS NULL`, emails, role)
+	if err != nil {
+		utils.Error.Err(err).Str("role", role).Msg("failed to grant role")
+		return 0, err
+	}
+	return tag.RowsAffected(), nil
+}
+
+// SetUserSuspended suspends or reinstates a user. Suspending keeps the time
+// of the first suspension.
+func (r *UserRepository) SetUserSuspended(ctx context.Context, id string, suspended bool) error {
+	query := `UPDATE users SET suspended_at=NULL WHERE id=$1 AND deleted_at IS NULL`
+	if suspended {
+		query = `UPDATE users SET suspended_at=COALESCE(suspended_at, NOW()) WHERE id=$1 AND deleted_at IS NULL`
+	}
+	tag, err := r.DB.Exec(ctx, query, id)
+	if err != nil {
+		utils.Error.Err(err).Str("id", id).Msg("failed to suspend user")
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// ListUserRoles returns the role of every live user whose role is not the
+// default one, by ID.
+func (r *UserRepository) ListUserRoles(ctx context.Context) (map[string]string, error) {
+	rows, err := r.DB.Query(ctx, `SELECT id, role FROM users WHERE role <> $1 AND deleted_at IS NULL`, models.RoleUser)
+	if err != nil {
+		utils.Error.Err(err).Msg("failed to list user roles")
+		return nil, err
+	}
+	defer rows.Close()
+
+	roles := map[string]string{}
+	for rows.Next() {
+		var id, role string
+		if err := rows.Scan(&id, &role); err != nil {
+			return nil, err
+		}
+		roles[id] = role
+	}
+	return roles, rows.Err()
+}
+
+// ListSuspendedUsers returns the IDs of the suspended users.
+func (r *UserRepository) ListSuspendedUsers(ctx context.Context) (map[string]bool, error) {
+	rows, err := r.DB.Query(ctx, `SELECT id FROM users WHERE suspended_at IS NOT NULL`)
+	if err != nil {
+		utils.Error.Err(err).Msg("failed to list suspended users")
+		return nil, err
+	}
+	defer rows.Close()
+
+	suspended := map[string]bool{}
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		suspended[id] = true
+	}
+	return suspended, rows.Err()
+}
@@ -2,46 +2,122 @@ package repositories
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"strings"
 	"time"
 
+	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgxpool"
 
 	"github.com/SrabanMondal/SecureStore/internal/models"
 	"github.com/SrabanMondal/SecureStore/internal/utils"
 )
 
+// ErrNotFound is returned by lookups that callers need to tell apart from
+// database failures.
+var ErrNotFound = errors.New("record not found")
+
 type FileRepository struct {
 	DB *pgxpool.Pool
+
+	// encrypts file paths at rest; nil stores them as given
+	Names *utils.NameCipher
 }
 
-func NewFileRepository(db *pgxpool.Pool) *FileRepository {
-	return &FileRepository{DB: db}
+func NewFileRepository(db *pgxpool.Pool, names *utils.NameCipher) *FileRepository {
+	return &FileRepository{DB: db, Names: names}
 }
 
+const fileColumns = `id, user_id, file_path, size, is_encrypted, storage_key, created_at, status, uploaded_at,
+	content_type, checksum_sha256, checksum_crc32c, current_version, folder_id, trashed_at, wrapped_key, key_id, key_algorithm,
+	encryption_mode, client_wrapped_key, client_iv, client_algorithm`
+
+func scanFile(names *utils.NameCipher, row interface{ Scan(...any) error }, f *models.File) error {
+	err := row.Scan(&f.ID, &f.UserID, &f.FilePath, &f.Size, &f.IsEncrypted, &f.StorageKey, &f.CreatedAt, &f.Status, &f.UploadedAt,
+		&f.ContentType, &f.ChecksumSHA256, &f.ChecksumCRC32C, &f.CurrentVersion, &f.FolderID, &f.TrashedAt,
+		&f.WrappedKey, &f.KeyID, &f.KeyAlgorithm,
+		&f.EncryptionMode, &f.ClientKey.WrappedKey, &f.ClientKey.IV, &f.ClientKey.Algorithm)
+	if err != nil {
+		return err
+	}
+	f.FilePath = names.OpenPath(f.UserID, f.FilePath)
+	return nil
+}
+
+// CreateFile inserts the file together with its first version, creating any
+// missing folders along its path and charging the version to the owner's
+// quota.
 func (r *FileRepository) CreateFile(ctx context.Context, file *models.File) error {
+	tx, err := r.DB.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	sealedPath := r.Names.SealPath(file.UserID, file.FilePath)
+	var isFolder bool
+	err = tx.QueryRow(ctx, `SELECT EXISTS(SELECT 1 FROM folders WHERE user_id=$1 AND path=$2)`, file.UserID, sealedPath).
+		Scan(&isFolder)
+	if err != nil {
+		return err
+	}
+	if isFolder {
+		return ErrPathConflict
+	}
+
+	file.FolderID, err = ensureFolderPath(ctx, tx, file.UserID, utils.ParentPath(sealedPath))
+	if err != nil {
+		return err
+	}
+
 	query := `
-		INSERT INTO files (user_id, file_path, size, is_encrypted, storage_key)
-		VALUES ($1, $2, $3, $4, $5)
-		RETURNING id, created_at, status
+		INSERT INTO files (user_id, file_path, folder_id, size, is_encrypted, storage_key, status,
+			wrapped_key, key_id, key_algorithm, encryption_mode, client_wrapped_key, client_iv, client_algorithm)
+		VALUES ($1, $2, $3, $4, $5, $6, COALESCE(NULLIF($7, ''), 'pending'), $8, $9, $10, $11, $12, $13, $14)
+		RETURNING id, created_at, status, current_version
 	`
-	err := r.DB.QueryRow(ctx, query,
-		file.UserID, file.FilePath, file.Size, file.IsEncrypted, file.StorageKey,
-	).Scan(&file.ID, &file.CreatedAt, &file.Status)
+	err = tx.QueryRow(ctx, query,
+		file.UserID, sealedPath, file.FolderID, file.Size, file.IsEncrypted, file.StorageKey, file.Status,
+		file.WrappedKey, file.KeyID, file.KeyAlgorithm,
+		file.EncryptionMode, file.ClientKey.WrappedKey, file.ClientKey.IV, file.ClientKey.Algorithm,
+	).Scan(&file.ID, &file.CreatedAt, &file.Status, &file.CurrentVersion)
+	if isUniqueViolation(err) {
+		return ErrPathConflict
+	}
 	if err != nil {
 		utils.Error.Err(err).Str("file_path", file.FilePath).Msg("failed to insert file")
 		return err
 	}
-	return nil
+
+	size := objectSize(file.Size, file.IsEncrypted)
+	_, err = tx.Exec(ctx, `
+		INSERT INTO file_versions (file_id, version, storage_key, size, object_size, is_encrypted, status, created_at,
+			wrapped_key, key_id, key_algorithm, encryption_mode, client_wrapped_key, client_iv, client_algorithm)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15)`,
+		file.ID, file.CurrentVersion, file.StorageKey, file.Size, size, file.IsEncrypted, file.Status, file.CreatedAt,
+		file.WrappedKey, file.KeyID, file.KeyAlgorithm,
+		file.EncryptionMode, file.ClientKey.WrappedKey, file.ClientKey.IV, file.ClientKey.Algorithm)
+	if err != nil {
+		utils.Error.Err(err).Str("file_id", file.ID).Msg("failed to insert first file version")
+		return err
+	}
+
+	if err := chargeUsage(ctx, tx, file.UserID, size); err != nil {
+		return err
+	}
+
+	return tx.Commit(ctx)
 }
 
+// GetFileByID returns the file with id, or ErrNotFound when there is none.
 func (r *FileRepository) GetFileByID(ctx context.Context, id string) (*models.File, error) {
-	query := `SELECT id, user_id, file_path, size, is_encrypted, storage_key, created_at, status, uploaded_at 
-			  FROM files WHERE id=$1`
+	query := `SELECT ` + fileColumns + ` FROM files WHERE id=$1`
 	var f models.File
-	err := r.DB.QueryRow(ctx, query, id).
-		Scan(&f.ID, &f.UserID, &f.FilePath, &f.Size, &f.IsEncrypted, &f.StorageKey, &f.CreatedAt, &f.Status, &f.UploadedAt)
+	err := scanFile(r.Names, r.DB.QueryRow(ctx, query, id), &f)
+	if errors.Is(err, pgx.ErrNoRows) || isInvalidID(err) {
+		return nil, ErrNotFound
+	}
 	if err != nil {
 		utils.Error.Err(err).Str("id", id).Msg("file not found")
 		return nil, err
@@ -50,11 +126,12 @@ func (r *FileRepository) GetFileByID(ctx context.Context, id string) (*models.Fi
 }
 
 func (r *FileRepository) GetFileByPath(ctx context.Context, userID, path string) (*models.File, error) {
-	query := `SELECT id, user_id, file_path, size, is_encrypted, storage_key, created_at, status, uploaded_at 
-			  FROM files WHERE user_id=$1 AND file_path=$2`
+	query := `SELECT ` + fileColumns + ` FROM files WHERE user_id=$1 AND file_path=$2`
 	var f models.File
-	err := r.DB.QueryRow(ctx, query, userID, path).
-		Scan(&f.ID, &f.UserID, &f.FilePath, &f.Size, &f.IsEncrypted, &f.StorageKey, &f.CreatedAt, &f.Status, &f.UploadedAt)
+	err := scanFile(r.Names, r.DB.QueryRow(ctx, query, userID, r.Names.SealPath(userID, path)), &f)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrNotFound
+	}
 	if err != nil {
 		utils.Error.Err(err).Str("path", path).Msg("file not found for user")
 		return nil, err
@@ -63,8 +140,7 @@ func (r *FileRepository) GetFileByPath(ctx context.Context, userID, path string)
 }
 
 func (r *FileRepository) ListFilesByUser(ctx context.Context, userID string) ([]models.File, error) {
-	query := `SELECT id, user_id, file_path, size, is_encrypted, storage_key, created_at, status, uploaded_at 
-			  FROM files WHERE user_id=$1`
+	query := `SELECT ` + fileColumns + ` FROM files WHERE user_id=$1 AND status NOT IN ('trashed', 'deleting')`
 	rows, err := r.DB.Query(ctx, query, userID)
 	if err != nil {
 		utils.Error.Err(err).Str("user_id", userID).Msg("failed to list files")
@@ -75,7 +151,7 @@ func (r *FileRepository) ListFilesByUser(ctx context.Context, userID string) ([]
 	var files []models.File
 	for rows.Next() {
 		var f models.File
-		if err := rows.Scan(&f.ID, &f.UserID, &f.FilePath, &f.Size, &f.IsEncrypted, &f.StorageKey, &f.CreatedAt, &f.Status, &f.UploadedAt); err != nil {
+		if err := scanFile(r.Names, rows, &f); err != nil {
 			return nil, err
 		}
 		files = append(files, f)
@@ -83,18 +159,52 @@ func (r *FileRepository) ListFilesByUser(ctx context.Context, userID string) ([]
 	return files, nil
 }
 
+// DeleteFile removes a file with all its versions and releases their bytes
+// from the owner's usage.
 func (r *FileRepository) DeleteFile(ctx context.Context, id string) error {
+	tx, err := r.DB.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	userID, err := lockFileOwner(ctx, tx, id)
+	if errors.Is(err, ErrNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	var size int64
+	if err := tx.QueryRow(ctx, `SELECT COALESCE(SUM(object_size), 0) FROM file_versions WHERE file_id=$1`, id).Scan(&size); err != nil {
+		utils.Error.Err(err).Str("id", id).Msg("failed to sum file versions")
+		return err
+	}
+
 	query := `DELETE FROM files WHERE id=$1`
-	_, err := r.DB.Exec(ctx, query, id)
+	_, err = tx.Exec(ctx, query, id)
 	if err != nil {
 		utils.Error.Err(err).Str("id", id).Msg("failed to delete file")
 		return err
 	}
-	return nil
+
+	if err := chargeUsage(ctx, tx, userID, -size); err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
 }
 
+// MarkFileUploaded flips a file and its current version to uploaded.
 func (r *FileRepository) MarkFileUploaded(ctx context.Context, id string) error {
-	query := `UPDATE files SET status='uploaded', uploaded_at=$2 WHERE id=$1`
+	query := `
+		WITH f AS (
+			UPDATE files SET status='uploaded', uploaded_at=$2 WHERE id=$1
+			RETURNING id, current_version
+		)
+		UPDATE file_versions v SET status='uploaded', uploaded_at=$2
+		FROM f WHERE v.file_id=f.id AND v.version=f.current_version
+	`
 	_, err := r.DB.Exec(ctx, query, id, time.Now())
 	if err != nil {
 		utils.Error.Err(err).Str("id", id).Msg("failed to mark file as uploaded")
@@ -103,6 +213,135 @@ func (r *FileRepository) MarkFileUploaded(ctx context.Context, id string) error
 	return nil
 }
 
+// MarkFileVerified records what was found in storage for a pending upload
+// and flips the file and its current version to uploaded. It returns
+// ErrNotFound when the file is no longer pending.
+func (r *FileRepository) MarkFileVerified(ctx context.Context, f *models.File) error {
+	tx, err := r.DB.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	query := `UPDATE files SET status='uploaded', uploaded_at=$2, size=$3, content_type=$4,
+			  checksum_sha256=$5, checksum_crc32c=$6 WHERE id=$1 AND status='pending'
+			  RETURNING status, uploaded_at, current_version`
+	err = tx.QueryRow(ctx, query, f.ID, time.Now(), f.Size, f.ContentType, f.ChecksumSHA256, f.ChecksumCRC32C).
+		Scan(&f.Status, &f.UploadedAt, &f.CurrentVersion)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return ErrNotFound
+	}
+	if err != nil {
+		utils.Error.Err(err).Str("id", f.ID).Msg("failed to mark file as verified")
+		return err
+	}
+
+	_, err = tx.Exec(ctx, `
+		UPDATE file_versions SET status='uploaded', uploaded_at=$3, size=$4, content_type=$5,
+		checksum_sha256=$6, checksum_crc32c=$7 WHERE file_id=$1 AND version=$2`,
+		f.ID, f.CurrentVersion, f.UploadedAt, f.Size, f.ContentType, f.ChecksumSHA256, f.ChecksumCRC32C)
+	if err != nil {
+		utils.Error.Err(err).Str("id", f.ID).Msg("failed to mark file version as verified")
+		return err
+	}
+
+	return tx.Commit(ctx)
+}
+
+// trashStatus moves uploaded files to the trash and anything else, including
+// files already in the trash, straight to deletion.
+const trashStatus = `status = CASE WHEN status = 'uploaded' THEN 'trashed' ELSE 'deleting' END,
+	trashed_at = CASE WHEN status = 'uploaded' THEN NOW() END`
+
+func (r *FileRepository) TrashFile(ctx context.Context, f *models.File) error {
+	query := `UPDATE files SET ` + trashStatus + ` WHERE id=$1 RETURNING status, trashed_at`
+	err := r.DB.QueryRow(ctx, query, f.ID).Scan(&f.Status, &f.TrashedAt)
+	if err != nil {
+		utils.Error.Err(err).Str("id", f.ID).Msg("failed to trash file")
+		return err
+	}
+	return nil
+}
+
+// RestoreFile takes a file out of the trash, recreating its folders if they
+// were deleted in the meantime.
+func (r *FileRepository) RestoreFile(ctx context.Context, f *models.File) error {
+	tx, err := r.DB.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	sealedPath := r.Names.SealPath(f.UserID, f.FilePath)
+	var isFolder bool
+	err = tx.QueryRow(ctx, `SELECT EXISTS(SELECT 1 FROM folders WHERE user_id=$1 AND path=$2)`, f.UserID, sealedPath).
+		Scan(&isFolder)
+	if err != nil {
+		return err
+	}
+	if isFolder {
+		return ErrPathConflict
+	}
+
+	folderID, err := ensureFolderPath(ctx, tx, f.UserID, utils.ParentPath(sealedPath))
+	if err != nil {
+		return err
+	}
+
+	query := `UPDATE files SET status='uploaded', trashed_at=NULL, folder_id=$2
+			  WHERE id=$1 AND status='trashed' RETURNING ` + fileColumns
+	err = scanFile(r.Names, tx.QueryRow(ctx, query, f.ID, folderID), f)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return ErrNotFound
+	}
+	if err != nil {
+		utils.Error.Err(err).Str("id", f.ID).Msg("failed to restore file")
+		return err
+	}
+
+	return tx.Commit(ctx)
+}
+
+func (r *FileRepository) ListTrashedFiles(ctx context.Context, userID string) ([]models.File, error) {
+	query := `SELECT ` + fileColumns + ` FROM files WHERE user_id=$1 AND status='trashed' ORDER BY trashed_at DESC`
+	rows, err := r.DB.Query(ctx, query, userID)
+	if err != nil {
+		utils.Error.Err(err).Str("user_id", userID).Msg("failed to list trashed files")
+		return nil, err
+	}
+	defer rows.Close()
+
+	var files []models.File
+	for rows.Next() {
+		var f models.File
+		if err := scanFile(r.Names, rows, &f); err != nil {
+			return nil, err
+		}
+		files = append(files, f)
+	}
+	return files, nil
+}
+
+// EmptyTrash hands every trashed file of the user to the cleanup job.
+func (r *FileRepository) EmptyTrash(ctx context.Context, userID string) (int64, error) {
+	tag, err := r.DB.Exec(ctx, `UPDATE files SET status='deleting' WHERE user_id=$1 AND status='trashed'`, userID)
+	if err != nil {
+		utils.Error.Err(err).Str("user_id", userID).Msg("failed to empty trash")
+		return 0, err
+	}
+	return tag.RowsAffected(), nil
+}
+
+// ExpireTrash hands files trashed before the cutoff to the cleanup job.
+func (r *FileRepository) ExpireTrash(ctx context.Context, before time.Time) (int64, error) {
+	tag, err := r.DB.Exec(ctx, `UPDATE files SET status='deleting' WHERE status='trashed' AND trashed_at < $1`, before)
+	if err != nil {
+		utils.Error.Err(err).Msg("failed to expire trashed files")
+		return 0, err
+	}
+	return tag.RowsAffected(), nil
+}
+
 func (r *FileRepository) UpdateFileStatus(ctx context.Context, id, status string) error {
 	query := `UPDATE files SET status=$2 WHERE id=$1`
 	_, err := r.DB.Exec(ctx, query, id, status)
@@ -125,8 +364,7 @@ func (r *FileRepository) ListFilesByStatus(ctx context.Context, statuses []strin
 		placeholders[i] = fmt.Sprintf("$%d", i+1)
 	}
 
-	query := `SELECT id, user_id, file_path, size, is_encrypted, storage_key, created_at, status, uploaded_at
-			  FROM files WHERE status IN (` + strings.Join(placeholders, ",") + `)`
+	query := `SELECT ` + fileColumns + ` FROM files WHERE status IN (` + strings.Join(placeholders, ",") + `)`
 
 	rows, err := r.DB.Query(ctx, query, args...)
 	if err != nil {
@@ -138,10 +376,7 @@ func (r *FileRepository) ListFilesByStatus(ctx context.Context, statuses []strin
 	var files []models.File
 	for rows.Next() {
 		var f models.File
-		if err := rows.Scan(
-			&f.ID, &f.UserID, &f.FilePath, &f.Size, &f.IsEncrypted, &f.StorageKey,
-			&f.CreatedAt, &f.Status, &f.UploadedAt,
-		); err != nil {
+		if err := scanFile(r.Names, rows, &f); err != nil {
 			return nil, err
 		}
 		files = append(files, f)
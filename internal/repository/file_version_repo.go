@@ -0,0 +1,454 @@
+package repositories
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+
+	"github.com/SrabanMondal/SecureStore/internal/models"
+	"github.com/SrabanMondal/SecureStore/internal/utils"
+)
+
+const versionColumns = `id, file_id, version, storage_key, size, object_size, is_encrypted, content_type,
+	checksum_sha256, checksum_crc32c, status, created_at, uploaded_at, wrapped_key, key_id, key_algorithm,
+	encryption_mode, client_wrapped_key, client_iv, client_algorithm`
+
+func scanVersion(row interface{ Scan(...any) error }, v *models.FileVersion) error {
+	return row.Scan(&v.ID, &v.FileID, &v.Version, &v.StorageKey, &v.Size, &v.ObjectSize, &v.IsEncrypted, &v.ContentType,
+		&v.ChecksumSHA256, &v.ChecksumCRC32C, &v.Status, &v.CreatedAt, &v.UploadedAt, &v.WrappedKey, &v.KeyID, &v.KeyAlgorithm,
+		&v.EncryptionMode, &v.ClientKey.WrappedKey, &v.ClientKey.IV, &v.ClientKey.Algorithm)
+}
+
+func collectVersions(rows pgx.Rows) ([]models.FileVersion, error) {
+	defer rows.Close()
+
+	var versions []models.FileVersion
+	for rows.Next() {
+		var v models.FileVersion
+		if err := scanVersion(rows, &v); err != nil {
+			return nil, err
+		}
+		versions = append(versions, v)
+	}
+	return versions, rows.Err()
+}
+
+// CreateVersion adds a version (pending unless v.Status says otherwise) numbered one past the highest existing
+// one, charging its object size to the owner's quota.
+func (r *FileRepository) CreateVersion(ctx context.Context, v *models.FileVersion) error {
+	tx, err := r.DB.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	userID, err := lockFileOwner(ctx, tx, v.FileID)
+	if err != nil {
+		return err
+	}
+
+	v.ObjectSize = objectSize(v.Size, v.IsEncrypted)
+	query := `
+		INSERT INTO file_versions (file_id, version, storage_key, size, object_size, is_encrypted, status,
+			wrapped_key, key_id, key_algorithm, encryption_mode, client_wrapped_key, client_iv, client_algorithm)
+		SELECT $1, COALESCE(MAX(version), 0) + 1, $2, $3, $4, $5, COALESCE(NULLIF($6, ''), 'pending'), $7, $8, $9,
+			$10, $11, $12, $13
+		FROM file_versions WHERE file_id=$1
+		RETURNING id, version, status, created_at
+	`
+	err = tx.QueryRow(ctx, query, v.FileID, v.StorageKey, v.Size, v.ObjectSize, v.IsEncrypted, v.Status,
+		v.WrappedKey, v.KeyID, v.KeyAlgorithm, v.EncryptionMode, v.ClientKey.WrappedKey, v.ClientKey.IV, v.ClientKey.Algorithm).
+		Scan(&v.ID, &v.Version, &v.Status, &v.CreatedAt)
+	if err != nil {
+		utils.Error.Err(err).Str("file_id", v.FileID).Msg("failed to insert file version")
+		return err
+	}
+
+	if err := chargeUsage(ctx, tx, userID, v.ObjectSize); err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
+
+func (r *FileRepository) GetVersion(ctx context.Context, fileID string, version int) (*models.FileVersion, error) {
+	query := `SELECT ` + versionColumns + ` FROM file_versions WHERE file_id=$1 AND version=$2`
+	var v models.FileVersion
+	err := scanVersion(r.DB.QueryRow(ctx, query, fileID, version), &v)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		utils.Error.Err(err).Str("file_id", fileID).Int("version", version).Msg("failed to get file version")
+		return nil, err
+	}
+	return &v, nil
+}
+
+// LatestPendingVersion returns the newest version still waiting for its
+// upload to be finalized.
+func (r *FileRepository) LatestPendingVersion(ctx context.Context, fileID string) (*models.FileVersion, error) {
+	query := `SELECT ` + versionColumns + ` FROM file_versions
+			  WHERE file_id=$1 AND status='pending' ORDER BY version DESC LIMIT 1`
+	var v models.FileVersion
+	err := scanVersion(r.DB.QueryRow(ctx, query, fileID), &v)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		utils.Error.Err(err).Str("file_id", fileID).Msg("failed to get pending file version")
+		return nil, err
+	}
+	return &v, nil
+}
+
+func (r *FileRepository) ListVersions(ctx context.Context, fileID string) ([]models.FileVersion, error) {
+	query := `SELECT ` + versionColumns + ` FROM file_versions WHERE file_id=$1 ORDER BY version DESC`
+	rows, err := r.DB.Query(ctx, query, fileID)
+	if err != nil {
+		utils.Error.Err(err).Str("file_id", fileID).Msg("failed to list file versions")
+		return nil, err
+	}
+	return collectVersions(rows)
+}
+
+// PromoteVersion marks an uploaded version as such and makes it the file's
+// current content.
+func (r *FileRepository) PromoteVersion(ctx context.Context, f *models.File, v *models.FileVersion) error {
+	tx, err := r.DB.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	err = tx.QueryRow(ctx, `
+		UPDATE file_versions SET status='uploaded', uploaded_at=$2, size=$3, content_type=$4,
+		checksum_sha256=$5, checksum_crc32c=$6 WHERE file_id=$7 AND version=$1
+		RETURNING status, uploaded_at`,
+		v.Version, time.Now(), v.Size, v.ContentType, v.ChecksumSHA256, v.ChecksumCRC32C, f.ID,
+	).Scan(&v.Status, &v.UploadedAt)
+	if err != nil {
+		utils.Error.Err(err).Str("file_id", f.ID).Int("version", v.Version).Msg("failed to mark file version as uploaded")
+		return err
+	}
+
+	if err := r.setCurrentVersion(ctx, tx, f, v); err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
+
+// RestoreVersion makes an earlier uploaded version the current one again.
+func (r *FileRepository) RestoreVersion(ctx context.Context, f *models.File, v *models.FileVersion) error {
+	tx, err := r.DB.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	if err := r.setCurrentVersion(ctx, tx, f, v); err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
+
+// setCurrentVersion copies a version's object metadata onto the file row,
+// which always describes the current version. A file that is trashed or
+// being deleted is left alone and ErrNotFound returned.
+func (r *FileRepository) setCurrentVersion(ctx context.Context, tx pgx.Tx, f *models.File, v *models.FileVersion) error {
+	query := `
+		UPDATE files SET current_version=$2, storage_key=$3, size=$4, is_encrypted=$5, content_type=$6,
+		checksum_sha256=$7, checksum_crc32c=$8, status='uploaded', uploaded_at=$9,
+		wrapped_key=$10, key_id=$11, key_algorithm=$12,
+		encryption_mode=$13, client_wrapped_key=$14, client_iv=$15, client_algorithm=$16
+		WHERE id=$1 AND status IN ('pending','uploaded')
+		RETURNING ` + fileColumns
+	err := scanFile(r.Names, tx.QueryRow(ctx, query, f.ID, v.Version, v.StorageKey, v.Size, v.IsEncrypted, v.ContentType,
+		v.ChecksumSHA256, v.ChecksumCRC32C, v.UploadedAt, v.WrappedKey, v.KeyID, v.KeyAlgorithm,
+		v.EncryptionMode, v.ClientKey.WrappedKey, v.ClientKey.IV, v.ClientKey.Algorithm), f)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return ErrNotFound
+	}
+	if err != nil {
+		utils.Error.Err(err).Str("id", f.ID).Int("version", v.Version).Msg("failed to set current file version")
+		return err
+	}
+	return nil
+}
+
+// DeleteVersion removes a version and releases its bytes from the owner's
+// usage.
+func (r *FileRepository) DeleteVersion(ctx context.Context, fileID string, version int) error {
+	tx, err := r.DB.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	userID, err := lockFileOwner(ctx, tx, fileID)
+	if errors.Is(err, ErrNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	var size int64
+	err = tx.QueryRow(ctx, `DELETE FROM file_versions WHERE file_id=$1 AND version=$2 RETURNING object_size`, fileID, version).
+		Scan(&size)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil
+	}
+	if err != nil {
+		utils.Error.Err(err).Str("file_id", fileID).Int("version", version).Msg("failed to delete file version")
+		return err
+	}
+
+	if err := chargeUsage(ctx, tx, userID, -size); err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
+
+// ListPrunableVersions returns non-current versions that fall outside the
+// retention policy: beyond the keep newest versions of their file (keep
+// counts the current one; 0 disables the limit), created before olderThan
+// (zero disables it), or still pending since before stalePending.
+func (r *FileRepository) ListPrunableVersions(ctx context.Context, keep int, olderThan, stalePending time.Time) ([]models.FileVersion, error) {
+	var cutoff *time.Time
+	if !olderThan.IsZero() {
+		cutoff = &olderThan
+	}
+
+	query := `
+		SELECT ` + versionColumns + ` FROM (
+			SELECT v.*, ROW_NUMBER() OVER (PARTITION BY v.file_id, v.status ORDER BY v.version DESC) AS rn
+			FROM file_versions v JOIN files f ON f.id = v.file_id
+			WHERE v.version <> f.current_version AND f.status <> 'deleting'
+		) old
+		WHERE (old.status = 'uploaded' AND (($1::int > 0 AND old.rn >= $1::int) OR old.created_at < $2::timestamp))
+		   OR (old.status = 'pending' AND old.created_at < $3)
+	`
+	rows, err := r.DB.Query(ctx, query, keep, cutoff, stalePending)
+	if err != nil {
+		utils.Error.Err(err).Msg("failed to list prunable file versions")
+		return nil, err
+	}
+	return collectVersions(rows)
+}
+
+// keyedVersions selects the encrypted versions whose data key is wrapped by
+// key $1. Legacy objects (an empty $1) are only picked up once uploaded,
+// since rotating them means rewriting the object.
+const keyedVersions = `is_encrypted AND key_id=$1 AND ($1 <> '' OR status='uploaded')`
+
+// CountVersionsByKey counts the versions wrapped by keyID, only those after
+// afterID when it is set.
+func (r *FileRepository) CountVersionsByKey(ctx context.Context, keyID string, afterID *string) (int64, error) {
+	var n int64
+	err := r.DB.QueryRow(ctx, `SELECT COUNT(*) FROM file_versions
+		WHERE `+keyedVersions+` AND ($2::uuid IS NULL OR id > $2::uuid)`, keyID, afterID).Scan(&n)
+	if err != nil {
+		utils.Error.Err(err).Str("key_id", keyID).Msg("failed to count file versions by key")
+		return 0, err
+	}
+	return n, nil
+}
+
+// CountVersionsPerKey returns how many encrypted versions each key wraps.
+func (r *FileRepository) CountVersionsPerKey(ctx context.Context) (map[string]int64, error) {
+	rows, err := r.DB.Query(ctx, `SELECT key_id, COUNT(*) FROM file_versions WHERE is_encrypted GROUP BY key_id`)
+	if err != nil {
+		utils.Error.Err(err).Msg("failed to count file versions per key")
+		return nil, err
+	}
+	defer rows.Close()
+
+	counts := map[string]int64{}
+	for rows.Next() {
+		var id string
+		var n int64
+		if err := rows.Scan(&id, &n); err != nil {
+			return nil, err
+		}
+		counts[id] = n
+	}
+	return counts, rows.Err()
+}
+
+// ListVersionsByKey pages through the versions wrapped by keyID in id order,
+// starting after afterID (nil for the first page).
+func (r *FileRepository) ListVersionsByKey(ctx context.Context, keyID string, afterID *string, limit int) ([]models.FileVersion, error) {
+	query := `SELECT ` + versionColumns + ` FROM file_versions
+			  WHERE ` + keyedVersions + ` AND ($2::uuid IS NULL OR id > $2::uuid)
+			  ORDER BY id LIMIT $3`
+	rows, err := r.DB.Query(ctx, query, keyID, afterID, limit)
+	if err != nil {
+		utils.Error.Err(err).Str("key_id", keyID).Msg("failed to list file versions by key")
+		return nil, err
+	}
+	return collectVersions(rows)
+}
+
+// RewrapVersion replaces the wrapped data key of v, and of its file when v is
+// current, provided it is still wrapped by fromKeyID. It reports whether v was
+// updated.
+func (r *FileRepository) RewrapVersion(ctx context.Context, v *models.FileVersion, fromKeyID string, dk models.DataKey) (bool, error) {
+	tx, err := r.DB.Begin(ctx)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback(ctx)
+
+	tag, err := tx.Exec(ctx, `UPDATE file_versions SET wrapped_key=$3, key_id=$4, key_algorithm=$5
+		WHERE id=$1 AND key_id=$2`, v.ID, fromKeyID, dk.WrappedKey, dk.KeyID, dk.KeyAlgorithm)
+	if err != nil {
+		utils.Error.Err(err).Str("version_id", v.ID).Msg("failed to rewrap file version")
+		return false, err
+	}
+	if tag.RowsAffected() == 0 {
+		return false, nil
+	}
+
+	_, err = tx.Exec(ctx, `UPDATE files SET wrapped_key=$3, key_id=$4, key_algorithm=$5
+		WHERE id=$1 AND current_version=$2`, v.FileID, v.Version, dk.WrappedKey, dk.KeyID, dk.KeyAlgorithm)
+	if err != nil {
+		utils.Error.Err(err).Str("file_id", v.FileID).Msg("failed to rewrap file")
+		return false, err
+	}
+
+	v.DataKey = dk
+	return true, tx.Commit(ctx)
+}
+
+// unboundVersions selects the uploaded encrypted versions whose object was
+// written before objects were bound to their file.
+const unboundVersions = `is_encrypted AND NOT aad_bound AND status='uploaded'`
+
+func (r *FileRepository) CountUnboundVersions(ctx context.Context) (int64, error) {
+	var n int64
+	err := r.DB.QueryRow(ctx, `SELECT COUNT(*) FROM file_versions WHERE `+unboundVersions).Scan(&n)
+	if err != nil {
+		utils.Error.Err(err).Msg("failed to count unbound file versions")
+		return 0, err
+	}
+	return n, nil
+}
+
+// ListUnboundVersions pages through the unbound versions in id order, starting
+// after afterID (nil for the first page).
+func (r *FileRepository) ListUnboundVersions(ctx context.Context, afterID *string, limit int) ([]models.FileVersion, error) {
+	query := `SELECT ` + versionColumns + ` FROM file_versions
+			  WHERE ` + unboundVersions + ` AND ($1::uuid IS NULL OR id > $1::uuid)
+			  ORDER BY id LIMIT $2`
+	rows, err := r.DB.Query(ctx, query, afterID, limit)
+	if err != nil {
+		utils.Error.Err(err).Msg("failed to list unbound file versions")
+		return nil, err
+	}
+	return collectVersions(rows)
+}
+
+// ReplaceVersionObject points v, and its file when v is current, at a newly
+// written object sealed under dk and bound to v, provided v is still wrapped
+// by fromKeyID and has not been rewritten already. The change in object size
+// is applied to the owner's usage. It reports whether v was updated; the
+// caller removes whichever object lost.
+func (r *FileRepository) ReplaceVersionObject(ctx context.Context, v *models.FileVersion, fromKeyID, storageKey string, objectSize int64, dk models.DataKey) (bool, error) {
+	tx, err := r.DB.Begin(ctx)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback(ctx)
+
+	userID, err := lockFileOwner(ctx, tx, v.FileID)
+	if errors.Is(err, ErrNotFound) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	var oldSize int64
+	err = tx.QueryRow(ctx, `
+		UPDATE file_versions new SET storage_key=$3, object_size=$4, wrapped_key=$5, key_id=$6, key_algorithm=$7,
+			aad_bound=TRUE
+		FROM file_versions old
+		WHERE new.id=$1 AND old.id=new.id AND new.key_id=$2 AND NOT new.aad_bound
+		RETURNING old.object_size`,
+		v.ID, fromKeyID, storageKey, objectSize, dk.WrappedKey, dk.KeyID, dk.KeyAlgorithm).Scan(&oldSize)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return false, nil
+	}
+	if err != nil {
+		utils.Error.Err(err).Str("version_id", v.ID).Msg("failed to replace file version object")
+		return false, err
+	}
+
+	_, err = tx.Exec(ctx, `UPDATE files SET storage_key=$3, wrapped_key=$4, key_id=$5, key_algorithm=$6
+		WHERE id=$1 AND current_version=$2`, v.FileID, v.Version, storageKey, dk.WrappedKey, dk.KeyID, dk.KeyAlgorithm)
+	if err != nil {
+		utils.Error.Err(err).Str("file_id", v.FileID).Msg("failed to replace file object")
+		return false, err
+	}
+
+	if err := adjustUsage(ctx, tx, userID, objectSize-oldSize); err != nil {
+		return false, err
+	}
+
+	v.StorageKey, v.ObjectSize, v.DataKey = storageKey, objectSize, dk
+	return true, tx.Commit(ctx)
+}
+
+// namedObjects selects the uploaded versions whose storage key still carries
+// their path. Unbound ones are left to the binding job, which gives them a new
+// object anyway.
+const namedObjects = `status='uploaded' AND storage_key !~ '^[0-9a-f-]{36}/[0-9a-f]{32}$'
+	AND NOT (is_encrypted AND NOT aad_bound)`
+
+// ListNamedObjects pages through the versions stored under named keys in id
+// order, starting after afterID (nil for the first page).
+func (r *FileRepository) ListNamedObjects(ctx context.Context, afterID *string, limit int) ([]models.FileVersion, error) {
+	query := `SELECT ` + versionColumns + ` FROM file_versions
+			  WHERE ` + namedObjects + ` AND ($1::uuid IS NULL OR id > $1::uuid)
+			  ORDER BY id LIMIT $2`
+	rows, err := r.DB.Query(ctx, query, afterID, limit)
+	if err != nil {
+		utils.Error.Err(err).Msg("failed to list named file versions")
+		return nil, err
+	}
+	return collectVersions(rows)
+}
+
+// MoveVersionObject points v, and its file when v is current, at a copy of its
+// object stored under storageKey, provided v has not been moved or rewritten
+// since it was read. It reports whether v was updated; the caller removes
+// whichever object lost.
+func (r *FileRepository) MoveVersionObject(ctx context.Context, v *models.FileVersion, storageKey string) (bool, error) {
+	tx, err := r.DB.Begin(ctx)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback(ctx)
+
+	tag, err := tx.Exec(ctx, `UPDATE file_versions SET storage_key=$3 WHERE id=$1 AND storage_key=$2`,
+		v.ID, v.StorageKey, storageKey)
+	if err != nil {
+		utils.Error.Err(err).Str("version_id", v.ID).Msg("failed to move file version object")
+		return false, err
+	}
+	if tag.RowsAffected() == 0 {
+		return false, nil
+	}
+
+	_, err = tx.Exec(ctx, `UPDATE files SET storage_key=$3 WHERE id=$1 AND storage_key=$2`,
+		v.FileID, v.StorageKey, storageKey)
+	if err != nil {
+		utils.Error.Err(err).Str("file_id", v.FileID).Msg("failed to move file object")
+		return false, err
+	}
+
+	v.StorageKey = storageKey
+	return true, tx.Commit(ctx)
+}
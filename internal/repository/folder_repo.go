@@ -0,0 +1,348 @@
+package repositories
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"strings"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+
+	"github.com/SrabanMondal/SecureStore/internal/models"
+	"github.com/SrabanMondal/SecureStore/internal/utils"
+)
+
+var (
+	ErrPathConflict = errors.New("path already in use")
+	ErrInvalidMove  = errors.New("folder cannot be moved into itself")
+)
+
+type FolderRepository struct {
+	DB *pgxpool.Pool
+
+	// encrypts folder names and paths at rest; nil stores them as given
+	Names *utils.NameCipher
+}
+
+func NewFolderRepository(db *pgxpool.Pool, names *utils.NameCipher) *FolderRepository {
+	return &FolderRepository{DB: db, Names: names}
+}
+
+const folderColumns = `id, user_id, parent_id, name, path, created_at, updated_at`
+
+func scanFolder(names *utils.NameCipher, row interface{ Scan(...any) error }, f *models.Folder) error {
+	err := row.Scan(&f.ID, &f.UserID, &f.ParentID, &f.Name, &f.Path, &f.CreatedAt, &f.UpdatedAt)
+	if err != nil {
+		return err
+	}
+	f.Name = names.OpenName(f.UserID, f.Name)
+	f.Path = names.OpenPath(f.UserID, f.Path)
+	return nil
+}
+
+func isUniqueViolation(err error) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && pgErr.Code == "23505"
+}
+
+// isInvalidID reports whether err is Postgres refusing an id that is not a
+// UUID, which no row can have.
+func isInvalidID(err error) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && pgErr.Code == "22P02"
+}
+
+func joinPath(parent, name string) string {
+	if parent == "" {
+		return name
+	}
+	return parent + "/" + name
+}
+
+func fileAtPath(ctx context.Context, tx pgx.Tx, userID string, paths []string) (bool, error) {
+	var exists bool
+	err := tx.QueryRow(ctx, `SELECT EXISTS(SELECT 1 FROM files WHERE user_id=$1 AND file_path=ANY($2))`, userID, paths).
+		Scan(&exists)
+	return exists, err
+}
+
+// ensureFolderPath creates every folder along dir, a path as stored (sealed
+// when names are encrypted), that does not exist yet and returns the id of
+// the last one, or nil for the root.
+func ensureFolderPath(ctx context.Context, tx pgx.Tx, userID, dir string) (*string, error) {
+	if dir == "" {
+		return nil, nil
+	}
+
+	segs := strings.Split(dir, "/")
+	paths := make([]string, len(segs))
+	for i := range segs {
+		paths[i] = strings.Join(segs[:i+1], "/")
+	}
+	conflict, err := fileAtPath(ctx, tx, userID, paths)
+	if err != nil {
+		return nil, err
+	}
+	if conflict {
+		return nil, ErrPathConflict
+	}
+
+	var parentID *string
+	for i, name := range segs {
+		var id string
+		err := tx.QueryRow(ctx, `
+			INSERT INTO folders (user_id, parent_id, name, path) VALUES ($1, $2, $3, $4)
+			ON CONFLICT (user_id, path) DO UPDATE SET path=EXCLUDED.path
+			RETURNING id`,
+			userID, parentID, name, paths[i]).Scan(&id)
+		if err != nil {
+			utils.Error.Err(err).Str("path", paths[i]).Msg("failed to ensure folder")
+			return nil, err
+		}
+		parentID = &id
+	}
+	return parentID, nil
+}
+
+func (r *FolderRepository) CreateFolder(ctx context.Context, f *models.Folder) error {
+	tx, err := r.DB.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	parentPath := ""
+	if f.ParentID != nil {
+		err := tx.QueryRow(ctx, `SELECT path FROM folders WHERE id=$1 AND user_id=$2`, *f.ParentID, f.UserID).
+			Scan(&parentPath)
+		if err != nil {
+			return ErrNotFound
+		}
+	}
+	name := r.Names.SealName(f.UserID, f.Name)
+	path := joinPath(parentPath, name)
+	f.Path = r.Names.OpenPath(f.UserID, path)
+
+	conflict, err := fileAtPath(ctx, tx, f.UserID, []string{path})
+	if err != nil {
+		return err
+	}
+	if conflict {
+		return ErrPathConflict
+	}
+
+	query := `
+		INSERT INTO folders (user_id, parent_id, name, path)
+		VALUES ($1, $2, $3, $4)
+		RETURNING id, created_at, updated_at
+	`
+	err = tx.QueryRow(ctx, query, f.UserID, f.ParentID, name, path).Scan(&f.ID, &f.CreatedAt, &f.UpdatedAt)
+	if isUniqueViolation(err) {
+		return ErrPathConflict
+	}
+	if err != nil {
+		utils.Error.Err(err).Str("path", f.Path).Msg("failed to insert folder")
+		return err
+	}
+
+	return tx.Commit(ctx)
+}
+
+func (r *FolderRepository) GetFolder(ctx context.Context, id string) (*models.Folder, error) {
+	query := `SELECT ` + folderColumns + ` FROM folders WHERE id=$1`
+	var f models.Folder
+	if err := scanFolder(r.Names, r.DB.QueryRow(ctx, query, id), &f); err != nil {
+		utils.Error.Err(err).Str("id", id).Msg("folder not found")
+		return nil, err
+	}
+	return &f, nil
+}
+
+// ListChildFolders lists the folders directly under parentID, or at the root
+// when it is nil, by name.
+func (r *FolderRepository) ListChildFolders(ctx context.Context, userID string, parentID *string) ([]models.Folder, error) {
+	query := `SELECT ` + folderColumns + ` FROM folders
+			  WHERE user_id=$1 AND parent_id IS NOT DISTINCT FROM $2 ORDER BY name`
+	rows, err := r.DB.Query(ctx, query, userID, parentID)
+	if err != nil {
+		utils.Error.Err(err).Str("user_id", userID).Msg("failed to list child folders")
+		return nil, err
+	}
+	defer rows.Close()
+
+	var folders []models.Folder
+	for rows.Next() {
+		var f models.Folder
+		if err := scanFolder(r.Names, rows, &f); err != nil {
+			return nil, err
+		}
+		folders = append(folders, f)
+	}
+	if r.Names != nil {
+		// the database could only order them by ciphertext
+		sort.Slice(folders, func(i, j int) bool { return folders[i].Name < folders[j].Name })
+	}
+	return folders, rows.Err()
+}
+
+// ListChildFiles pages through the files directly inside folderID, or at the
+// root when it is nil, ordered by path as stored: by ciphertext when names are
+// encrypted.
+func (r *FolderRepository) ListChildFiles(ctx context.Context, userID string, folderID *string, limit, offset int) ([]models.File, error) {
+	query := `SELECT ` + fileColumns + ` FROM files
+			  WHERE user_id=$1 AND folder_id IS NOT DISTINCT FROM $2 AND status NOT IN ('trashed', 'deleting')
+			  ORDER BY file_path LIMIT $3 OFFSET $4`
+	rows, err := r.DB.Query(ctx, query, userID, folderID, limit, offset)
+	if err != nil {
+		utils.Error.Err(err).Str("user_id", userID).Msg("failed to list child files")
+		return nil, err
+	}
+	defer rows.Close()
+
+	var files []models.File
+	for rows.Next() {
+		var f models.File
+		if err := scanFile(r.Names, rows, &f); err != nil {
+			return nil, err
+		}
+		files = append(files, f)
+	}
+	return files, rows.Err()
+}
+
+// Breadcrumbs returns the chain of folders from the root down to id,
+// inclusive.
+func (r *FolderRepository) Breadcrumbs(ctx context.Context, id string) ([]models.Breadcrumb, error) {
+	query := `
+		WITH RECURSIVE chain AS (
+			SELECT id, parent_id, user_id, name, 0 AS depth FROM folders WHERE id=$1
+			UNION ALL
+			SELECT f.id, f.parent_id, f.user_id, f.name, c.depth + 1
+			FROM folders f JOIN chain c ON f.id = c.parent_id
+		)
+		SELECT id, user_id, name FROM chain ORDER BY depth DESC
+	`
+	rows, err := r.DB.Query(ctx, query, id)
+	if err != nil {
+		utils.Error.Err(err).Str("id", id).Msg("failed to load breadcrumbs")
+		return nil, err
+	}
+	defer rows.Close()
+
+	var crumbs []models.Breadcrumb
+	for rows.Next() {
+		var b models.Breadcrumb
+		var userID string
+		if err := rows.Scan(&b.ID, &userID, &b.Name); err != nil {
+			return nil, err
+		}
+		b.Name = r.Names.OpenName(userID, b.Name)
+		crumbs = append(crumbs, b)
+	}
+	return crumbs, rows.Err()
+}
+
+// MoveFolder renames f and/or re-parents it under parentID (nil for the
+// root). The paths of every folder, file and unfinished tus upload beneath it
+// are rewritten in the same transaction; stored objects are left alone.
+func (r *FolderRepository) MoveFolder(ctx context.Context, f *models.Folder, parentID *string, name string) error {
+	tx, err := r.DB.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	var oldPath string
+	if err := tx.QueryRow(ctx, `SELECT path FROM folders WHERE id=$1 FOR UPDATE`, f.ID).Scan(&oldPath); err != nil {
+		return ErrNotFound
+	}
+
+	parentPath := ""
+	if parentID != nil {
+		err := tx.QueryRow(ctx, `SELECT path FROM folders WHERE id=$1 AND user_id=$2`, *parentID, f.UserID).
+			Scan(&parentPath)
+		if err != nil {
+			return ErrNotFound
+		}
+		if parentPath == oldPath || strings.HasPrefix(parentPath, oldPath+"/") {
+			return ErrInvalidMove
+		}
+	}
+
+	name = r.Names.SealName(f.UserID, name)
+	newPath := joinPath(parentPath, name)
+	if newPath != oldPath {
+		conflict, err := fileAtPath(ctx, tx, f.UserID, []string{newPath})
+		if err != nil {
+			return err
+		}
+		if conflict {
+			return ErrPathConflict
+		}
+	}
+
+	renames := []struct {
+		query string
+		what  string
+	}{
+		{`UPDATE folders SET path = $3::text || substr(path, length($2::text) + 1), updated_at=NOW()
+		  WHERE user_id=$1 AND (path=$2::text OR left(path, length($2::text) + 1) = $2::text || '/')`, "folders"},
+		{`UPDATE files SET file_path = $3::text || substr(file_path, length($2::text) + 1)
+		  WHERE user_id=$1 AND left(file_path, length($2::text) + 1) = $2::text || '/'`, "files"},
+		{`UPDATE tus_uploads SET file_path = $3::text || substr(file_path, length($2::text) + 1)
+		  WHERE user_id=$1 AND file_id IS NULL AND left(file_path, length($2::text) + 1) = $2::text || '/'`, "tus uploads"},
+	}
+	for _, rn := range renames {
+		_, err := tx.Exec(ctx, rn.query, f.UserID, oldPath, newPath)
+		if isUniqueViolation(err) {
+			return ErrPathConflict
+		}
+		if err != nil {
+			utils.Error.Err(err).Str("id", f.ID).Msg("failed to move " + rn.what)
+			return err
+		}
+	}
+
+	err = scanFolder(r.Names, tx.QueryRow(ctx, `UPDATE folders SET parent_id=$2, name=$3 WHERE id=$1 RETURNING `+folderColumns,
+		f.ID, parentID, name), f)
+	if err != nil {
+		utils.Error.Err(err).Str("id", f.ID).Msg("failed to update folder")
+		return err
+	}
+
+	return tx.Commit(ctx)
+}
+
+// DeleteFolder removes f and everything below it. Uploaded files go to the
+// trash and the rest to the cleanup job; subfolders go by cascade.
+func (r *FolderRepository) DeleteFolder(ctx context.Context, f *models.Folder) error {
+	tx, err := r.DB.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	var path string
+	if err := tx.QueryRow(ctx, `SELECT path FROM folders WHERE id=$1 FOR UPDATE`, f.ID).Scan(&path); err != nil {
+		return ErrNotFound
+	}
+
+	_, err = tx.Exec(ctx, `
+		UPDATE files SET `+trashStatus+`
+		WHERE user_id=$1 AND left(file_path, length($2::text) + 1) = $2::text || '/'
+		AND status NOT IN ('trashed', 'deleting')`,
+		f.UserID, path)
+	if err != nil {
+		utils.Error.Err(err).Str("id", f.ID).Msg("failed to trash folder files")
+		return err
+	}
+
+	if _, err := tx.Exec(ctx, `DELETE FROM folders WHERE id=$1`, f.ID); err != nil {
+		utils.Error.Err(err).Str("id", f.ID).Msg("failed to delete folder")
+		return err
+	}
+
+	return tx.Commit(ctx)
+}
@@ -0,0 +1,94 @@
+package repositories
+
+import (
+	"context"
+	"errors"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+
+	"github.com/SrabanMondal/SecureStore/internal/models"
+	"github.com/SrabanMondal/SecureStore/internal/utils"
+)
+
+type KeyRotationRepository struct {
+	DB *pgxpool.Pool
+}
+
+func NewKeyRotationRepository(db *pgxpool.Pool) *KeyRotationRepository {
+	return &KeyRotationRepository{DB: db}
+}
+
+const keyRotationColumns = `id, from_key_id, to_key_id, status, total, processed, failed, cursor, error,
+	created_at, updated_at, completed_at`
+
+func scanKeyRotation(row interface{ Scan(...any) error }, j *models.KeyRotation) error {
+	return row.Scan(&j.ID, &j.FromKeyID, &j.ToKeyID, &j.Status, &j.Total, &j.Processed, &j.Failed, &j.Cursor, &j.Error,
+		&j.CreatedAt, &j.UpdatedAt, &j.CompletedAt)
+}
+
+func (r *KeyRotationRepository) CreateJob(ctx context.Context, j *models.KeyRotation) error {
+	query := `
+		INSERT INTO key_rotations (from_key_id, to_key_id, total)
+		VALUES ($1, $2, $3)
+		RETURNING ` + keyRotationColumns
+	if err := scanKeyRotation(r.DB.QueryRow(ctx, query, j.FromKeyID, j.ToKeyID, j.Total), j); err != nil {
+		utils.Error.Err(err).Str("from_key_id", j.FromKeyID).Msg("failed to create key rotation")
+		return err
+	}
+	return nil
+}
+
+func (r *KeyRotationRepository) GetJob(ctx context.Context, id string) (*models.KeyRotation, error) {
+	query := `SELECT ` + keyRotationColumns + ` FROM key_rotations WHERE id=$1`
+	var j models.KeyRotation
+	err := scanKeyRotation(r.DB.QueryRow(ctx, query, id), &j)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		utils.Error.Err(err).Str("id", id).Msg("failed to get key rotation")
+		return nil, err
+	}
+	return &j, nil
+}
+
+// ListJobs lists rotations newest first, or only those in status when it is
+// not empty.
+func (r *KeyRotationRepository) ListJobs(ctx context.Context, status string) ([]models.KeyRotation, error) {
+	query := `SELECT ` + keyRotationColumns + ` FROM key_rotations
+			  WHERE $1 = '' OR status = $1 ORDER BY created_at DESC`
+	rows, err := r.DB.Query(ctx, query, status)
+	if err != nil {
+		utils.Error.Err(err).Msg("failed to list key rotations")
+		return nil, err
+	}
+	defer rows.Close()
+
+	var jobs []models.KeyRotation
+	for rows.Next() {
+		var j models.KeyRotation
+		if err := scanKeyRotation(rows, &j); err != nil {
+			return nil, err
+		}
+		jobs = append(jobs, j)
+	}
+	return jobs, rows.Err()
+}
+
+// SaveProgress records how far a job got, along with its status and error.
+func (r *KeyRotationRepository) SaveProgress(ctx context.Context, j *models.KeyRotation) error {
+	query := `
+		UPDATE key_rotations SET status=$2, processed=$3, failed=$4, cursor=$5, error=$6, updated_at=NOW(),
+		completed_at = CASE WHEN $2 = 'completed' THEN NOW() END
+		WHERE id=$1
+		RETURNING updated_at, completed_at
+	`
+	err := r.DB.QueryRow(ctx, query, j.ID, j.Status, j.Processed, j.Failed, j.Cursor, j.Error).
+		Scan(&j.UpdatedAt, &j.CompletedAt)
+	if err != nil {
+		utils.Error.Err(err).Str("id", j.ID).Msg("failed to save key rotation progress")
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,259 @@
+package repositories
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+
+	"github.com/SrabanMondal/SecureStore/internal/models"
+	"github.com/SrabanMondal/SecureStore/internal/utils"
+)
+
+const mfaColumns = `user_id, wrapped_key, key_id, key_algorithm, enabled_at, last_step, created_at`
+
+func scanMFA(row interface{ Scan(...any) error }, m *models.MFA) error {
+	return row.Scan(&m.UserID, &m.WrappedKey, &m.KeyID, &m.KeyAlgorithm, &m.EnabledAt, &m.LastStep, &m.CreatedAt)
+}
+
+func (r *UserRepository) GetMFA(ctx context.Context, userID string) (*models.MFA, error) {
+	query := `SELECT ` + mfaColumns + ` FROM user_mfa WHERE user_id=$1`
+	var m models.MFA
+	err := scanMFA(r.DB.QueryRow(ctx, query, userID), &m)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		utils.Error.Err(err).Str("user_id", userID).Msg("failed to get mfa")
+		return nil, err
+	}
+	return &m, nil
+}
+
+// SaveMFAEnrollment stores a new, not yet enabled TOTP secret, replacing an
+// unconfirmed one. It reports false when MFA is already enabled.
+func (r *UserRepository) SaveMFAEnrollment(ctx context.Context, m *models.MFA) (bool, error) {
+	query := `
+		INSERT INTO user_mfa (user_id, wrapped_key, key_id, key_algorithm)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (user_id) DO UPDATE
+		SET wrapped_key=EXCLUDED.wrapped_key, key_id=EXCLUDED.key_id, key_algorithm=EXCLUDED.key_algorithm,
+			last_step=0, created_at=NOW()
+		WHERE user_mfa.enabled_at IS NULL
+		RETURNING created_at`
+	err := r.DB.QueryRow(ctx, query, m.UserID, m.WrappedKey, m.KeyID, m.KeyAlgorithm).Scan(&m.CreatedAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return false, nil
+	}
+	if err != nil {
+		utils.Error.Err(err).Str("user_id", m.UserID).Msg("failed to save mfa enrollment")
+		return false, err
+	}
+	return true, nil
+}
+
+// EnableMFA turns on a pending enrollment, recording step as used, and
+// replaces the user's recovery codes with codeHashes. It reports false when
+// there was no pending enrollment.
+func (r *UserRepository) EnableMFA(ctx context.Context, userID string, step int64, codeHashes [][]byte) (bool, error) {
+	tx, err := r.DB.Begin(ctx)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback(ctx)
+
+	tag, err := tx.Exec(ctx, `UPDATE user_mfa SET enabled_at=NOW(), last_step=$2
+		WHERE user_id=$1 AND enabled_at IS NULL AND last_step < $2`, userID, step)
+	if err != nil {
+		utils.Error.Err(err).Str("user_id", userID).Msg("failed to enable mfa")
+		return false, err
+	}
+	if tag.RowsAffected() == 0 {
+		return false, nil
+	}
+
+	if _, err := tx.Exec(ctx, `DELETE FROM mfa_recovery_codes WHERE user_id=$1`, userID); err != nil {
+		utils.Error.Err(err).Str("user_id", userID).Msg("failed to clear recovery codes")
+		return false, err
+	}
+	for _, h := range codeHashes {
+		if _, err := tx.Exec(ctx, `INSERT INTO mfa_recovery_codes (user_id, code_hash) VALUES ($1, $2)`, userID, h); err != nil {
+			utils.Error.Err(err).Str("user_id", userID).Msg("failed to store recovery code")
+			return false, err
+		}
+	}
+	return true, tx.Commit(ctx)
+}
+
+// UseTOTPStep records step as the last one used, provided it is later than
+// any used before. It reports whether it was.
+func (r *UserRepository) UseTOTPStep(ctx context.Context, userID string, step int64) (bool, error) {
+	tag, err := r.DB.Exec(ctx, `UPDATE user_mfa SET last_step=$2
+		WHERE user_id=$1 AND enabled_at IS NOT NULL AND last_step < $2`, userID, step)
+	if err != nil {
+		utils.Error.Err(err).Str("user_id", userID).Msg("failed to record totp step")
+		return false, err
+	}
+	return tag.RowsAffected() > 0, nil
+}
+
+// UseRecoveryCode marks the user's unused code with hash used and reports
+// whether there was one.
+func (r *UserRepository) UseRecoveryCode(ctx context.Context, userID string, hash []byte) (bool, error) {
+	tag, err := r.DB.Exec(ctx, `UPDATE mfa_recovery_codes SET used_at=NOW()
+		WHERE user_id=$1 AND code_hash=$2 AND used_at IS NULL`, userID, hash)
+	if err != nil {
+		utils.Error.Err(err).Str("user_id", userID).Msg("failed to use recovery code")
+		return false, err
+	}
+	return tag.RowsAffected() > 0, nil
+}
+
+func (r *UserRepository) CountRecoveryCodes(ctx context.Context, userID string) (int, error) {
+	var n int
+	err := r.DB.QueryRow(ctx, `SELECT COUNT(*) FROM mfa_recovery_codes WHERE user_id=$1 AND used_at IS NULL`, userID).Scan(&n)
+	if err != nil {
+		utils.Error.Err(err).Str("user_id", userID).Msg("failed to count recovery codes")
+		return 0, err
+	}
+	return n, nil
+}
+
+// DeleteMFA removes the user's enrollment, recovery codes and pending
+// challenges.
+func (r *UserRepository) DeleteMFA(ctx context.Context, userID string) error {
+	tx, err := r.DB.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	for _, q := range []string{
+		`DELETE FROM user_mfa WHERE user_id=$1`,
+		`DELETE FROM mfa_recovery_codes WHERE user_id=$1`,
+		`DELETE FROM mfa_challenges WHERE user_id=$1`,
+	} {
+		if _, err := tx.Exec(ctx, q, userID); err != nil {
+			utils.Error.Err(err).Str("user_id", userID).Msg("failed to delete mfa")
+			return err
+		}
+	}
+	return tx.Commit(ctx)
+}
+
+func (r *UserRepository) CreateMFAChallenge(ctx context.Context, userID string, hash []byte, expiresAt time.Time) error {
+	_, err := r.DB.Exec(ctx, `INSERT INTO mfa_challenges (token_hash, user_id, expires_at) VALUES ($1, $2, $3)`,
+		hash, userID, expiresAt)
+	if err != nil {
+		utils.Error.Err(err).Str("user_id", userID).Msg("failed to create mfa challenge")
+		return err
+	}
+	return nil
+}
+
+// AttemptMFAChallenge counts an attempt against the challenge with hash and
+// returns its user, or ErrNotFound when it is unknown, expired or out of
+// attempts.
+func (r *UserRepository) AttemptMFAChallenge(ctx context.Context, hash []byte, maxAttempts int) (string, error) {
+	var userID string
+	err := r.DB.QueryRow(ctx, `UPDATE mfa_challenges SET attempts=attempts+1
+		WHERE token_hash=$1 AND expires_at > NOW() AND attempts < $2
+		RETURNING user_id`, hash, maxAttempts).Scan(&userID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return "", ErrNotFound
+	}
+	if err != nil {
+		utils.Error.Err(err).Msg("failed to attempt mfa challenge")
+		return "", err
+	}
+	return userID, nil
+}
+
+func (r *UserRepository) DeleteMFAChallenge(ctx context.Context, hash []byte) error {
+	if _, err := r.DB.Exec(ctx, `DELETE FROM mfa_challenges WHERE token_hash=$1`, hash); err != nil {
+		utils.Error.Err(err).Msg("failed to delete mfa challenge")
+		return err
+	}
+	return nil
+}
+
+func (r *UserRepository) DeleteExpiredMFAChallenges(ctx context.Context) (int64, error) {
+	tag, err := r.DB.Exec(ctx, `DELETE FROM mfa_challenges WHERE expires_at <= NOW()`)
+	if err != nil {
+		utils.Error.Err(err).Msg("failed to delete expired mfa challenges")
+		return 0, err
+	}
+	return tag.RowsAffected(), nil
+}
+
+func (r *UserRepository) CountMFAByKey(ctx context.Context, keyID string) (int64, error) {
+	var n int64
+	err := r.DB.QueryRow(ctx, `SELECT COUNT(*) FROM user_mfa WHERE key_id=$1`, keyID).Scan(&n)
+	if err != nil {
+		utils.Error.Err(err).Str("key_id", keyID).Msg("failed to count mfa secrets")
+		return 0, err
+	}
+	return n, nil
+}
+
+func (r *UserRepository) CountMFAPerKey(ctx context.Context) (map[string]int64, error) {
+	rows, err := r.DB.Query(ctx, `SELECT key_id, COUNT(*) FROM user_mfa GROUP BY key_id`)
+	if err != nil {
+		utils.Error.Err(err).Msg("failed to count mfa secrets per key")
+		return nil, err
+	}
+	defer rows.Close()
+
+	counts := map[string]int64{}
+	for rows.Next() {
+		var id string
+		var n int64
+		if err := rows.Scan(&id, &n); err != nil {
+			return nil, err
+		}
+		counts[id] = n
+	}
+	return counts, rows.Err()
+}
+
+// ListMFAByKey pages through the TOTP secrets wrapped by keyID in user
+// order, starting after afterUserID when it is set.
+func (r *UserRepository) ListMFAByKey(ctx context.Context, keyID string, afterUserID *string, limit int) ([]models.MFA, error) {
+	query := `SELECT ` + mfaColumns + ` FROM user_mfa
+			  WHERE key_id=$1 AND ($2::uuid IS NULL OR user_id > $2::uuid)
+			  ORDER BY user_id LIMIT $3`
+	rows, err := r.DB.Query(ctx, query, keyID, afterUserID, limit)
+	if err != nil {
+		utils.Error.Err(err).Str("key_id", keyID).Msg("failed to list mfa secrets")
+		return nil, err
+	}
+	defer rows.Close()
+
+	var list []models.MFA
+	for rows.Next() {
+		var m models.MFA
+		if err := scanMFA(rows, &m); err != nil {
+			return nil, err
+		}
+		list = append(list, m)
+	}
+	return list, rows.Err()
+}
+
+// RewrapMFA swaps the master key wrapping m's secret for dk, provided the
+// stored secret has not changed since m was read. It reports whether m was
+// updated.
+func (r *UserRepository) RewrapMFA(ctx context.Context, m *models.MFA, dk models.DataKey) (bool, error) {
+	tag, err := r.DB.Exec(ctx, `UPDATE user_mfa SET wrapped_key=$4, key_id=$5, key_algorithm=$6
+		WHERE user_id=$1 AND key_id=$2 AND wrapped_key=$3`, m.UserID, m.KeyID, m.WrappedKey, dk.WrappedKey, dk.KeyID, dk.KeyAlgorithm)
+	if err != nil {
+		utils.Error.Err(err).Str("user_id", m.UserID).Msg("failed to rewrap mfa secret")
+		return false, err
+	}
+	if tag.RowsAffected() == 0 {
+		return false, nil
+	}
+	m.DataKey = dk
+	return true, nil
+}
@@ -0,0 +1,137 @@
+package repositories
+
+import (
+	"context"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+
+	"github.com/SrabanMondal/SecureStore/internal/models"
+	"github.com/SrabanMondal/SecureStore/internal/utils"
+)
+
+type MultipartRepository struct {
+	DB *pgxpool.Pool
+}
+
+func NewMultipartRepository(db *pgxpool.Pool) *MultipartRepository {
+	return &MultipartRepository{DB: db}
+}
+
+func (r *MultipartRepository) CreateUpload(ctx context.Context, u *models.MultipartUpload) error {
+	query := `
+		INSERT INTO multipart_uploads (file_id, version, user_id, upload_id, storage_key, size, part_size)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		RETURNING id, created_at, updated_at
+	`
+	err := r.DB.QueryRow(ctx, query,
+		u.FileID, u.Version, u.UserID, u.UploadID, u.StorageKey, u.Size, u.PartSize,
+	).Scan(&u.ID, &u.CreatedAt, &u.UpdatedAt)
+	if err != nil {
+		utils.Error.Err(err).Str("file_id", u.FileID).Msg("failed to create multipart upload")
+		return err
+	}
+	return nil
+}
+
+func (r *MultipartRepository) GetUpload(ctx context.Context, id string) (*models.MultipartUpload, error) {
+	query := `SELECT id, file_id, version, user_id, upload_id, storage_key, size, part_size, created_at, updated_at
+			  FROM multipart_uploads WHERE id=$1`
+	var u models.MultipartUpload
+	err := r.DB.QueryRow(ctx, query, id).
+		Scan(&u.ID, &u.FileID, &u.Version, &u.UserID, &u.UploadID, &u.StorageKey, &u.Size, &u.PartSize, &u.CreatedAt, &u.UpdatedAt)
+	if err != nil {
+		utils.Error.Err(err).Str("id", id).Msg("multipart upload not found")
+		return nil, err
+	}
+	return &u, nil
+}
+
+func (r *MultipartRepository) TouchUpload(ctx context.Context, id string) error {
+	query := `UPDATE multipart_uploads SET updated_at=$2 WHERE id=$1`
+	_, err := r.DB.Exec(ctx, query, id, time.Now())
+	if err != nil {
+		utils.Error.Err(err).Str("id", id).Msg("failed to touch multipart upload")
+		return err
+	}
+	return nil
+}
+
+// SaveParts records the parts the object store reports for an upload,
+// replacing any previously stored ETag for the same part number.
+func (r *MultipartRepository) SaveParts(ctx context.Context, uploadID string, parts []models.MultipartPart) error {
+	tx, err := r.DB.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	query := `
+		INSERT INTO multipart_parts (upload_id, part_number, etag, size)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (upload_id, part_number) DO UPDATE SET etag=EXCLUDED.etag, size=EXCLUDED.size, uploaded_at=NOW()
+		WHERE multipart_parts.etag <> EXCLUDED.etag
+	`
+	for _, p := range parts {
+		if _, err := tx.Exec(ctx, query, uploadID, p.PartNumber, p.ETag, p.Size); err != nil {
+			utils.Error.Err(err).Str("upload_id", uploadID).Int("part", p.PartNumber).Msg("failed to save multipart part")
+			return err
+		}
+	}
+	if _, err := tx.Exec(ctx, `UPDATE multipart_uploads SET updated_at=$2 WHERE id=$1`, uploadID, time.Now()); err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
+
+func (r *MultipartRepository) ListParts(ctx context.Context, uploadID string) ([]models.MultipartPart, error) {
+	query := `SELECT part_number, etag, size, uploaded_at FROM multipart_parts
+			  WHERE upload_id=$1 ORDER BY part_number`
+	rows, err := r.DB.Query(ctx, query, uploadID)
+	if err != nil {
+		utils.Error.Err(err).Str("upload_id", uploadID).Msg("failed to list multipart parts")
+		return nil, err
+	}
+	defer rows.Close()
+
+	var parts []models.MultipartPart
+	for rows.Next() {
+		var p models.MultipartPart
+		if err := rows.Scan(&p.PartNumber, &p.ETag, &p.Size, &p.UploadedAt); err != nil {
+			return nil, err
+		}
+		parts = append(parts, p)
+	}
+	return parts, nil
+}
+
+func (r *MultipartRepository) DeleteUpload(ctx context.Context, id string) error {
+	query := `DELETE FROM multipart_uploads WHERE id=$1`
+	_, err := r.DB.Exec(ctx, query, id)
+	if err != nil {
+		utils.Error.Err(err).Str("id", id).Msg("failed to delete multipart upload")
+		return err
+	}
+	return nil
+}
+
+func (r *MultipartRepository) ListStaleUploads(ctx context.Context, before time.Time) ([]models.MultipartUpload, error) {
+	query := `SELECT id, file_id, version, user_id, upload_id, storage_key, size, part_size, created_at, updated_at
+			  FROM multipart_uploads WHERE updated_at < $1`
+	rows, err := r.DB.Query(ctx, query, before)
+	if err != nil {
+		utils.Error.Err(err).Msg("failed to list stale multipart uploads")
+		return nil, err
+	}
+	defer rows.Close()
+
+	var uploads []models.MultipartUpload
+	for rows.Next() {
+		var u models.MultipartUpload
+		if err := rows.Scan(&u.ID, &u.FileID, &u.Version, &u.UserID, &u.UploadID, &u.StorageKey, &u.Size, &u.PartSize, &u.CreatedAt, &u.UpdatedAt); err != nil {
+			return nil, err
+		}
+		uploads = append(uploads, u)
+	}
+	return uploads, nil
+}
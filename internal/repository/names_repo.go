@@ -0,0 +1,129 @@
+package repositories
+
+import (
+	"context"
+
+	"github.com/jackc/pgx/v5"
+
+	"github.com/SrabanMondal/SecureStore/internal/utils"
+)
+
+// users converted per page by SealNames
+const sealNamesBatch = 100
+
+// sealedColumn is a name-bearing column and how it is sealed.
+type sealedColumn struct {
+	name string
+	path bool
+}
+
+var sealedTables = []struct {
+	table   string
+	columns []sealedColumn
+}{
+	{"files", []sealedColumn{{"file_path", true}}},
+	{"folders", []sealedColumn{{"name", false}, {"path", true}}},
+	{"tus_uploads", []sealedColumn{{"file_path", true}, {"metadata", false}}},
+}
+
+// SealNames encrypts the names stored for users not converted yet, that is
+// everything written before NAME_ENC_KEY was set, one user per transaction.
+// Names that are already sealed stay as they are. It has to finish before
+// requests are served, since lookups only match sealed names. It returns how
+// many users were converted.
+func (r *FileRepository) SealNames(ctx context.Context) (int, error) {
+	if r.Names == nil {
+		return 0, nil
+	}
+
+	converted := 0
+	for {
+		rows, err := r.DB.Query(ctx, `SELECT id FROM users WHERE NOT names_sealed ORDER BY id LIMIT $1`, sealNamesBatch)
+		if err != nil {
+			utils.Error.Err(err).Msg("failed to list users with unsealed names")
+			return converted, err
+		}
+		var userIDs []string
+		for rows.Next() {
+			var id string
+			if err := rows.Scan(&id); err != nil {
+				rows.Close()
+				return converted, err
+			}
+			userIDs = append(userIDs, id)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return converted, err
+		}
+
+		for _, id := range userIDs {
+			if err := r.sealUserNames(ctx, id); err != nil {
+				utils.Error.Err(err).Str("user_id", id).Msg("failed to seal names")
+				return converted, err
+			}
+			converted++
+		}
+		if len(userIDs) < sealNamesBatch {
+			return converted, nil
+		}
+	}
+}
+
+func (r *FileRepository) sealUserNames(ctx context.Context, userID string) error {
+	tx, err := r.DB.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	for _, t := range sealedTables {
+		for _, col := range t.columns {
+			if err := r.sealColumn(ctx, tx, t.table, col, userID); err != nil {
+				return err
+			}
+		}
+	}
+
+	if _, err := tx.Exec(ctx, `UPDATE users SET names_sealed=TRUE WHERE id=$1`, userID); err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
+
+func (r *FileRepository) sealColumn(ctx context.Context, tx pgx.Tx, table string, col sealedColumn, userID string) error {
+	rows, err := tx.Query(ctx, `SELECT id, `+col.name+` FROM `+table+` WHERE user_id=$1 FOR UPDATE`, userID)
+	if err != nil {
+		return err
+	}
+	type entry struct{ id, value string }
+	var entries []entry
+	for rows.Next() {
+		var e entry
+		if err := rows.Scan(&e.id, &e.value); err != nil {
+			rows.Close()
+			return err
+		}
+		entries = append(entries, e)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, e := range entries {
+		var sealed string
+		if col.path {
+			sealed = r.Names.SealPath(userID, r.Names.OpenPath(userID, e.value))
+		} else {
+			sealed = r.Names.SealName(userID, r.Names.OpenName(userID, e.value))
+		}
+		if sealed == e.value {
+			continue
+		}
+		if _, err := tx.Exec(ctx, `UPDATE `+table+` SET `+col.name+`=$2 WHERE id=$1`, e.id, sealed); err != nil {
+			return err
+		}
+	}
+	return nil
+}
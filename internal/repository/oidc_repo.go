@@ -0,0 +1,175 @@
+package repositories
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+
+	"github.com/SrabanMondal/SecureStore/internal/models"
+	"github.com/SrabanMondal/SecureStore/internal/utils"
+)
+
+// ErrUserConflict is returned when a new user's username or email is taken.
+var ErrUserConflict = errors.New("username or email already in use")
+
+func (r *UserRepository) CreateOIDCState(ctx context.Context, hash []byte, s *models.OIDCState, expiresAt time.Time) error {
+	_, err := r.DB.Exec(ctx, `INSERT INTO oidc_states (state_hash, provider, nonce, code_verifier, binding_hash, reauth_user_id, expires_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)`, hash, s.Provider, s.Nonce, s.CodeVerifier, s.BindingHash, s.ReauthUserID, expiresAt)
+	if err != nil {
+		utils.Error.Err(err).Str("provider", s.Provider).Msg("failed to create oidc state")
+		return err
+	}
+	return nil
+}
+
+// TakeOIDCState removes and returns the live state with hash started for
+// provider, or ErrNotFound when there is none.
+func (r *UserRepository) TakeOIDCState(ctx context.Context, hash []byte, provider string) (*models.OIDCState, error) {
+	var s models.OIDCState
+	err := r.DB.QueryRow(ctx, `DELETE FROM oidc_states
+		WHERE state_hash=$1 AND provider=$2 AND expires_at > NOW()
+		RETURNING provider, nonce, code_verifier, binding_hash, reauth_user_id`, hash, provider).
+		Scan(&s.Provider, &s.Nonce, &s.CodeVerifier, &s.BindingHash, &s.ReauthUserID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		utils.Error.Err(err).Str("provider", provider).Msg("failed to take oidc state")
+		return nil, err
+	}
+	return &s, nil
+}
+
+func (r *UserRepository) DeleteExpiredOIDCStates(ctx context.Context) (int64, error) {
+	tag, err := r.DB.Exec(ctx, `DELETE FROM oidc_states WHERE expires_at <= NOW()`)
+	if err != nil {
+		utils.Error.Err(err).Msg("failed to delete expired oidc states")
+		return 0, err
+	}
+	return tag.RowsAffected(), nil
+}
+
+// UseIdentity records a login through the provider account and returns the
+// user it is linked to, or ErrNotFound when it is not linked to a live user.
+func (r *UserRepository) UseIdentity(ctx context.Context, provider, subject, email string) (string, error) {
+	var userID string
+	err := r.DB.QueryRow(ctx, `
+		UPDATE user_identities SET last_login_at=NOW(), email=$3
+		WHERE provider=$1 AND subject=$2
+		  AND user_id IN (SELECT id FROM users WHERE deleted_at IS NULL)
+		RETURNING user_id`, provider, subject, email).Scan(&userID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return "", ErrNotFound
+	}
+	if err != nil {
+		utils.Error.Err(err).Str("provider", provider).Msg("failed to use identity")
+		return "", err
+	}
+	return userID, nil
+}
+
+// IdentityUser returns the live user the provider account is linked to, or
+// ErrNotFound when there is none.
+func (r *UserRepository) IdentityUser(ctx context.Context, provider, subject string) (string, error) {
+	var userID string
+	err := r.DB.QueryRow(ctx, `
+		SELECT i.user_id FROM user_identities i JOIN users u ON u.id = i.user_id
+		WHERE i.provider=$1 AND i.subject=$2 AND u.deleted_at IS NULL`, provider, subject).Scan(&userID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return "", ErrNotFound
+	}
+	if err != nil {
+		utils.Error.Err(err).Str("provider", provider).Msg("failed to get identity")
+		return "", err
+	}
+	return userID, nil
+}
+
+// SetReauthenticated records that the user has just signed in again at
+// their identity provider.
+func (r *UserRepository) SetReauthenticated(ctx context.Context, userID string) error {
+	_, err := r.DB.Exec(ctx, `UPDATE users SET reauthenticated_at=NOW() WHERE id=$1 AND deleted_at IS NULL`, userID)
+	if err != nil {
+		utils.Error.Err(err).Str("user_id", userID).Msg("failed to record reauthentication")
+		return err
+	}
+	return nil
+}
+
+// TakeReauthentication uses up the user's sign-in recorded by
+// SetReauthenticated, reporting false when there is none within the last
+// within.
+func (r *UserRepository) TakeReauthentication(ctx context.Context, userID string, within time.Duration) (bool, error) {
+	tag, err := r.DB.Exec(ctx, `
+		UPDATE users SET reauthenticated_at=NULL
+		WHERE id=$1 AND reauthenticated_at > NOW() - make_interval(secs => $2)`, userID, within.Seconds())
+	if err != nil {
+		utils.Error.Err(err).Str("user_id", userID).Msg("failed to take reauthentication")
+		return false, err
+	}
+	return tag.RowsAffected() == 1, nil
+}
+
+// LinkIdentity links the provider account to an existing user. It reports
+// false when the account is already linked.
+func (r *UserRepository) LinkIdentity(ctx context.Context, id *models.UserIdentity) (bool, error) {
+	err := r.DB.QueryRow(ctx, `
+		INSERT INTO user_identities (provider, subject, user_id, email, last_login_at)
+		VALUES ($1, $2, $3, $4, NOW())
+		ON CONFLICT (provider, subject) DO NOTHING
+		RETURNING created_at`, id.Provider, id.Subject, id.UserID, id.Email).Scan(&id.CreatedAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return false, nil
+	}
+	if err != nil {
+		utils.Error.Err(err).Str("user_id", id.UserID).Str("provider", id.Provider).Msg("failed to link identity")
+		return false, err
+	}
+	return true, nil
+}
+
+// CreateOIDCUser inserts a user signing up through a provider, with their
+// key encryption key and the identity they signed in with. The user has no
+// password, and the provider has verified the email, so it is marked verified.
+func (r *UserRepository) CreateOIDCUser(ctx context.Context, user *models.User, key *models.UserKey, id *models.UserIdentity) error {
+	tx, err := r.DB.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	err = tx.QueryRow(ctx, `
+		INSERT INTO users (username, email, email_verified_at)
+		VALUES ($1, $2, NOW())
+		RETURNING id, role, created_at`, user.Username, user.Email).
+		Scan(&user.ID, &user.Role, &user.CreatedAt)
+	if isUniqueViolation(err) {
+		return ErrUserConflict
+	}
+	if err != nil {
+		utils.Error.Err(err).Msg("failed to create user")
+		return err
+	}
+
+	key.UserID = user.ID
+	if err := insertUserKey(ctx, tx, key); err != nil {
+		utils.Error.Err(err).Str("user_id", user.ID).Msg("failed to create user key")
+		return err
+	}
+
+	id.UserID = user.ID
+	err = tx.QueryRow(ctx, `
+		INSERT INTO user_identities (provider, subject, user_id, email, last_login_at)
+		VALUES ($1, $2, $3, $4, NOW())
+		RETURNING created_at`, id.Provider, id.Subject, id.UserID, id.Email).Scan(&id.CreatedAt)
+	if isUniqueViolation(err) {
+		return ErrUserConflict
+	}
+	if err != nil {
+		utils.Error.Err(err).Str("user_id", user.ID).Str("provider", id.Provider).Msg("failed to link identity")
+		return err
+	}
+	return tx.Commit(ctx)
+}
@@ -0,0 +1,99 @@
+package repositories
+
+import (
+	"context"
+	"errors"
+
+	"github.com/jackc/pgx/v5"
+
+	"github.com/SrabanMondal/SecureStore/internal/models"
+	"github.com/SrabanMondal/SecureStore/internal/utils"
+)
+
+const personalTokenColumns = `id, user_id, name, token_hash, prefix, scopes, expires_at, last_used_at, created_at, revoked_at`
+
+func scanPersonalToken(row interface{ Scan(...any) error }, t *models.PersonalAccessToken) error {
+	return row.Scan(&t.ID, &t.UserID, &t.Name, &t.TokenHash, &t.Prefix, &t.Scopes, &t.ExpiresAt, &t.LastUsedAt,
+		&t.CreatedAt, &t.RevokedAt)
+}
+
+func (r *TokenRepository) CreatePersonalToken(ctx context.Context, t *models.PersonalAccessToken) error {
+	query := `
+		INSERT INTO personal_access_tokens (user_id, name, token_hash, prefix, scopes, expires_at)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		RETURNING id, created_at`
+	err := r.DB.QueryRow(ctx, query, t.UserID, t.Name, t.TokenHash, t.Prefix, t.Scopes, t.ExpiresAt).Scan(&t.ID, &t.CreatedAt)
+	if err != nil {
+		utils.Error.Err(err).Str("user_id", t.UserID).Msg("failed to create personal access token")
+		return err
+	}
+	return nil
+}
+
+// GetActivePersonalToken looks up a token by hash, provided it is neither
+// revoked nor expired and its user is not deleted.
+func (r *TokenRepository) GetActivePersonalToken(ctx context.Context, hash []byte) (*models.PersonalAccessToken, error) {
+	query := `SELECT ` + personalTokenColumns + ` FROM personal_access_tokens
+			  WHERE token_hash=$1 AND revoked_at IS NULL AND (expires_at IS NULL OR expires_at > NOW())
+			    AND user_id IN (SELECT id FROM users WHERE deleted_at IS NULL)`
+	var t models.PersonalAccessToken
+	err := scanPersonalToken(r.DB.QueryRow(ctx, query, hash), &t)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		utils.Error.Err(err).Msg("failed to get personal access token")
+		return nil, err
+	}
+	return &t, nil
+}
+
+// ListPersonalTokens returns the user's tokens that are not revoked, newest
+// first.
+func (r *TokenRepository) ListPersonalTokens(ctx context.Context, userID string) ([]models.PersonalAccessToken, error) {
+	query := `SELECT ` + personalTokenColumns + ` FROM personal_access_tokens
+			  WHERE user_id=$1 AND revoked_at IS NULL
+			  ORDER BY created_at DESC`
+	rows, err := r.DB.Query(ctx, query, userID)
+	if err != nil {
+		utils.Error.Err(err).Str("user_id", userID).Msg("failed to list personal access tokens")
+		return nil, err
+	}
+	defer rows.Close()
+
+	tokens := []models.PersonalAccessToken{}
+	for rows.Next() {
+		var t models.PersonalAccessToken
+		if err := scanPersonalToken(rows, &t); err != nil {
+			return nil, err
+		}
+		tokens = append(tokens, t)
+	}
+	return tokens, rows.Err()
+}
+
+// RevokePersonalToken revokes the user's token id, or returns ErrNotFound.
+func (r *TokenRepository) RevokePersonalToken(ctx context.Context, userID, id string) error {
+	tag, err := r.DB.Exec(ctx, `UPDATE personal_access_tokens SET revoked_at=NOW()
+		WHERE id=$1 AND user_id=$2 AND revoked_at IS NULL`, id, userID)
+	if err != nil {
+		utils.Error.Err(err).Str("id", id).Msg("failed to revoke personal access token")
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// TouchPersonalToken records that the token was just used. Uses within a
+// minute of the last recorded one are not written.
+func (r *TokenRepository) TouchPersonalToken(ctx context.Context, id string) error {
+	_, err := r.DB.Exec(ctx, `UPDATE personal_access_tokens SET last_used_at=NOW()
+		WHERE id=$1 AND (last_used_at IS NULL OR last_used_at < NOW() - INTERVAL '1 minute')`, id)
+	if err != nil {
+		utils.Error.Err(err).Str("id", id).Msg("failed to record personal access token use")
+		return err
+	}
+	return nil
+}
@@ -45,6 +45,18 @@ func (r *ShareRepository) GetShareLinkByToken(ctx context.Context, token string)
 	return &s, nil
 }
 
+func (r *ShareRepository) GetShareLinkByID(ctx context.Context, id string) (*models.ShareLink, error) {
+	query := `SELECT id, file_id, share_token, expires_at, password_hash, created_at FROM share_links WHERE id=$1`
+	var s models.ShareLink
+	err := r.DB.QueryRow(ctx, query, id).
+		Scan(&s.ID, &s.FileID, &s.ShareToken, &s.ExpiresAt, &s.PasswordHash, &s.CreatedAt)
+	if err != nil {
+		utils.Error.Err(err).Str("id", id).Msg("share link not found")
+		return nil, err
+	}
+	return &s, nil
+}
+
 func (r *ShareRepository) DeleteExpiredShareLinks(ctx context.Context) error {
 	query := `DELETE FROM share_links WHERE expires_at < $1`
 	_, err := r.DB.Exec(ctx, query, time.Now())
@@ -57,10 +69,51 @@ func (r *ShareRepository) DeleteExpiredShareLinks(ctx context.Context) error {
 
 func (r *ShareRepository) DeleteShareLink(ctx context.Context, id string) error {
 	query := `DELETE FROM share_links WHERE id=$1`
-	_, err := r.DB.Exec(ctx, query, id)
+	tag, err := r.DB.Exec(ctx, query, id)
 	if err != nil {
 		utils.Error.Err(err).Str("id", id).Msg("failed to delete share link")
 		return err
 	}
+	if tag.RowsAffected() == 0 {
+		return ErrNotFound
+	}
 	return nil
 }
+
+// ListShareLinks pages through share links across the system, newest first,
+// with the owner of each file but without the tokens. userID limits them to
+// one user's files when set.
+func (r *ShareRepository) ListShareLinks(ctx context.Context, userID string, limit, offset int) ([]models.ShareLink, error) {
+	query := `
+		SELECT s.id, s.file_id, f.user_id, s.expires_at, s.password_hash, s.created_at
+		FROM share_links s JOIN files f ON f.id = s.file_id
+		WHERE $1 = '' OR f.user_id::text = $1
+		ORDER BY s.created_at DESC, s.id
+		LIMIT $2 OFFSET $3`
+	rows, err := r.DB.Query(ctx, query, userID, limit, offset)
+	if err != nil {
+		utils.Error.Err(err).Msg("failed to list share links")
+		return nil, err
+	}
+	defer rows.Close()
+
+	links := []models.ShareLink{}
+	for rows.Next() {
+		var s models.ShareLink
+		if err := rows.Scan(&s.ID, &s.FileID, &s.UserID, &s.ExpiresAt, &s.PasswordHash, &s.CreatedAt); err != nil {
+			return nil, err
+		}
+		links = append(links, s)
+	}
+	return links, rows.Err()
+}
+
+// DeleteUserShareLinks deletes every link to the user's files.
+func (r *ShareRepository) DeleteUserShareLinks(ctx context.Context, userID string) (int64, error) {
+	tag, err := r.DB.Exec(ctx, `DELETE FROM share_links WHERE file_id IN (SELECT id FROM files WHERE user_id=$1)`, userID)
+	if err != nil {
+		utils.Error.Err(err).Str("user_id", userID).Msg("failed to delete user share links")
+		return 0, err
+	}
+	return tag.RowsAffected(), nil
+}
@@ -0,0 +1,165 @@
+package repositories
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+
+	"github.com/SrabanMondal/SecureStore/internal/models"
+	"github.com/SrabanMondal/SecureStore/internal/utils"
+)
+
+type TokenRepository struct {
+	DB *pgxpool.Pool
+}
+
+func NewTokenRepository(db *pgxpool.Pool) *TokenRepository {
+	return &TokenRepository{DB: db}
+}
+
+const refreshTokenColumns = `id, user_id, family_id, token_hash, expires_at, created_at, used_at, revoked_at`
+
+func scanRefreshToken(row interface{ Scan(...any) error }, t *models.RefreshToken) error {
+	return row.Scan(&t.ID, &t.UserID, &t.FamilyID, &t.TokenHash, &t.ExpiresAt, &t.CreatedAt, &t.UsedAt, &t.RevokedAt)
+}
+
+func insertRefreshToken(ctx context.Context, q interface {
+	QueryRow(context.Context, string, ...any) pgx.Row
+}, t *models.RefreshToken) error {
+	query := `
+		INSERT INTO refresh_tokens (user_id, family_id, token_hash, expires_at)
+		VALUES ($1, COALESCE($2::uuid, gen_random_uuid()), $3, $4)
+		RETURNING id, family_id, created_at`
+	var familyID *string
+	if t.FamilyID != "" {
+		familyID = &t.FamilyID
+	}
+	return q.QueryRow(ctx, query, t.UserID, familyID, t.TokenHash, t.ExpiresAt).Scan(&t.ID, &t.FamilyID, &t.CreatedAt)
+}
+
+// CreateRefreshToken stores a token, starting a new family unless t.FamilyID
+// is set.
+func (r *TokenRepository) CreateRefreshToken(ctx context.Context, t *models.RefreshToken) error {
+	if err := insertRefreshToken(ctx, r.DB, t); err != nil {
+		utils.Error.Err(err).Str("user_id", t.UserID).Msg("failed to create refresh token")
+		return err
+	}
+	return nil
+}
+
+func (r *TokenRepository) GetRefreshTokenByHash(ctx context.Context, hash []byte) (*models.RefreshToken, error) {
+	query := `SELECT ` + refreshTokenColumns + ` FROM refresh_tokens WHERE token_hash=$1`
+	var t models.RefreshToken
+	err := scanRefreshToken(r.DB.QueryRow(ctx, query, hash), &t)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		utils.Error.Err(err).Msg("failed to get refresh token")
+		return nil, err
+	}
+	return &t, nil
+}
+
+// RotateRefreshToken marks old used and stores next in its family, provided
+// old has not been used or revoked in the meantime. It reports whether the
+// rotation happened.
+func (r *TokenRepository) RotateRefreshToken(ctx context.Context, old, next *models.RefreshToken) (bool, error) {
+	tx, err := r.DB.Begin(ctx)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback(ctx)
+
+	tag, err := tx.Exec(ctx, `UPDATE refresh_tokens SET used_at=NOW()
+		WHERE id=$1 AND used_at IS NULL AND revoked_at IS NULL`, old.ID)
+	if err != nil {
+		utils.Error.Err(err).Str("id", old.ID).Msg("failed to mark refresh token used")
+		return false, err
+	}
+	if tag.RowsAffected() == 0 {
+		return false, nil
+	}
+
+	next.UserID, next.FamilyID = old.UserID, old.FamilyID
+	if err := insertRefreshToken(ctx, tx, next); err != nil {
+		utils.Error.Err(err).Str("user_id", old.UserID).Msg("failed to create refresh token")
+		return false, err
+	}
+	return true, tx.Commit(ctx)
+}
+
+// RevokeFamily revokes every token issued from the same login.
+func (r *TokenRepository) RevokeFamily(ctx context.Context, familyID string) error {
+	_, err := r.DB.Exec(ctx, `UPDATE refresh_tokens SET revoked_at=NOW() WHERE family_id=$1 AND revoked_at IS NULL`, familyID)
+	if err != nil {
+		utils.Error.Err(err).Str("family_id", familyID).Msg("failed to revoke refresh token family")
+		return err
+	}
+	return nil
+}
+
+func (r *TokenRepository) RevokeUserTokens(ctx context.Context, userID string) error {
+	_, err := r.DB.Exec(ctx, `UPDATE refresh_tokens SET revoked_at=NOW() WHERE user_id=$1 AND revoked_at IS NULL`, userID)
+	if err != nil {
+		utils.Error.Err(err).Str("user_id", userID).Msg("failed to revoke refresh tokens")
+		return err
+	}
+	return nil
+}
+
+// RevokeAccessToken puts an access token on the deny list until it expires.
+func (r *TokenRepository) RevokeAccessToken(ctx context.Context, jti, userID string, expiresAt time.Time) error {
+	_, err := r.DB.Exec(ctx, `INSERT INTO revoked_tokens (jti, user_id, expires_at) VALUES ($1, $2, $3)
+		ON CONFLICT (jti) DO NOTHING`, jti, userID, expiresAt)
+	if err != nil {
+		utils.Error.Err(err).Str("user_id", userID).Msg("failed to revoke access token")
+		return err
+	}
+	return nil
+}
+
+// ListRevokedAccessTokens returns the jti and expiry of every revoked access
+// token that has not expired yet.
+func (r *TokenRepository) ListRevokedAccessTokens(ctx context.Context) (map[string]time.Time, error) {
+	rows, err := r.DB.Query(ctx, `SELECT jti, expires_at FROM revoked_tokens WHERE expires_at > NOW()`)
+	if err != nil {
+		utils.Error.Err(err).Msg("failed to list revoked access tokens")
+		return nil, err
+	}
+	defer rows.Close()
+
+	revoked := map[string]time.Time{}
+	for rows.Next() {
+		var jti string
+		var exp time.Time
+		if err := rows.Scan(&jti, &exp); err != nil {
+			return nil, err
+		}
+		revoked[jti] = exp
+	}
+	return revoked, rows.Err()
+}
+
+// DeleteExpiredTokens drops refresh tokens, deny list entries and personal
+// access tokens past their expiry, and revoked personal access tokens.
+func (r *TokenRepository) DeleteExpiredTokens(ctx context.Context) (int64, error) {
+	var n int64
+	for _, table := range []string{"refresh_tokens", "revoked_tokens"} {
+		tag, err := r.DB.Exec(ctx, `DELETE FROM `+table+` WHERE expires_at < NOW()`)
+		if err != nil {
+			utils.Error.Err(err).Msg("failed to delete expired " + table)
+			return n, err
+		}
+		n += tag.RowsAffected()
+	}
+	tag, err := r.DB.Exec(ctx, `DELETE FROM personal_access_tokens WHERE expires_at < NOW() OR revoked_at IS NOT NULL`)
+	if err != nil {
+		utils.Error.Err(err).Msg("failed to delete expired personal_access_tokens")
+		return n, err
+	}
+	return n + tag.RowsAffected(), nil
+}
@@ -0,0 +1,166 @@
+package repositories
+
+import (
+	"context"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+
+	"github.com/SrabanMondal/SecureStore/internal/models"
+	"github.com/SrabanMondal/SecureStore/internal/utils"
+)
+
+type TusRepository struct {
+	DB *pgxpool.Pool
+
+	// encrypts file paths and metadata at rest; nil stores them as given
+	Names *utils.NameCipher
+}
+
+func NewTusRepository(db *pgxpool.Pool, names *utils.NameCipher) *TusRepository {
+	return &TusRepository{DB: db, Names: names}
+}
+
+const tusUploadColumns = `id, user_id, file_path, upload_length, upload_offset, metadata, is_encrypted, file_id, expires_at, created_at, updated_at,
+	wrapped_key, key_id, key_algorithm`
+
+func scanTusUpload(names *utils.NameCipher, row interface{ Scan(...any) error }, u *models.TusUpload) error {
+	err := row.Scan(&u.ID, &u.UserID, &u.FilePath, &u.Length, &u.Offset, &u.Metadata,
+		&u.IsEncrypted, &u.FileID, &u.ExpiresAt, &u.CreatedAt, &u.UpdatedAt, &u.WrappedKey, &u.KeyID, &u.KeyAlgorithm)
+	if err != nil {
+		return err
+	}
+	u.FilePath = names.OpenPath(u.UserID, u.FilePath)
+	u.Metadata = names.OpenName(u.UserID, u.Metadata)
+	return nil
+}
+
+func (r *TusRepository) CreateUpload(ctx context.Context, u *models.TusUpload) error {
+	query := `
+		INSERT INTO tus_uploads (user_id, file_path, upload_length, metadata, is_encrypted, expires_at,
+			wrapped_key, key_id, key_algorithm)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+		RETURNING id, upload_offset, created_at, updated_at
+	`
+	err := r.DB.QueryRow(ctx, query,
+		u.UserID, r.Names.SealPath(u.UserID, u.FilePath), u.Length, r.Names.SealName(u.UserID, u.Metadata), u.IsEncrypted,
+		u.ExpiresAt, u.WrappedKey, u.KeyID, u.KeyAlgorithm,
+	).Scan(&u.ID, &u.Offset, &u.CreatedAt, &u.UpdatedAt)
+	if err != nil {
+		utils.Error.Err(err).Str("file_path", u.FilePath).Msg("failed to create tus upload")
+		return err
+	}
+	return nil
+}
+
+func (r *TusRepository) GetUpload(ctx context.Context, id string) (*models.TusUpload, error) {
+	query := `SELECT ` + tusUploadColumns + ` FROM tus_uploads WHERE id=$1`
+	var u models.TusUpload
+	if err := scanTusUpload(r.Names, r.DB.QueryRow(ctx, query, id), &u); err != nil {
+		utils.Error.Err(err).Str("id", id).Msg("tus upload not found")
+		return nil, err
+	}
+	return &u, nil
+}
+
+// AddSegment records a stored chunk and advances the offset, but only if the
+// offset is still the one the chunk was written at. It reports false when a
+// concurrent request got there first.
+func (r *TusRepository) AddSegment(ctx context.Context, seg *models.TusSegment, expiresAt time.Time) (bool, error) {
+	tx, err := r.DB.Begin(ctx)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback(ctx)
+
+	tag, err := tx.Exec(ctx, `
+		UPDATE tus_uploads SET upload_offset=$3, expires_at=$4, updated_at=NOW()
+		WHERE id=$1 AND upload_offset=$2 AND file_id IS NULL`,
+		seg.UploadID, seg.Offset, seg.Offset+seg.Size, expiresAt)
+	if err != nil {
+		utils.Error.Err(err).Str("id", seg.UploadID).Msg("failed to advance tus offset")
+		return false, err
+	}
+	if tag.RowsAffected() == 0 {
+		return false, nil
+	}
+
+	_, err = tx.Exec(ctx, `
+		INSERT INTO tus_segments (upload_id, segment_offset, size, storage_key)
+		VALUES ($1, $2, $3, $4)`,
+		seg.UploadID, seg.Offset, seg.Size, seg.StorageKey)
+	if err != nil {
+		utils.Error.Err(err).Str("id", seg.UploadID).Msg("failed to insert tus segment")
+		return false, err
+	}
+
+	return true, tx.Commit(ctx)
+}
+
+func (r *TusRepository) ListSegments(ctx context.Context, uploadID string) ([]models.TusSegment, error) {
+	query := `SELECT upload_id, segment_offset, size, storage_key FROM tus_segments
+			  WHERE upload_id=$1 ORDER BY segment_offset`
+	rows, err := r.DB.Query(ctx, query, uploadID)
+	if err != nil {
+		utils.Error.Err(err).Str("upload_id", uploadID).Msg("failed to list tus segments")
+		return nil, err
+	}
+	defer rows.Close()
+
+	var segs []models.TusSegment
+	for rows.Next() {
+		var s models.TusSegment
+		if err := rows.Scan(&s.UploadID, &s.Offset, &s.Size, &s.StorageKey); err != nil {
+			return nil, err
+		}
+		segs = append(segs, s)
+	}
+	return segs, nil
+}
+
+func (r *TusRepository) DeleteSegments(ctx context.Context, uploadID string) error {
+	_, err := r.DB.Exec(ctx, `DELETE FROM tus_segments WHERE upload_id=$1`, uploadID)
+	if err != nil {
+		utils.Error.Err(err).Str("upload_id", uploadID).Msg("failed to delete tus segments")
+		return err
+	}
+	return nil
+}
+
+func (r *TusRepository) SetFileID(ctx context.Context, id, fileID string) error {
+	_, err := r.DB.Exec(ctx, `UPDATE tus_uploads SET file_id=$2, updated_at=NOW() WHERE id=$1`, id, fileID)
+	if err != nil {
+		utils.Error.Err(err).Str("id", id).Msg("failed to link tus upload to file")
+		return err
+	}
+	return nil
+}
+
+func (r *TusRepository) DeleteUpload(ctx context.Context, id string) error {
+	_, err := r.DB.Exec(ctx, `DELETE FROM tus_uploads WHERE id=$1`, id)
+	if err != nil {
+		utils.Error.Err(err).Str("id", id).Msg("failed to delete tus upload")
+		return err
+	}
+	return nil
+}
+
+func (r *TusRepository) ListExpiredUploads(ctx context.Context, now time.Time) ([]models.TusUpload, error) {
+	query := `SELECT ` + tusUploadColumns + ` FROM tus_uploads WHERE expires_at < $1`
+	rows, err := r.DB.Query(ctx, query, now)
+	if err != nil {
+		utils.Error.Err(err).Msg("failed to list expired tus uploads")
+		return nil, err
+	}
+	defer rows.Close()
+
+	var uploads []models.TusUpload
+	for rows.Next() {
+		var u models.TusUpload
+		if err := scanTusUpload(r.Names, rows, &u); err != nil {
+			return nil, err
+		}
+		uploads = append(uploads, u)
+	}
+	return uploads, nil
+}
@@ -0,0 +1,174 @@
+package repositories
+
+import (
+	"context"
+	"errors"
+
+	"github.com/jackc/pgx/v5"
+
+	"github.com/SrabanMondal/SecureStore/internal/models"
+	"github.com/SrabanMondal/SecureStore/internal/utils"
+)
+
+var ErrQuotaExceeded = errors.New("storage quota exceeded")
+
+// objectSize is the number of bytes a version occupies in storage, which is
+// what usage is counted in.
+func objectSize(size int64, encrypted bool) int64 {
+	if encrypted {
+		return utils.EncryptedSize(size)
+	}
+	return size
+}
+
+// lockFileOwner locks a file row for the rest of tx and returns its owner, so
+// that version inserts and deletes of one file are serialized.
+func lockFileOwner(ctx context.Context, tx pgx.Tx, fileID string) (string, error) {
+	var userID *string
+	err := tx.QueryRow(ctx, `SELECT user_id FROM files WHERE id=$1 FOR UPDATE`, fileID).Scan(&userID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return "", ErrNotFound
+	}
+	if err != nil || userID == nil {
+		return "", err
+	}
+	return *userID, nil
+}
+
+// chargeUsage adds delta bytes to the user's usage inside tx. A positive delta
+// that would take the user over their quota fails with ErrQuotaExceeded;
+// releases are always applied.
+func chargeUsage(ctx context.Context, tx pgx.Tx, userID string, delta int64) error {
+	if userID == "" || delta == 0 {
+		return nil
+	}
+
+	if delta < 0 {
+		return adjustUsage(ctx, tx, userID, delta)
+	}
+
+	if _, err := tx.Exec(ctx, `INSERT INTO user_quotas (user_id) VALUES ($1) ON CONFLICT DO NOTHING`, userID); err != nil {
+		utils.Error.Err(err).Str("user_id", userID).Msg("failed to create usage row")
+		return err
+	}
+	tag, err := tx.Exec(ctx, `
+		UPDATE user_quotas q SET used_bytes = q.used_bytes + $2, updated_at=NOW()
+		FROM quota_settings s
+		WHERE q.user_id=$1 AND q.used_bytes + $2 <= COALESCE(q.quota_bytes, s.default_quota_bytes)`,
+		userID, delta)
+	if err != nil {
+		utils.Error.Err(err).Str("user_id", userID).Msg("failed to charge usage")
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrQuotaExceeded
+	}
+	return nil
+}
+
+// adjustUsage adds delta bytes to the user's usage inside tx without checking
+// the quota, for releases and for changes the user did not ask for.
+func adjustUsage(ctx context.Context, tx pgx.Tx, userID string, delta int64) error {
+	if userID == "" || delta == 0 {
+		return nil
+	}
+	_, err := tx.Exec(ctx, `
+		INSERT INTO user_quotas (user_id, used_bytes) VALUES ($1, GREATEST($2::bigint, 0))
+		ON CONFLICT (user_id) DO UPDATE SET used_bytes = GREATEST(user_quotas.used_bytes + $2::bigint, 0), updated_at=NOW()`,
+		userID, delta)
+	if err != nil {
+		utils.Error.Err(err).Str("user_id", userID).Msg("failed to adjust usage")
+	}
+	return err
+}
+
+// GetUsage returns the user's usage and effective quota. Users who never
+// uploaded anything have no usage row and get the default quota.
+func (r *FileRepository) GetUsage(ctx context.Context, userID string) (*models.Usage, error) {
+	query := `
+		SELECT COALESCE(q.used_bytes, 0), COALESCE(q.quota_bytes, s.default_quota_bytes), q.updated_at
+		FROM quota_settings s LEFT JOIN user_quotas q ON q.user_id=$1
+	`
+	u := &models.Usage{UserID: userID}
+	if err := r.DB.QueryRow(ctx, query, userID).Scan(&u.UsedBytes, &u.QuotaBytes, &u.UpdatedAt); err != nil {
+		utils.Error.Err(err).Str("user_id", userID).Msg("failed to get usage")
+		return nil, err
+	}
+	return u, nil
+}
+
+func (r *FileRepository) SystemUsage(ctx context.Context) (*models.SystemUsage, error) {
+	query := `
+		SELECT
+			(SELECT COUNT(*) FROM users WHERE deleted_at IS NULL),
+			(SELECT COUNT(*) FROM files WHERE status='uploaded'),
+			(SELECT COUNT(*) FROM files WHERE status='trashed'),
+			(SELECT COUNT(*) FROM file_versions),
+			(SELECT COALESCE(SUM(used_bytes), 0) FROM user_quotas)
+	`
+	u := &models.SystemUsage{}
+	if err := r.DB.QueryRow(ctx, query).Scan(&u.Users, &u.Files, &u.TrashedFiles, &u.Versions, &u.UsedBytes); err != nil {
+		utils.Error.Err(err).Msg("failed to get system usage")
+		return nil, err
+	}
+	return u, nil
+}
+
+// ListUploadedVersions pages through every uploaded version in id order,
+// starting after afterID (empty for the first page).
+func (r *FileRepository) ListUploadedVersions(ctx context.Context, afterID string, limit int) ([]models.FileVersion, error) {
+	var after *string
+	if afterID != "" {
+		after = &afterID
+	}
+
+	query := `SELECT ` + versionColumns + ` FROM file_versions
+			  WHERE status='uploaded' AND ($1::uuid IS NULL OR id > $1::uuid)
+			  ORDER BY id LIMIT $2`
+	rows, err := r.DB.Query(ctx, query, after, limit)
+	if err != nil {
+		utils.Error.Err(err).Msg("failed to list uploaded file versions")
+		return nil, err
+	}
+	return collectVersions(rows)
+}
+
+func (r *FileRepository) SetVersionObjectSize(ctx context.Context, id string, size int64) error {
+	_, err := r.DB.Exec(ctx, `UPDATE file_versions SET object_size=$2 WHERE id=$1`, id, size)
+	if err != nil {
+		utils.Error.Err(err).Str("version_id", id).Msg("failed to set version object size")
+		return err
+	}
+	return nil
+}
+
+// RecomputeUsage resets every user's usage to the sum of their versions'
+// object sizes and returns how many counters changed.
+func (r *FileRepository) RecomputeUsage(ctx context.Context) (int64, error) {
+	query := `
+		INSERT INTO user_quotas (user_id, used_bytes)
+		SELECT f.user_id, SUM(v.object_size)
+		FROM files f JOIN file_versions v ON v.file_id = f.id
+		WHERE f.user_id IS NOT NULL
+		GROUP BY f.user_id
+		ON CONFLICT (user_id) DO UPDATE SET used_bytes=EXCLUDED.used_bytes, updated_at=NOW()
+		WHERE user_quotas.used_bytes <> EXCLUDED.used_bytes
+	`
+	tag, err := r.DB.Exec(ctx, query)
+	if err != nil {
+		utils.Error.Err(err).Msg("failed to recompute usage")
+		return 0, err
+	}
+
+	// users whose files are all gone have nothing left to sum
+	zeroed, err := r.DB.Exec(ctx, `
+		UPDATE user_quotas q SET used_bytes=0, updated_at=NOW()
+		WHERE q.used_bytes <> 0 AND NOT EXISTS (
+			SELECT 1 FROM files f JOIN file_versions v ON v.file_id = f.id WHERE f.user_id = q.user_id
+		)`)
+	if err != nil {
+		utils.Error.Err(err).Msg("failed to reset usage of users without files")
+		return 0, err
+	}
+	return tag.RowsAffected() + zeroed.RowsAffected(), nil
+}
@@ -0,0 +1,150 @@
+package repositories
+
+import (
+	"context"
+	"errors"
+
+	"github.com/jackc/pgx/v5"
+
+	"github.com/SrabanMondal/SecureStore/internal/models"
+	"github.com/SrabanMondal/SecureStore/internal/utils"
+)
+
+const userKeyColumns = `user_id, wrapped_key, key_id, key_algorithm, passphrase_salt, kdf_time, kdf_memory, kdf_threads,
+	created_at, updated_at`
+
+func scanUserKey(row interface{ Scan(...any) error }, k *models.UserKey) error {
+	return row.Scan(&k.UserID, &k.WrappedKey, &k.KeyID, &k.KeyAlgorithm, &k.PassphraseSalt, &k.KDFTime, &k.KDFMemory, &k.KDFThreads,
+		&k.CreatedAt, &k.UpdatedAt)
+}
+
+func insertUserKey(ctx context.Context, q interface {
+	QueryRow(context.Context, string, ...any) pgx.Row
+}, k *models.UserKey) error {
+	query := `
+		INSERT INTO user_keys (user_id, wrapped_key, key_id, key_algorithm, passphrase_salt, kdf_time, kdf_memory, kdf_threads)
+		SELECT $1, $2, $3, $4, $5, $6, $7, $8
+		WHERE EXISTS (SELECT 1 FROM users WHERE id=$1 AND deleted_at IS NULL)
+		ON CONFLICT (user_id) DO NOTHING
+		RETURNING created_at, updated_at`
+	return q.QueryRow(ctx, query, k.UserID, k.WrappedKey, k.KeyID, k.KeyAlgorithm, k.PassphraseSalt,
+		k.KDFTime, k.KDFMemory, k.KDFThreads).Scan(&k.CreatedAt, &k.UpdatedAt)
+}
+
+func (r *UserRepository) GetUserKey(ctx context.Context, userID string) (*models.UserKey, error) {
+	query := `SELECT ` + userKeyColumns + ` FROM user_keys WHERE user_id=$1`
+	var k models.UserKey
+	err := scanUserKey(r.DB.QueryRow(ctx, query, userID), &k)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		utils.Error.Err(err).Str("user_id", userID).Msg("failed to get user key")
+		return nil, err
+	}
+	return &k, nil
+}
+
+// CreateUserKey stores a key for a user who has none yet. It reports false
+// when the user already has a key or no longer exists.
+func (r *UserRepository) CreateUserKey(ctx context.Context, k *models.UserKey) (bool, error) {
+	err := insertUserKey(ctx, r.DB, k)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return false, nil
+	}
+	if err != nil {
+		utils.Error.Err(err).Str("user_id", k.UserID).Msg("failed to create user key")
+		return false, err
+	}
+	return true, nil
+}
+
+// UpdateUserKey replaces the wrapped key and its passphrase protection.
+func (r *UserRepository) UpdateUserKey(ctx context.Context, k *models.UserKey) error {
+	query := `
+		UPDATE user_keys
+		SET wrapped_key=$2, key_id=$3, key_algorithm=$4, passphrase_salt=$5, kdf_time=$6, kdf_memory=$7, kdf_threads=$8,
+			updated_at=NOW()
+		WHERE user_id=$1
+		RETURNING updated_at`
+	err := r.DB.QueryRow(ctx, query, k.UserID, k.WrappedKey, k.KeyID, k.KeyAlgorithm, k.PassphraseSalt,
+		k.KDFTime, k.KDFMemory, k.KDFThreads).Scan(&k.UpdatedAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return ErrNotFound
+	}
+	if err != nil {
+		utils.Error.Err(err).Str("user_id", k.UserID).Msg("failed to update user key")
+		return err
+	}
+	return nil
+}
+
+func (r *UserRepository) CountUserKeysByKey(ctx context.Context, keyID string) (int64, error) {
+	var n int64
+	err := r.DB.QueryRow(ctx, `SELECT COUNT(*) FROM user_keys WHERE key_id=$1`, keyID).Scan(&n)
+	if err != nil {
+		utils.Error.Err(err).Str("key_id", keyID).Msg("failed to count user keys")
+		return 0, err
+	}
+	return n, nil
+}
+
+func (r *UserRepository) CountUserKeysPerKey(ctx context.Context) (map[string]int64, error) {
+	rows, err := r.DB.Query(ctx, `SELECT key_id, COUNT(*) FROM user_keys GROUP BY key_id`)
+	if err != nil {
+		utils.Error.Err(err).Msg("failed to count user keys per key")
+		return nil, err
+	}
+	defer rows.Close()
+
+	counts := map[string]int64{}
+	for rows.Next() {
+		var id string
+		var n int64
+		if err := rows.Scan(&id, &n); err != nil {
+			return nil, err
+		}
+		counts[id] = n
+	}
+	return counts, rows.Err()
+}
+
+// ListUserKeysByKey pages through the user keys wrapped by keyID in user
+// order, starting after afterUserID when it is set.
+func (r *UserRepository) ListUserKeysByKey(ctx context.Context, keyID string, afterUserID *string, limit int) ([]models.UserKey, error) {
+	query := `SELECT ` + userKeyColumns + ` FROM user_keys
+			  WHERE key_id=$1 AND ($2::uuid IS NULL OR user_id > $2::uuid)
+			  ORDER BY user_id LIMIT $3`
+	rows, err := r.DB.Query(ctx, query, keyID, afterUserID, limit)
+	if err != nil {
+		utils.Error.Err(err).Str("key_id", keyID).Msg("failed to list user keys")
+		return nil, err
+	}
+	defer rows.Close()
+
+	var keys []models.UserKey
+	for rows.Next() {
+		var k models.UserKey
+		if err := scanUserKey(rows, &k); err != nil {
+			return nil, err
+		}
+		keys = append(keys, k)
+	}
+	return keys, rows.Err()
+}
+
+// RewrapUserKey swaps the master key wrapping k for dk, provided the stored
+// key has not changed since k was read. It reports whether k was updated.
+func (r *UserRepository) RewrapUserKey(ctx context.Context, k *models.UserKey, dk models.DataKey) (bool, error) {
+	tag, err := r.DB.Exec(ctx, `UPDATE user_keys SET wrapped_key=$4, key_id=$5, key_algorithm=$6, updated_at=NOW()
+		WHERE user_id=$1 AND key_id=$2 AND wrapped_key=$3`, k.UserID, k.KeyID, k.WrappedKey, dk.WrappedKey, dk.KeyID, dk.KeyAlgorithm)
+	if err != nil {
+		utils.Error.Err(err).Str("user_id", k.UserID).Msg("failed to rewrap user key")
+		return false, err
+	}
+	if tag.RowsAffected() == 0 {
+		return false, nil
+	}
+	k.DataKey = dk
+	return true, nil
+}
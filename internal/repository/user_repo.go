@@ -8,6 +8,13 @@ import (
 	"github.com/SrabanMondal/SecureStore/internal/models"
 )
 
+// users without a password scan with an empty hash
+const userColumns = `id, username, email, COALESCE(password_hash, ''), role, suspended_at, created_at`
+
+func scanUser(row interface{ Scan(...any) error }, u *models.User) error {
+	return row.Scan(&u.ID, &u.Username, &u.Email, &u.PasswordHash, &u.Role, &u.SuspendedAt, &u.CreatedAt)
+}
+
 type UserRepository struct {
 	DB *pgxpool.Pool
 }
@@ -16,26 +23,39 @@ func NewUserRepository(db *pgxpool.Pool) *UserRepository {
 	return &UserRepository{DB: db}
 }
 
-func (r *UserRepository) CreateUser(ctx context.Context, user *models.User) error {
+// CreateUser inserts the user together with their key encryption key, so no
+// account exists without one.
+func (r *UserRepository) CreateUser(ctx context.Context, user *models.User, key *models.UserKey) error {
+	tx, err := r.DB.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
 	query := `
 		INSERT INTO users (username, email, password_hash)
 		VALUES ($1, $2, $3)
-		RETURNING id, created_at
+		RETURNING id, role, created_at
 	`
-	err := r.DB.QueryRow(ctx, query, user.Username, user.Email, user.PasswordHash).
-		Scan(&user.ID, &user.CreatedAt)
+	err = tx.QueryRow(ctx, query, user.Username, user.Email, user.PasswordHash).
+		Scan(&user.ID, &user.Role, &user.CreatedAt)
 	if err != nil {
 		utils.Error.Err(err).Msg("failed to create user")
 		return err
 	}
-	return nil
+
+	key.UserID = user.ID
+	if err := insertUserKey(ctx, tx, key); err != nil {
+		utils.Error.Err(err).Str("user_id", user.ID).Msg("failed to create user key")
+		return err
+	}
+	return tx.Commit(ctx)
 }
 
 func (r *UserRepository) GetUserByEmail(ctx context.Context, email string) (*models.User, error) {
-	query := `SELECT id, username, email, password_hash, created_at FROM users WHERE email=$1`
+	query := `SELECT ` + userColumns + ` FROM users WHERE email=$1 AND deleted_at IS NULL`
 	var u models.User
-	err := r.DB.QueryRow(ctx, query, email).
-		Scan(&u.ID, &u.Username, &u.Email, &u.PasswordHash, &u.CreatedAt)
+	err := scanUser(r.DB.QueryRow(ctx, query, email), &u)
 	if err != nil {
 		utils.Error.Err(err).Str("email", email).Msg("user not found")
 		return nil, err
@@ -44,10 +64,9 @@ func (r *UserRepository) GetUserByEmail(ctx context.Context, email string) (*mod
 }
 
 func (r *UserRepository) GetUserByUsername(ctx context.Context, username string) (*models.User, error) {
-	query := `SELECT id, username, email, password_hash, created_at FROM users WHERE username=$1`
+	query := `SELECT ` + userColumns + ` FROM users WHERE username=$1 AND deleted_at IS NULL`
 	var u models.User
-	err := r.DB.QueryRow(ctx, query, username).
-		Scan(&u.ID, &u.Username, &u.Email, &u.PasswordHash, &u.CreatedAt)
+	err := scanUser(r.DB.QueryRow(ctx, query, username), &u)
 	if err != nil {
 		utils.Error.Err(err).Str("username", username).Msg("user not found")
 		return nil, err
@@ -56,13 +75,66 @@ func (r *UserRepository) GetUserByUsername(ctx context.Context, username string)
 }
 
 func (r *UserRepository) GetUserByID(ctx context.Context, id string) (*models.User, error) {
-	query := `SELECT id, username, email, password_hash, created_at FROM users WHERE id=$1`
+	query := `SELECT ` + userColumns + ` FROM users WHERE id=$1 AND deleted_at IS NULL`
 	var u models.User
-	err := r.DB.QueryRow(ctx, query, id).
-		Scan(&u.ID, &u.Username, &u.Email, &u.PasswordHash, &u.CreatedAt)
+	err := scanUser(r.DB.QueryRow(ctx, query, id), &u)
 	if err != nil {
 		utils.Error.Err(err).Str("id", id).Msg("user not found")
 		return nil, err
 	}
 	return &u, nil
 }
+
+// DeleteUser crypto-shreds a user: their key encryption key is destroyed, so
+// every object sealed under it becomes unrecoverable, and their files are
+// handed to the cleanup job. The user row stays, marked deleted, until
+// PurgeDeletedUsers finds nothing left of them.
+func (r *UserRepository) DeleteUser(ctx context.Context, id string) error {
+	tx, err := r.DB.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	tag, err := tx.Exec(ctx, `UPDATE users SET deleted_at=NOW() WHERE id=$1 AND deleted_at IS NULL`, id)
+	if err != nil {
+		utils.Error.Err(err).Str("id", id).Msg("failed to mark user deleted")
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrNotFound
+	}
+
+	if _, err := tx.Exec(ctx, `DELETE FROM user_keys WHERE user_id=$1`, id); err != nil {
+		utils.Error.Err(err).Str("id", id).Msg("failed to destroy user key")
+		return err
+	}
+	if _, err := tx.Exec(ctx, `UPDATE refresh_tokens SET revoked_at=NOW() WHERE user_id=$1 AND revoked_at IS NULL`, id); err != nil {
+		utils.Error.Err(err).Str("id", id).Msg("failed to revoke user refresh tokens")
+		return err
+	}
+	if _, err := tx.Exec(ctx, `UPDATE personal_access_tokens SET revoked_at=NOW() WHERE user_id=$1 AND revoked_at IS NULL`, id); err != nil {
+		utils.Error.Err(err).Str("id", id).Msg("failed to revoke user personal access tokens")
+		return err
+	}
+	if _, err := tx.Exec(ctx, `UPDATE files SET status='deleting' WHERE user_id=$1`, id); err != nil {
+		utils.Error.Err(err).Str("id", id).Msg("failed to delete user files")
+		return err
+	}
+	return tx.Commit(ctx)
+}
+
+// PurgeDeletedUsers removes deleted users once the cleanup jobs have removed
+// all their files and uploads.
+func (r *UserRepository) PurgeDeletedUsers(ctx context.Context) (int64, error) {
+	tag, err := r.DB.Exec(ctx, `
+		DELETE FROM users u
+		WHERE u.deleted_at IS NOT NULL
+		  AND NOT EXISTS (SELECT 1 FROM files f WHERE f.user_id = u.id)
+		  AND NOT EXISTS (SELECT 1 FROM tus_uploads t WHERE t.user_id = u.id)`)
+	if err != nil {
+		utils.Error.Err(err).Msg("failed to purge deleted users")
+		return 0, err
+	}
+	return tag.RowsAffected(), nil
+}
@@ -0,0 +1,123 @@
+package repositories
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+
+	"github.com/SrabanMondal/SecureStore/internal/models"
+	"github.com/SrabanMondal/SecureStore/internal/utils"
+)
+
+// CreateUserToken stores a mailed token for purpose, unless the user was sent
+// one for the same purpose less than throttle ago. It reports whether it was
+// stored.
+func (r *UserRepository) CreateUserToken(ctx context.Context, userID, purpose string, hash []byte, expiresAt time.Time, throttle time.Duration) (bool, error) {
+	tag, err := r.DB.Exec(ctx, `
+		INSERT INTO user_tokens (user_id, purpose, token_hash, expires_at)
+		SELECT $1, $2, $3, $4
+		WHERE NOT EXISTS (
+			SELECT 1 FROM user_tokens
+			WHERE user_id=$1 AND purpose=$2 AND created_at > NOW() - make_interval(secs => $5)
+		)`, userID, purpose, hash, expiresAt, throttle.Seconds())
+	if err != nil {
+		utils.Error.Err(err).Str("user_id", userID).Str("purpose", purpose).Msg("failed to create user token")
+		return false, err
+	}
+	return tag.RowsAffected() > 0, nil
+}
+
+// useUserToken marks the live token with hash used and returns its user, or
+// ErrNotFound when it is unknown, used, expired or its user deleted.
+func useUserToken(ctx context.Context, tx pgx.Tx, purpose string, hash []byte) (string, error) {
+	var userID string
+	err := tx.QueryRow(ctx, `
+		UPDATE user_tokens SET used_at=NOW()
+		WHERE token_hash=$1 AND purpose=$2 AND used_at IS NULL AND expires_at > NOW()
+		  AND user_id IN (SELECT id FROM users WHERE deleted_at IS NULL)
+		RETURNING user_id`, hash, purpose).Scan(&userID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return "", ErrNotFound
+	}
+	if err != nil {
+		utils.Error.Err(err).Str("purpose", purpose).Msg("failed to use user token")
+		return "", err
+	}
+	return userID, nil
+}
+
+// ResetPassword spends the reset token with hash and sets the user's
+// password. Their other reset tokens are spent and their refresh tokens
+// revoked with it. Following the link proves the address, so it is marked
+// verified too.
+func (r *UserRepository) ResetPassword(ctx context.Context, hash []byte, passwordHash string) (string, error) {
+	tx, err := r.DB.Begin(ctx)
+	if err != nil {
+		return "", err
+	}
+	defer tx.Rollback(ctx)
+
+	userID, err := useUserToken(ctx, tx, models.TokenPasswordReset, hash)
+	if err != nil {
+		return "", err
+	}
+	if _, err := tx.Exec(ctx, `UPDATE users SET password_hash=$2, email_verified_at=COALESCE(email_verified_at, NOW())
+		WHERE id=$1`, userID, passwordHash); err != nil {
+		utils.Error.Err(err).Str("user_id", userID).Msg("failed to reset password")
+		return "", err
+	}
+	if _, err := tx.Exec(ctx, `UPDATE user_tokens SET used_at=NOW()
+		WHERE user_id=$1 AND purpose=$2 AND used_at IS NULL`, userID, models.TokenPasswordReset); err != nil {
+		utils.Error.Err(err).Str("user_id", userID).Msg("failed to spend reset tokens")
+		return "", err
+	}
+	if _, err := tx.Exec(ctx, `UPDATE refresh_tokens SET revoked_at=NOW() WHERE user_id=$1 AND revoked_at IS NULL`, userID); err != nil {
+		utils.Error.Err(err).Str("user_id", userID).Msg("failed to revoke user refresh tokens")
+		return "", err
+	}
+	return userID, tx.Commit(ctx)
+}
+
+// VerifyEmail spends the verification token with hash and marks its user's
+// address verified.
+func (r *UserRepository) VerifyEmail(ctx context.Context, hash []byte) (string, error) {
+	tx, err := r.DB.Begin(ctx)
+	if err != nil {
+		return "", err
+	}
+	defer tx.Rollback(ctx)
+
+	userID, err := useUserToken(ctx, tx, models.TokenEmailVerify, hash)
+	if err != nil {
+		return "", err
+	}
+	if _, err := tx.Exec(ctx, `UPDATE users SET email_verified_at=COALESCE(email_verified_at, NOW()) WHERE id=$1`, userID); err != nil {
+		utils.Error.Err(err).Str("user_id", userID).Msg("failed to verify email")
+		return "", err
+	}
+	return userID, tx.Commit(ctx)
+}
+
+func (r *UserRepository) IsEmailVerified(ctx context.Context, userID string) (bool, error) {
+	var verified bool
+	err := r.DB.QueryRow(ctx, `SELECT email_verified_at IS NOT NULL FROM users WHERE id=$1 AND deleted_at IS NULL`, userID).Scan(&verified)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return false, ErrNotFound
+	}
+	if err != nil {
+		utils.Error.Err(err).Str("user_id", userID).Msg("failed to check email verification")
+		return false, err
+	}
+	return verified, nil
+}
+
+func (r *UserRepository) DeleteExpiredUserTokens(ctx context.Context) (int64, error) {
+	tag, err := r.DB.Exec(ctx, `DELETE FROM user_tokens WHERE expires_at <= NOW()`)
+	if err != nil {
+		utils.Error.Err(err).Msg("failed to delete expired user tokens")
+		return 0, err
+	}
+	return tag.RowsAffected(), nil
+}
@@ -0,0 +1,198 @@
+package services
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/SrabanMondal/SecureStore/internal/repository"
+)
+
+// randomUUID is an id nothing has.
+func randomUUID(t *testing.T) string {
+	b := randomBytes(t, 16)
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	h := hex.EncodeToString(b)
+	return h[:8] + "-" + h[8:12] + "-" + h[12:16] + "-" + h[16:20] + "-" + h[20:]
+}
+
+// TestOtherUsersGetNotFound checks that a user reaching for someone else's
+// file, folder, upload or share link gets the same error as for an id that
+// does not exist, so ids of other users' things cannot be probed.
+func TestOtherUsersGetNotFound(t *testing.T) {
+	e := newTestEnv(t)
+	folders := NewFolderService(repositories.NewFolderRepository(e.db, nil))
+	tus := NewTusService(repositories.NewTusRepository(e.db, nil), e.files)
+	shares := NewShareService(repositories.NewShareRepository(e.db), e.fileRepo, e.files)
+
+	alice := e.register(t, "alice")
+	bob := e.register(t, "bob")
+
+	file := e.upload(t, alice.ID, "/report.txt", []byte("report"))
+	trashed := e.upload(t, alice.ID, "/old.txt", []byte("old"))
+	if _, err := e.files.DeleteFile(e.ctx, alice.ID, trashed.ID); err != nil {
+		t.Fatal(err)
+	}
+	pending, _ := e.presign(t, alice.ID, "/pending.txt", 7, []byte("pending"))
+	folder, err := folders.CreateFolder(e.ctx, alice.ID, nil, "docs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	bobsFolder, err := folders.CreateFolder(e.ctx, bob.ID, nil, "mine")
+	if err != nil {
+		t.Fatal(err)
+	}
+	multipart, err := e.files.InitiateMultipart(e.ctx, alice.ID, "/big.bin", 10<<20, 0, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	upload, err := tus.Create(e.ctx, alice.ID, "/tus.bin", 5, "", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	share, err := shares.CreateShareLink(e.ctx, alice.ID, file.ID, time.Hour, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		name string
+		id   string
+		want error
+		call func(id string) error
+	}{
+		{"download", file.ID, ErrFileNotFound, func(id string) error {
+			_, err := e.files.DownloadableFile(e.ctx, bob.ID, id)
+			return err
+		}},
+		{"delete", file.ID, ErrFileNotFound, func(id string) error {
+			_, err := e.files.DeleteFile(e.ctx, bob.ID, id)
+			return err
+		}},
+		{"finalize", pending.ID, ErrFileNotFound, func(id string) error {
+			_, err := e.files.FinalizeUpload(e.ctx, bob.ID, id, 0, "", "")
+			return err
+		}},
+		{"list versions", file.ID, ErrFileNotFound, func(id string) error {
+			_, err := e.files.ListVersions(e.ctx, bob.ID, id)
+			return err
+		}},
+		{"download version", file.ID, ErrFileNotFound, func(id string) error {
+			_, err := e.files.VersionFile(e.ctx, bob.ID, id, 1)
+			return err
+		}},
+		{"restore version", file.ID, ErrFileNotFound, func(id string) error {
+			_, err := e.files.RestoreVersion(e.ctx, bob.ID, id, 1)
+			return err
+		}},
+		{"restore from trash", trashed.ID, ErrFileNotFound, func(id string) error {
+			_, err := e.files.RestoreFile(e.ctx, bob.ID, id)
+			return err
+		}},
+		{"list folder", folder.ID, ErrFolderNotFound, func(id string) error {
+			_, err := folders.Children(e.ctx, bob.ID, id, 0, 0)
+			return err
+		}},
+		{"rename folder", folder.ID, ErrFolderNotFound, func(id string) error {
+			_, err := folders.RenameFolder(e.ctx, bob.ID, id, "taken")
+			return err
+		}},
+		{"move folder", folder.ID, ErrFolderNotFound, func(id string) error {
+			_, err := folders.MoveFolder(e.ctx, bob.ID, id, nil)
+			return err
+		}},
+		{"move into folder", folder.ID, ErrFolderNotFound, func(id string) error {
+			_, err := folders.MoveFolder(e.ctx, bob.ID, bobsFolder.ID, &id)
+			return err
+		}},
+		{"create in folder", folder.ID, ErrFolderNotFound, func(id string) error {
+			_, err := folders.CreateFolder(e.ctx, bob.ID, &id, "inside")
+			return err
+		}},
+		{"delete folder", folder.ID, ErrFolderNotFound, func(id string) error {
+			return folders.DeleteFolder(e.ctx, bob.ID, id)
+		}},
+		{"multipart part urls", multipart.ID, ErrUploadNotFound, func(id string) error {
+			_, err := e.files.MultipartPartURLs(e.ctx, bob.ID, id, nil)
+			return err
+		}},
+		{"multipart status", multipart.ID, ErrUploadNotFound, func(id string) error {
+			_, _, err := e.files.MultipartStatus(e.ctx, bob.ID, id)
+			return err
+		}},
+		{"complete multipart", multipart.ID, ErrUploadNotFound, func(id string) error {
+			_, err := e.files.CompleteMultipart(e.ctx, bob.ID, id, nil)
+			return err
+		}},
+		{"abort multipart", multipart.ID, ErrUploadNotFound, func(id string) error {
+			return e.files.AbortMultipart(e.ctx, bob.ID, id)
+		}},
+		{"tus head", upload.ID, ErrUploadNotFound, func(id string) error {
+			_, err := tus.Get(e.ctx, bob.ID, id)
+			return err
+		}},
+		{"tus patch", upload.ID, ErrUploadNotFound, func(id string) error {
+			_, err := tus.WriteChunk(e.ctx, bob.ID, id, 0, 5, bytes.NewReader([]byte("bob's")), nil)
+			return err
+		}},
+		{"tus terminate", upload.ID, ErrUploadNotFound, func(id string) error {
+			return tus.Terminate(e.ctx, bob.ID, id)
+		}},
+		{"share file", file.ID, ErrFileNotFound, func(id string) error {
+			_, err := shares.CreateShareLink(e.ctx, bob.ID, id, time.Hour, "")
+			return err
+		}},
+		{"delete share", share.ID, ErrShareNotFound, func(id string) error {
+			return shares.DeleteLink(e.ctx, bob.ID, id)
+		}},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			for _, id := range []string{tc.id, randomUUID(t), "not-an-id"} {
+				if err := tc.call(id); !errors.Is(err, tc.want) {
+					t.Errorf("id %s: got %v, want %v", id, err, tc.want)
+				}
+			}
+		})
+	}
+
+	// and nothing of alice's was touched
+	if got := e.fileStatus(t, file.ID); got != "uploaded" {
+		t.Errorf("file status = %q", got)
+	}
+	if got := e.fileStatus(t, trashed.ID); got != "trashed" {
+		t.Errorf("trashed file status = %q", got)
+	}
+	if got := e.fileStatus(t, pending.ID); got != "pending" {
+		t.Errorf("pending file status = %q", got)
+	}
+	if listing, err := folders.Children(e.ctx, alice.ID, folder.ID, 0, 0); err != nil || listing.Folder.Name != "docs" || len(listing.Folders) != 0 {
+		t.Errorf("folder changed: %v", err)
+	}
+	if _, _, err := e.files.MultipartStatus(e.ctx, alice.ID, multipart.ID); err != nil {
+		t.Errorf("multipart upload: %v", err)
+	}
+	if u, err := tus.Get(e.ctx, alice.ID, upload.ID); err != nil || u.Offset != 0 {
+		t.Errorf("tus upload: %v", err)
+	}
+	if _, err := shares.ValidateShareLink(e.ctx, share.ShareToken, ""); err != nil {
+		t.Errorf("share link: %v", err)
+	}
+}
+
+func TestCreateShareLinkTrashed(t *testing.T) {
+	e := newTestEnv(t)
+	shares := NewShareService(repositories.NewShareRepository(e.db), e.fileRepo, e.files)
+	alice := e.register(t, "alice")
+
+	file := e.upload(t, alice.ID, "/old.txt", []byte("old"))
+	if _, err := e.files.DeleteFile(e.ctx, alice.ID, file.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := shares.CreateShareLink(e.ctx, alice.ID, file.ID, time.Hour, ""); !errors.Is(err, ErrFileNotFound) {
+		t.Errorf("sharing a trashed file = %v, want ErrFileNotFound", err)
+	}
+}
@@ -0,0 +1,206 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+
+	"github.com/SrabanMondal/SecureStore/internal/mailer"
+	"github.com/SrabanMondal/SecureStore/internal/models"
+	"github.com/SrabanMondal/SecureStore/internal/repository"
+	"github.com/SrabanMondal/SecureStore/internal/utils"
+)
+
+const (
+	passwordResetTTL = time.Hour
+	emailVerifyTTL   = 24 * time.Hour
+	// least time between two mails of the same kind to one user
+	mailThrottle = time.Minute
+	// how long mailing may take once the request that asked for it is done
+	mailTimeout = 30 * time.Second
+)
+
+var (
+	ErrInvalidUserToken = errors.New("invalid or expired token")
+	ErrEmailVerified    = errors.New("email address is already verified")
+	ErrEmailNotVerified = errors.New("verify your email address first")
+	ErrMailThrottled    = errors.New("an email was sent recently, try again in a minute")
+	ErrEmptyPassword    = errors.New("password is required")
+)
+
+// AccountService mails password reset and email verification links. Their
+// tokens are single use, expire, and are stored hashed.
+type AccountService struct {
+	UserRepo *repositories.UserRepository
+	Mailer   mailer.Mailer
+	// base of the links in mails, where the frontend serves the
+	// reset-password and verify-email pages
+	LinkBaseURL string
+	// keeps unverified users from uploading
+	RequireVerifiedEmail bool
+}
+
+func NewAccountService(userRepo *repositories.UserRepository, m mailer.Mailer, linkBaseURL string, requireVerified bool) *AccountService {
+	return &AccountService{
+		UserRepo:             userRepo,
+		Mailer:               m,
+		LinkBaseURL:          strings.TrimRight(linkBaseURL, "/"),
+		RequireVerifiedEmail: requireVerified,
+	}
+}
+
+// newToken stores a token for purpose and returns it, or ErrMailThrottled
+// when the user was mailed one too recently.
+func (s *AccountService) newToken(ctx context.Context, userID, purpose string, ttl time.Duration) (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	token := base64.RawURLEncoding.EncodeToString(b)
+	stored, err := s.UserRepo.CreateUserToken(ctx, userID, purpose, hashUserToken(token), time.Now().Add(ttl), mailThrottle)
+	if err != nil {
+		return "", err
+	}
+	if !stored {
+		return "", ErrMailThrottled
+	}
+	return token, nil
+}
+
+func (s *AccountService) link(page, token string) string {
+	return s.LinkBaseURL + "/" + page + "?token=" + url.QueryEscape(token)
+}
+
+// SendVerification mails the user a link confirming their address.
+func (s *AccountService) SendVerification(ctx context.Context, userID string) error {
+	user, err := s.UserRepo.GetUserByID(ctx, userID)
+	if err != nil {
+		return ErrUserNotFound
+	}
+	verified, err := s.UserRepo.IsEmailVerified(ctx, userID)
+	if err != nil {
+		return err
+	}
+	if verified {
+		return ErrEmailVerified
+	}
+
+	token, err := s.newToken(ctx, userID, models.TokenEmailVerify, emailVerifyTTL)
+	if err != nil {
+		return err
+	}
+	return s.Mailer.Send(ctx, mailer.Message{
+		To:      user.Email,
+		Subject: "Confirm your email address",
+		Body: fmt.Sprintf("Hi %s,\n\nConfirm your email address by opening this link within 24 hours:\n\n%s\n",
+			user.Username, s.link("verify-email", token)),
+	})
+}
+
+func (s *AccountService) VerifyEmail(ctx context.Context, token string) error {
+	userID, err := s.UserRepo.VerifyEmail(ctx, hashUserToken(token))
+	if errors.Is(err, repositories.ErrNotFound) {
+		return ErrInvalidUserToken
+	}
+	if err != nil {
+		return err
+	}
+	utils.Info.Info().Str("user_id", userID).Msg("email verified")
+	return nil
+}
+
+// ForgotPassword mails a reset link if email belongs to a user. It answers
+// the same either way, and mails in the background, so callers cannot tell
+// which addresses have accounts.
+func (s *AccountService) ForgotPassword(ctx context.Context, email string) error {
+	user, err := s.UserRepo.GetUserByEmail(ctx, email)
+	if err != nil {
+		return nil
+	}
+	token, err := s.newToken(ctx, user.ID, models.TokenPasswordReset, passwordResetTTL)
+	if errors.Is(err, ErrMailThrottled) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	msg := mailer.Message{
+		To:      user.Email,
+		Subject: "Reset your password",
+		Body: fmt.Sprintf("Hi %s,\n\nSomeone asked to reset your password. If it was you, open this link within an hour:\n\n%s\n\nOtherwise you can ignore this email.\n",
+			user.Username, s.link("reset-password", token)),
+	}
+	go func() {
+		ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), mailTimeout)
+		defer cancel()
+		if err := s.Mailer.Send(ctx, msg); err != nil {
+			utils.Error.Err(err).Str("user_id", user.ID).Msg("failed to send password reset mail")
+		}
+	}()
+	return nil
+}
+
+// ResetPassword sets a new password with a token from ForgotPassword and
+// ends every session of the user.
+func (s *AccountService) ResetPassword(ctx context.Context, token, password string) error {
+	if password == "" {
+		return ErrEmptyPassword
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return err
+	}
+	userID, err := s.UserRepo.ResetPassword(ctx, hashUserToken(token), string(hash))
+	if errors.Is(err, repositories.ErrNotFound) {
+		return ErrInvalidUserToken
+	}
+	if err != nil {
+		return err
+	}
+	utils.Info.Info().Str("user_id", userID).Msg("password reset")
+	return nil
+}
+
+// CheckUpload refuses users whose address is unverified when verification is
+// required.
+func (s *AccountService) CheckUpload(ctx context.Context, userID string) error {
+	if !s.RequireVerifiedEmail {
+		return nil
+	}
+	verified, err := s.UserRepo.IsEmailVerified(ctx, userID)
+	if errors.Is(err, repositories.ErrNotFound) {
+		return ErrUserNotFound
+	}
+	if err != nil {
+		return err
+	}
+	if !verified {
+		return ErrEmailNotVerified
+	}
+	return nil
+}
+
+func (s *AccountService) DeleteExpiredTokens(ctx context.Context) error {
+	n, err := s.UserRepo.DeleteExpiredUserTokens(ctx)
+	if err != nil {
+		return err
+	}
+	if n > 0 {
+		utils.Info.Info().Int64("tokens", n).Msg("deleted expired user tokens")
+	}
+	return nil
+}
+
+func hashUserToken(token string) []byte {
+	sum := sha256.Sum256([]byte(token))
+	return sum[:]
+}
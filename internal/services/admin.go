@@ -0,0 +1,225 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"slices"
+
+	"github.com/SrabanMondal/SecureStore/internal/models"
+	"github.com/SrabanMondal/SecureStore/internal/repository"
+	"github.com/SrabanMondal/SecureStore/internal/utils"
+)
+
+// how many of the heaviest users the usage report lists
+const topUsersCount = 10
+
+var (
+	ErrInvalidRole     = errors.New("invalid role")
+	ErrSelfAdminAction = errors.New("admins cannot do this to their own account")
+)
+
+// AdminService backs the admin API. Every change is logged with the admin
+// who made it.
+type AdminService struct {
+	UserRepo  *repositories.UserRepository
+	FileRepo  *repositories.FileRepository
+	ShareRepo *repositories.ShareRepository
+	Auth      *AuthService
+}
+
+func NewAdminService(userRepo *repositories.UserRepository, fileRepo *repositories.FileRepository, shareRepo *repositories.ShareRepository, auth *AuthService) *AdminService {
+	return &AdminService{UserRepo: userRepo, FileRepo: fileRepo, ShareRepo: shareRepo, Auth: auth}
+}
+
+// UserPage is a page of users; NextOffset is set when there are more.
+type UserPage struct {
+	Users      []models.UserSummary `json:"users"`
+	NextOffset *int                 `json:"next_offset,omitempty"`
+}
+
+type SharePage struct {
+	Shares     []models.ShareLink `json:"shares"`
+	NextOffset *int               `json:"next_offset,omitempty"`
+}
+
+// UsageReport is storage across the system along with its heaviest users.
+type UsageReport struct {
+	*models.SystemUsage
+	TopUsers []models.UserSummary `json:"top_users"`
+}
+
+func pageBounds(limit, offset int) (int, int) {
+	if limit <= 0 {
+		limit = defaultListLimit
+	}
+	if limit > maxListLimit {
+		limit = maxListLimit
+	}
+	if offset < 0 {
+		offset = 0
+	}
+	return limit, offset
+}
+
+func (s *AdminService) ListUsers(ctx context.Context, limit, offset int) (*UserPage, error) {
+	limit, offset = pageBounds(limit, offset)
+	users, err := s.UserRepo.ListUsers(ctx, false, limit+1, offset)
+	if err != nil {
+		return nil, err
+	}
+	page := &UserPage{Users: users}
+	if len(users) > limit {
+		page.Users = users[:limit]
+		next := offset + limit
+		page.NextOffset = &next
+	}
+	return page, nil
+}
+
+func (s *AdminService) GetUser(ctx context.Context, id string) (*models.UserSummary, error) {
+	u, err := s.UserRepo.GetUserSummary(ctx, id)
+	if errors.Is(err, repositories.ErrNotFound) {
+		return nil, ErrUserNotFound
+	}
+	return u, err
+}
+
+// SetRole changes the user's role. Their access tokens act with the new role
+// at once on this instance and within revocationRefresh on others; tokens
+// issued from then on carry it too.
+func (s *AdminService) SetRole(ctx context.Context, adminID, id, role string) error {
+	if !slices.Contains(models.Roles, role) {
+		return ErrInvalidRole
+	}
+	if id == adminID {
+		return ErrSelfAdminAction
+	}
+	err := s.UserRepo.SetUserRole(ctx, id, role)
+	if errors.Is(err, repositories.ErrNotFound) {
+		return ErrUserNotFound
+	}
+	if err != nil {
+		return err
+	}
+	s.Auth.Tokens.noteRole(id, role)
+	utils.Info.Info().Str("admin_id", adminID).Str("user_id", id).Str("role", role).Msg("user role changed")
+	return nil
+}
+
+// SetSuspended suspends or reinstates a user. A suspended user cannot log in,
+// refresh or use any token until reinstated; nothing of theirs is revoked.
+func (s *AdminService) SetSuspended(ctx context.Context, adminID, id string, suspended bool) error {
+	if id == adminID {
+		return ErrSelfAdminAction
+	}
+	err := s.UserRepo.SetUserSuspended(ctx, id, suspended)
+	if errors.Is(err, repositories.ErrNotFound) {
+		return ErrUserNotFound
+	}
+	if err != nil {
+		return err
+	}
+	s.Auth.Tokens.noteSuspended(id, suspended)
+
+	msg := "user reinstated"
+	if suspended {
+		msg = "user suspended"
+	}
+	utils.Info.Info().Str("admin_id", adminID).Str("user_id", id).Msg(msg)
+	return nil
+}
+
+// DeleteUser deletes another user's account. See AuthService.DeleteUser.
+func (s *AdminService) DeleteUser(ctx context.Context, adminID, id string) error {
+	if id == adminID {
+		return ErrSelfAdminAction
+	}
+	if err := s.Auth.DeleteUser(ctx, id); err != nil {
+		return err
+	}
+	utils.Info.Info().Str("admin_id", adminID).Str("user_id", id).Msg("user deleted by admin")
+	return nil
+}
+
+func (s *AdminService) Usage(ctx context.Context) (*UsageReport, error) {
+	usage, err := s.FileRepo.SystemUsage(ctx)
+	if err != nil {
+		return nil, err
+	}
+	top, err := s.UserRepo.ListUsers(ctx, true, topUsersCount, 0)
+	if err != nil {
+		return nil, err
+	}
+	return &UsageReport{SystemUsage: usage, TopUsers: top}, nil
+}
+
+// ForceDeleteFile hands any user's file to the cleanup job, skipping the
+// trash.
+func (s *AdminService) ForceDeleteFile(ctx context.Context, adminID, fileID string) error {
+	file, err := s.FileRepo.GetFileByID(ctx, fileID)
+	if err != nil {
+		return ErrFileNotFound
+	}
+	if file.Status == "deleting" {
+		return nil
+	}
+	if err := s.FileRepo.UpdateFileStatus(ctx, file.ID, "deleting"); err != nil {
+		return err
+	}
+	utils.Info.Info().Str("admin_id", adminID).Str("file_id", file.ID).Str("user_id", file.UserID).Msg("file force deleted")
+	return nil
+}
+
+// ListShares pages through share links across the system, or those to the
+// files of userID when it is set.
+func (s *AdminService) ListShares(ctx context.Context, userID string, limit, offset int) (*SharePage, error) {
+	limit, offset = pageBounds(limit, offset)
+	links, err := s.ShareRepo.ListShareLinks(ctx, userID, limit+1, offset)
+	if err != nil {
+		return nil, err
+	}
+	page := &SharePage{Shares: links}
+	if len(links) > limit {
+		page.Shares = links[:limit]
+		next := offset + limit
+		page.NextOffset = &next
+	}
+	return page, nil
+}
+
+func (s *AdminService) RevokeShare(ctx context.Context, adminID, id string) error {
+	err := s.ShareRepo.DeleteShareLink(ctx, id)
+	if errors.Is(err, repositories.ErrNotFound) {
+		return ErrShareNotFound
+	}
+	if err != nil {
+		return err
+	}
+	utils.Info.Info().Str("admin_id", adminID).Str("share_id", id).Msg("share link revoked")
+	return nil
+}
+
+// RevokeUserShares deletes every share link to the user's files and returns
+// how many there were.
+func (s *AdminService) RevokeUserShares(ctx context.Context, adminID, userID string) (int64, error) {
+	n, err := s.ShareRepo.DeleteUserShareLinks(ctx, userID)
+	if err != nil {
+		return 0, err
+	}
+	utils.Info.Info().Str("admin_id", adminID).Str("user_id", userID).Int64("shares", n).Msg("user share links revoked")
+	return n, nil
+}
+
+// GrantAdmins makes the users with these emails admins, for bootstrapping
+// the first admin. Users registered later are not affected.
+func (s *AdminService) GrantAdmins(ctx context.Context, emails []string) error {
+	if len(emails) == 0 {
+		return nil
+	}
+	n, err := s.UserRepo.GrantRoleByEmail(ctx, emails, models.RoleAdmin)
+	if err != nil {
+		return err
+	}
+	utils.Info.Info().Int64("users", n).Msg("granted admin role from ADMIN_EMAILS")
+	return nil
+}
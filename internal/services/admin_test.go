@@ -0,0 +1,108 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/SrabanMondal/SecureStore/internal/models"
+	"github.com/SrabanMondal/SecureStore/internal/utils"
+)
+
+func TestAdminSelfActions(t *testing.T) {
+	// refused before any repository is touched
+	s := &AdminService{}
+	ctx := context.Background()
+
+	if err := s.SetRole(ctx, "admin-1", "admin-1", models.RoleUser); !errors.Is(err, ErrSelfAdminAction) {
+		t.Errorf("SetRole on self = %v, want ErrSelfAdminAction", err)
+	}
+	if err := s.SetRole(ctx, "admin-1", "user-1", "owner"); !errors.Is(err, ErrInvalidRole) {
+		t.Errorf("SetRole to unknown role = %v, want ErrInvalidRole", err)
+	}
+	for _, suspended := range []bool{true, false} {
+		if err := s.SetSuspended(ctx, "admin-1", "admin-1", suspended); !errors.Is(err, ErrSelfAdminAction) {
+			t.Errorf("SetSuspended(%v) on self = %v, want ErrSelfAdminAction", suspended, err)
+		}
+	}
+	if err := s.DeleteUser(ctx, "admin-1", "admin-1"); !errors.Is(err, ErrSelfAdminAction) {
+		t.Errorf("DeleteUser on self = %v, want ErrSelfAdminAction", err)
+	}
+}
+
+func TestAuthenticateUsesCurrentRole(t *testing.T) {
+	keys, err := utils.GenerateSigningKeys()
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := NewTokenService(nil, nil, utils.JWTSettings{Keys: keys, Issuer: "securestore-test", Audience: "securestore-test"},
+		time.Minute, time.Hour)
+	// as loaded by reload
+	s.denied, s.suspended, s.roles = map[string]time.Time{}, map[string]bool{}, map[string]string{"admin-1": models.RoleAdmin}
+	s.loadedAt = time.Now()
+	ctx := context.Background()
+
+	adminToken, err := utils.GenerateJWT("admin-1", models.RoleAdmin, s.JWT, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	userToken, err := utils.GenerateJWT("user-1", models.RoleUser, s.JWT, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	role := func(token string) string {
+		t.Helper()
+		claims, err := s.Authenticate(ctx, token)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return claims.Role
+	}
+
+	if got := role(adminToken); got != models.RoleAdmin {
+		t.Errorf("admin's role = %q", got)
+	}
+	s.noteRole("admin-1", models.RoleUser)
+	if got := role(adminToken); got != models.RoleUser {
+		t.Errorf("demoted admin's token acts as %q", got)
+	}
+
+	s.noteRole("user-1", models.RoleAuditor)
+	if got := role(userToken); got != models.RoleAuditor {
+		t.Errorf("promoted user's token acts as %q", got)
+	}
+}
+
+func TestSetRoleAppliesAtOnce(t *testing.T) {
+	e := newTestEnv(t)
+	admin := NewAdminService(e.userRepo, e.fileRepo, nil, e.auth)
+	root := e.register(t, "root")
+	alice := e.register(t, "alice")
+
+	if err := admin.SetRole(e.ctx, root.ID, alice.ID, models.RoleAdmin); err != nil {
+		t.Fatal(err)
+	}
+	login, err := e.auth.Login(e.ctx, "alice@example.com", "password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	role := func() string {
+		t.Helper()
+		claims, err := e.auth.Tokens.Authenticate(e.ctx, login.AccessToken)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return claims.Role
+	}
+	if got := role(); got != models.RoleAdmin {
+		t.Fatalf("role = %q, want admin", got)
+	}
+
+	if err := admin.SetRole(e.ctx, root.ID, alice.ID, models.RoleUser); err != nil {
+		t.Fatal(err)
+	}
+	if got := role(); got != models.RoleUser {
+		t.Errorf("demoted admin's token acts as %q", got)
+	}
+}
@@ -3,7 +3,6 @@ package services
 import (
 	"context"
 	"errors"
-	"time"
 
 	"golang.org/x/crypto/bcrypt"
 
@@ -12,34 +11,58 @@ import (
 	"github.com/SrabanMondal/SecureStore/internal/repository"
 )
 
+var (
+	ErrInvalidCredentials = errors.New("invalid email or password")
+	ErrUserNotFound       = errors.New("user not found")
+	ErrReauthRequired     = errors.New("sign in again at your identity provider first")
+)
+
 type AuthService struct {
-	UserRepo   *repositories.UserRepository
-	JWTSecret  string
-	JWTExpiry  time.Duration
+	UserRepo *repositories.UserRepository
+	UserKeys *UserKeyService
+	Tokens   *TokenService
+	MFA      *MFAService
 }
 
-func NewAuthService(userRepo *repositories.UserRepository, secret string, expiry time.Duration) *AuthService {
+func NewAuthService(userRepo *repositories.UserRepository, userKeys *UserKeyService, tokens *TokenService, mfa *MFAService) *AuthService {
 	return &AuthService{
-		UserRepo:  userRepo,
-		JWTSecret: secret,
-		JWTExpiry: expiry,
+		UserRepo: userRepo,
+		UserKeys: userKeys,
+		Tokens:   tokens,
+		MFA:      mfa,
 	}
 }
 
-func (s *AuthService) Register(ctx context.Context, username, email, password string) (*models.User, error) {
+// LoginResult is either a session or, for accounts with MFA, the token of
+// the second login step.
+type LoginResult struct {
+	*TokenPair
+	MFARequired  bool   `json:"mfa_required,omitempty"`
+	MFAToken     string `json:"mfa_token,omitempty"`
+	MFAExpiresIn int64  `json:"mfa_expires_in,omitempty"`
+}
+
+// Register creates the user along with their key encryption key, sealed
+// under keyPassphrase when one is given.
+func (s *AuthService) Register(ctx context.Context, username, email, password, keyPassphrase string) (*models.User, error) {
 
 	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
 	if err != nil {
 		return nil, err
 	}
 
+	key, err := s.UserKeys.NewKey(ctx, keyPassphrase)
+	if err != nil {
+		return nil, err
+	}
+
 	user := &models.User{
 		Username:     username,
 		Email:        email,
 		PasswordHash: string(hash),
 	}
 
-	err = s.UserRepo.CreateUser(ctx, user)
+	err = s.UserRepo.CreateUser(ctx, user, key)
 	if err != nil {
 		return nil, err
 	}
@@ -47,21 +70,133 @@ func (s *AuthService) Register(ctx context.Context, username, email, password st
 	return user, nil
 }
 
-func (s *AuthService) Login(ctx context.Context, email, password string) (string, error) {
+// Login checks the password and starts a session with an access and a
+// refresh token, or, when the user has MFA enabled, a challenge to complete
+// with LoginMFA. Users without a password cannot log in with one.
+func (s *AuthService) Login(ctx context.Context, email, password string) (*LoginResult, error) {
 	user, err := s.UserRepo.GetUserByEmail(ctx, email)
 	if err != nil {
-		return "", errors.New("invalid email or password")
+		return nil, ErrInvalidCredentials
+	}
+	if user.PasswordHash == "" {
+		return nil, ErrInvalidCredentials
 	}
 
 	err = bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(password))
 	if err != nil {
-		return "", errors.New("invalid email or password")
+		return nil, ErrInvalidCredentials
+	}
+	return s.startSession(ctx, user.ID)
+}
+
+// startSession finishes a login of userID whose first factor has been
+// checked, by issuing tokens or starting the MFA challenge. Suspended users
+// are refused before the second step.
+func (s *AuthService) startSession(ctx context.Context, userID string) (*LoginResult, error) {
+	user, err := s.UserRepo.GetUserByID(ctx, userID)
+	if err != nil {
+		return nil, ErrUserNotFound
+	}
+	if user.SuspendedAt != nil {
+		return nil, ErrAccountSuspended
+	}
+	mfa, err := s.MFA.Enabled(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if mfa {
+		token, err := s.MFA.Challenge(ctx, userID)
+		if err != nil {
+			return nil, err
+		}
+		return &LoginResult{MFARequired: true, MFAToken: token, MFAExpiresIn: int64(mfaChallengeTTL.Seconds())}, nil
+	}
+
+	tokens, err := s.Tokens.Issue(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	return &LoginResult{TokenPair: tokens}, nil
+}
+
+// LoginMFA completes a login with the code of the user's authenticator or a
+// recovery code.
+func (s *AuthService) LoginMFA(ctx context.Context, mfaToken, code string) (*TokenPair, error) {
+	userID, err := s.MFA.Verify(ctx, mfaToken, code)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := s.UserRepo.GetUserByID(ctx, userID); err != nil {
+		return nil, ErrInvalidMFAChallenge
 	}
+	return s.Tokens.Issue(ctx, userID)
+}
 
-	token, err := utils.GenerateJWT(user.ID, s.JWTSecret, s.JWTExpiry)
+// confirmPassword checks the password of the caller, who is already signed
+// in. Users without a password confirm who they are by signing in again at
+// their identity provider (see OIDCService.Reauthenticate) shortly before;
+// each such sign-in confirms one action.
+func (s *AuthService) confirmPassword(ctx context.Context, userID, password string) error {
+	user, err := s.UserRepo.GetUserByID(ctx, userID)
 	if err != nil {
-		return "", err
+		return ErrInvalidCredentials
 	}
+	if user.PasswordHash == "" {
+		ok, err := s.UserRepo.TakeReauthentication(ctx, userID, reauthWindow)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return ErrReauthRequired
+		}
+		return nil
+	}
+	if err := bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(password)); err != nil {
+		return ErrInvalidCredentials
+	}
+	return nil
+}
+
+// DisableMFA turns MFA off once the caller's password and a current code are
+// confirmed.
+func (s *AuthService) DisableMFA(ctx context.Context, userID, password, code string) error {
+	if err := s.confirmPassword(ctx, userID, password); err != nil {
+		return err
+	}
+	return s.MFA.Disable(ctx, userID, code)
+}
 
-	return token, nil
+// DeleteAccount deletes the caller's own account once their password is
+// confirmed. See DeleteUser.
+func (s *AuthService) DeleteAccount(ctx context.Context, userID, password string) error {
+	if err := s.confirmPassword(ctx, userID, password); err != nil {
+		return err
+	}
+	return s.DeleteUser(ctx, userID)
+}
+
+// DeleteUser destroys the user's key encryption key, leaving their encrypted
+// objects unrecoverable, revokes their refresh and personal access tokens and
+// queues their files for removal. The account is purged once nothing of it is left.
+func (s *AuthService) DeleteUser(ctx context.Context, userID string) error {
+	err := s.UserRepo.DeleteUser(ctx, userID)
+	if errors.Is(err, repositories.ErrNotFound) {
+		return ErrUserNotFound
+	}
+	if err != nil {
+		return err
+	}
+	utils.Info.Info().Str("user_id", userID).Msg("user deleted and key destroyed")
+	return nil
+}
+
+func (s *AuthService) PurgeDeletedUsers(ctx context.Context) error {
+	n, err := s.UserRepo.PurgeDeletedUsers(ctx)
+	if err != nil {
+		return err
+	}
+	if n > 0 {
+		utils.Info.Info().Int64("users", n).Msg("purged deleted users")
+	}
+	return nil
 }
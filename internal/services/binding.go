@@ -0,0 +1,116 @@
+package services
+
+import (
+	"context"
+	"errors"
+
+	"github.com/SrabanMondal/SecureStore/internal/models"
+	"github.com/SrabanMondal/SecureStore/internal/utils"
+)
+
+// versions rewritten per page by BindObjects
+const bindBatchSize = 100
+
+// rewriteVersion copies v's object into a new one sealed under key, wrapped as
+// dk, and bound to v. The version is switched over provided it is still
+// wrapped as it was read, and the losing object is removed.
+func (s *FileService) rewriteVersion(ctx context.Context, ownerID string, v *models.FileVersion, key []byte, dk models.DataKey) error {
+	storageKey, err := objectKey(ownerID)
+	if err != nil {
+		return err
+	}
+
+	file := &models.File{ID: v.FileID, UserID: ownerID, CurrentVersion: v.Version, StorageKey: v.StorageKey, DataKey: v.DataKey}
+	plain, err := s.openDecrypted(ctx, file, true)
+	if err != nil {
+		return err
+	}
+	defer plain.Close()
+	enc, err := s.encryptStream(plain, key, utils.ObjectAAD(v.FileID, ownerID, int64(v.Version)))
+	if err != nil {
+		return err
+	}
+	defer enc.Close()
+
+	objectSize := utils.EncryptedSize(v.Size)
+	if err := s.Storage.Put(ctx, storageKey, enc, objectSize, "application/octet-stream"); err != nil {
+		return err
+	}
+
+	oldKey := v.StorageKey
+	replaced, err := s.FileRepo.ReplaceVersionObject(ctx, v, v.KeyID, storageKey, objectSize, dk)
+	if err != nil || !replaced {
+		_ = s.Storage.Remove(ctx, storageKey)
+		return err
+	}
+	if err := s.Storage.Remove(ctx, oldKey); err != nil {
+		utils.Warn.Warn().Err(err).Str("key", oldKey).Msg("failed to remove rewritten object")
+	}
+	return nil
+}
+
+// BindObjects rewrites encrypted versions stored before objects were bound to
+// their file ID, owner and version, so they can be read with unbound objects
+// disallowed. Data keys are kept; legacy objects without one are moved to a
+// fresh data key under the active master key. Versions whose owner's key needs
+// a passphrase, only available during their requests, or was destroyed are
+// skipped.
+func (s *FileService) BindObjects(ctx context.Context) error {
+	var after *string
+	var bound, skipped, failed int
+	for {
+		versions, err := s.FileRepo.ListUnboundVersions(ctx, after, bindBatchSize)
+		if err != nil {
+			return err
+		}
+
+		for i := range versions {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			v := &versions[i]
+			after = &v.ID
+
+			err := s.bindVersion(ctx, v)
+			switch {
+			case err == nil:
+				bound++
+			case errors.Is(err, ErrPassphraseRequired), errors.Is(err, ErrKeyDestroyed):
+				skipped++
+			default:
+				failed++
+				utils.Error.Err(err).Str("file_id", v.FileID).Int("version", v.Version).Msg("failed to bind file version")
+			}
+		}
+
+		if len(versions) < bindBatchSize {
+			break
+		}
+	}
+
+	if bound+skipped+failed > 0 {
+		utils.Info.Info().Int("bound", bound).Int("skipped", skipped).Int("failed", failed).Msg("bound encrypted objects")
+	}
+	return nil
+}
+
+func (s *FileService) bindVersion(ctx context.Context, v *models.FileVersion) error {
+	file, err := s.FileRepo.GetFileByID(ctx, v.FileID)
+	if err != nil {
+		return err
+	}
+
+	if v.KeyID == "" {
+		key, dk, err := s.generateDataKey(ctx, s.KMS.ActiveKeyID())
+		if err != nil {
+			return err
+		}
+		return s.rewriteVersion(ctx, file.UserID, v, key, dk)
+	}
+
+	key, err := s.dataKey(ctx, file.UserID, v.DataKey)
+	if err != nil {
+		return err
+	}
+	return s.rewriteVersion(ctx, file.UserID, v, key, v.DataKey)
+}
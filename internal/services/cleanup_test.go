@@ -0,0 +1,118 @@
+package services
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/SrabanMondal/SecureStore/internal/repository"
+)
+
+func TestCleanupDeletedFiles(t *testing.T) {
+	e := newTestEnv(t)
+	user := e.register(t, "alice")
+
+	kept := e.upload(t, user.ID, "/kept.txt", []byte("kept"))
+	trashed := e.upload(t, user.ID, "/trashed.txt", []byte("trashed"))
+	emptied := e.upload(t, user.ID, "/emptied.txt", []byte("emptied"))
+	if _, err := e.files.DeleteFile(e.ctx, user.ID, emptied.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := e.files.EmptyTrash(e.ctx, user.ID); err != nil {
+		t.Fatal(err)
+	}
+	// trashed after the trash was emptied, so it waits for retention
+	if _, err := e.files.DeleteFile(e.ctx, user.ID, trashed.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := e.files.CleanupDeletedFiles(e.ctx); err != nil {
+		t.Fatal(err)
+	}
+	if got := e.fileStatus(t, emptied.ID); got != "" {
+		t.Errorf("emptied file still there with status %q", got)
+	}
+	if e.objectExists(emptied.StorageKey) {
+		t.Error("object of emptied file not removed")
+	}
+	if got := e.fileStatus(t, trashed.ID); got != "trashed" {
+		t.Errorf("trashed file within retention has status %q", got)
+	}
+	if got := e.fileStatus(t, kept.ID); got != "uploaded" || !e.objectExists(kept.StorageKey) {
+		t.Errorf("kept file has status %q", got)
+	}
+
+	// past retention the trashed file goes too
+	e.files.Retention.TrashFor = -time.Minute
+	if err := e.files.CleanupDeletedFiles(e.ctx); err != nil {
+		t.Fatal(err)
+	}
+	if got := e.fileStatus(t, trashed.ID); got != "" {
+		t.Errorf("expired trashed file still there with status %q", got)
+	}
+	if e.objectExists(trashed.StorageKey) {
+		t.Error("object of expired trashed file not removed")
+	}
+
+	usage, err := e.files.Usage(e.ctx, user.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if usage.UsedBytes != int64(len("kept")) {
+		t.Errorf("used bytes = %d, want %d", usage.UsedBytes, len("kept"))
+	}
+}
+
+func TestReconcilePendingFiles(t *testing.T) {
+	e := newTestEnv(t)
+	user := e.register(t, "alice")
+
+	complete, _ := e.presign(t, user.ID, "/complete.txt", 5, []byte("hello"))
+	missing, _ := e.presign(t, user.ID, "/missing.txt", 5, nil)
+	short, shortVersion := e.presign(t, user.ID, "/short.txt", 5, []byte("hi"))
+
+	if err := e.files.ReconcilePendingFiles(e.ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := e.fileRepo.GetFileByID(e.ctx, complete.ID)
+	if err != nil {
+		t.Fatalf("complete upload: %v", err)
+	}
+	if f.Status != "uploaded" || f.Size != 5 || f.ContentType != "text/plain" {
+		t.Errorf("complete upload = status %q, size %d, type %q", f.Status, f.Size, f.ContentType)
+	}
+
+	if got := e.fileStatus(t, missing.ID); got != "" {
+		t.Errorf("upload without object still there with status %q", got)
+	}
+
+	if got := e.fileStatus(t, short.ID); got != "" {
+		t.Errorf("upload of the wrong size still there with status %q", got)
+	}
+	if e.objectExists(shortVersion.StorageKey) {
+		t.Error("object of the wrong size not removed")
+	}
+}
+
+func TestMarkFileVerifiedOnlyPending(t *testing.T) {
+	e := newTestEnv(t)
+	user := e.register(t, "alice")
+
+	file, _ := e.presign(t, user.ID, "/late.txt", 5, []byte("hello"))
+	listed, err := e.fileRepo.GetFileByID(e.ctx, file.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// deleted between the reconciler listing it and promoting it
+	if _, err := e.files.DeleteFile(e.ctx, user.ID, file.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := e.fileRepo.MarkFileVerified(e.ctx, listed); !errors.Is(err, repositories.ErrNotFound) {
+		t.Fatalf("MarkFileVerified = %v, want ErrNotFound", err)
+	}
+	if got := e.fileStatus(t, file.ID); got != "deleting" {
+		t.Errorf("status = %q, want deleting", got)
+	}
+}
@@ -0,0 +1,28 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/SrabanMondal/SecureStore/internal/models"
+)
+
+// bounds on the client key fields; they are opaque to the server
+const (
+	maxClientKeyField  = 1024
+	maxClientAlgorithm = 64
+)
+
+var ErrInvalidClientKey = errors.New("invalid client key")
+
+func validateClientKey(k *models.ClientKey) error {
+	switch {
+	case k.WrappedKey == "" || k.IV == "" || k.Algorithm == "":
+		return fmt.Errorf("%w: wrapped_key, iv and algorithm are required", ErrInvalidClientKey)
+	case len(k.WrappedKey) > maxClientKeyField || len(k.IV) > maxClientKeyField:
+		return fmt.Errorf("%w: wrapped_key and iv must be at most %d characters", ErrInvalidClientKey, maxClientKeyField)
+	case len(k.Algorithm) > maxClientAlgorithm:
+		return fmt.Errorf("%w: algorithm must be at most %d characters", ErrInvalidClientKey, maxClientAlgorithm)
+	}
+	return nil
+}
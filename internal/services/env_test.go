@@ -0,0 +1,124 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"testing"
+	"time"
+
+	"github.com/SrabanMondal/SecureStore/internal/kms"
+	"github.com/SrabanMondal/SecureStore/internal/models"
+	"github.com/SrabanMondal/SecureStore/internal/repository"
+	"github.com/SrabanMondal/SecureStore/internal/storage"
+	"github.com/SrabanMondal/SecureStore/internal/testdb"
+	"github.com/SrabanMondal/SecureStore/internal/utils"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// testEnv wires the services to a test database and in-memory storage the
+// way cmd/server does.
+type testEnv struct {
+	ctx   context.Context
+	db    *pgxpool.Pool
+	ring  *utils.Keyring
+	store *storage.MemoryBackend
+
+	userRepo *repositories.UserRepository
+	fileRepo *repositories.FileRepository
+
+	auth  *AuthService
+	mfa   *MFAService
+	files *FileService
+}
+
+func newTestEnv(t *testing.T) *testEnv {
+	t.Helper()
+	db := testdb.New(t)
+
+	ring := utils.NewKeyring()
+	if err := ring.Add("test", randomBytes(t, 32)); err != nil {
+		t.Fatal(err)
+	}
+	keys := kms.NewLocalProvider(ring)
+	signing, err := utils.GenerateSigningKeys()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	e := &testEnv{
+		ctx:      context.Background(),
+		db:       db,
+		ring:     ring,
+		store:    storage.NewMemoryBackend(),
+		userRepo: repositories.NewUserRepository(db),
+		fileRepo: repositories.NewFileRepository(db, nil),
+	}
+	userKeys := NewUserKeyService(e.userRepo, keys)
+	tokens := NewTokenService(repositories.NewTokenRepository(db), e.userRepo,
+		utils.JWTSettings{Keys: signing, Issuer: "securestore-test", Audience: "securestore-test"}, time.Minute, time.Hour)
+	e.mfa = NewMFAService(e.userRepo, keys, "SecureStore")
+	e.auth = NewAuthService(e.userRepo, userKeys, tokens, e.mfa)
+	e.files = NewFileService(e.fileRepo, repositories.NewMultipartRepository(db), e.store, keys, userKeys, false,
+		RetentionPolicy{KeepVersions: 10, TrashFor: 24 * time.Hour})
+	return e
+}
+
+func (e *testEnv) register(t *testing.T, name string) *models.User {
+	t.Helper()
+	user, err := e.auth.Register(e.ctx, name, name+"@example.com", "password", "")
+	if err != nil {
+		t.Fatalf("register %s: %v", name, err)
+	}
+	return user
+}
+
+// presign reserves a presigned upload of size bytes at path and, unless data
+// is nil, stores data as its object.
+func (e *testEnv) presign(t *testing.T, userID, path string, size int64, data []byte) (*models.File, *models.FileVersion) {
+	t.Helper()
+	_, file, v, err := e.files.GeneratePresignedUpload(e.ctx, userID, path, size)
+	if err != nil {
+		t.Fatalf("presign %s: %v", path, err)
+	}
+	if data != nil {
+		if err := e.store.Put(e.ctx, v.StorageKey, bytes.NewReader(data), int64(len(data)), "text/plain"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return file, v
+}
+
+// upload stores data at path as an uploaded file.
+func (e *testEnv) upload(t *testing.T, userID, path string, data []byte) *models.File {
+	t.Helper()
+	file, _ := e.presign(t, userID, path, int64(len(data)), data)
+	file, err := e.files.FinalizeUpload(e.ctx, userID, file.ID, 0, "", "")
+	if err != nil {
+		t.Fatalf("finalize %s: %v", path, err)
+	}
+	return file
+}
+
+func (e *testEnv) fileStatus(t *testing.T, id string) string {
+	t.Helper()
+	f, err := e.fileRepo.GetFileByID(e.ctx, id)
+	if err != nil {
+		return ""
+	}
+	return f.Status
+}
+
+func (e *testEnv) objectExists(key string) bool {
+	_, err := e.store.Stat(context.Background(), key)
+	return err == nil
+}
+
+func randomBytes(t *testing.T, n int) []byte {
+	t.Helper()
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
@@ -0,0 +1,90 @@
+package services
+
+import (
+	"context"
+	"errors"
+
+	"github.com/SrabanMondal/SecureStore/internal/kms"
+	"github.com/SrabanMondal/SecureStore/internal/models"
+	"github.com/SrabanMondal/SecureStore/internal/utils"
+)
+
+var ErrUnknownKey = errors.New("data key was wrapped with an unknown key")
+
+// newDataKey generates the key for one encrypted object of userID, wrapped
+// under that user's key encryption key. Only the wrapped form is ever stored.
+func (s *FileService) newDataKey(ctx context.Context, userID string) (models.DataKey, error) {
+	kek, err := s.UserKeys.KEK(ctx, userID)
+	if err != nil {
+		return models.DataKey{}, err
+	}
+	key, err := utils.NewDataKey()
+	if err != nil {
+		return models.DataKey{}, err
+	}
+	wrapped, err := utils.WrapKey(kek, key)
+	if err != nil {
+		return models.DataKey{}, err
+	}
+	return models.DataKey{
+		WrappedKey:   wrapped,
+		KeyID:        UserKeyID,
+		KeyAlgorithm: utils.KeyWrapAES256GCM,
+	}, nil
+}
+
+// generateDataKey has the KMS generate a key for one object, wrapped under
+// kekID, and returns it in the clear as well.
+func (s *FileService) generateDataKey(ctx context.Context, kekID string) ([]byte, models.DataKey, error) {
+	if !s.KMS.HasKey(kekID) {
+		return nil, models.DataKey{}, ErrUnknownKey
+	}
+	dk, err := s.KMS.GenerateDataKey(ctx, kekID)
+	if err != nil {
+		return nil, models.DataKey{}, err
+	}
+	return dk.Plaintext, models.DataKey{
+		WrappedKey:   dk.Ciphertext,
+		KeyID:        dk.KeyID,
+		KeyAlgorithm: s.KMS.Algorithm(),
+	}, nil
+}
+
+func (s *FileService) wrapDataKey(ctx context.Context, key []byte, kekID string) (models.DataKey, error) {
+	if !s.KMS.HasKey(kekID) {
+		return models.DataKey{}, ErrUnknownKey
+	}
+	wrapped, err := s.KMS.Encrypt(ctx, kekID, key)
+	if err != nil {
+		return models.DataKey{}, err
+	}
+	return models.DataKey{
+		WrappedKey:   wrapped,
+		KeyID:        kekID,
+		KeyAlgorithm: s.KMS.Algorithm(),
+	}, nil
+}
+
+// dataKey unwraps the key an object of userID was encrypted with, using
+// whichever key encryption key wrapped it. Legacy objects carry no data key
+// and were sealed with the legacy key itself, which only the local provider
+// has.
+func (s *FileService) dataKey(ctx context.Context, userID string, dk models.DataKey) ([]byte, error) {
+	if dk.KeyID == UserKeyID {
+		kek, err := s.UserKeys.KEK(ctx, userID)
+		if err != nil {
+			return nil, err
+		}
+		return utils.UnwrapKey(kek, dk.WrappedKey)
+	}
+	if dk.KeyID == "" {
+		if legacy, ok := s.KMS.(kms.LegacyKeyer); ok && legacy.LegacyKey() != nil {
+			return legacy.LegacyKey(), nil
+		}
+		return nil, ErrUnknownKey
+	}
+	if !s.KMS.HasKey(dk.KeyID) || dk.KeyAlgorithm != s.KMS.Algorithm() {
+		return nil, ErrUnknownKey
+	}
+	return s.KMS.Decrypt(ctx, dk.KeyID, dk.WrappedKey)
+}
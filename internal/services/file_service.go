@@ -3,147 +3,455 @@ package services
 import (
 	"bytes"
 	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
 	"fmt"
+	"hash/crc32"
 	"io"
 	"time"
 
-	"github.com/minio/minio-go/v7"
-
+	"github.com/SrabanMondal/SecureStore/internal/kms"
 	"github.com/SrabanMondal/SecureStore/internal/models"
 	"github.com/SrabanMondal/SecureStore/internal/repository"
+	"github.com/SrabanMondal/SecureStore/internal/storage"
 	"github.com/SrabanMondal/SecureStore/internal/utils"
 )
 
 type FileService struct {
-	FileRepo *repositories.FileRepository
-	Minio    *minio.Client
-	Bucket   string
-	FileKey  []byte
+	FileRepo   *repositories.FileRepository
+	UploadRepo *repositories.MultipartRepository
+	Storage    storage.Backend
+	KMS        kms.Provider
+	UserKeys   *UserKeyService
+	Retention  RetentionPolicy
+
+	// AllowUnbound lets downloads decrypt objects written before objects
+	// were bound to their file; see BindObjects.
+	AllowUnbound bool
 }
 
-func NewFileService(repo *repositories.FileRepository, minio *minio.Client, bucket string, fileKey []byte) *FileService {
+func NewFileService(repo *repositories.FileRepository, uploadRepo *repositories.MultipartRepository, store storage.Backend, keyProvider kms.Provider, userKeys *UserKeyService, allowUnbound bool, retention RetentionPolicy) *FileService {
 	return &FileService{
-		FileRepo: repo,
-		Minio:    minio,
-		Bucket:   bucket,
-		FileKey:  fileKey,
+		FileRepo:     repo,
+		UploadRepo:   uploadRepo,
+		Storage:      store,
+		KMS:          keyProvider,
+		UserKeys:     userKeys,
+		Retention:    retention,
+		AllowUnbound: allowUnbound,
 	}
 }
 
 
-func (s *FileService) GeneratePresignedUpload(ctx context.Context, userID, filePath string, size int64) (string, *models.File, error) {
-	storageKey := fmt.Sprintf("%s/%s", userID, filePath)
+// GeneratePresignedUpload reserves a new version of the file at filePath,
+// creating the file if needed, and presigns a PUT for its object.
+func (s *FileService) GeneratePresignedUpload(ctx context.Context, userID, filePath string, size int64) (string, *models.File, *models.FileVersion, error) {
+	return s.presignUpload(ctx, userID, filePath, size, nil)
+}
+
+// GenerateClientUpload is GeneratePresignedUpload for an object the client
+// has encrypted itself; size is that of the ciphertext. client is stored as
+// given and handed back on download.
+func (s *FileService) GenerateClientUpload(ctx context.Context, userID, filePath string, size int64, client models.ClientKey) (string, *models.File, *models.FileVersion, error) {
+	return s.presignUpload(ctx, userID, filePath, size, &client)
+}
+
+func (s *FileService) presignUpload(ctx context.Context, userID, filePath string, size int64, client *models.ClientKey) (string, *models.File, *models.FileVersion, error) {
+	file, version, err := s.prepareUpload(ctx, userID, filePath, size, false, client, "")
+	if err != nil {
+		return "", nil, nil, err
+	}
 
-	url, err := s.Minio.PresignedPutObject(ctx, s.Bucket, storageKey, 15*time.Minute)
+	url, err := s.Storage.PresignedPut(ctx, version.StorageKey, 15*time.Minute)
 	if err != nil {
-		return "", nil, err
+		_ = s.discardVersion(ctx, file.ID, version.Version)
+		return "", nil, nil, err
+	}
+
+	return url, file, version, nil
+}
+
+var (
+	ErrFileNotFound   = errors.New("file not found")
+	ErrFileNotPending = errors.New("file is not awaiting upload")
+	ErrObjectMissing  = errors.New("object has not been uploaded")
+	ErrSizeMismatch   = errors.New("uploaded size does not match declared size")
+)
+
+// FinalizeUpload checks a presigned upload against what actually landed in
+// storage before making it the file's current version. version picks the
+// upload to finalize; zero means the newest pending one. sha256 (hex) and
+// crc32c (hex or base64) are optional; when given, the object is read back and
+// both are verified.
+func (s *FileService) FinalizeUpload(ctx context.Context, userID, fileID string, version int, sha256Sum, crc32cSum string) (*models.File, error) {
+	file, err := s.ownedFile(ctx, userID, fileID)
+	if err != nil {
+		return nil, err
+	}
+	if file.Status == "trashed" {
+		return nil, fmt.Errorf("%w: restore or delete the trashed file first", ErrFileBusy)
+	}
+	if file.Status != "pending" && file.Status != "uploaded" {
+		return nil, ErrFileBusy
+	}
+
+	var v *models.FileVersion
+	if version > 0 {
+		v, err = s.FileRepo.GetVersion(ctx, file.ID, version)
+	} else {
+		v, err = s.FileRepo.LatestPendingVersion(ctx, file.ID)
+	}
+	if errors.Is(err, repositories.ErrNotFound) {
+		if version > 0 {
+			return nil, ErrVersionNotFound
+		}
+		return nil, ErrFileNotPending
+	}
+	if err != nil {
+		return nil, err
+	}
+	if v.Status != "pending" {
+		return nil, ErrFileNotPending
+	}
+
+	info, err := s.Storage.Stat(ctx, v.StorageKey)
+	if errors.Is(err, storage.ErrNotFound) {
+		return nil, ErrObjectMissing
+	}
+	if err != nil {
+		return nil, err
+	}
+	if info.Size != v.Size {
+		return nil, fmt.Errorf("%w: declared %d bytes, stored %d", ErrSizeMismatch, v.Size, info.Size)
+	}
+
+	if sha256Sum != "" || crc32cSum != "" {
+		wantSHA, err := decodeChecksum(sha256Sum, sha256.Size)
+		if err != nil {
+			return nil, err
+		}
+		wantCRC, err := decodeChecksum(crc32cSum, crc32.Size)
+		if err != nil {
+			return nil, err
+		}
+
+		gotSHA, gotCRC, err := s.objectChecksums(ctx, v.StorageKey)
+		if err != nil {
+			return nil, err
+		}
+		if (wantSHA != nil && !bytes.Equal(wantSHA, gotSHA)) || (wantCRC != nil && !bytes.Equal(wantCRC, gotCRC)) {
+			return nil, ErrChecksumMismatch
+		}
+		v.ChecksumSHA256 = hex.EncodeToString(gotSHA)
+		v.ChecksumCRC32C = hex.EncodeToString(gotCRC)
 	}
 
-	file := &models.File{
-		UserID:      userID,
-		FilePath:    filePath,
-		Size:        size,
-		IsEncrypted: false,
-		StorageKey:  storageKey,
+	v.Size = info.Size
+	v.ContentType = info.ContentType
+	// trashed or deleted while the object was checked
+	if err := s.FileRepo.PromoteVersion(ctx, file, v); errors.Is(err, repositories.ErrNotFound) {
+		return nil, ErrFileBusy
+	} else if err != nil {
+		return nil, err
 	}
+	return file, nil
+}
 
-	if err := s.FileRepo.CreateFile(ctx, file); err != nil {
-		return "", nil, err
+func (s *FileService) objectChecksums(ctx context.Context, key string) ([]byte, []byte, error) {
+	obj, err := s.Storage.Get(ctx, key, storage.GetOptions{})
+	if err != nil {
+		return nil, nil, err
 	}
+	defer obj.Close()
 
-	return url.String(), file, nil
+	sha := sha256.New()
+	crc := crc32.New(crc32.MakeTable(crc32.Castagnoli))
+	if _, err := io.Copy(io.MultiWriter(sha, crc), obj); err != nil {
+		return nil, nil, err
+	}
+	return sha.Sum(nil), crc.Sum(nil), nil
 }
 
-func (s *FileService) MarkUploaded(ctx context.Context, fileID string) error {
-	return s.FileRepo.MarkFileUploaded(ctx, fileID)
+// decodeChecksum accepts a digest as hex or standard base64 (the form S3 uses
+// for CRC32C). An empty value yields nil.
+func decodeChecksum(v string, size int) ([]byte, error) {
+	if v == "" {
+		return nil, nil
+	}
+	if b, err := hex.DecodeString(v); err == nil && len(b) == size {
+		return b, nil
+	}
+	if b, err := base64.StdEncoding.DecodeString(v); err == nil && len(b) == size {
+		return b, nil
+	}
+	return nil, fmt.Errorf("invalid checksum %q", v)
 }
 
 
 func (s *FileService) UploadEncrypted(ctx context.Context, userID, filePath string, file io.Reader, size int64) error {
-	storageKey := fmt.Sprintf("%s/%s", userID, filePath)
+	_, err := s.uploadDirect(ctx, userID, filePath, file, size, true)
+	return err
+}
 
-	dbFile := &models.File{
-		UserID:      userID,
-		FilePath:    filePath,
-		Size:        size,
-		IsEncrypted: true,
-		StorageKey:  storageKey,
+// uploadDirect streams size bytes from r into storage through the server,
+// optionally encrypting them, and records them as the file's current version.
+func (s *FileService) uploadDirect(ctx context.Context, userID, filePath string, r io.Reader, size int64, encrypt bool) (*models.File, error) {
+	dbFile, version, err := s.prepareUpload(ctx, userID, filePath, size, encrypt, nil, "")
+	if err != nil {
+		return nil, err
 	}
-	if err := s.FileRepo.CreateFile(ctx, dbFile); err != nil {
-		return err
+
+	body, objectSize := r, size
+	if encrypt {
+		key, err := s.dataKey(ctx, userID, version.DataKey)
+		if err != nil {
+			_ = s.discardVersion(ctx, dbFile.ID, version.Version)
+			return nil, err
+		}
+		enc, err := s.encryptStream(r, key, utils.ObjectAAD(dbFile.ID, userID, int64(version.Version)))
+		if err != nil {
+			_ = s.discardVersion(ctx, dbFile.ID, version.Version)
+			return nil, err
+		}
+		defer enc.Close()
+		body, objectSize = enc, utils.EncryptedSize(size)
 	}
 
-	data, err := io.ReadAll(file)
+	err = s.Storage.Put(ctx, version.StorageKey, body, objectSize, "application/octet-stream")
 	if err != nil {
-		_ = s.FileRepo.DeleteFile(ctx, dbFile.ID)
-		return err
+		_ = s.discardVersion(ctx, dbFile.ID, version.Version)
+		return nil, err
 	}
 
-	cipherData, nonce, err := utils.Encrypt(data, s.FileKey)
+	if err := s.FileRepo.PromoteVersion(ctx, dbFile, version); errors.Is(err, repositories.ErrNotFound) {
+		return nil, ErrFileBusy
+	} else if err != nil {
+		return nil, err
+	}
+	return dbFile, nil
+}
+
+func (s *FileService) GetDownloadURL(ctx context.Context, file *models.File) (string, error) {
+	return s.Storage.PresignedGet(ctx, file.StorageKey, 15*time.Minute)
+}
+
+// encryptStream returns a reader producing the encrypted form of src under
+// the data key, bound to binding. The caller must Close it to stop the
+// encrypting goroutine early.
+func (s *FileService) encryptStream(src io.Reader, key, binding []byte) (io.ReadCloser, error) {
+	pr, pw := io.Pipe()
+	enc, err := utils.NewEncryptWriter(pw, key, binding)
 	if err != nil {
-		_ = s.FileRepo.DeleteFile(ctx, dbFile.ID)
-		return err
+		return nil, err
 	}
-	finalData := append(nonce, cipherData...)
 
-	_, err = s.Minio.PutObject(ctx, s.Bucket, storageKey, bytes.NewReader(finalData), int64(len(finalData)), minio.PutObjectOptions{})
+	go func() {
+		_, err := io.Copy(enc, src)
+		if err == nil {
+			err = enc.Close()
+		}
+		pw.CloseWithError(err)
+	}()
+
+	return pr, nil
+}
+
+// fileBinding is what the object of file's current version is bound to.
+func fileBinding(file *models.File) []byte {
+	return utils.ObjectAAD(file.ID, file.UserID, int64(file.CurrentVersion))
+}
+
+// DownloadableFile returns the caller's file fileID to download. Files in the
+// trash or being deleted are not found.
+func (s *FileService) DownloadableFile(ctx context.Context, userID, fileID string) (*models.File, error) {
+	file, err := s.ownedFile(ctx, userID, fileID)
 	if err != nil {
-		_ = s.FileRepo.DeleteFile(ctx, dbFile.ID)
-		return err
+		return nil, err
 	}
+	if file.Status == "trashed" || file.Status == "deleting" {
+		return nil, ErrFileNotFound
+	}
+	return file, nil
+}
 
-	return s.FileRepo.MarkFileUploaded(ctx, dbFile.ID)
+// DownloadDecrypt streams the plaintext of file's current version, checking
+// the object is bound to it unless unbound objects are allowed.
+func (s *FileService) DownloadDecrypt(ctx context.Context, file *models.File) (io.ReadCloser, error) {
+	return s.openDecrypted(ctx, file, s.AllowUnbound)
 }
 
+func (s *FileService) openDecrypted(ctx context.Context, file *models.File, allowUnbound bool) (io.ReadCloser, error) {
+	key, err := s.dataKey(ctx, file.UserID, file.DataKey)
+	if err != nil {
+		return nil, err
+	}
+	obj, err := s.Storage.Get(ctx, file.StorageKey, storage.GetOptions{})
+	if err != nil {
+		return nil, err
+	}
 
-func (s *FileService) GetDownloadURL(ctx context.Context, file *models.File) (string, error) {
-	url, err := s.Minio.PresignedGetObject(ctx, s.Bucket, file.StorageKey, 15*time.Minute, nil)
+	plain, err := utils.NewDecryptReader(obj, key, fileBinding(file), allowUnbound)
 	if err != nil {
-		return "", err
+		obj.Close()
+		return nil, err
 	}
-	return url.String(), nil
+
+	return readCloser{Reader: plain, Closer: obj}, nil
 }
 
-func (s *FileService) DownloadDecrypt(ctx context.Context, file *models.File) ([]byte, error) {
-	obj, err := s.Minio.GetObject(ctx, s.Bucket, file.StorageKey, minio.GetObjectOptions{})
+// DecryptedObject describes the plaintext view of an encrypted object.
+type DecryptedObject struct {
+	Size         int64
+	ETag         string
+	LastModified time.Time
+
+	cipherSize int64
+	header     *utils.StreamHeader
+}
+
+func (s *FileService) StatDecrypted(ctx context.Context, file *models.File) (*DecryptedObject, error) {
+	info, err := s.Storage.Stat(ctx, file.StorageKey)
+	if err != nil {
+		return nil, err
+	}
+
+	obj, err := s.Storage.Get(ctx, file.StorageKey, storage.GetOptions{Length: utils.StreamHeaderSize})
 	if err != nil {
 		return nil, err
 	}
 	defer obj.Close()
 
-	data, err := io.ReadAll(obj)
+	head, err := io.ReadAll(obj)
+	if err != nil {
+		return nil, err
+	}
+
+	res := &DecryptedObject{
+		ETag:         info.ETag,
+		LastModified: info.LastModified,
+		cipherSize:   info.Size,
+	}
+	if utils.IsStreamHeader(head) {
+		res.header, err = utils.ParseStreamHeader(head)
+		if err != nil {
+			return nil, err
+		}
+		res.Size = res.header.PlainSize(info.Size)
+	} else {
+		// legacy layout: 12 byte nonce, ciphertext, 16 byte tag
+		res.Size = info.Size - 12 - 16
+	}
+	return res, nil
+}
+
+// DownloadDecryptRange streams length plaintext bytes starting at offset,
+// fetching only the chunks that cover the range. Legacy objects are decrypted
+// whole and sliced.
+func (s *FileService) DownloadDecryptRange(ctx context.Context, file *models.File, obj *DecryptedObject, offset, length int64) (io.ReadCloser, error) {
+	if obj.header == nil {
+		body, err := s.DownloadDecrypt(ctx, file)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := io.CopyN(io.Discard, body, offset); err != nil {
+			body.Close()
+			return nil, err
+		}
+		return readCloser{Reader: io.LimitReader(body, length), Closer: body}, nil
+	}
+
+	if !obj.header.Bound() && !s.AllowUnbound {
+		return nil, utils.ErrUnboundObject
+	}
+	key, err := s.dataKey(ctx, file.UserID, file.DataKey)
+	if err != nil {
+		return nil, err
+	}
+	start, end, first, skip := obj.header.ChunkRange(offset, length, obj.cipherSize)
+	raw, err := s.Storage.Get(ctx, file.StorageKey, storage.GetOptions{Offset: start, Length: end - start + 1})
+	if err != nil {
+		return nil, err
+	}
+
+	plain, err := utils.NewChunkDecryptReader(raw, key, obj.header, fileBinding(file), first, obj.cipherSize)
 	if err != nil {
+		raw.Close()
+		return nil, err
+	}
+	if _, err := io.CopyN(io.Discard, plain, skip); err != nil {
+		raw.Close()
 		return nil, err
 	}
 
-	nonceSize := 12
-	nonce, cipherData := data[:nonceSize], data[nonceSize:]
-	return utils.Decrypt(cipherData, nonce, s.FileKey)
+	return readCloser{Reader: io.LimitReader(plain, length), Closer: raw}, nil
 }
 
-func (s *FileService) DeleteFile(ctx context.Context, fileID string) error {
-	// just mark for deletion
-	return s.FileRepo.UpdateFileStatus(ctx, fileID, "deleting")
+type readCloser struct {
+	io.Reader
+	io.Closer
 }
 
+// DeleteFile moves an uploaded file to the trash. Files that never finished
+// uploading, or are already in the trash, are marked for deletion.
+func (s *FileService) DeleteFile(ctx context.Context, userID, fileID string) (*models.File, error) {
+	file, err := s.ownedFile(ctx, userID, fileID)
+	if err != nil {
+		return nil, err
+	}
+	if file.Status == "deleting" {
+		return file, nil
+	}
+	if err := s.FileRepo.TrashFile(ctx, file); err != nil {
+		return nil, err
+	}
+	return file, nil
+}
+
+// CleanupDeletedFiles purges files marked for deletion, after first handing
+// over those that have outlived the trash retention period.
 func (s *FileService) CleanupDeletedFiles(ctx context.Context) error {
+	expired, err := s.FileRepo.ExpireTrash(ctx, time.Now().Add(-s.Retention.TrashFor))
+	if err != nil {
+		return err
+	}
+	if expired > 0 {
+		utils.Info.Info().Int64("files", expired).Msg("trash retention expired")
+	}
+
 	rows, err := s.FileRepo.ListFilesByStatus(ctx, []string{"deleting"})
 	if err != nil {
 		return err
 	}
 
 	for _, f := range rows {
-		err := s.Minio.RemoveObject(ctx, s.Bucket, f.StorageKey, minio.RemoveObjectOptions{})
+		versions, err := s.FileRepo.ListVersions(ctx, f.ID)
 		if err != nil {
 			continue
 		}
 
+		removed := true
+		for _, v := range versions {
+			if err := s.Storage.Remove(ctx, v.StorageKey); err != nil {
+				removed = false
+			}
+		}
+		if !removed {
+			continue
+		}
+
 		_ = s.FileRepo.DeleteFile(ctx, f.ID)
 	}
 
 	return nil
 }
 
+// ReconcilePendingFiles settles presigned uploads that were never finalized.
+// Objects that arrived with the declared size are promoted, the rest are
+// discarded. Only the size is checked: the checksums FinalizeUpload verifies
+// are given by the client when it finalizes and never stored, so files
+// promoted here have none recorded.
 func (s *FileService) ReconcilePendingFiles(ctx context.Context) error {
 	rows, err := s.FileRepo.ListFilesByStatus(ctx, []string{"pending"})
 	if err != nil {
@@ -152,15 +460,35 @@ func (s *FileService) ReconcilePendingFiles(ctx context.Context) error {
 	}
 
 	for _, f := range rows {
-		_, err := s.Minio.StatObject(ctx, s.Bucket, f.StorageKey, minio.StatObjectOptions{})
+		info, err := s.Storage.Stat(ctx, f.StorageKey)
+		if errors.Is(err, storage.ErrNotFound) {
+			utils.Warn.Debug().Str("file_id", f.ID).Msg("pending file missing in storage, deleting DB row")
+			_ = s.FileRepo.DeleteFile(ctx, f.ID)
+			continue
+		}
 		if err != nil {
-			utils.Warn.Debug().Str("file_id", f.ID).Msg("pending file missing in MinIO, deleting DB row")
+			utils.Error.Err(err).Str("file_id", f.ID).Msg("failed to stat pending file")
+			continue
+		}
+
+		if info.Size != f.Size {
+			utils.Warn.Warn().Str("file_id", f.ID).Int64("declared", f.Size).Int64("stored", info.Size).
+				Msg("pending file size mismatch, discarding upload")
+			_ = s.Storage.Remove(ctx, f.StorageKey)
 			_ = s.FileRepo.DeleteFile(ctx, f.ID)
 			continue
 		}
 
-		_ = s.FileRepo.MarkFileUploaded(ctx, f.ID)
+		f.ContentType = info.ContentType
+		err = s.FileRepo.MarkFileVerified(ctx, &f)
+		if errors.Is(err, repositories.ErrNotFound) {
+			// finalized or deleted since it was listed
+			continue
+		}
+		if err != nil {
+			utils.Error.Err(err).Str("file_id", f.ID).Msg("failed to mark pending file uploaded")
+		}
 	}
 
-	return nil
+	return s.abortStaleUploads(ctx)
 }
@@ -0,0 +1,164 @@
+package services
+
+import (
+	"context"
+	"errors"
+
+	"github.com/SrabanMondal/SecureStore/internal/models"
+	"github.com/SrabanMondal/SecureStore/internal/repository"
+	"github.com/SrabanMondal/SecureStore/internal/utils"
+)
+
+const (
+	defaultListLimit = 100
+	maxListLimit     = 1000
+)
+
+var (
+	ErrFolderNotFound = errors.New("folder not found")
+	ErrPathConflict   = errors.New("a file or folder already exists at that path")
+	ErrInvalidMove    = errors.New("folder cannot be moved into itself")
+)
+
+type FolderService struct {
+	FolderRepo *repositories.FolderRepository
+}
+
+func NewFolderService(repo *repositories.FolderRepository) *FolderService {
+	return &FolderService{FolderRepo: repo}
+}
+
+// FolderListing is one page of a folder's contents. Subfolders are always
+// listed in full; files are paged with limit/offset.
+type FolderListing struct {
+	Folder      *models.Folder      `json:"folder"`
+	Breadcrumbs []models.Breadcrumb `json:"breadcrumbs"`
+	Folders     []models.Folder     `json:"folders"`
+	Files       []models.File       `json:"files"`
+	NextOffset  *int                `json:"next_offset,omitempty"`
+}
+
+func folderError(err error) error {
+	switch {
+	case errors.Is(err, repositories.ErrNotFound):
+		return ErrFolderNotFound
+	case errors.Is(err, repositories.ErrPathConflict):
+		return ErrPathConflict
+	case errors.Is(err, repositories.ErrInvalidMove):
+		return ErrInvalidMove
+	}
+	return err
+}
+
+func (s *FolderService) getFolder(ctx context.Context, userID, id string) (*models.Folder, error) {
+	folder, err := s.FolderRepo.GetFolder(ctx, id)
+	if err != nil || !canAccessFolder(userID, folder) {
+		return nil, ErrFolderNotFound
+	}
+	return folder, nil
+}
+
+func (s *FolderService) CreateFolder(ctx context.Context, userID string, parentID *string, name string) (*models.Folder, error) {
+	if err := utils.ValidateName(name); err != nil {
+		return nil, err
+	}
+
+	if parentID != nil {
+		if _, err := s.getFolder(ctx, userID, *parentID); err != nil {
+			return nil, err
+		}
+	}
+
+	folder := &models.Folder{UserID: userID, ParentID: parentID, Name: name}
+	if err := s.FolderRepo.CreateFolder(ctx, folder); err != nil {
+		return nil, folderError(err)
+	}
+	return folder, nil
+}
+
+// Children lists a folder, or the root when id is empty.
+func (s *FolderService) Children(ctx context.Context, userID, id string, limit, offset int) (*FolderListing, error) {
+	if limit <= 0 {
+		limit = defaultListLimit
+	}
+	if limit > maxListLimit {
+		limit = maxListLimit
+	}
+	if offset < 0 {
+		offset = 0
+	}
+
+	listing := &FolderListing{}
+	var folderID *string
+	if id != "" {
+		folder, err := s.getFolder(ctx, userID, id)
+		if err != nil {
+			return nil, err
+		}
+		crumbs, err := s.FolderRepo.Breadcrumbs(ctx, folder.ID)
+		if err != nil {
+			return nil, err
+		}
+		listing.Folder, listing.Breadcrumbs = folder, crumbs
+		folderID = &folder.ID
+	}
+
+	folders, err := s.FolderRepo.ListChildFolders(ctx, userID, folderID)
+	if err != nil {
+		return nil, err
+	}
+	// fetch one extra file to learn whether another page exists
+	files, err := s.FolderRepo.ListChildFiles(ctx, userID, folderID, limit+1, offset)
+	if err != nil {
+		return nil, err
+	}
+	if len(files) > limit {
+		files = files[:limit]
+		next := offset + limit
+		listing.NextOffset = &next
+	}
+
+	listing.Folders, listing.Files = folders, files
+	return listing, nil
+}
+
+func (s *FolderService) RenameFolder(ctx context.Context, userID, id, name string) (*models.Folder, error) {
+	if err := utils.ValidateName(name); err != nil {
+		return nil, err
+	}
+	folder, err := s.getFolder(ctx, userID, id)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.FolderRepo.MoveFolder(ctx, folder, folder.ParentID, name); err != nil {
+		return nil, folderError(err)
+	}
+	return folder, nil
+}
+
+// MoveFolder re-parents a folder; a nil parentID moves it to the root.
+func (s *FolderService) MoveFolder(ctx context.Context, userID, id string, parentID *string) (*models.Folder, error) {
+	folder, err := s.getFolder(ctx, userID, id)
+	if err != nil {
+		return nil, err
+	}
+	if parentID != nil {
+		if _, err := s.getFolder(ctx, userID, *parentID); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := s.FolderRepo.MoveFolder(ctx, folder, parentID, folder.Name); err != nil {
+		return nil, folderError(err)
+	}
+	return folder, nil
+}
+
+func (s *FolderService) DeleteFolder(ctx context.Context, userID, id string) error {
+	folder, err := s.getFolder(ctx, userID, id)
+	if err != nil {
+		return err
+	}
+	return folderError(s.FolderRepo.DeleteFolder(ctx, folder))
+}
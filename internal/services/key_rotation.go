@@ -0,0 +1,381 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/SrabanMondal/SecureStore/internal/models"
+	"github.com/SrabanMondal/SecureStore/internal/repository"
+	"github.com/SrabanMondal/SecureStore/internal/utils"
+)
+
+// versions handled per batch; progress is saved after each one
+const rotationBatchSize = 100
+
+var (
+	ErrRotationNotFound = errors.New("key rotation not found")
+	ErrRotationRunning  = errors.New("a rotation away from that key is already running")
+	ErrRotationDone     = errors.New("key rotation already completed")
+	ErrSameKey          = errors.New("source and target key are the same")
+)
+
+// KeyRotationService moves encrypted versions from one key encryption key to
+// another. Data keys are re-wrapped in place; legacy objects, which have no
+// data key, are re-encrypted into new objects. Jobs live in Postgres and pick
+// up from their cursor after a restart.
+type KeyRotationService struct {
+	Repo    *repositories.KeyRotationRepository
+	FileSvc *FileService
+	MFA     *MFAService
+	wake    chan struct{}
+}
+
+func NewKeyRotationService(repo *repositories.KeyRotationRepository, fileSvc *FileService, mfa *MFAService) *KeyRotationService {
+	return &KeyRotationService{
+		Repo:    repo,
+		FileSvc: fileSvc,
+		MFA:     mfa,
+		wake:    make(chan struct{}, 1),
+	}
+}
+
+// Wake fires when a job is started or resumed, so the runner need not wait
+// for its next tick.
+func (s *KeyRotationService) Wake() <-chan struct{} {
+	return s.wake
+}
+
+func (s *KeyRotationService) notify() {
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+// KeyStatus is one key encryption key and how many versions, user keys and
+// MFA secrets it still wraps. Versions whose data key is wrapped by its
+// owner's key are listed under UserKeyID.
+type KeyStatus struct {
+	ID         string `json:"id"`
+	Active     bool   `json:"active"`
+	Versions   int64  `json:"versions"`
+	UserKeys   int64  `json:"user_keys"`
+	MFASecrets int64  `json:"mfa_secrets"`
+}
+
+// Keys lists the keyring, plus an entry with an empty ID for legacy objects
+// when any remain.
+func (s *KeyRotationService) Keys(ctx context.Context) ([]KeyStatus, error) {
+	counts, err := s.FileSvc.FileRepo.CountVersionsPerKey(ctx)
+	if err != nil {
+		return nil, err
+	}
+	userKeys, err := s.FileSvc.UserKeys.UserRepo.CountUserKeysPerKey(ctx)
+	if err != nil {
+		return nil, err
+	}
+	mfa, err := s.MFA.UserRepo.CountMFAPerKey(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	active := s.FileSvc.KMS.ActiveKeyID()
+	var keys []KeyStatus
+	for _, id := range s.FileSvc.KMS.KeyIDs() {
+		keys = append(keys, KeyStatus{ID: id, Active: id == active, Versions: counts[id], UserKeys: userKeys[id], MFASecrets: mfa[id]})
+		delete(counts, id)
+		delete(userKeys, id)
+		delete(mfa, id)
+	}
+	// versions recorded under a fingerprint or a key no longer configured
+	for id, n := range counts {
+		keys = append(keys, KeyStatus{ID: id, Versions: n, UserKeys: userKeys[id], MFASecrets: mfa[id]})
+		delete(userKeys, id)
+		delete(mfa, id)
+	}
+	for id, n := range userKeys {
+		keys = append(keys, KeyStatus{ID: id, UserKeys: n, MFASecrets: mfa[id]})
+		delete(mfa, id)
+	}
+	for id, n := range mfa {
+		keys = append(keys, KeyStatus{ID: id, MFASecrets: n})
+	}
+	return keys, nil
+}
+
+// Start creates a job moving everything wrapped by fromKeyID ("" for legacy
+// objects), user keys and MFA secrets included, to toKeyID, or to the active key when
+// toKeyID is empty.
+func (s *KeyRotationService) Start(ctx context.Context, fromKeyID, toKeyID string) (*models.KeyRotation, error) {
+	if toKeyID == "" {
+		toKeyID = s.FileSvc.KMS.ActiveKeyID()
+	}
+	if fromKeyID == toKeyID {
+		return nil, ErrSameKey
+	}
+	if !s.FileSvc.KMS.HasKey(toKeyID) {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownKey, toKeyID)
+	}
+	if fromKeyID != "" && !s.FileSvc.KMS.HasKey(fromKeyID) {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownKey, fromKeyID)
+	}
+
+	running, err := s.Repo.ListJobs(ctx, "running")
+	if err != nil {
+		return nil, err
+	}
+	for _, j := range running {
+		if j.FromKeyID == fromKeyID {
+			return nil, ErrRotationRunning
+		}
+	}
+
+	total, err := s.FileSvc.FileRepo.CountVersionsByKey(ctx, fromKeyID, nil)
+	if err != nil {
+		return nil, err
+	}
+	userKeys, err := s.FileSvc.UserKeys.UserRepo.CountUserKeysByKey(ctx, fromKeyID)
+	if err != nil {
+		return nil, err
+	}
+	mfa, err := s.MFA.UserRepo.CountMFAByKey(ctx, fromKeyID)
+	if err != nil {
+		return nil, err
+	}
+	total += userKeys + mfa
+	job := &models.KeyRotation{FromKeyID: fromKeyID, ToKeyID: toKeyID, Total: total}
+	if err := s.Repo.CreateJob(ctx, job); err != nil {
+		return nil, err
+	}
+
+	utils.Info.Info().Str("id", job.ID).Str("from_key_id", fromKeyID).Str("to_key_id", toKeyID).
+		Int64("total", total).Msg("key rotation started")
+	s.notify()
+	return job, nil
+}
+
+func (s *KeyRotationService) Get(ctx context.Context, id string) (*models.KeyRotation, error) {
+	job, err := s.Repo.GetJob(ctx, id)
+	if errors.Is(err, repositories.ErrNotFound) {
+		return nil, ErrRotationNotFound
+	}
+	return job, err
+}
+
+func (s *KeyRotationService) List(ctx context.Context) ([]models.KeyRotation, error) {
+	return s.Repo.ListJobs(ctx, "")
+}
+
+// Resume puts a failed job back to running from where it stopped.
+func (s *KeyRotationService) Resume(ctx context.Context, id string) (*models.KeyRotation, error) {
+	job, err := s.Get(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	switch job.Status {
+	case "completed":
+		return nil, ErrRotationDone
+	case "running":
+		return job, nil
+	}
+
+	job.Status, job.Error = "running", ""
+	if err := s.Repo.SaveProgress(ctx, job); err != nil {
+		return nil, err
+	}
+	s.notify()
+	return job, nil
+}
+
+// Run works through every running job. It is called periodically and on
+// Wake by the background runner.
+func (s *KeyRotationService) Run(ctx context.Context) error {
+	jobs, err := s.Repo.ListJobs(ctx, "running")
+	if err != nil {
+		return err
+	}
+	for i := range jobs {
+		if err := s.runJob(ctx, &jobs[i]); err != nil {
+			if ctx.Err() != nil {
+				// left running, to resume from its cursor
+				return nil
+			}
+			jobs[i].Status, jobs[i].Error = "failed", err.Error()
+			_ = s.Repo.SaveProgress(ctx, &jobs[i])
+			utils.Error.Err(err).Str("id", jobs[i].ID).Msg("key rotation failed")
+		}
+	}
+	return nil
+}
+
+func (s *KeyRotationService) runJob(ctx context.Context, job *models.KeyRotation) error {
+	if err := s.recount(ctx, job); err != nil {
+		return err
+	}
+
+	// user keys and MFA secrets go first; once versions have started the
+	// cursor is set
+	if job.Cursor == nil {
+		if err := s.rotateUserKeys(ctx, job); err != nil {
+			return err
+		}
+		if err := s.rotateMFASecrets(ctx, job); err != nil {
+			return err
+		}
+	}
+
+	for {
+		versions, err := s.FileSvc.FileRepo.ListVersionsByKey(ctx, job.FromKeyID, job.Cursor, rotationBatchSize)
+		if err != nil {
+			return err
+		}
+
+		for i := range versions {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			v := &versions[i]
+			if err := s.rotateVersion(ctx, job, v); err != nil {
+				job.Failed++
+				utils.Error.Err(err).Str("id", job.ID).Str("file_id", v.FileID).Int("version", v.Version).
+					Msg("failed to rotate file version")
+			}
+			job.Processed++
+			job.Cursor = &v.ID
+		}
+
+		if len(versions) < rotationBatchSize {
+			job.Status = "completed"
+		}
+		if err := s.Repo.SaveProgress(ctx, job); err != nil {
+			return err
+		}
+		if job.Status == "completed" {
+			utils.Info.Info().Str("id", job.ID).Int64("processed", job.Processed).Int64("failed", job.Failed).
+				Msg("key rotation completed")
+			return nil
+		}
+	}
+}
+
+// recount sets the job's progress from what is left to do, since a resumed
+// job redoes some items: until versions have started, every user key and MFA
+// secret still wrapped by the source key, failed ones included, and after
+// that the versions past the cursor.
+func (s *KeyRotationService) recount(ctx context.Context, job *models.KeyRotation) error {
+	left, err := s.FileSvc.FileRepo.CountVersionsByKey(ctx, job.FromKeyID, job.Cursor)
+	if err != nil {
+		return err
+	}
+	if job.Cursor == nil {
+		userKeys, err := s.FileSvc.UserKeys.UserRepo.CountUserKeysByKey(ctx, job.FromKeyID)
+		if err != nil {
+			return err
+		}
+		mfa, err := s.MFA.UserRepo.CountMFAByKey(ctx, job.FromKeyID)
+		if err != nil {
+			return err
+		}
+		left += userKeys + mfa
+		job.Failed = 0
+	}
+	// items deleted since the job started count as done
+	job.Processed = max(job.Total-left, 0)
+	return nil
+}
+
+// rotateUserKeys rewraps the user keys still wrapped by the job's source key.
+// Rewrapped keys drop out of the listing, so a rerun only sees the rest.
+func (s *KeyRotationService) rotateUserKeys(ctx context.Context, job *models.KeyRotation) error {
+	var after *string
+	for {
+		keys, err := s.FileSvc.UserKeys.UserRepo.ListUserKeysByKey(ctx, job.FromKeyID, after, rotationBatchSize)
+		if err != nil {
+			return err
+		}
+
+		for i := range keys {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			k := &keys[i]
+			if err := s.FileSvc.UserKeys.rewrap(ctx, k, job.ToKeyID); err != nil {
+				job.Failed++
+				utils.Error.Err(err).Str("id", job.ID).Str("user_id", k.UserID).Msg("failed to rotate user key")
+			}
+			job.Processed++
+			after = &k.UserID
+		}
+
+		if err := s.Repo.SaveProgress(ctx, job); err != nil {
+			return err
+		}
+		if len(keys) < rotationBatchSize {
+			return nil
+		}
+	}
+}
+
+// rotateMFASecrets rewraps the TOTP secrets still wrapped by the job's source
+// key, the same way as rotateUserKeys.
+func (s *KeyRotationService) rotateMFASecrets(ctx context.Context, job *models.KeyRotation) error {
+	var after *string
+	for {
+		list, err := s.MFA.UserRepo.ListMFAByKey(ctx, job.FromKeyID, after, rotationBatchSize)
+		if err != nil {
+			return err
+		}
+
+		for i := range list {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			m := &list[i]
+			if err := s.MFA.rewrap(ctx, m, job.ToKeyID); err != nil {
+				job.Failed++
+				utils.Error.Err(err).Str("id", job.ID).Str("user_id", m.UserID).Msg("failed to rotate mfa secret")
+			}
+			job.Processed++
+			after = &m.UserID
+		}
+
+		if err := s.Repo.SaveProgress(ctx, job); err != nil {
+			return err
+		}
+		if len(list) < rotationBatchSize {
+			return nil
+		}
+	}
+}
+
+func (s *KeyRotationService) rotateVersion(ctx context.Context, job *models.KeyRotation, v *models.FileVersion) error {
+	if v.KeyID == "" {
+		return s.reencryptVersion(ctx, job, v)
+	}
+
+	key, err := s.FileSvc.dataKey(ctx, "", v.DataKey)
+	if err != nil {
+		return err
+	}
+	dk, err := s.FileSvc.wrapDataKey(ctx, key, job.ToKeyID)
+	if err != nil {
+		return err
+	}
+	_, err = s.FileSvc.FileRepo.RewrapVersion(ctx, v, job.FromKeyID, dk)
+	return err
+}
+
+// reencryptVersion copies a legacy object into a new one sealed under a fresh
+// data key, then switches the version over and removes the old object.
+func (s *KeyRotationService) reencryptVersion(ctx context.Context, job *models.KeyRotation, v *models.FileVersion) error {
+	file, err := s.FileSvc.FileRepo.GetFileByID(ctx, v.FileID)
+	if err != nil {
+		return err
+	}
+	key, dk, err := s.FileSvc.generateDataKey(ctx, job.ToKeyID)
+	if err != nil {
+		return err
+	}
+	return s.FileSvc.rewriteVersion(ctx, file.UserID, v, key, dk)
+}
@@ -0,0 +1,54 @@
+package services
+
+import (
+	"testing"
+
+	"github.com/SrabanMondal/SecureStore/internal/repository"
+)
+
+func TestKeyRotationResumeCounts(t *testing.T) {
+	e := newTestEnv(t)
+	alice := e.register(t, "alice")
+	bob := e.register(t, "bob")
+	e.upload(t, alice.ID, "/a.txt", []byte("alice"))
+	e.upload(t, bob.ID, "/b.txt", []byte("bob"))
+
+	if err := e.ring.Add("next", randomBytes(t, 32)); err != nil {
+		t.Fatal(err)
+	}
+	rotations := NewKeyRotationService(repositories.NewKeyRotationRepository(e.db), e.files, e.mfa)
+	job, err := rotations.Start(e.ctx, "test", "next")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// as if it had failed on every user key before stopping: the user keys
+	// are redone on resume and must not be counted again
+	job.Status, job.Processed, job.Failed, job.Error = "failed", 2, 2, "stopped"
+	if err := rotations.Repo.SaveProgress(e.ctx, job); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := rotations.Resume(e.ctx, job.ID); err != nil {
+		t.Fatal(err)
+	}
+	if err := rotations.Run(e.ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	job, err = rotations.Get(e.ctx, job.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if job.Status != "completed" || job.Processed != job.Total || job.Failed != 0 {
+		t.Errorf("job = %s, %d of %d processed, %d failed", job.Status, job.Processed, job.Total, job.Failed)
+	}
+	keys, err := rotations.Keys(e.ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, k := range keys {
+		if k.ID == "test" && (k.Versions != 0 || k.UserKeys != 0) {
+			t.Errorf("old key still wraps %d versions and %d user keys", k.Versions, k.UserKeys)
+		}
+	}
+}
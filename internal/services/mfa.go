@@ -0,0 +1,322 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base32"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/SrabanMondal/SecureStore/internal/kms"
+	"github.com/SrabanMondal/SecureStore/internal/models"
+	"github.com/SrabanMondal/SecureStore/internal/repository"
+	"github.com/SrabanMondal/SecureStore/internal/utils"
+)
+
+const (
+	// how long the second login step may take, and how many codes it may try
+	mfaChallengeTTL      = 5 * time.Minute
+	mfaChallengeAttempts = 5
+
+	recoveryCodeCount = 10
+	recoveryCodeSize  = 10
+)
+
+var (
+	ErrMFAEnabled          = errors.New("two-factor authentication is already enabled")
+	ErrMFANotEnabled       = errors.New("two-factor authentication is not enabled")
+	ErrMFANotEnrolled      = errors.New("no two-factor enrollment is pending, start one first")
+	ErrInvalidMFACode      = errors.New("invalid authentication code")
+	ErrInvalidMFAChallenge = errors.New("invalid or expired mfa token")
+)
+
+var recoveryEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// MFAService manages TOTP two-factor authentication. Secrets are wrapped by
+// the master key, like user keys, since the server has to read them at every
+// login. Recovery codes are single use and stored hashed.
+type MFAService struct {
+	UserRepo *repositories.UserRepository
+	KMS      kms.Provider
+	Issuer   string
+}
+
+func NewMFAService(userRepo *repositories.UserRepository, keyProvider kms.Provider, issuer string) *MFAService {
+	return &MFAService{UserRepo: userRepo, KMS: keyProvider, Issuer: issuer}
+}
+
+// TOTPEnrollment is what an authenticator app needs to enroll.
+type TOTPEnrollment struct {
+	Secret string `json:"secret"`
+	URI    string `json:"otpauth_uri"`
+}
+
+type MFAStatus struct {
+	Enabled           bool       `json:"enabled"`
+	EnabledAt         *time.Time `json:"enabled_at,omitempty"`
+	RecoveryCodesLeft int        `json:"recovery_codes_left"`
+}
+
+func (s *MFAService) Status(ctx context.Context, userID string) (*MFAStatus, error) {
+	m, err := s.UserRepo.GetMFA(ctx, userID)
+	if errors.Is(err, repositories.ErrNotFound) || (err == nil && m.EnabledAt == nil) {
+		return &MFAStatus{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	left, err := s.UserRepo.CountRecoveryCodes(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	return &MFAStatus{Enabled: true, EnabledAt: m.EnabledAt, RecoveryCodesLeft: left}, nil
+}
+
+// Enabled reports whether logging in as the user takes a second step.
+func (s *MFAService) Enabled(ctx context.Context, userID string) (bool, error) {
+	m, err := s.UserRepo.GetMFA(ctx, userID)
+	if errors.Is(err, repositories.ErrNotFound) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return m.EnabledAt != nil, nil
+}
+
+// Enroll starts TOTP enrollment with a new secret, replacing one not yet
+// confirmed. MFA stays off until Confirm.
+func (s *MFAService) Enroll(ctx context.Context, userID string) (*TOTPEnrollment, error) {
+	user, err := s.UserRepo.GetUserByID(ctx, userID)
+	if err != nil {
+		return nil, ErrUserNotFound
+	}
+	secret, err := utils.NewTOTPSecret()
+	if err != nil {
+		return nil, err
+	}
+	keyID := s.KMS.ActiveKeyID()
+	wrapped, err := s.KMS.Encrypt(ctx, keyID, secret)
+	if err != nil {
+		return nil, err
+	}
+
+	m := &models.MFA{UserID: userID, DataKey: models.DataKey{WrappedKey: wrapped, KeyID: keyID, KeyAlgorithm: s.KMS.Algorithm()}}
+	saved, err := s.UserRepo.SaveMFAEnrollment(ctx, m)
+	if err != nil {
+		return nil, err
+	}
+	if !saved {
+		return nil, ErrMFAEnabled
+	}
+	return &TOTPEnrollment{Secret: utils.EncodeTOTPSecret(secret), URI: utils.TOTPURI(s.Issuer, user.Email, secret)}, nil
+}
+
+// Confirm enables MFA once code shows the authenticator was set up, and
+// returns a fresh set of recovery codes. They are not shown again.
+func (s *MFAService) Confirm(ctx context.Context, userID, code string) ([]string, error) {
+	m, err := s.UserRepo.GetMFA(ctx, userID)
+	if errors.Is(err, repositories.ErrNotFound) {
+		return nil, ErrMFANotEnrolled
+	}
+	if err != nil {
+		return nil, err
+	}
+	if m.EnabledAt != nil {
+		return nil, ErrMFAEnabled
+	}
+
+	secret, err := s.secret(ctx, m)
+	if err != nil {
+		return nil, err
+	}
+	step, ok := utils.VerifyTOTP(secret, code, time.Now())
+	if !ok {
+		return nil, ErrInvalidMFACode
+	}
+
+	codes := make([]string, recoveryCodeCount)
+	hashes := make([][]byte, recoveryCodeCount)
+	for i := range codes {
+		if codes[i], err = newRecoveryCode(); err != nil {
+			return nil, err
+		}
+		hashes[i] = hashRecoveryCode(codes[i])
+	}
+	enabled, err := s.UserRepo.EnableMFA(ctx, userID, step, hashes)
+	if err != nil {
+		return nil, err
+	}
+	if !enabled {
+		// enabled or re-enrolled concurrently
+		return nil, ErrInvalidMFACode
+	}
+	utils.Info.Info().Str("user_id", userID).Msg("mfa enabled")
+	return codes, nil
+}
+
+// Disable turns MFA off after checking code, a TOTP or recovery code.
+func (s *MFAService) Disable(ctx context.Context, userID, code string) error {
+	m, err := s.UserRepo.GetMFA(ctx, userID)
+	if errors.Is(err, repositories.ErrNotFound) || (err == nil && m.EnabledAt == nil) {
+		return ErrMFANotEnabled
+	}
+	if err != nil {
+		return err
+	}
+	if err := s.check(ctx, m, code); err != nil {
+		return err
+	}
+	if err := s.UserRepo.DeleteMFA(ctx, userID); err != nil {
+		return err
+	}
+	utils.Info.Info().Str("user_id", userID).Msg("mfa disabled")
+	return nil
+}
+
+// Challenge starts the second login step for userID and returns its token.
+func (s *MFAService) Challenge(ctx context.Context, userID string) (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	token := base64.RawURLEncoding.EncodeToString(b)
+	if err := s.UserRepo.CreateMFAChallenge(ctx, userID, hashMFAToken(token), time.Now().Add(mfaChallengeTTL)); err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+// Verify completes the challenge of token with code and returns its user.
+// Each challenge allows a few codes before it has to be started over.
+func (s *MFAService) Verify(ctx context.Context, token, code string) (string, error) {
+	hash := hashMFAToken(token)
+	userID, err := s.UserRepo.AttemptMFAChallenge(ctx, hash, mfaChallengeAttempts)
+	if errors.Is(err, repositories.ErrNotFound) {
+		return "", ErrInvalidMFAChallenge
+	}
+	if err != nil {
+		return "", err
+	}
+
+	m, err := s.UserRepo.GetMFA(ctx, userID)
+	if errors.Is(err, repositories.ErrNotFound) || (err == nil && m.EnabledAt == nil) {
+		// disabled since the password step
+		return "", ErrInvalidMFAChallenge
+	}
+	if err != nil {
+		return "", err
+	}
+	if err := s.check(ctx, m, code); err != nil {
+		return "", err
+	}
+	if err := s.UserRepo.DeleteMFAChallenge(ctx, hash); err != nil {
+		return "", err
+	}
+	return userID, nil
+}
+
+// check accepts a current TOTP code not used before, or an unused recovery
+// code, which is then spent.
+func (s *MFAService) check(ctx context.Context, m *models.MFA, code string) error {
+	code = strings.TrimSpace(code)
+	if isTOTPCode(code) {
+		secret, err := s.secret(ctx, m)
+		if err != nil {
+			return err
+		}
+		step, ok := utils.VerifyTOTP(secret, code, time.Now())
+		if !ok {
+			return ErrInvalidMFACode
+		}
+		fresh, err := s.UserRepo.UseTOTPStep(ctx, m.UserID, step)
+		if err != nil {
+			return err
+		}
+		if !fresh {
+			return ErrInvalidMFACode
+		}
+		return nil
+	}
+
+	used, err := s.UserRepo.UseRecoveryCode(ctx, m.UserID, hashRecoveryCode(code))
+	if err != nil {
+		return err
+	}
+	if !used {
+		return ErrInvalidMFACode
+	}
+	utils.Info.Info().Str("user_id", m.UserID).Msg("mfa recovery code used")
+	return nil
+}
+
+func (s *MFAService) secret(ctx context.Context, m *models.MFA) ([]byte, error) {
+	if !s.KMS.HasKey(m.KeyID) || m.KeyAlgorithm != s.KMS.Algorithm() {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownKey, m.KeyID)
+	}
+	return s.KMS.Decrypt(ctx, m.KeyID, m.WrappedKey)
+}
+
+// rewrap moves m's secret from the master key wrapping it to toKeyID.
+func (s *MFAService) rewrap(ctx context.Context, m *models.MFA, toKeyID string) error {
+	secret, err := s.secret(ctx, m)
+	if err != nil {
+		return err
+	}
+	wrapped, err := s.KMS.Encrypt(ctx, toKeyID, secret)
+	if err != nil {
+		return err
+	}
+	_, err = s.UserRepo.RewrapMFA(ctx, m, models.DataKey{WrappedKey: wrapped, KeyID: toKeyID, KeyAlgorithm: s.KMS.Algorithm()})
+	return err
+}
+
+func (s *MFAService) DeleteExpiredChallenges(ctx context.Context) error {
+	n, err := s.UserRepo.DeleteExpiredMFAChallenges(ctx)
+	if err != nil {
+		return err
+	}
+	if n > 0 {
+		utils.Info.Info().Int64("challenges", n).Msg("deleted expired mfa challenges")
+	}
+	return nil
+}
+
+func isTOTPCode(code string) bool {
+	code = strings.ReplaceAll(code, " ", "")
+	if len(code) != 6 {
+		return false
+	}
+	for _, c := range code {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// newRecoveryCode returns a random code formatted as xxxx-xxxx-xxxx-xxxx.
+func newRecoveryCode() (string, error) {
+	b := make([]byte, recoveryCodeSize)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	s := strings.ToLower(recoveryEncoding.EncodeToString(b))
+	return s[0:4] + "-" + s[4:8] + "-" + s[8:12] + "-" + s[12:16], nil
+}
+
+// hashRecoveryCode hashes code ignoring case, dashes and spaces.
+func hashRecoveryCode(code string) []byte {
+	code = strings.ToLower(strings.NewReplacer("-", "", " ", "").Replace(code))
+	sum := sha256.Sum256([]byte(code))
+	return sum[:]
+}
+
+func hashMFAToken(token string) []byte {
+	sum := sha256.Sum256([]byte(token))
+	return sum[:]
+}
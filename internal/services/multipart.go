@@ -0,0 +1,276 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/SrabanMondal/SecureStore/internal/models"
+	"github.com/SrabanMondal/SecureStore/internal/repository"
+	"github.com/SrabanMondal/SecureStore/internal/storage"
+	"github.com/SrabanMondal/SecureStore/internal/utils"
+)
+
+const (
+	minPartSize     = 5 << 20
+	defaultPartSize = 64 << 20
+	maxParts        = 10000
+	maxObjectSize   = 5 << 40
+
+	// multipart uploads with no activity for this long are aborted by
+	// ReconcilePendingFiles
+	multipartStaleAfter = 24 * time.Hour
+)
+
+var (
+	ErrMultipartUnsupported = errors.New("storage backend does not support multipart uploads")
+	ErrUploadNotFound       = errors.New("upload not found")
+	ErrInvalidPart          = errors.New("invalid part number")
+	ErrIncompleteUpload     = errors.New("upload is missing parts")
+	ErrInvalidSize          = errors.New("invalid upload size")
+)
+
+func (s *FileService) multipart() (storage.MultipartBackend, error) {
+	mp, ok := s.Storage.(storage.MultipartBackend)
+	if !ok {
+		return nil, ErrMultipartUnsupported
+	}
+	return mp, nil
+}
+
+// InitiateMultipart starts a multipart upload of size bytes to filePath. A
+// non-nil client marks the parts as ciphertext encrypted by the client.
+func (s *FileService) InitiateMultipart(ctx context.Context, userID, filePath string, size, partSize int64, client *models.ClientKey) (*models.MultipartUpload, error) {
+	mp, err := s.multipart()
+	if err != nil {
+		return nil, err
+	}
+	if size <= 0 || size > maxObjectSize {
+		return nil, fmt.Errorf("%w: must be between 1 byte and %d bytes", ErrInvalidSize, int64(maxObjectSize))
+	}
+	if partSize <= 0 {
+		partSize = defaultPartSize
+	}
+	if partSize < minPartSize {
+		partSize = minPartSize
+	}
+	// grow the part size until the upload fits in maxParts
+	for (size+partSize-1)/partSize > maxParts {
+		partSize *= 2
+	}
+
+	file, version, err := s.prepareUpload(ctx, userID, filePath, size, false, client, "uploading")
+	if err != nil {
+		return nil, err
+	}
+
+	uploadID, err := mp.NewMultipartUpload(ctx, version.StorageKey, "application/octet-stream")
+	if err != nil {
+		_ = s.discardVersion(ctx, file.ID, version.Version)
+		return nil, err
+	}
+
+	upload := &models.MultipartUpload{
+		FileID:     file.ID,
+		Version:    version.Version,
+		UserID:     userID,
+		UploadID:   uploadID,
+		StorageKey: version.StorageKey,
+		Size:       size,
+		PartSize:   partSize,
+	}
+	if err := s.UploadRepo.CreateUpload(ctx, upload); err != nil {
+		_ = mp.AbortMultipartUpload(ctx, version.StorageKey, uploadID)
+		_ = s.discardVersion(ctx, file.ID, version.Version)
+		return nil, err
+	}
+
+	return upload, nil
+}
+
+func (s *FileService) getUpload(ctx context.Context, userID, id string) (*models.MultipartUpload, error) {
+	upload, err := s.UploadRepo.GetUpload(ctx, id)
+	if err != nil || !canAccessMultipartUpload(userID, upload) {
+		return nil, ErrUploadNotFound
+	}
+	return upload, nil
+}
+
+// MultipartPartURLs presigns upload URLs for the given part numbers. An empty
+// list presigns every part of the upload.
+func (s *FileService) MultipartPartURLs(ctx context.Context, userID, id string, partNumbers []int) (map[int]string, error) {
+	mp, err := s.multipart()
+	if err != nil {
+		return nil, err
+	}
+	upload, err := s.getUpload(ctx, userID, id)
+	if err != nil {
+		return nil, err
+	}
+
+	count := upload.PartCount()
+	if len(partNumbers) == 0 {
+		for n := 1; n <= count; n++ {
+			partNumbers = append(partNumbers, n)
+		}
+	}
+
+	urls := make(map[int]string, len(partNumbers))
+	for _, n := range partNumbers {
+		if n < 1 || n > count {
+			return nil, ErrInvalidPart
+		}
+		url, err := mp.PresignedUploadPart(ctx, upload.StorageKey, upload.UploadID, n, time.Hour)
+		if err != nil {
+			return nil, err
+		}
+		urls[n] = url
+	}
+
+	_ = s.UploadRepo.TouchUpload(ctx, upload.ID)
+	return urls, nil
+}
+
+// MultipartStatus syncs the parts the object store has received into Postgres
+// and returns them, so a client can resume with only the missing parts.
+func (s *FileService) MultipartStatus(ctx context.Context, userID, id string) (*models.MultipartUpload, []models.MultipartPart, error) {
+	mp, err := s.multipart()
+	if err != nil {
+		return nil, nil, err
+	}
+	upload, err := s.getUpload(ctx, userID, id)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	stored, err := mp.ListParts(ctx, upload.StorageKey, upload.UploadID)
+	if errors.Is(err, storage.ErrUploadNotFound) {
+		return nil, nil, ErrUploadNotFound
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	parts := make([]models.MultipartPart, len(stored))
+	for i, p := range stored {
+		parts[i] = models.MultipartPart{PartNumber: p.Number, ETag: strings.Trim(p.ETag, `"`), Size: p.Size}
+	}
+	if err := s.UploadRepo.SaveParts(ctx, upload.ID, parts); err != nil {
+		return nil, nil, err
+	}
+
+	parts, err = s.UploadRepo.ListParts(ctx, upload.ID)
+	if err != nil {
+		return nil, nil, err
+	}
+	return upload, parts, nil
+}
+
+// CompleteMultipart stitches the parts together. When the client does not
+// send its part list, the parts known to the object store are used.
+func (s *FileService) CompleteMultipart(ctx context.Context, userID, id string, parts []storage.Part) (*models.MultipartUpload, error) {
+	mp, err := s.multipart()
+	if err != nil {
+		return nil, err
+	}
+	upload, stored, err := s.MultipartStatus(ctx, userID, id)
+	if err != nil {
+		return nil, err
+	}
+
+	known := make(map[int]models.MultipartPart, len(stored))
+	for _, p := range stored {
+		known[p.PartNumber] = p
+	}
+	if len(parts) == 0 {
+		for _, p := range stored {
+			parts = append(parts, storage.Part{Number: p.PartNumber, ETag: p.ETag})
+		}
+	}
+	sort.Slice(parts, func(i, j int) bool { return parts[i].Number < parts[j].Number })
+
+	if len(parts) != upload.PartCount() {
+		return nil, ErrIncompleteUpload
+	}
+	var total int64
+	for i, p := range parts {
+		k, ok := known[p.Number]
+		if p.Number != i+1 || !ok || k.ETag != strings.Trim(p.ETag, `"`) {
+			return nil, ErrIncompleteUpload
+		}
+		parts[i].ETag = k.ETag
+		total += k.Size
+	}
+	if total != upload.Size {
+		return nil, fmt.Errorf("%w: expected %d bytes, got %d", ErrIncompleteUpload, upload.Size, total)
+	}
+
+	file, err := s.FileRepo.GetFileByID(ctx, upload.FileID)
+	if err != nil {
+		return nil, err
+	}
+	version, err := s.FileRepo.GetVersion(ctx, upload.FileID, upload.Version)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := mp.CompleteMultipartUpload(ctx, upload.StorageKey, upload.UploadID, parts); err != nil {
+		return nil, err
+	}
+	if err := s.FileRepo.PromoteVersion(ctx, file, version); errors.Is(err, repositories.ErrNotFound) {
+		return nil, ErrFileBusy
+	} else if err != nil {
+		return nil, err
+	}
+	_ = s.UploadRepo.DeleteUpload(ctx, upload.ID)
+
+	return upload, nil
+}
+
+func (s *FileService) AbortMultipart(ctx context.Context, userID, id string) error {
+	mp, err := s.multipart()
+	if err != nil {
+		return err
+	}
+	upload, err := s.getUpload(ctx, userID, id)
+	if err != nil {
+		return err
+	}
+	return s.abortUpload(ctx, mp, upload)
+}
+
+func (s *FileService) abortUpload(ctx context.Context, mp storage.MultipartBackend, upload *models.MultipartUpload) error {
+	err := mp.AbortMultipartUpload(ctx, upload.StorageKey, upload.UploadID)
+	if err != nil && !errors.Is(err, storage.ErrUploadNotFound) {
+		return err
+	}
+	if err := s.discardVersion(ctx, upload.FileID, upload.Version); err != nil {
+		return err
+	}
+	// a new file's upload row went with the file; a new version's did not
+	return s.UploadRepo.DeleteUpload(ctx, upload.ID)
+}
+
+func (s *FileService) abortStaleUploads(ctx context.Context) error {
+	mp, err := s.multipart()
+	if err != nil {
+		return nil
+	}
+
+	uploads, err := s.UploadRepo.ListStaleUploads(ctx, time.Now().Add(-multipartStaleAfter))
+	if err != nil {
+		return err
+	}
+
+	for i := range uploads {
+		if err := s.abortUpload(ctx, mp, &uploads[i]); err != nil {
+			utils.Error.Err(err).Str("upload_id", uploads[i].ID).Msg("failed to abort stale multipart upload")
+			continue
+		}
+		utils.Info.Info().Str("upload_id", uploads[i].ID).Msg("aborted stale multipart upload")
+	}
+	return nil
+}
@@ -0,0 +1,91 @@
+package services
+
+import (
+	"context"
+
+	"github.com/SrabanMondal/SecureStore/internal/models"
+	"github.com/SrabanMondal/SecureStore/internal/storage"
+	"github.com/SrabanMondal/SecureStore/internal/utils"
+)
+
+// versions moved per page by RenameObjects
+const renameBatchSize = 100
+
+// RenameObjects copies objects stored under keys that carry their file's path,
+// as keys did before they became random, to fresh keys, so the bucket no
+// longer reveals names. The bytes are copied as they are; nothing is
+// decrypted.
+func (s *FileService) RenameObjects(ctx context.Context) error {
+	var after *string
+	var moved, failed int
+	for {
+		versions, err := s.FileRepo.ListNamedObjects(ctx, after, renameBatchSize)
+		if err != nil {
+			return err
+		}
+
+		for i := range versions {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			v := &versions[i]
+			after = &v.ID
+
+			if err := s.renameObject(ctx, v); err != nil {
+				failed++
+				utils.Error.Err(err).Str("file_id", v.FileID).Int("version", v.Version).Msg("failed to rename file version object")
+				continue
+			}
+			moved++
+		}
+
+		if len(versions) < renameBatchSize {
+			break
+		}
+	}
+
+	if moved+failed > 0 {
+		utils.Info.Info().Int("moved", moved).Int("failed", failed).Msg("renamed named objects")
+	}
+	return nil
+}
+
+func (s *FileService) renameObject(ctx context.Context, v *models.FileVersion) error {
+	file, err := s.FileRepo.GetFileByID(ctx, v.FileID)
+	if err != nil {
+		return err
+	}
+	storageKey, err := objectKey(file.UserID)
+	if err != nil {
+		return err
+	}
+
+	info, err := s.Storage.Stat(ctx, v.StorageKey)
+	if err != nil {
+		return err
+	}
+	obj, err := s.Storage.Get(ctx, v.StorageKey, storage.GetOptions{})
+	if err != nil {
+		return err
+	}
+	defer obj.Close()
+
+	contentType := v.ContentType
+	if v.IsEncrypted || contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	if err := s.Storage.Put(ctx, storageKey, obj, info.Size, contentType); err != nil {
+		return err
+	}
+
+	oldKey := v.StorageKey
+	moved, err := s.FileRepo.MoveVersionObject(ctx, v, storageKey)
+	if err != nil || !moved {
+		_ = s.Storage.Remove(ctx, storageKey)
+		return err
+	}
+	if err := s.Storage.Remove(ctx, oldKey); err != nil {
+		utils.Warn.Warn().Err(err).Str("key", oldKey).Msg("failed to remove renamed object")
+	}
+	return nil
+}
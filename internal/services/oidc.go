@@ -0,0 +1,342 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/SrabanMondal/SecureStore/internal/models"
+	"github.com/SrabanMondal/SecureStore/internal/oidc"
+	"github.com/SrabanMondal/SecureStore/internal/repository"
+	"github.com/SrabanMondal/SecureStore/internal/utils"
+)
+
+const (
+	// how long a user may take to sign in at the provider
+	OIDCStateTTL = 10 * time.Minute
+	// how long a sign-in to confirm who the user is may be used for
+	reauthWindow = 5 * time.Minute
+	// usernames tried for a provisioned user before giving up
+	provisionAttempts = 5
+	maxUsernameLength = 32
+)
+
+var (
+	ErrUnknownProvider       = errors.New("unknown identity provider")
+	ErrInvalidOIDCState      = errors.New("invalid or expired login state, start the login again")
+	ErrOIDCEmailNotVerified  = errors.New("the identity provider has not verified your email address")
+	ErrOIDCDomainNotAllowed  = errors.New("your email domain may not sign in with this provider")
+	ErrOIDCNoAccount         = errors.New("no account uses this email address")
+	ErrOIDCAccountExists     = errors.New("an account already uses this email address, sign in with its password")
+	ErrOIDCAccountUnverified = errors.New("an account with this email address exists but is unverified; reset its password to verify it, then sign in again")
+	ErrOIDCStaleLogin        = errors.New("the identity provider did not have you sign in again")
+	ErrOIDCOtherAccount      = errors.New("you signed in at the identity provider with an account not linked to yours")
+)
+
+// OIDCService signs users in through OpenID Connect providers. A provider
+// account is linked to a user the first time it signs in: to the user with
+// the same verified email, or to a new user when the provider may provision
+// them. Later logins go by the provider's subject, so changing the email at
+// either end keeps the link. The login ends like a password login, including
+// the MFA step for users who enabled it.
+type OIDCService struct {
+	UserRepo  *repositories.UserRepository
+	Auth      *AuthService
+	Providers []*oidc.Provider
+}
+
+func NewOIDCService(userRepo *repositories.UserRepository, auth *AuthService, configs []oidc.Config) *OIDCService {
+	s := &OIDCService{UserRepo: userRepo, Auth: auth}
+	for _, cfg := range configs {
+		s.Providers = append(s.Providers, oidc.NewProvider(cfg))
+	}
+	return s
+}
+
+func (s *OIDCService) provider(name string) (*oidc.Provider, error) {
+	for _, p := range s.Providers {
+		if p.Name == name {
+			return p, nil
+		}
+	}
+	return nil, ErrUnknownProvider
+}
+
+// ProviderNames lists the providers users can sign in with.
+func (s *OIDCService) ProviderNames() []string {
+	names := make([]string, 0, len(s.Providers))
+	for _, p := range s.Providers {
+		names = append(names, p.Name)
+	}
+	return names
+}
+
+// LoginURL starts a login with the provider and returns the URL to send the
+// user to, along with a binding for the browser to keep and present at the
+// callback. The state, nonce and PKCE verifier it uses, and the binding's
+// hash, are kept until the callback.
+func (s *OIDCService) LoginURL(ctx context.Context, name string) (authURL, binding string, err error) {
+	return s.start(ctx, name, nil)
+}
+
+// ReauthURL is LoginURL for a signed-in user who must sign in again at the
+// provider, completed by Reauthenticate rather than Callback.
+func (s *OIDCService) ReauthURL(ctx context.Context, name, userID string) (authURL, binding string, err error) {
+	return s.start(ctx, name, &userID)
+}
+
+func (s *OIDCService) start(ctx context.Context, name string, reauthUserID *string) (authURL, binding string, err error) {
+	p, err := s.provider(name)
+	if err != nil {
+		return "", "", err
+	}
+	state, err := randomToken()
+	if err != nil {
+		return "", "", err
+	}
+	nonce, err := randomToken()
+	if err != nil {
+		return "", "", err
+	}
+	binding, err = randomToken()
+	if err != nil {
+		return "", "", err
+	}
+	verifier, challenge, err := oidc.NewPKCE()
+	if err != nil {
+		return "", "", err
+	}
+
+	st := &models.OIDCState{
+		Provider:     p.Name,
+		Nonce:        nonce,
+		CodeVerifier: verifier,
+		BindingHash:  hashOIDCState(binding),
+		ReauthUserID: reauthUserID,
+	}
+	if err := s.UserRepo.CreateOIDCState(ctx, hashOIDCState(state), st, time.Now().Add(OIDCStateTTL)); err != nil {
+		return "", "", err
+	}
+	authURL, err = p.AuthCodeURL(ctx, state, nonce, challenge, reauthUserID != nil)
+	if err != nil {
+		return "", "", err
+	}
+	return authURL, binding, nil
+}
+
+// Callback completes a login with the code and state the provider
+// redirected back with. binding must be the one LoginURL returned for the
+// state, so a code and state lured from another login cannot sign this
+// browser in to someone else's account.
+func (s *OIDCService) Callback(ctx context.Context, name, code, state, binding string) (*LoginResult, error) {
+	p, err := s.provider(name)
+	if err != nil {
+		return nil, err
+	}
+	claims, err := s.finish(ctx, p, code, state, binding, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	userID, err := s.UserRepo.UseIdentity(ctx, p.Name, claims.Subject, claims.Email)
+	if errors.Is(err, repositories.ErrNotFound) {
+		userID, err = s.firstLogin(ctx, p, claims)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return s.Auth.startSession(ctx, userID)
+}
+
+// Reauthenticate completes a sign-in started by ReauthURL for userID. The
+// provider must have had the user sign in just now, as the provider account
+// linked to userID; the user may then take one action that needs them to
+// confirm who they are (see AuthService.confirmPassword) within reauthWindow.
+func (s *OIDCService) Reauthenticate(ctx context.Context, userID, name, code, state, binding string) error {
+	p, err := s.provider(name)
+	if err != nil {
+		return err
+	}
+	claims, err := s.finish(ctx, p, code, state, binding, &userID)
+	if err != nil {
+		return err
+	}
+	// max_age=0 asked for a fresh sign-in; a provider that ignored it and
+	// reused its session reports an earlier one, or none
+	if claims.AuthTime.IsZero() || time.Since(claims.AuthTime) > OIDCStateTTL {
+		utils.Warn.Warn().Str("user_id", userID).Str("provider", p.Name).Msg("oidc reauthentication without a fresh sign-in")
+		return ErrOIDCStaleLogin
+	}
+
+	linked, err := s.UserRepo.IdentityUser(ctx, p.Name, claims.Subject)
+	if errors.Is(err, repositories.ErrNotFound) || (err == nil && linked != userID) {
+		return ErrOIDCOtherAccount
+	}
+	if err != nil {
+		return err
+	}
+	return s.UserRepo.SetReauthenticated(ctx, userID)
+}
+
+// finish takes the state of a login started in this browser, by
+// reauthUserID when it is set and for signing in otherwise, redeems the code
+// and verifies the ID token.
+func (s *OIDCService) finish(ctx context.Context, p *oidc.Provider, code, state, binding string, reauthUserID *string) (*oidc.Claims, error) {
+	st, err := s.UserRepo.TakeOIDCState(ctx, hashOIDCState(state), p.Name)
+	if errors.Is(err, repositories.ErrNotFound) {
+		return nil, ErrInvalidOIDCState
+	}
+	if err != nil {
+		return nil, err
+	}
+	if subtle.ConstantTimeCompare(st.BindingHash, hashOIDCState(binding)) != 1 {
+		utils.Warn.Warn().Str("provider", p.Name).Msg("oidc callback from another browser")
+		return nil, ErrInvalidOIDCState
+	}
+	if (st.ReauthUserID == nil) != (reauthUserID == nil) || (reauthUserID != nil && *st.ReauthUserID != *reauthUserID) {
+		return nil, ErrInvalidOIDCState
+	}
+
+	rawIDToken, err := p.Exchange(ctx, code, st.CodeVerifier)
+	if err != nil {
+		utils.Warn.Warn().Err(err).Str("provider", p.Name).Msg("oidc code exchange failed")
+		return nil, err
+	}
+	claims, err := p.VerifyIDToken(ctx, rawIDToken, st.Nonce)
+	if err != nil {
+		utils.Warn.Warn().Err(err).Str("provider", p.Name).Msg("oidc id token rejected")
+		return nil, err
+	}
+	return claims, nil
+}
+
+// firstLogin links the provider account to a user by its verified email,
+// provisioning the user if need be, and returns the user.
+func (s *OIDCService) firstLogin(ctx context.Context, p *oidc.Provider, claims *oidc.Claims) (string, error) {
+	if claims.Email == "" || !claims.EmailVerified {
+		return "", ErrOIDCEmailNotVerified
+	}
+	if len(p.AllowedDomains) > 0 {
+		domain := strings.ToLower(claims.Email[strings.LastIndex(claims.Email, "@")+1:])
+		if !slices.Contains(p.AllowedDomains, domain) {
+			return "", ErrOIDCDomainNotAllowed
+		}
+	}
+	identity := &models.UserIdentity{Provider: p.Name, Subject: claims.Subject, Email: claims.Email}
+
+	user, err := s.UserRepo.GetUserByEmail(ctx, claims.Email)
+	if err != nil {
+		if !p.AutoProvision {
+			return "", ErrOIDCNoAccount
+		}
+		return s.provision(ctx, claims, identity)
+	}
+
+	if !p.LinkExisting {
+		return "", ErrOIDCAccountExists
+	}
+	// whoever registered an unverified account may not own the address, and
+	// would keep its password
+	verified, err := s.UserRepo.IsEmailVerified(ctx, user.ID)
+	if err != nil {
+		return "", err
+	}
+	if !verified {
+		return "", ErrOIDCAccountUnverified
+	}
+
+	identity.UserID = user.ID
+	linked, err := s.UserRepo.LinkIdentity(ctx, identity)
+	if err != nil {
+		return "", err
+	}
+	if !linked {
+		// linked by a concurrent login
+		return s.UserRepo.UseIdentity(ctx, p.Name, claims.Subject, claims.Email)
+	}
+	utils.Info.Info().Str("user_id", user.ID).Str("provider", p.Name).Msg("identity linked")
+	return user.ID, nil
+}
+
+// provision creates a user for the provider account. They have no password
+// until they set one through a password reset.
+func (s *OIDCService) provision(ctx context.Context, claims *oidc.Claims, identity *models.UserIdentity) (string, error) {
+	key, err := s.Auth.UserKeys.NewKey(ctx, "")
+	if err != nil {
+		return "", err
+	}
+
+	base := usernameFor(claims)
+	username := base
+	for i := 0; ; i++ {
+		user := &models.User{Username: username, Email: claims.Email}
+		err = s.UserRepo.CreateOIDCUser(ctx, user, key, identity)
+		if err == nil {
+			utils.Info.Info().Str("user_id", user.ID).Str("provider", identity.Provider).Msg("user provisioned")
+			return user.ID, nil
+		}
+		if !errors.Is(err, repositories.ErrUserConflict) || i == provisionAttempts-1 {
+			return "", err
+		}
+		// most likely the username is taken; try it with a random suffix
+		suffix := make([]byte, 3)
+		if _, err := rand.Read(suffix); err != nil {
+			return "", err
+		}
+		username = base + "-" + hex.EncodeToString(suffix)
+	}
+}
+
+// usernameFor derives a username from the provider's preferred username or
+// the email's local part.
+func usernameFor(claims *oidc.Claims) string {
+	name := claims.PreferredUsername
+	if name == "" || strings.Contains(name, "@") {
+		name, _, _ = strings.Cut(claims.Email, "@")
+	}
+	var b strings.Builder
+	for _, c := range strings.ToLower(name) {
+		if (c >= 'a' && c <= 'z') || (c >= '0' && c <= '9') || c == '.' || c == '_' || c == '-' {
+			b.WriteRune(c)
+		}
+	}
+	name = b.String()
+	if len(name) > maxUsernameLength {
+		name = name[:maxUsernameLength]
+	}
+	if name == "" {
+		name = "user"
+	}
+	return name
+}
+
+func (s *OIDCService) DeleteExpiredStates(ctx context.Context) error {
+	n, err := s.UserRepo.DeleteExpiredOIDCStates(ctx)
+	if err != nil {
+		return err
+	}
+	if n > 0 {
+		utils.Info.Info().Int64("states", n).Msg("deleted expired oidc states")
+	}
+	return nil
+}
+
+func randomToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+func hashOIDCState(state string) []byte {
+	sum := sha256.Sum256([]byte(state))
+	return sum[:]
+}
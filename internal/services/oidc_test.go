@@ -0,0 +1,297 @@
+package services
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/SrabanMondal/SecureStore/internal/oidc"
+	"github.com/SrabanMondal/SecureStore/internal/oidc/oidctest"
+)
+
+func newTestOIDC(t *testing.T, e *testEnv, cfg oidc.Config) (*OIDCService, *oidctest.Provider) {
+	idp := oidctest.New(t)
+	cfg.Name, cfg.Issuer, cfg.ClientID = "test", idp.URL, "securestore"
+	cfg.RedirectURL = "https://securestore.example/api/oidc/test/callback"
+	return NewOIDCService(e.userRepo, e.auth, []oidc.Config{cfg}), idp
+}
+
+// oidcLogin signs id in at the provider and completes the callback.
+func oidcLogin(t *testing.T, e *testEnv, s *OIDCService, idp *oidctest.Provider, id oidctest.Identity) (*LoginResult, error) {
+	t.Helper()
+	authURL, binding, err := s.LoginURL(e.ctx, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	code, state := idp.Login(t, authURL, id)
+	return s.Callback(e.ctx, "test", code, state, binding)
+}
+
+func TestOIDCProvision(t *testing.T) {
+	e := newTestEnv(t)
+	s, idp := newTestOIDC(t, e, oidc.Config{AutoProvision: true})
+	id := oidctest.Identity{Subject: "sub-1", Email: "carol@example.com", EmailVerified: true, PreferredUsername: "Carol"}
+
+	res, err := oidcLogin(t, e, s, idp, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.TokenPair == nil {
+		t.Fatal("no tokens issued")
+	}
+	user, err := e.userRepo.GetUserByEmail(e.ctx, "carol@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.Username != "carol" || user.PasswordHash != "" {
+		t.Errorf("provisioned user %q with password hash %q", user.Username, user.PasswordHash)
+	}
+
+	// the same subject signs in to the same user, whatever its email now
+	id.Email = "carol@example.org"
+	if _, err := oidcLogin(t, e, s, idp, id); err != nil {
+		t.Fatal(err)
+	}
+	if again, err := e.userRepo.GetUserByEmail(e.ctx, "carol@example.com"); err != nil || again.ID != user.ID {
+		t.Errorf("second login provisioned another user: %v", err)
+	}
+
+	// no password to log in with
+	if _, err := e.auth.Login(e.ctx, "carol@example.com", ""); !errors.Is(err, ErrInvalidCredentials) {
+		t.Errorf("password login = %v, want ErrInvalidCredentials", err)
+	}
+}
+
+// oidcReauth signs id in again at the provider for userID.
+func oidcReauth(t *testing.T, e *testEnv, s *OIDCService, idp *oidctest.Provider, userID string, id oidctest.Identity) error {
+	t.Helper()
+	authURL, binding, err := s.ReauthURL(e.ctx, "test", userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	code, state := idp.Login(t, authURL, id)
+	return s.Reauthenticate(e.ctx, userID, "test", code, state, binding)
+}
+
+func TestOIDCReauthenticate(t *testing.T) {
+	e := newTestEnv(t)
+	s, idp := newTestOIDC(t, e, oidc.Config{AutoProvision: true})
+	carol := oidctest.Identity{Subject: "sub-1", Email: "carol@example.com", EmailVerified: true}
+	dave := oidctest.Identity{Subject: "sub-2", Email: "dave@example.com", EmailVerified: true}
+	for _, id := range []oidctest.Identity{carol, dave} {
+		if _, err := oidcLogin(t, e, s, idp, id); err != nil {
+			t.Fatal(err)
+		}
+	}
+	user, err := e.userRepo.GetUserByEmail(e.ctx, "carol@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a session alone does not confirm anything
+	if err := e.auth.DeleteAccount(e.ctx, user.ID, ""); !errors.Is(err, ErrReauthRequired) {
+		t.Errorf("DeleteAccount = %v, want ErrReauthRequired", err)
+	}
+	if err := e.auth.DisableMFA(e.ctx, user.ID, "", "123456"); !errors.Is(err, ErrReauthRequired) {
+		t.Errorf("DisableMFA = %v, want ErrReauthRequired", err)
+	}
+
+	t.Run("rejected", func(t *testing.T) {
+		stale := carol
+		stale.AuthTime = time.Now().Add(-time.Hour)
+		if err := oidcReauth(t, e, s, idp, user.ID, stale); !errors.Is(err, ErrOIDCStaleLogin) {
+			t.Errorf("provider reusing an old sign-in = %v, want ErrOIDCStaleLogin", err)
+		}
+		if err := oidcReauth(t, e, s, idp, user.ID, dave); !errors.Is(err, ErrOIDCOtherAccount) {
+			t.Errorf("signed in as another user = %v, want ErrOIDCOtherAccount", err)
+		}
+		stranger := oidctest.Identity{Subject: "sub-3", Email: "carol@example.com", EmailVerified: true}
+		if err := oidcReauth(t, e, s, idp, user.ID, stranger); !errors.Is(err, ErrOIDCOtherAccount) {
+			t.Errorf("signed in as an unlinked account = %v, want ErrOIDCOtherAccount", err)
+		}
+
+		// states are not interchangeable between users or with logins
+		authURL, binding, err := s.ReauthURL(e.ctx, "test", user.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		code, state := idp.Login(t, authURL, carol)
+		if err := s.Reauthenticate(e.ctx, randomUUID(t), "test", code, state, binding); !errors.Is(err, ErrInvalidOIDCState) {
+			t.Errorf("completed by another user = %v, want ErrInvalidOIDCState", err)
+		}
+		authURL, binding, err = s.ReauthURL(e.ctx, "test", user.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		code, state = idp.Login(t, authURL, carol)
+		if _, err := s.Callback(e.ctx, "test", code, state, binding); !errors.Is(err, ErrInvalidOIDCState) {
+			t.Errorf("completed as a login = %v, want ErrInvalidOIDCState", err)
+		}
+		authURL, binding, err = s.LoginURL(e.ctx, "test")
+		if err != nil {
+			t.Fatal(err)
+		}
+		code, state = idp.Login(t, authURL, carol)
+		if err := s.Reauthenticate(e.ctx, user.ID, "test", code, state, binding); !errors.Is(err, ErrInvalidOIDCState) {
+			t.Errorf("login completed as a reauthentication = %v, want ErrInvalidOIDCState", err)
+		}
+
+		if err := e.auth.DeleteAccount(e.ctx, user.ID, ""); !errors.Is(err, ErrReauthRequired) {
+			t.Errorf("DeleteAccount after failed reauthentications = %v, want ErrReauthRequired", err)
+		}
+	})
+
+	// each sign-in confirms one action, for a short while
+	if err := oidcReauth(t, e, s, idp, user.ID, carol); err != nil {
+		t.Fatal(err)
+	}
+	if err := e.auth.DisableMFA(e.ctx, user.ID, "", "123456"); !errors.Is(err, ErrMFANotEnabled) {
+		t.Errorf("DisableMFA after reauthenticating = %v, want ErrMFANotEnabled", err)
+	}
+	if err := e.auth.DeleteAccount(e.ctx, user.ID, ""); !errors.Is(err, ErrReauthRequired) {
+		t.Errorf("DeleteAccount on a used sign-in = %v, want ErrReauthRequired", err)
+	}
+
+	if err := oidcReauth(t, e, s, idp, user.ID, carol); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := e.db.Exec(e.ctx, `UPDATE users SET reauthenticated_at = NOW() - INTERVAL '10 minutes' WHERE id=$1`, user.ID); err != nil {
+		t.Fatal(err)
+	}
+	if err := e.auth.DeleteAccount(e.ctx, user.ID, ""); !errors.Is(err, ErrReauthRequired) {
+		t.Errorf("DeleteAccount on an old sign-in = %v, want ErrReauthRequired", err)
+	}
+
+	if err := oidcReauth(t, e, s, idp, user.ID, carol); err != nil {
+		t.Fatal(err)
+	}
+	if err := e.auth.DeleteAccount(e.ctx, user.ID, ""); err != nil {
+		t.Errorf("DeleteAccount after reauthenticating = %v", err)
+	}
+}
+
+func TestOIDCProvisionRefused(t *testing.T) {
+	e := newTestEnv(t)
+	s, idp := newTestOIDC(t, e, oidc.Config{AutoProvision: true, AllowedDomains: []string{"example.com"}})
+
+	for _, tc := range []struct {
+		id   oidctest.Identity
+		want error
+	}{
+		{oidctest.Identity{Subject: "sub-1", Email: "dave@example.com"}, ErrOIDCEmailNotVerified},
+		{oidctest.Identity{Subject: "sub-2", Email: "dave@example.org", EmailVerified: true}, ErrOIDCDomainNotAllowed},
+	} {
+		if _, err := oidcLogin(t, e, s, idp, tc.id); !errors.Is(err, tc.want) {
+			t.Errorf("login as %s = %v, want %v", tc.id.Email, err, tc.want)
+		}
+	}
+
+	s, idp = newTestOIDC(t, e, oidc.Config{})
+	id := oidctest.Identity{Subject: "sub-3", Email: "erin@example.com", EmailVerified: true}
+	if _, err := oidcLogin(t, e, s, idp, id); !errors.Is(err, ErrOIDCNoAccount) {
+		t.Errorf("login without provisioning = %v, want ErrOIDCNoAccount", err)
+	}
+}
+
+func TestOIDCLinkExisting(t *testing.T) {
+	e := newTestEnv(t)
+	alice := e.register(t, "alice")
+	id := oidctest.Identity{Subject: "sub-1", Email: "alice@example.com", EmailVerified: true}
+
+	s, idp := newTestOIDC(t, e, oidc.Config{})
+	if _, err := oidcLogin(t, e, s, idp, id); !errors.Is(err, ErrOIDCAccountExists) {
+		t.Errorf("login without linking = %v, want ErrOIDCAccountExists", err)
+	}
+
+	s, idp = newTestOIDC(t, e, oidc.Config{LinkExisting: true})
+	if _, err := oidcLogin(t, e, s, idp, id); !errors.Is(err, ErrOIDCAccountUnverified) {
+		t.Errorf("login to unverified account = %v, want ErrOIDCAccountUnverified", err)
+	}
+
+	if _, err := e.db.Exec(e.ctx, `UPDATE users SET email_verified_at=NOW() WHERE id=$1`, alice.ID); err != nil {
+		t.Fatal(err)
+	}
+	res, err := oidcLogin(t, e, s, idp, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	claims, err := e.auth.Tokens.Authenticate(e.ctx, res.AccessToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if claims.UserID != alice.ID {
+		t.Errorf("signed in as %s, want %s", claims.UserID, alice.ID)
+	}
+
+	// linking keeps the password
+	if _, err := e.auth.Login(e.ctx, "alice@example.com", "password"); err != nil {
+		t.Errorf("password login after linking = %v", err)
+	}
+	if err := e.auth.DeleteAccount(e.ctx, alice.ID, ""); !errors.Is(err, ErrInvalidCredentials) {
+		t.Errorf("DeleteAccount without password = %v, want ErrInvalidCredentials", err)
+	}
+}
+
+func TestOIDCCallbackRejects(t *testing.T) {
+	e := newTestEnv(t)
+	s, idp := newTestOIDC(t, e, oidc.Config{AutoProvision: true})
+	id := oidctest.Identity{Subject: "sub-1", Email: "carol@example.com", EmailVerified: true}
+
+	t.Run("unknown state", func(t *testing.T) {
+		authURL, binding, err := s.LoginURL(e.ctx, "test")
+		if err != nil {
+			t.Fatal(err)
+		}
+		code, _ := idp.Login(t, authURL, id)
+		if _, err := s.Callback(e.ctx, "test", code, "forged", binding); !errors.Is(err, ErrInvalidOIDCState) {
+			t.Errorf("Callback = %v, want ErrInvalidOIDCState", err)
+		}
+	})
+
+	t.Run("state used twice", func(t *testing.T) {
+		authURL, binding, err := s.LoginURL(e.ctx, "test")
+		if err != nil {
+			t.Fatal(err)
+		}
+		code, state := idp.Login(t, authURL, id)
+		if _, err := s.Callback(e.ctx, "test", code, state, binding); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := s.Callback(e.ctx, "test", code, state, binding); !errors.Is(err, ErrInvalidOIDCState) {
+			t.Errorf("second Callback = %v, want ErrInvalidOIDCState", err)
+		}
+	})
+
+	// an attacker's own code and state, handed to a victim's browser, would
+	// sign the victim in to the attacker's account
+	t.Run("other browser", func(t *testing.T) {
+		_, victims, err := s.LoginURL(e.ctx, "test")
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, binding := range []string{victims, ""} {
+			authURL, _, err := s.LoginURL(e.ctx, "test")
+			if err != nil {
+				t.Fatal(err)
+			}
+			code, state := idp.Login(t, authURL, id)
+			if _, err := s.Callback(e.ctx, "test", code, state, binding); !errors.Is(err, ErrInvalidOIDCState) {
+				t.Errorf("Callback with binding %q = %v, want ErrInvalidOIDCState", binding, err)
+			}
+		}
+	})
+
+	t.Run("nonce mismatch", func(t *testing.T) {
+		replayed := id
+		replayed.Nonce = "from another login"
+		if _, err := oidcLogin(t, e, s, idp, replayed); !errors.Is(err, oidc.ErrInvalidIDToken) {
+			t.Errorf("Callback = %v, want ErrInvalidIDToken", err)
+		}
+	})
+
+	t.Run("unknown provider", func(t *testing.T) {
+		if _, err := s.Callback(e.ctx, "other", "code", "state", "binding"); !errors.Is(err, ErrUnknownProvider) {
+			t.Errorf("Callback = %v, want ErrUnknownProvider", err)
+		}
+	})
+}
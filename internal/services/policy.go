@@ -0,0 +1,35 @@
+package services
+
+import "github.com/SrabanMondal/SecureStore/internal/models"
+
+// Authorization policy. Every operation on a stored resource looks the
+// resource up and asks one of these whether the caller may see it. Callers
+// that may not are told the resource does not exist, the same as for an
+// unknown ID, so IDs reveal nothing about other users' data.
+
+// owns is the rule underneath the others: a resource is its owner's alone.
+func owns(userID, ownerID string) bool {
+	return userID != "" && userID == ownerID
+}
+
+func canAccessFile(userID string, f *models.File) bool {
+	return owns(userID, f.UserID)
+}
+
+func canAccessFolder(userID string, f *models.Folder) bool {
+	return owns(userID, f.UserID)
+}
+
+func canAccessMultipartUpload(userID string, u *models.MultipartUpload) bool {
+	return owns(userID, u.UserID)
+}
+
+func canAccessTusUpload(userID string, u *models.TusUpload) bool {
+	return owns(userID, u.UserID)
+}
+
+// canManageShare covers creating and deleting links to file, which only its
+// owner may do. Following a link needs no account; see ValidateShareLink.
+func canManageShare(userID string, file *models.File) bool {
+	return canAccessFile(userID, file)
+}
@@ -14,6 +14,8 @@ import (
 	//"github.com/SrabanMondal/SecureStore/internal/utils"
 )
 
+var ErrShareNotFound = errors.New("share link not found")
+
 type ShareService struct {
 	ShareRepo *repositories.ShareRepository
 	FileRepo  *repositories.FileRepository
@@ -36,11 +38,19 @@ func generateToken() (string, error) {
 	return base64.RawURLEncoding.EncodeToString(b), nil
 }
 
-func (s *ShareService) CreateShareLink(ctx context.Context, fileID string, expiry time.Duration, password string) (*models.ShareLink, error) {
+// CreateShareLink shares the caller's file fileID until expiry passes. Files
+// in the trash or being deleted are not found.
+func (s *ShareService) CreateShareLink(ctx context.Context, userID, fileID string, expiry time.Duration, password string) (*models.ShareLink, error) {
 	file, err := s.FileRepo.GetFileByID(ctx, fileID)
+	if errors.Is(err, repositories.ErrNotFound) {
+		return nil, ErrFileNotFound
+	}
 	if err != nil {
 		return nil, err
 	}
+	if !canManageShare(userID, file) || file.Status == "trashed" || file.Status == "deleting" {
+		return nil, ErrFileNotFound
+	}
 
 	token, err := generateToken()
 	if err != nil {
@@ -91,7 +101,8 @@ func (s *ShareService) ValidateShareLink(ctx context.Context, token, password st
 	}
 
 	file, err := s.FileRepo.GetFileByID(ctx, share.FileID)
-	if err != nil {
+	// links to trashed files stay dormant until the file is restored
+	if err != nil || file.Status != "uploaded" {
 		return nil, errors.New("file not found")
 	}
 
@@ -100,11 +111,11 @@ func (s *ShareService) ValidateShareLink(ctx context.Context, token, password st
 
 func (s *ShareService) GetDownloadContent(ctx context.Context, file *models.File) (interface{}, error) {
 	if file.IsEncrypted {
-		data, err := s.FileSvc.DownloadDecrypt(ctx, file)
+		body, err := s.FileSvc.DownloadDecrypt(ctx, file)
 		if err != nil {
 			return nil, err
 		}
-		return data, nil
+		return body, nil
 	}
 
 	url, err := s.FileSvc.GetDownloadURL(ctx, file)
@@ -119,6 +130,19 @@ func (s *ShareService) CleanupExpiredShares(ctx context.Context) error {
 }
 
 
-func (s *ShareService) DeleteLink(ctx context.Context, id string) error{
-	return s.ShareRepo.DeleteShareLink(ctx, id)
+// DeleteLink deletes a link to one of the caller's files.
+func (s *ShareService) DeleteLink(ctx context.Context, userID, id string) error {
+	share, err := s.ShareRepo.GetShareLinkByID(ctx, id)
+	if err != nil {
+		return ErrShareNotFound
+	}
+	file, err := s.FileRepo.GetFileByID(ctx, share.FileID)
+	if err != nil || !canManageShare(userID, file) {
+		return ErrShareNotFound
+	}
+	err = s.ShareRepo.DeleteShareLink(ctx, share.ID)
+	if errors.Is(err, repositories.ErrNotFound) {
+		return ErrShareNotFound
+	}
+	return err
 }
\ No newline at end of file
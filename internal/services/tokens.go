@@ -0,0 +1,313 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/SrabanMondal/SecureStore/internal/models"
+	"github.com/SrabanMondal/SecureStore/internal/repository"
+	"github.com/SrabanMondal/SecureStore/internal/utils"
+)
+
+// how stale the cached deny lists and roles may get before they are
+// reloaded, which bounds how long a token revoked, a user suspended or a role
+// changed on another instance keeps working
+const revocationRefresh = 30 * time.Second
+
+var (
+	ErrInvalidRefreshToken = errors.New("invalid or expired refresh token")
+	ErrRefreshTokenReused  = errors.New("refresh token was already used, the session has been revoked")
+	ErrTokenRevoked        = errors.New("token has been revoked")
+	ErrAccountSuspended    = errors.New("account is suspended")
+)
+
+// TokenPair is what a login or refresh hands back.
+type TokenPair struct {
+	AccessToken      string    `json:"access_token"`
+	TokenType        string    `json:"token_type"`
+	ExpiresIn        int64     `json:"expires_in"`
+	RefreshToken     string    `json:"refresh_token"`
+	RefreshExpiresAt time.Time `json:"refresh_expires_at"`
+}
+
+// TokenService issues short-lived access tokens and rotating refresh tokens.
+// Refresh tokens are stored hashed; each use swaps the token for a new one of
+// the same family, and presenting a used token again revokes the family, as
+// only a stolen copy would be. Revoked access tokens are denied by jti until
+// they expire, and every token of a suspended user while they are suspended.
+// A token acts with its user's current role, not the one it was issued with.
+type TokenService struct {
+	TokenRepo     *repositories.TokenRepository
+	UserRepo      *repositories.UserRepository
+	JWT           utils.JWTSettings
+	AccessExpiry  time.Duration
+	RefreshExpiry time.Duration
+
+	mu        sync.RWMutex
+	denied    map[string]time.Time
+	suspended map[string]bool
+	// users whose role is not RoleUser
+	roles    map[string]string
+	loadedAt time.Time
+}
+
+func NewTokenService(tokenRepo *repositories.TokenRepository, userRepo *repositories.UserRepository, settings utils.JWTSettings, accessExpiry, refreshExpiry time.Duration) *TokenService {
+	return &TokenService{
+		TokenRepo:     tokenRepo,
+		UserRepo:      userRepo,
+		JWT:           settings,
+		AccessExpiry:  accessExpiry,
+		RefreshExpiry: refreshExpiry,
+	}
+}
+
+func hashToken(token string) []byte {
+	sum := sha256.Sum256([]byte(token))
+	return sum[:]
+}
+
+func (s *TokenService) newRefreshToken() (string, *models.RefreshToken, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", nil, err
+	}
+	token := base64.RawURLEncoding.EncodeToString(b)
+	return token, &models.RefreshToken{TokenHash: hashToken(token), ExpiresAt: time.Now().Add(s.RefreshExpiry)}, nil
+}
+
+func (s *TokenService) pair(user *models.User, refresh string, t *models.RefreshToken) (*TokenPair, error) {
+	access, err := utils.GenerateJWT(user.ID, user.Role, s.JWT, s.AccessExpiry)
+	if err != nil {
+		return nil, err
+	}
+	return &TokenPair{
+		AccessToken:      access,
+		TokenType:        "Bearer",
+		ExpiresIn:        int64(s.AccessExpiry.Seconds()),
+		RefreshToken:     refresh,
+		RefreshExpiresAt: t.ExpiresAt,
+	}, nil
+}
+
+// Issue starts a new session for userID.
+func (s *TokenService) Issue(ctx context.Context, userID string) (*TokenPair, error) {
+	user, err := s.UserRepo.GetUserByID(ctx, userID)
+	if err != nil {
+		return nil, ErrUserNotFound
+	}
+	if user.SuspendedAt != nil {
+		return nil, ErrAccountSuspended
+	}
+	refresh, t, err := s.newRefreshToken()
+	if err != nil {
+		return nil, err
+	}
+	t.UserID = userID
+	if err := s.TokenRepo.CreateRefreshToken(ctx, t); err != nil {
+		return nil, err
+	}
+	return s.pair(user, refresh, t)
+}
+
+// Refresh swaps a refresh token for a new pair.
+func (s *TokenService) Refresh(ctx context.Context, refresh string) (*TokenPair, error) {
+	t, err := s.TokenRepo.GetRefreshTokenByHash(ctx, hashToken(refresh))
+	if errors.Is(err, repositories.ErrNotFound) {
+		return nil, ErrInvalidRefreshToken
+	}
+	if err != nil {
+		return nil, err
+	}
+	if t.RevokedAt != nil || time.Now().After(t.ExpiresAt) {
+		return nil, ErrInvalidRefreshToken
+	}
+	if t.UsedAt != nil {
+		return nil, s.reused(ctx, t)
+	}
+	user, err := s.UserRepo.GetUserByID(ctx, t.UserID)
+	if err != nil {
+		return nil, ErrInvalidRefreshToken
+	}
+	if user.SuspendedAt != nil {
+		return nil, ErrAccountSuspended
+	}
+
+	next, nt, err := s.newRefreshToken()
+	if err != nil {
+		return nil, err
+	}
+	rotated, err := s.TokenRepo.RotateRefreshToken(ctx, t, nt)
+	if err != nil {
+		return nil, err
+	}
+	if !rotated {
+		// used or revoked since it was read
+		return nil, s.reused(ctx, t)
+	}
+	return s.pair(user, next, nt)
+}
+
+func (s *TokenService) reused(ctx context.Context, t *models.RefreshToken) error {
+	utils.Warn.Warn().Str("user_id", t.UserID).Str("family_id", t.FamilyID).Msg("refresh token reused, revoking its family")
+	if err := s.TokenRepo.RevokeFamily(ctx, t.FamilyID); err != nil {
+		return err
+	}
+	return ErrRefreshTokenReused
+}
+
+// Logout revokes the access token claims were taken from and, when given,
+// the session of refresh. all revokes every session of the user instead.
+func (s *TokenService) Logout(ctx context.Context, claims *utils.AccessClaims, refresh string, all bool) error {
+	if err := s.TokenRepo.RevokeAccessToken(ctx, claims.ID, claims.UserID, claims.ExpiresAt); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	if s.denied != nil {
+		s.denied[claims.ID] = claims.ExpiresAt
+	}
+	s.mu.Unlock()
+
+	if all {
+		return s.TokenRepo.RevokeUserTokens(ctx, claims.UserID)
+	}
+	if refresh == "" {
+		return nil
+	}
+	t, err := s.TokenRepo.GetRefreshTokenByHash(ctx, hashToken(refresh))
+	if errors.Is(err, repositories.ErrNotFound) || (err == nil && t.UserID != claims.UserID) {
+		return ErrInvalidRefreshToken
+	}
+	if err != nil {
+		return err
+	}
+	return s.TokenRepo.RevokeFamily(ctx, t.FamilyID)
+}
+
+// Authenticate verifies an access token and checks it against the deny
+// lists. The claims carry the user's current role rather than the token's,
+// so a demotion applies without waiting for the token to expire.
+func (s *TokenService) Authenticate(ctx context.Context, token string) (*utils.AccessClaims, error) {
+	claims, err := utils.ParseJWT(token, s.JWT)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.check(ctx, claims.ID, claims.UserID); err != nil {
+		return nil, err
+	}
+	claims.Role = s.role(claims.UserID)
+	return claims, nil
+}
+
+// check looks a token's jti and user up in the cached deny lists, reloading
+// them once they are older than revocationRefresh.
+func (s *TokenService) check(ctx context.Context, jti, userID string) error {
+	s.mu.RLock()
+	fresh := s.denied != nil && time.Since(s.loadedAt) < revocationRefresh
+	s.mu.RUnlock()
+	if !fresh && !s.reload(ctx) {
+		// nothing cached to fall back on
+		return ErrTokenRevoked
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if exp, denied := s.denied[jti]; denied && time.Now().Before(exp) {
+		return ErrTokenRevoked
+	}
+	if s.suspended[userID] {
+		return ErrAccountSuspended
+	}
+	return nil
+}
+
+// reload fetches the deny lists unless another caller just did. If that
+// fails the stale lists are used until the next attempt; it reports false
+// when there are none.
+func (s *TokenService) reload(ctx context.Context) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.denied != nil && time.Since(s.loadedAt) < revocationRefresh {
+		return true
+	}
+
+	list, err := s.TokenRepo.ListRevokedAccessTokens(ctx)
+	var suspended map[string]bool
+	if err == nil {
+		suspended, err = s.UserRepo.ListSuspendedUsers(ctx)
+	}
+	var roles map[string]string
+	if err == nil {
+		roles, err = s.UserRepo.ListUserRoles(ctx)
+	}
+	switch {
+	case err == nil:
+		s.denied, s.suspended, s.roles = list, suspended, roles
+	case s.denied == nil:
+		return false
+	default:
+		utils.Warn.Warn().Err(err).Msg("using stale token deny lists")
+	}
+	s.loadedAt = time.Now()
+	return true
+}
+
+// noteSuspended updates the cached list of suspended users on this instance
+// at once; other instances pick the change up on their next reload.
+func (s *TokenService) noteSuspended(userID string, suspended bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.suspended == nil {
+		return
+	}
+	if suspended {
+		s.suspended[userID] = true
+	} else {
+		delete(s.suspended, userID)
+	}
+}
+
+// role returns the cached role of userID, which check has loaded.
+func (s *TokenService) role(userID string) string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if role, ok := s.roles[userID]; ok {
+		return role
+	}
+	return models.RoleUser
+}
+
+// noteRole updates the cached role of userID on this instance at once, like
+// noteSuspended.
+func (s *TokenService) noteRole(userID, role string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.roles == nil {
+		return
+	}
+	if role == models.RoleUser {
+		delete(s.roles, userID)
+	} else {
+		s.roles[userID] = role
+	}
+}
+
+// JWKS returns the public keys access tokens can be verified with.
+func (s *TokenService) JWKS() map[string][]utils.JWK {
+	return s.JWT.Keys.JWKS()
+}
+
+func (s *TokenService) DeleteExpiredTokens(ctx context.Context) error {
+	n, err := s.TokenRepo.DeleteExpiredTokens(ctx)
+	if err != nil {
+		return err
+	}
+	if n > 0 {
+		utils.Info.Info().Int64("tokens", n).Msg("deleted expired tokens")
+	}
+	return nil
+}
@@ -0,0 +1,45 @@
+package services
+
+import (
+	"context"
+	"errors"
+
+	"github.com/SrabanMondal/SecureStore/internal/models"
+	"github.com/SrabanMondal/SecureStore/internal/repository"
+)
+
+var ErrFileNotTrashed = errors.New("file is not in the trash")
+
+func (s *FileService) ListTrash(ctx context.Context, userID string) ([]models.File, error) {
+	return s.FileRepo.ListTrashedFiles(ctx, userID)
+}
+
+// RestoreFile brings a trashed file back to its original path. Share links to
+// it work again once it is restored.
+func (s *FileService) RestoreFile(ctx context.Context, userID, fileID string) (*models.File, error) {
+	file, err := s.ownedFile(ctx, userID, fileID)
+	if err != nil {
+		return nil, err
+	}
+	if file.Status != "trashed" {
+		return nil, ErrFileNotTrashed
+	}
+
+	err = s.FileRepo.RestoreFile(ctx, file)
+	switch {
+	case errors.Is(err, repositories.ErrNotFound):
+		// purged or restored concurrently
+		return nil, ErrFileNotTrashed
+	case errors.Is(err, repositories.ErrPathConflict):
+		return nil, ErrPathConflict
+	case err != nil:
+		return nil, err
+	}
+	return file, nil
+}
+
+// EmptyTrash permanently deletes everything in the user's trash. Objects are
+// removed by the cleanup job.
+func (s *FileService) EmptyTrash(ctx context.Context, userID string) (int64, error) {
+	return s.FileRepo.EmptyTrash(ctx, userID)
+}
@@ -0,0 +1,391 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/SrabanMondal/SecureStore/internal/models"
+	"github.com/SrabanMondal/SecureStore/internal/repository"
+	"github.com/SrabanMondal/SecureStore/internal/storage"
+	"github.com/SrabanMondal/SecureStore/internal/utils"
+)
+
+const (
+	// tus uploads expire this long after their last PATCH
+	TusExpiry       = 24 * time.Hour
+	TusMaxSize      = maxObjectSize
+	TusChecksumAlgs = "md5,sha1,sha256"
+)
+
+var (
+	ErrOffsetMismatch   = errors.New("upload offset does not match")
+	ErrChecksumMismatch = errors.New("checksum mismatch")
+	ErrUnsupportedAlgo  = errors.New("unsupported checksum algorithm")
+	ErrUploadExpired    = errors.New("upload expired")
+	ErrUploadTooLarge   = errors.New("upload exceeds declared length")
+	ErrUploadFinished   = errors.New("upload already finished")
+)
+
+type TusService struct {
+	TusRepo *repositories.TusRepository
+	FileSvc *FileService
+}
+
+func NewTusService(tusRepo *repositories.TusRepository, fileSvc *FileService) *TusService {
+	return &TusService{
+		TusRepo: tusRepo,
+		FileSvc: fileSvc,
+	}
+}
+
+// Checksum is a parsed Upload-Checksum header.
+type Checksum struct {
+	Algo string
+	Sum  []byte
+}
+
+func ParseChecksum(header string) (*Checksum, error) {
+	algo, sum, ok := strings.Cut(strings.TrimSpace(header), " ")
+	if !ok {
+		return nil, ErrUnsupportedAlgo
+	}
+	if newHash(algo) == nil {
+		return nil, ErrUnsupportedAlgo
+	}
+	raw, err := base64.StdEncoding.DecodeString(sum)
+	if err != nil {
+		return nil, fmt.Errorf("invalid checksum encoding: %w", err)
+	}
+	return &Checksum{Algo: algo, Sum: raw}, nil
+}
+
+func newHash(algo string) hash.Hash {
+	switch algo {
+	case "md5":
+		return md5.New()
+	case "sha1":
+		return sha1.New()
+	case "sha256":
+		return sha256.New()
+	}
+	return nil
+}
+
+func (s *TusService) Create(ctx context.Context, userID, filePath string, length int64, metadata string, encrypted bool) (*models.TusUpload, error) {
+	if length < 0 || length > TusMaxSize {
+		return nil, ErrInvalidSize
+	}
+	filePath, err := utils.NormalizePath(filePath)
+	if err != nil {
+		return nil, err
+	}
+	// refuse before any bytes arrive; the quota is charged when the upload
+	// finishes and becomes a file version
+	if err := s.FileSvc.CheckQuota(ctx, userID, length, encrypted); err != nil {
+		return nil, err
+	}
+
+	upload := &models.TusUpload{
+		UserID:      userID,
+		FilePath:    filePath,
+		Length:      length,
+		Metadata:    metadata,
+		IsEncrypted: encrypted,
+		ExpiresAt:   time.Now().Add(TusExpiry),
+	}
+	if encrypted {
+		if upload.DataKey, err = s.FileSvc.newDataKey(ctx, userID); err != nil {
+			return nil, err
+		}
+	}
+	if err := s.TusRepo.CreateUpload(ctx, upload); err != nil {
+		return nil, err
+	}
+
+	// a zero length upload is complete as soon as it exists
+	if length == 0 {
+		if err := s.finish(ctx, upload); err != nil {
+			_ = s.TusRepo.DeleteUpload(ctx, upload.ID)
+			return nil, err
+		}
+	}
+	return upload, nil
+}
+
+func (s *TusService) Get(ctx context.Context, userID, id string) (*models.TusUpload, error) {
+	upload, err := s.TusRepo.GetUpload(ctx, id)
+	if err != nil || !canAccessTusUpload(userID, upload) {
+		return nil, ErrUploadNotFound
+	}
+	if upload.FileID == nil && time.Now().After(upload.ExpiresAt) {
+		return nil, ErrUploadExpired
+	}
+	return upload, nil
+}
+
+// WriteChunk stores the bytes of one PATCH request at offset as a segment
+// object. length is the request's Content-Length, or -1 when it is not known.
+// When the last byte arrives the segments are stitched into the final file.
+func (s *TusService) WriteChunk(ctx context.Context, userID, id string, offset, length int64, r io.Reader, checksum *Checksum) (*models.TusUpload, error) {
+	upload, err := s.Get(ctx, userID, id)
+	if err != nil {
+		return nil, err
+	}
+	if upload.FileID != nil {
+		return nil, ErrUploadFinished
+	}
+	if offset != upload.Offset {
+		return nil, ErrOffsetMismatch
+	}
+	if upload.Offset == upload.Length {
+		// every byte arrived but assembly failed earlier; retry it
+		if err := s.finish(ctx, upload); err != nil {
+			return nil, err
+		}
+		return upload, nil
+	}
+
+	remaining := upload.Length - upload.Offset
+	if length > remaining {
+		return nil, ErrUploadTooLarge
+	}
+	// the segment is stored with its exact size when it is known; otherwise
+	// one byte past the remaining length is read so oversized bodies are
+	// detected
+	limit := length
+	if limit < 0 {
+		limit = remaining + 1
+	}
+	counter := &countingReader{r: io.LimitReader(r, limit)}
+	var body io.Reader = counter
+	var h hash.Hash
+	if checksum != nil {
+		h = newHash(checksum.Algo)
+		body = io.TeeReader(body, h)
+	}
+
+	suffix := make([]byte, 8)
+	if _, err := rand.Read(suffix); err != nil {
+		return nil, err
+	}
+	seg := &models.TusSegment{
+		UploadID:   upload.ID,
+		Offset:     offset,
+		StorageKey: fmt.Sprintf("tus/%s/%020d-%s", upload.ID, offset, hex.EncodeToString(suffix)),
+	}
+
+	objectSize := length
+	if upload.IsEncrypted {
+		// segments of encrypted uploads are sealed so plaintext never rests in storage
+		key, err := s.FileSvc.dataKey(ctx, upload.UserID, upload.DataKey)
+		if err != nil {
+			return nil, err
+		}
+		enc, err := s.FileSvc.encryptStream(body, key, utils.ObjectAAD(upload.ID, upload.UserID, seg.Offset))
+		if err != nil {
+			return nil, err
+		}
+		defer enc.Close()
+		body = enc
+		if length >= 0 {
+			objectSize = utils.EncryptedSize(length)
+		}
+	}
+
+	if err := s.FileSvc.Storage.Put(ctx, seg.StorageKey, body, objectSize, "application/octet-stream"); err != nil {
+		return nil, err
+	}
+	seg.Size = counter.n
+
+	discard := func(cause error) (*models.TusUpload, error) {
+		_ = s.FileSvc.Storage.Remove(ctx, seg.StorageKey)
+		return nil, cause
+	}
+	if seg.Size > remaining {
+		return discard(ErrUploadTooLarge)
+	}
+	if length >= 0 && seg.Size != length {
+		// the backend should have refused the short body already
+		return discard(io.ErrUnexpectedEOF)
+	}
+	if h != nil && !bytes.Equal(h.Sum(nil), checksum.Sum) {
+		return discard(ErrChecksumMismatch)
+	}
+	if seg.Size == 0 {
+		_ = s.FileSvc.Storage.Remove(ctx, seg.StorageKey)
+		return upload, nil
+	}
+
+	expiresAt := time.Now().Add(TusExpiry)
+	ok, err := s.TusRepo.AddSegment(ctx, seg, expiresAt)
+	if err != nil {
+		return discard(err)
+	}
+	if !ok {
+		return discard(ErrOffsetMismatch)
+	}
+	upload.Offset += seg.Size
+	upload.ExpiresAt = expiresAt
+
+	if upload.Offset == upload.Length {
+		if err := s.finish(ctx, upload); err != nil {
+			return nil, err
+		}
+	}
+	return upload, nil
+}
+
+// finish assembles the segments into a regular file, the same way an
+// encrypted or direct upload would be stored, and drops the segments.
+func (s *TusService) finish(ctx context.Context, upload *models.TusUpload) error {
+	segs, err := s.TusRepo.ListSegments(ctx, upload.ID)
+	if err != nil {
+		return err
+	}
+
+	src := &segmentReader{ctx: ctx, fileSvc: s.FileSvc, segs: segs, ownerID: upload.UserID}
+	if upload.IsEncrypted {
+		if src.key, err = s.FileSvc.dataKey(ctx, upload.UserID, upload.DataKey); err != nil {
+			return err
+		}
+	}
+	defer src.Close()
+
+	file, err := s.FileSvc.uploadDirect(ctx, upload.UserID, upload.FilePath, src, upload.Length, upload.IsEncrypted)
+	if err != nil {
+		utils.Error.Err(err).Str("upload_id", upload.ID).Msg("failed to assemble tus upload")
+		return err
+	}
+	if err := s.TusRepo.SetFileID(ctx, upload.ID, file.ID); err != nil {
+		return err
+	}
+	upload.FileID = &file.ID
+
+	s.removeSegments(ctx, upload.ID, segs)
+	return nil
+}
+
+func (s *TusService) removeSegments(ctx context.Context, uploadID string, segs []models.TusSegment) {
+	for _, seg := range segs {
+		if err := s.FileSvc.Storage.Remove(ctx, seg.StorageKey); err != nil {
+			utils.Warn.Warn().Err(err).Str("key", seg.StorageKey).Msg("failed to remove tus segment")
+		}
+	}
+	_ = s.TusRepo.DeleteSegments(ctx, uploadID)
+}
+
+func (s *TusService) Terminate(ctx context.Context, userID, id string) error {
+	upload, err := s.TusRepo.GetUpload(ctx, id)
+	if err != nil || !canAccessTusUpload(userID, upload) {
+		return ErrUploadNotFound
+	}
+	return s.terminate(ctx, upload)
+}
+
+func (s *TusService) terminate(ctx context.Context, upload *models.TusUpload) error {
+	segs, err := s.TusRepo.ListSegments(ctx, upload.ID)
+	if err != nil {
+		return err
+	}
+	s.removeSegments(ctx, upload.ID, segs)
+	return s.TusRepo.DeleteUpload(ctx, upload.ID)
+}
+
+// ExpireUploads drops uploads whose expiration has passed, including finished
+// ones whose tus bookkeeping is no longer needed.
+func (s *TusService) ExpireUploads(ctx context.Context) error {
+	uploads, err := s.TusRepo.ListExpiredUploads(ctx, time.Now())
+	if err != nil {
+		return err
+	}
+	for i := range uploads {
+		if err := s.terminate(ctx, &uploads[i]); err != nil {
+			utils.Error.Err(err).Str("upload_id", uploads[i].ID).Msg("failed to expire tus upload")
+		}
+	}
+	return nil
+}
+
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// segmentReader reads the segments of an upload one after another, opening
+// each object only when the previous one is exhausted. Segments are
+// decrypted with key unless it is nil.
+type segmentReader struct {
+	ctx     context.Context
+	fileSvc *FileService
+	segs    []models.TusSegment
+	key     []byte
+	ownerID string
+	cur     io.ReadCloser
+}
+
+func (r *segmentReader) Read(p []byte) (int, error) {
+	for {
+		if r.cur == nil {
+			if len(r.segs) == 0 {
+				return 0, io.EOF
+			}
+			cur, err := r.open(r.segs[0])
+			if err != nil {
+				return 0, err
+			}
+			r.cur, r.segs = cur, r.segs[1:]
+		}
+
+		n, err := r.cur.Read(p)
+		if err == io.EOF {
+			r.cur.Close()
+			r.cur = nil
+			if n > 0 {
+				return n, nil
+			}
+			continue
+		}
+		return n, err
+	}
+}
+
+func (r *segmentReader) open(seg models.TusSegment) (io.ReadCloser, error) {
+	obj, err := r.fileSvc.Storage.Get(r.ctx, seg.StorageKey, storage.GetOptions{})
+	if err != nil {
+		return nil, err
+	}
+	if r.key == nil {
+		return obj, nil
+	}
+	plain, err := utils.NewDecryptReader(obj, r.key, utils.ObjectAAD(seg.UploadID, r.ownerID, seg.Offset), r.fileSvc.AllowUnbound)
+	if err != nil {
+		obj.Close()
+		return nil, err
+	}
+	return readCloser{Reader: plain, Closer: obj}, nil
+}
+
+func (r *segmentReader) Close() error {
+	if r.cur != nil {
+		return r.cur.Close()
+	}
+	return nil
+}
@@ -0,0 +1,94 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/SrabanMondal/SecureStore/internal/models"
+	"github.com/SrabanMondal/SecureStore/internal/repository"
+	"github.com/SrabanMondal/SecureStore/internal/storage"
+	"github.com/SrabanMondal/SecureStore/internal/utils"
+)
+
+// versions are stat'ed this many at a time when recomputing usage
+const usagePageSize = 500
+
+var (
+	ErrFileTooLarge  = errors.New("file is larger than the storage quota")
+	ErrQuotaExceeded = errors.New("storage quota exceeded")
+)
+
+func (s *FileService) Usage(ctx context.Context, userID string) (*models.Usage, error) {
+	return s.FileRepo.GetUsage(ctx, userID)
+}
+
+// CheckQuota fails early for an upload of size bytes that cannot fit in the
+// user's quota. It reserves nothing; the charge itself happens when the
+// version is created.
+func (s *FileService) CheckQuota(ctx context.Context, userID string, size int64, encrypted bool) error {
+	usage, err := s.Usage(ctx, userID)
+	if err != nil {
+		return err
+	}
+
+	need := size
+	if encrypted {
+		need = utils.EncryptedSize(size)
+	}
+	if need > usage.QuotaBytes {
+		return fmt.Errorf("%w: %d bytes, quota is %d", ErrFileTooLarge, need, usage.QuotaBytes)
+	}
+	if need > usage.Available() {
+		return fmt.Errorf("%w: %d bytes needed, %d available", ErrQuotaExceeded, need, usage.Available())
+	}
+	return nil
+}
+
+func quotaError(err error) error {
+	if errors.Is(err, repositories.ErrQuotaExceeded) {
+		return ErrQuotaExceeded
+	}
+	return err
+}
+
+// RecomputeUsage corrects drift in the usage counters: the object size of
+// every uploaded version is refreshed from storage, then each user's usage is
+// summed again. Pending versions keep counting what they reserved.
+func (s *FileService) RecomputeUsage(ctx context.Context) error {
+	after := ""
+	for {
+		versions, err := s.FileRepo.ListUploadedVersions(ctx, after, usagePageSize)
+		if err != nil {
+			return err
+		}
+
+		for _, v := range versions {
+			info, err := s.Storage.Stat(ctx, v.StorageKey)
+			if errors.Is(err, storage.ErrNotFound) {
+				utils.Warn.Warn().Str("file_id", v.FileID).Int("version", v.Version).Msg("version object missing in storage")
+				info = &storage.ObjectInfo{}
+			} else if err != nil {
+				utils.Error.Err(err).Str("file_id", v.FileID).Int("version", v.Version).Msg("failed to stat version object")
+				continue
+			}
+			if info.Size != v.ObjectSize {
+				_ = s.FileRepo.SetVersionObjectSize(ctx, v.ID, info.Size)
+			}
+		}
+
+		if len(versions) < usagePageSize {
+			break
+		}
+		after = versions[len(versions)-1].ID
+	}
+
+	changed, err := s.FileRepo.RecomputeUsage(ctx)
+	if err != nil {
+		return err
+	}
+	if changed > 0 {
+		utils.Info.Info().Int64("users", changed).Msg("corrected usage drift")
+	}
+	return nil
+}
@@ -0,0 +1,223 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+
+	"golang.org/x/crypto/argon2"
+
+	"github.com/SrabanMondal/SecureStore/internal/kms"
+	"github.com/SrabanMondal/SecureStore/internal/models"
+	"github.com/SrabanMondal/SecureStore/internal/repository"
+	"github.com/SrabanMondal/SecureStore/internal/utils"
+)
+
+// UserKeyID is the key ID recorded on data keys wrapped by their owner's key
+// encryption key rather than by a master key.
+const UserKeyID = "user"
+
+// Argon2id parameters for new passphrases; the ones a key was sealed with are
+// stored next to it.
+const (
+	kdfTime       = 3
+	kdfMemory     = 64 * 1024
+	kdfThreads    = 4
+	kdfSaltSize   = 16
+	minPassphrase = 8
+)
+
+var (
+	ErrPassphraseRequired = errors.New("this account's key is protected, send its passphrase in X-Key-Passphrase")
+	ErrWrongPassphrase    = errors.New("wrong key passphrase")
+	ErrWeakPassphrase     = fmt.Errorf("key passphrase must be at least %d characters", minPassphrase)
+	ErrKeyDestroyed       = errors.New("the account's key has been destroyed")
+)
+
+// UserKeyService manages each user's key encryption key. The key is wrapped
+// by the master key, and optionally sealed first under a key derived from a
+// passphrase only the user knows. Destroying it makes every object of the
+// user unrecoverable.
+type UserKeyService struct {
+	UserRepo *repositories.UserRepository
+	KMS      kms.Provider
+}
+
+func NewUserKeyService(userRepo *repositories.UserRepository, keyProvider kms.Provider) *UserKeyService {
+	return &UserKeyService{UserRepo: userRepo, KMS: keyProvider}
+}
+
+type passphraseKey struct{}
+
+// WithPassphrase attaches the user's key passphrase to ctx for the services
+// that need to open their key.
+func WithPassphrase(ctx context.Context, passphrase string) context.Context {
+	return context.WithValue(ctx, passphraseKey{}, passphrase)
+}
+
+func passphraseFrom(ctx context.Context) string {
+	p, _ := ctx.Value(passphraseKey{}).(string)
+	return p
+}
+
+// NewKey generates a key encryption key for a new user, protected by
+// passphrase unless it is empty.
+func (s *UserKeyService) NewKey(ctx context.Context, passphrase string) (*models.UserKey, error) {
+	if passphrase == "" {
+		// wrapped under the active master key alone, so the KMS can make it
+		keyID := s.KMS.ActiveKeyID()
+		dk, err := s.KMS.GenerateDataKey(ctx, keyID)
+		if err != nil {
+			return nil, err
+		}
+		return &models.UserKey{DataKey: models.DataKey{WrappedKey: dk.Ciphertext, KeyID: keyID, KeyAlgorithm: s.KMS.Algorithm()}}, nil
+	}
+
+	kek, err := utils.NewDataKey()
+	if err != nil {
+		return nil, err
+	}
+	k := &models.UserKey{}
+	if err := s.seal(ctx, k, kek, passphrase); err != nil {
+		return nil, err
+	}
+	return k, nil
+}
+
+// seal stores kek in k, under passphrase when set and then under the active
+// master key.
+func (s *UserKeyService) seal(ctx context.Context, k *models.UserKey, kek []byte, passphrase string) error {
+	if passphrase != "" && len(passphrase) < minPassphrase {
+		return ErrWeakPassphrase
+	}
+	k.PassphraseSalt, k.KDFTime, k.KDFMemory, k.KDFThreads = nil, 0, 0, 0
+	inner := kek
+	if passphrase != "" {
+		salt := make([]byte, kdfSaltSize)
+		if _, err := rand.Read(salt); err != nil {
+			return err
+		}
+		k.PassphraseSalt, k.KDFTime, k.KDFMemory, k.KDFThreads = salt, kdfTime, kdfMemory, kdfThreads
+
+		var err error
+		if inner, err = utils.WrapKey(passphraseKEK(k, passphrase), kek); err != nil {
+			return err
+		}
+	}
+
+	keyID := s.KMS.ActiveKeyID()
+	wrapped, err := s.KMS.Encrypt(ctx, keyID, inner)
+	if err != nil {
+		return err
+	}
+	k.DataKey = models.DataKey{WrappedKey: wrapped, KeyID: keyID, KeyAlgorithm: s.KMS.Algorithm()}
+	return nil
+}
+
+func passphraseKEK(k *models.UserKey, passphrase string) []byte {
+	return argon2.IDKey([]byte(passphrase), k.PassphraseSalt, k.KDFTime, k.KDFMemory, k.KDFThreads, utils.DataKeySize)
+}
+
+// open recovers the key encryption key held in k.
+func (s *UserKeyService) open(ctx context.Context, k *models.UserKey, passphrase string) ([]byte, error) {
+	if !s.KMS.HasKey(k.KeyID) || k.KeyAlgorithm != s.KMS.Algorithm() {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownKey, k.KeyID)
+	}
+	inner, err := s.KMS.Decrypt(ctx, k.KeyID, k.WrappedKey)
+	if err != nil {
+		return nil, err
+	}
+	if !k.PassphraseProtected() {
+		return inner, nil
+	}
+	if passphrase == "" {
+		return nil, ErrPassphraseRequired
+	}
+	kek, err := utils.UnwrapKey(passphraseKEK(k, passphrase), inner)
+	if err != nil {
+		return nil, ErrWrongPassphrase
+	}
+	return kek, nil
+}
+
+// Key returns the user's key record. Users registered before per-user keys
+// get an unprotected one on first use; deleted users get ErrKeyDestroyed.
+func (s *UserKeyService) Key(ctx context.Context, userID string) (*models.UserKey, error) {
+	k, err := s.UserRepo.GetUserKey(ctx, userID)
+	if !errors.Is(err, repositories.ErrNotFound) {
+		return k, err
+	}
+
+	if k, err = s.NewKey(ctx, ""); err != nil {
+		return nil, err
+	}
+	k.UserID = userID
+	created, err := s.UserRepo.CreateUserKey(ctx, k)
+	if err != nil {
+		return nil, err
+	}
+	if created {
+		utils.Info.Info().Str("user_id", userID).Msg("created user key")
+		return k, nil
+	}
+
+	// created concurrently, or the user is gone
+	k, err = s.UserRepo.GetUserKey(ctx, userID)
+	if errors.Is(err, repositories.ErrNotFound) {
+		return nil, ErrKeyDestroyed
+	}
+	return k, err
+}
+
+// KEK opens the user's key encryption key with the passphrase attached to ctx
+// by WithPassphrase.
+func (s *UserKeyService) KEK(ctx context.Context, userID string) ([]byte, error) {
+	k, err := s.Key(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	return s.open(ctx, k, passphraseFrom(ctx))
+}
+
+// SetPassphrase re-seals the user's key under next, or removes passphrase
+// protection when next is empty. current must open the key if it is
+// protected. The key itself, and so every data key under it, is unchanged.
+func (s *UserKeyService) SetPassphrase(ctx context.Context, userID, current, next string) (*models.UserKey, error) {
+	k, err := s.Key(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	kek, err := s.open(ctx, k, current)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.seal(ctx, k, kek, next); err != nil {
+		return nil, err
+	}
+	if err := s.UserRepo.UpdateUserKey(ctx, k); err != nil {
+		if errors.Is(err, repositories.ErrNotFound) {
+			return nil, ErrKeyDestroyed
+		}
+		return nil, err
+	}
+	return k, nil
+}
+
+// rewrap moves k from the master key wrapping it to toKeyID. The passphrase
+// layer, if any, stays sealed.
+func (s *UserKeyService) rewrap(ctx context.Context, k *models.UserKey, toKeyID string) error {
+	if !s.KMS.HasKey(k.KeyID) {
+		return fmt.Errorf("%w: %s", ErrUnknownKey, k.KeyID)
+	}
+	inner, err := s.KMS.Decrypt(ctx, k.KeyID, k.WrappedKey)
+	if err != nil {
+		return err
+	}
+	wrapped, err := s.KMS.Encrypt(ctx, toKeyID, inner)
+	if err != nil {
+		return err
+	}
+	_, err = s.UserRepo.RewrapUserKey(ctx, k, models.DataKey{WrappedKey: wrapped, KeyID: toKeyID, KeyAlgorithm: s.KMS.Algorithm()})
+	return err
+}
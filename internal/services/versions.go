@@ -0,0 +1,269 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/SrabanMondal/SecureStore/internal/models"
+	"github.com/SrabanMondal/SecureStore/internal/repository"
+	"github.com/SrabanMondal/SecureStore/internal/utils"
+)
+
+// pending versions of existing files that were never finalized are dropped
+// by PruneVersions after this long
+const pendingVersionExpiry = 24 * time.Hour
+
+var (
+	ErrFileBusy           = errors.New("file has an upload or deletion in progress")
+	ErrVersionNotFound    = errors.New("version not found")
+	ErrVersionNotUploaded = errors.New("version has not been uploaded")
+)
+
+// RetentionPolicy limits how long deleted data lingers. KeepVersions counts
+// the current version, and zero disables either version limit. Trashed files
+// are purged after TrashFor.
+type RetentionPolicy struct {
+	KeepVersions    int
+	KeepVersionsFor time.Duration
+	TrashFor        time.Duration
+}
+
+// objectKey builds a storage key that is never reused, so every version
+// keeps its own immutable object. It is random below the owner's ID and says
+// nothing about the file's name.
+func objectKey(userID string) (string, error) {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return "", err
+	}
+	return userID + "/" + hex.EncodeToString(id), nil
+}
+
+func (s *FileService) ownedFile(ctx context.Context, userID, fileID string) (*models.File, error) {
+	file, err := s.FileRepo.GetFileByID(ctx, fileID)
+	if err != nil || !canAccessFile(userID, file) {
+		return nil, ErrFileNotFound
+	}
+	return file, nil
+}
+
+// prepareUpload returns the file at filePath and the version an upload should
+// be written to. A new path gets a new file whose first version is returned;
+// an existing file gets a new version next to its current one. status is the
+// initial status of the new file or version ("" means pending). encrypted has
+// the server seal the object under a new data key; a non-nil client marks it
+// as ciphertext encrypted by the client under that key. The version's size is
+// charged to the user's quota until it is deleted.
+func (s *FileService) prepareUpload(ctx context.Context, userID, filePath string, size int64, encrypted bool, client *models.ClientKey, status string) (*models.File, *models.FileVersion, error) {
+	if size < 0 || size > maxObjectSize {
+		return nil, nil, fmt.Errorf("%w: must be between 0 and %d bytes", ErrInvalidSize, int64(maxObjectSize))
+	}
+	mode, ck := models.EncryptionNone, models.ClientKey{}
+	switch {
+	case client != nil:
+		if err := validateClientKey(client); err != nil {
+			return nil, nil, err
+		}
+		mode, ck = models.EncryptionClient, *client
+	case encrypted:
+		mode = models.EncryptionServer
+	}
+	filePath, err := utils.NormalizePath(filePath)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := s.CheckQuota(ctx, userID, size, encrypted); err != nil {
+		return nil, nil, err
+	}
+	var dk models.DataKey
+	if encrypted {
+		if dk, err = s.newDataKey(ctx, userID); err != nil {
+			return nil, nil, err
+		}
+	}
+	storageKey, err := objectKey(userID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	file, err := s.FileRepo.GetFileByPath(ctx, userID, filePath)
+	if errors.Is(err, repositories.ErrNotFound) {
+		file = &models.File{
+			UserID:      userID,
+			FilePath:    filePath,
+			Size:        size,
+			IsEncrypted: encrypted,
+			StorageKey:  storageKey,
+			Status:      status,
+			DataKey:     dk,
+
+			EncryptionMode: mode,
+			ClientKey:      ck,
+		}
+		if err := s.FileRepo.CreateFile(ctx, file); err != nil {
+			if errors.Is(err, repositories.ErrPathConflict) {
+				return nil, nil, ErrPathConflict
+			}
+			return nil, nil, quotaError(err)
+		}
+		return file, &models.FileVersion{
+			FileID:         file.ID,
+			Version:        file.CurrentVersion,
+			StorageKey:     storageKey,
+			Size:           size,
+			IsEncrypted:    encrypted,
+			EncryptionMode: mode,
+			ClientKey:      ck,
+			Status:         file.Status,
+			CreatedAt:      file.CreatedAt,
+			DataKey:        dk,
+		}, nil
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	if file.Status == "trashed" {
+		return nil, nil, fmt.Errorf("%w: restore or delete the trashed file first", ErrFileBusy)
+	}
+	if file.Status != "uploaded" {
+		return nil, nil, ErrFileBusy
+	}
+
+	version := &models.FileVersion{
+		FileID:         file.ID,
+		StorageKey:     storageKey,
+		Size:           size,
+		IsEncrypted:    encrypted,
+		EncryptionMode: mode,
+		ClientKey:      ck,
+		Status:         status,
+		DataKey:        dk,
+	}
+	if err := s.FileRepo.CreateVersion(ctx, version); err != nil {
+		return nil, nil, quotaError(err)
+	}
+	return file, version, nil
+}
+
+// discardVersion drops a version whose upload failed or was abandoned. When it
+// is the only version of a file that never finished uploading, the file goes
+// with it.
+func (s *FileService) discardVersion(ctx context.Context, fileID string, version int) error {
+	file, err := s.FileRepo.GetFileByID(ctx, fileID)
+	if err != nil {
+		return err
+	}
+	if file.Status != "uploaded" && file.CurrentVersion == version {
+		return s.FileRepo.DeleteFile(ctx, fileID)
+	}
+	return s.FileRepo.DeleteVersion(ctx, fileID, version)
+}
+
+func (s *FileService) ListVersions(ctx context.Context, userID, fileID string) ([]models.FileVersion, error) {
+	file, err := s.ownedFile(ctx, userID, fileID)
+	if err != nil {
+		return nil, err
+	}
+
+	versions, err := s.FileRepo.ListVersions(ctx, file.ID)
+	if err != nil {
+		return nil, err
+	}
+	for i := range versions {
+		versions[i].IsCurrent = versions[i].Version == file.CurrentVersion
+	}
+	return versions, nil
+}
+
+func (s *FileService) uploadedVersion(ctx context.Context, file *models.File, version int) (*models.FileVersion, error) {
+	v, err := s.FileRepo.GetVersion(ctx, file.ID, version)
+	if errors.Is(err, repositories.ErrNotFound) {
+		return nil, ErrVersionNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if v.Status != "uploaded" {
+		return nil, ErrVersionNotUploaded
+	}
+	return v, nil
+}
+
+// VersionFile returns the file as it was at the given version, suitable for
+// handing to the download paths.
+func (s *FileService) VersionFile(ctx context.Context, userID, fileID string, version int) (*models.File, error) {
+	file, err := s.ownedFile(ctx, userID, fileID)
+	if err != nil {
+		return nil, err
+	}
+	v, err := s.uploadedVersion(ctx, file, version)
+	if err != nil {
+		return nil, err
+	}
+
+	file.CurrentVersion = v.Version
+	file.StorageKey = v.StorageKey
+	file.Size = v.Size
+	file.IsEncrypted = v.IsEncrypted
+	file.EncryptionMode = v.EncryptionMode
+	file.ClientKey = v.ClientKey
+	file.ContentType = v.ContentType
+	file.ChecksumSHA256 = v.ChecksumSHA256
+	file.ChecksumCRC32C = v.ChecksumCRC32C
+	file.UploadedAt = v.UploadedAt
+	file.DataKey = v.DataKey
+	return file, nil
+}
+
+// RestoreVersion makes an earlier version current again. The versions in
+// between are kept and remain subject to retention.
+func (s *FileService) RestoreVersion(ctx context.Context, userID, fileID string, version int) (*models.File, error) {
+	file, err := s.ownedFile(ctx, userID, fileID)
+	if err != nil {
+		return nil, err
+	}
+	if file.Status != "uploaded" {
+		return nil, ErrFileBusy
+	}
+	v, err := s.uploadedVersion(ctx, file, version)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.FileRepo.RestoreVersion(ctx, file, v); errors.Is(err, repositories.ErrNotFound) {
+		return nil, ErrFileBusy
+	} else if err != nil {
+		return nil, err
+	}
+	return file, nil
+}
+
+// PruneVersions removes old versions outside the retention policy, along
+// with versions of existing files whose upload was never finalized.
+func (s *FileService) PruneVersions(ctx context.Context) error {
+	var olderThan time.Time
+	if s.Retention.KeepVersionsFor > 0 {
+		olderThan = time.Now().Add(-s.Retention.KeepVersionsFor)
+	}
+
+	versions, err := s.FileRepo.ListPrunableVersions(ctx, s.Retention.KeepVersions, olderThan, time.Now().Add(-pendingVersionExpiry))
+	if err != nil {
+		return err
+	}
+
+	for _, v := range versions {
+		if err := s.Storage.Remove(ctx, v.StorageKey); err != nil {
+			utils.Error.Err(err).Str("file_id", v.FileID).Int("version", v.Version).Msg("failed to remove version object")
+			continue
+		}
+		if err := s.FileRepo.DeleteVersion(ctx, v.FileID, v.Version); err != nil {
+			continue
+		}
+		utils.Info.Info().Str("file_id", v.FileID).Int("version", v.Version).Msg("pruned file version")
+	}
+	return nil
+}
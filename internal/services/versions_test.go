@@ -0,0 +1,38 @@
+package services
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/SrabanMondal/SecureStore/internal/repository"
+)
+
+func TestFinalizeTrashedFile(t *testing.T) {
+	e := newTestEnv(t)
+	alice := e.register(t, "alice")
+
+	file := e.upload(t, alice.ID, "/report.txt", []byte("report"))
+	_, v := e.presign(t, alice.ID, "/report.txt", 6, []byte("update"))
+	if _, err := e.files.DeleteFile(e.ctx, alice.ID, file.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := e.files.FinalizeUpload(e.ctx, alice.ID, file.ID, v.Version, "", ""); !errors.Is(err, ErrFileBusy) {
+		t.Errorf("finalizing a version of a trashed file = %v, want ErrFileBusy", err)
+	}
+	if got := e.fileStatus(t, file.ID); got != "trashed" {
+		t.Errorf("file status = %q, want trashed", got)
+	}
+
+	// nor does a finalize already past the status check bring it back
+	trashed, err := e.fileRepo.GetFileByID(e.ctx, file.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := e.fileRepo.PromoteVersion(e.ctx, trashed, v); !errors.Is(err, repositories.ErrNotFound) {
+		t.Errorf("PromoteVersion on a trashed file = %v, want ErrNotFound", err)
+	}
+	if got := e.fileStatus(t, file.ID); got != "trashed" {
+		t.Errorf("file status = %q, want trashed", got)
+	}
+}
@@ -0,0 +1,348 @@
+package storage
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	ErrInvalidKey       = errors.New("invalid object key")
+	ErrInvalidSignature = errors.New("invalid or expired signature")
+)
+
+// LocalBackend stores objects as files under Root. Presigned URLs point back at
+// the app itself (see handlers.StorageHandler) and are signed with HMAC-SHA256.
+type LocalBackend struct {
+	Root       string
+	BaseURL    string
+	SigningKey []byte
+}
+
+func NewLocalBackend(root, baseURL string, signingKey []byte) (*LocalBackend, error) {
+	if err := os.MkdirAll(root, 0o750); err != nil {
+		return nil, err
+	}
+	return &LocalBackend{
+		Root:       root,
+		BaseURL:    strings.TrimRight(baseURL, "/"),
+		SigningKey: signingKey,
+	}, nil
+}
+
+const localMultipartDir = ".multipart"
+
+func (b *LocalBackend) path(key string) (string, error) {
+	if key == "" || strings.HasPrefix(key, "/") {
+		return "", ErrInvalidKey
+	}
+	segs := strings.Split(key, "/")
+	if segs[0] == localMultipartDir {
+		return "", ErrInvalidKey
+	}
+	for _, seg := range segs {
+		if seg == "" || seg == "." || seg == ".." {
+			return "", ErrInvalidKey
+		}
+	}
+	return filepath.Join(b.Root, filepath.FromSlash(key)), nil
+}
+
+func (b *LocalBackend) Put(ctx context.Context, key string, r io.Reader, size int64, contentType string) error {
+	p, err := b.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(p), 0o750); err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(p), ".upload-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	n, err := io.Copy(tmp, r)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	if size >= 0 && n != size {
+		return fmt.Errorf("size mismatch: expected %d bytes, got %d", size, n)
+	}
+
+	return os.Rename(tmp.Name(), p)
+}
+
+// Open returns the underlying file, for callers that need io.ReadSeeker.
+func (b *LocalBackend) Open(key string) (*os.File, error) {
+	p, err := b.path(key)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(p)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrNotFound
+	}
+	return f, err
+}
+
+func (b *LocalBackend) Get(ctx context.Context, key string, opts GetOptions) (io.ReadCloser, error) {
+	f, err := b.Open(key)
+	if err != nil {
+		return nil, err
+	}
+	if opts.Offset > 0 {
+		if _, err := f.Seek(opts.Offset, io.SeekStart); err != nil {
+			f.Close()
+			return nil, err
+		}
+	}
+	if opts.Length > 0 {
+		return struct {
+			io.Reader
+			io.Closer
+		}{io.LimitReader(f, opts.Length), f}, nil
+	}
+	return f, nil
+}
+
+func (b *LocalBackend) Stat(ctx context.Context, key string) (*ObjectInfo, error) {
+	f, err := b.Open(key)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	sniff := make([]byte, 512)
+	n, _ := io.ReadFull(f, sniff)
+
+	return &ObjectInfo{
+		Key:          key,
+		Size:         fi.Size(),
+		ETag:         fmt.Sprintf("%x-%x", fi.ModTime().UnixNano(), fi.Size()),
+		ContentType:  http.DetectContentType(sniff[:n]),
+		LastModified: fi.ModTime(),
+	}, nil
+}
+
+func (b *LocalBackend) Remove(ctx context.Context, key string) error {
+	p, err := b.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(p); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+func (b *LocalBackend) PresignedPut(ctx context.Context, key string, expiry time.Duration) (string, error) {
+	return b.presign(http.MethodPut, key, expiry)
+}
+
+func (b *LocalBackend) PresignedGet(ctx context.Context, key string, expiry time.Duration) (string, error) {
+	return b.presign(http.MethodGet, key, expiry)
+}
+
+func (b *LocalBackend) presign(method, key string, expiry time.Duration) (string, error) {
+	return b.presignQuery(method, key, expiry, url.Values{})
+}
+
+func (b *LocalBackend) presignQuery(method, key string, expiry time.Duration, q url.Values) (string, error) {
+	if _, err := b.path(key); err != nil {
+		return "", err
+	}
+	q.Set("expires", strconv.FormatInt(time.Now().Add(expiry).Unix(), 10))
+	q.Set("signature", b.sign(method, key, q))
+	return fmt.Sprintf("%s/api/storage/%s?%s", b.BaseURL, escapeKey(key), q.Encode()), nil
+}
+
+func (b *LocalBackend) sign(method, key string, q url.Values) string {
+	mac := hmac.New(sha256.New, b.SigningKey)
+	mac.Write([]byte(strings.Join([]string{
+		method, key, q.Get("expires"), q.Get("upload_id"), q.Get("part_number"),
+	}, "\n")))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// Verify checks the query of a URL produced by one of the Presigned methods.
+func (b *LocalBackend) Verify(method, key string, q url.Values) error {
+	exp, err := strconv.ParseInt(q.Get("expires"), 10, 64)
+	if err != nil || time.Now().Unix() > exp {
+		return ErrInvalidSignature
+	}
+	if !hmac.Equal([]byte(b.sign(method, key, q)), []byte(q.Get("signature"))) {
+		return ErrInvalidSignature
+	}
+	return nil
+}
+
+func escapeKey(key string) string {
+	segs := strings.Split(key, "/")
+	for i, s := range segs {
+		segs[i] = url.PathEscape(s)
+	}
+	return strings.Join(segs, "/")
+}
+
+func (b *LocalBackend) uploadDir(uploadID string) (string, error) {
+	if uploadID == "" || strings.ContainsAny(uploadID, "/\\.") {
+		return "", ErrUploadNotFound
+	}
+	return filepath.Join(b.Root, localMultipartDir, uploadID), nil
+}
+
+// checkUpload ensures uploadID exists and was started for key.
+func (b *LocalBackend) checkUpload(key, uploadID string) (string, error) {
+	dir, err := b.uploadDir(uploadID)
+	if err != nil {
+		return "", err
+	}
+	owner, err := os.ReadFile(filepath.Join(dir, "key"))
+	if err != nil || string(owner) != key {
+		return "", ErrUploadNotFound
+	}
+	return dir, nil
+}
+
+func (b *LocalBackend) NewMultipartUpload(ctx context.Context, key, contentType string) (string, error) {
+	if _, err := b.path(key); err != nil {
+		return "", err
+	}
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return "", err
+	}
+	uploadID := hex.EncodeToString(id)
+
+	dir, _ := b.uploadDir(uploadID)
+	if err := os.MkdirAll(dir, 0o750); err != nil {
+		return "", err
+	}
+	if err := os.WriteFile(filepath.Join(dir, "key"), []byte(key), 0o640); err != nil {
+		return "", err
+	}
+	return uploadID, nil
+}
+
+func (b *LocalBackend) PresignedUploadPart(ctx context.Context, key, uploadID string, part int, expiry time.Duration) (string, error) {
+	q := url.Values{}
+	q.Set("upload_id", uploadID)
+	q.Set("part_number", strconv.Itoa(part))
+	return b.presignQuery(http.MethodPut, key, expiry, q)
+}
+
+func (b *LocalBackend) UploadPart(ctx context.Context, key, uploadID string, part int, r io.Reader, size int64) (*Part, error) {
+	dir, err := b.checkUpload(key, uploadID)
+	if err != nil {
+		return nil, err
+	}
+
+	tmp, err := os.CreateTemp(dir, ".part-*")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(tmp.Name())
+
+	h := md5.New()
+	n, err := io.Copy(io.MultiWriter(tmp, h), r)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return nil, err
+	}
+	if size >= 0 && n != size {
+		return nil, fmt.Errorf("size mismatch: expected %d bytes, got %d", size, n)
+	}
+	if err := os.Rename(tmp.Name(), filepath.Join(dir, strconv.Itoa(part))); err != nil {
+		return nil, err
+	}
+
+	return &Part{Number: part, ETag: hex.EncodeToString(h.Sum(nil)), Size: n}, nil
+}
+
+func (b *LocalBackend) ListParts(ctx context.Context, key, uploadID string) ([]Part, error) {
+	dir, err := b.checkUpload(key, uploadID)
+	if err != nil {
+		return nil, err
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var parts []Part
+	for _, e := range entries {
+		n, err := strconv.Atoi(e.Name())
+		if err != nil {
+			continue
+		}
+		f, err := os.Open(filepath.Join(dir, e.Name()))
+		if err != nil {
+			return nil, err
+		}
+		h := md5.New()
+		size, err := io.Copy(h, f)
+		f.Close()
+		if err != nil {
+			return nil, err
+		}
+		parts = append(parts, Part{Number: n, ETag: hex.EncodeToString(h.Sum(nil)), Size: size})
+	}
+	sort.Slice(parts, func(i, j int) bool { return parts[i].Number < parts[j].Number })
+	return parts, nil
+}
+
+func (b *LocalBackend) CompleteMultipartUpload(ctx context.Context, key, uploadID string, parts []Part) error {
+	dir, err := b.checkUpload(key, uploadID)
+	if err != nil {
+		return err
+	}
+
+	readers := make([]io.Reader, 0, len(parts))
+	for _, p := range parts {
+		f, err := os.Open(filepath.Join(dir, strconv.Itoa(p.Number)))
+		if err != nil {
+			return fmt.Errorf("part %d missing", p.Number)
+		}
+		defer f.Close()
+		readers = append(readers, f)
+	}
+
+	if err := b.Put(ctx, key, io.MultiReader(readers...), -1, ""); err != nil {
+		return err
+	}
+	return os.RemoveAll(dir)
+}
+
+func (b *LocalBackend) AbortMultipartUpload(ctx context.Context, key, uploadID string) error {
+	dir, err := b.checkUpload(key, uploadID)
+	if err != nil {
+		return err
+	}
+	return os.RemoveAll(dir)
+}
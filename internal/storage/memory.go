@@ -0,0 +1,218 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/url"
+	"sort"
+	"sync"
+	"time"
+)
+
+type memObject struct {
+	data        []byte
+	contentType string
+	modTime     time.Time
+}
+
+// MemoryBackend keeps objects in a map. It is meant for tests and local runs
+// where nothing needs to survive a restart; presigned URLs are not reachable.
+type MemoryBackend struct {
+	mu      sync.RWMutex
+	objects map[string]memObject
+	uploads map[string]*memUpload
+}
+
+type memUpload struct {
+	key         string
+	contentType string
+	parts       map[int][]byte
+}
+
+func NewMemoryBackend() *MemoryBackend {
+	return &MemoryBackend{
+		objects: make(map[string]memObject),
+		uploads: make(map[string]*memUpload),
+	}
+}
+
+func (b *MemoryBackend) Put(ctx context.Context, key string, r io.Reader, size int64, contentType string) error {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	if size >= 0 && int64(len(data)) != size {
+		return fmt.Errorf("size mismatch: expected %d bytes, got %d", size, len(data))
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.objects[key] = memObject{data: data, contentType: contentType, modTime: time.Now()}
+	return nil
+}
+
+func (b *MemoryBackend) Get(ctx context.Context, key string, opts GetOptions) (io.ReadCloser, error) {
+	b.mu.RLock()
+	obj, ok := b.objects[key]
+	b.mu.RUnlock()
+	if !ok {
+		return nil, ErrNotFound
+	}
+
+	data := obj.data
+	if opts.Offset > int64(len(data)) {
+		data = nil
+	} else {
+		data = data[opts.Offset:]
+	}
+	if opts.Length > 0 && opts.Length < int64(len(data)) {
+		data = data[:opts.Length]
+	}
+	return io.NopCloser(bytes.NewReader(data)), nil
+}
+
+func (b *MemoryBackend) Stat(ctx context.Context, key string) (*ObjectInfo, error) {
+	b.mu.RLock()
+	obj, ok := b.objects[key]
+	b.mu.RUnlock()
+	if !ok {
+		return nil, ErrNotFound
+	}
+
+	sum := md5.Sum(obj.data)
+	return &ObjectInfo{
+		Key:          key,
+		Size:         int64(len(obj.data)),
+		ETag:         hex.EncodeToString(sum[:]),
+		ContentType:  obj.contentType,
+		LastModified: obj.modTime,
+	}, nil
+}
+
+func (b *MemoryBackend) Remove(ctx context.Context, key string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	delete(b.objects, key)
+	return nil
+}
+
+func (b *MemoryBackend) PresignedPut(ctx context.Context, key string, expiry time.Duration) (string, error) {
+	return memoryURL("PUT", key, expiry), nil
+}
+
+func (b *MemoryBackend) PresignedGet(ctx context.Context, key string, expiry time.Duration) (string, error) {
+	return memoryURL("GET", key, expiry), nil
+}
+
+func memoryURL(method, key string, expiry time.Duration) string {
+	return memoryURLQuery(method, key, expiry, url.Values{})
+}
+
+func memoryURLQuery(method, key string, expiry time.Duration, q url.Values) string {
+	q.Set("method", method)
+	q.Set("expires", fmt.Sprint(time.Now().Add(expiry).Unix()))
+	return "memory:///" + escapeKey(key) + "?" + q.Encode()
+}
+
+func (b *MemoryBackend) upload(key, uploadID string) (*memUpload, error) {
+	up, ok := b.uploads[uploadID]
+	if !ok || up.key != key {
+		return nil, ErrUploadNotFound
+	}
+	return up, nil
+}
+
+func (b *MemoryBackend) NewMultipartUpload(ctx context.Context, key, contentType string) (string, error) {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return "", err
+	}
+	uploadID := hex.EncodeToString(id)
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.uploads[uploadID] = &memUpload{key: key, contentType: contentType, parts: make(map[int][]byte)}
+	return uploadID, nil
+}
+
+func (b *MemoryBackend) PresignedUploadPart(ctx context.Context, key, uploadID string, part int, expiry time.Duration) (string, error) {
+	q := url.Values{}
+	q.Set("upload_id", uploadID)
+	q.Set("part_number", fmt.Sprint(part))
+	return memoryURLQuery("PUT", key, expiry, q), nil
+}
+
+func (b *MemoryBackend) UploadPart(ctx context.Context, key, uploadID string, part int, r io.Reader, size int64) (*Part, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	if size >= 0 && int64(len(data)) != size {
+		return nil, fmt.Errorf("size mismatch: expected %d bytes, got %d", size, len(data))
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	up, err := b.upload(key, uploadID)
+	if err != nil {
+		return nil, err
+	}
+	up.parts[part] = data
+
+	sum := md5.Sum(data)
+	return &Part{Number: part, ETag: hex.EncodeToString(sum[:]), Size: int64(len(data))}, nil
+}
+
+func (b *MemoryBackend) ListParts(ctx context.Context, key, uploadID string) ([]Part, error) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	up, err := b.upload(key, uploadID)
+	if err != nil {
+		return nil, err
+	}
+
+	parts := make([]Part, 0, len(up.parts))
+	for n, data := range up.parts {
+		sum := md5.Sum(data)
+		parts = append(parts, Part{Number: n, ETag: hex.EncodeToString(sum[:]), Size: int64(len(data))})
+	}
+	sort.Slice(parts, func(i, j int) bool { return parts[i].Number < parts[j].Number })
+	return parts, nil
+}
+
+func (b *MemoryBackend) CompleteMultipartUpload(ctx context.Context, key, uploadID string, parts []Part) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	up, err := b.upload(key, uploadID)
+	if err != nil {
+		return err
+	}
+
+	var buf bytes.Buffer
+	for _, p := range parts {
+		data, ok := up.parts[p.Number]
+		if !ok {
+			return fmt.Errorf("part %d missing", p.Number)
+		}
+		buf.Write(data)
+	}
+
+	b.objects[key] = memObject{data: buf.Bytes(), contentType: up.contentType, modTime: time.Now()}
+	delete(b.uploads, uploadID)
+	return nil
+}
+
+func (b *MemoryBackend) AbortMultipartUpload(ctx context.Context, key, uploadID string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if _, err := b.upload(key, uploadID); err != nil {
+		return err
+	}
+	delete(b.uploads, uploadID)
+	return nil
+}
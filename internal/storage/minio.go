@@ -0,0 +1,161 @@
+package storage
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+)
+
+// part size for objects put without a known size, which caps them at
+// 10000 parts, about 156 GiB
+const unknownSizePartSize = 16 << 20
+
+type MinioBackend struct {
+	Client *minio.Client
+	Bucket string
+}
+
+func NewMinioBackend(client *minio.Client, bucket string) *MinioBackend {
+	return &MinioBackend{Client: client, Bucket: bucket}
+}
+
+func (b *MinioBackend) Put(ctx context.Context, key string, r io.Reader, size int64, contentType string) error {
+	opts := minio.PutObjectOptions{ContentType: contentType}
+	if size < 0 {
+		// minio-go sizes parts, and the buffer it holds one in, for the
+		// largest object it can store unless told otherwise
+		opts.PartSize = unknownSizePartSize
+	}
+	_, err := b.Client.PutObject(ctx, b.Bucket, key, r, size, opts)
+	return err
+}
+
+func (b *MinioBackend) Get(ctx context.Context, key string, opts GetOptions) (io.ReadCloser, error) {
+	getOpts := minio.GetObjectOptions{}
+	if opts.Offset > 0 || opts.Length > 0 {
+		end := int64(0)
+		if opts.Length > 0 {
+			end = opts.Offset + opts.Length - 1
+		}
+		if err := getOpts.SetRange(opts.Offset, end); err != nil {
+			return nil, err
+		}
+	}
+
+	obj, err := b.Client.GetObject(ctx, b.Bucket, key, getOpts)
+	if err != nil {
+		return nil, mapMinioErr(err)
+	}
+	return obj, nil
+}
+
+func (b *MinioBackend) Stat(ctx context.Context, key string) (*ObjectInfo, error) {
+	info, err := b.Client.StatObject(ctx, b.Bucket, key, minio.StatObjectOptions{})
+	if err != nil {
+		return nil, mapMinioErr(err)
+	}
+	return &ObjectInfo{
+		Key:          key,
+		Size:         info.Size,
+		ETag:         info.ETag,
+		ContentType:  info.ContentType,
+		LastModified: info.LastModified,
+	}, nil
+}
+
+func (b *MinioBackend) Remove(ctx context.Context, key string) error {
+	return b.Client.RemoveObject(ctx, b.Bucket, key, minio.RemoveObjectOptions{})
+}
+
+func (b *MinioBackend) PresignedPut(ctx context.Context, key string, expiry time.Duration) (string, error) {
+	u, err := b.Client.PresignedPutObject(ctx, b.Bucket, key, expiry)
+	if err != nil {
+		return "", err
+	}
+	return u.String(), nil
+}
+
+func (b *MinioBackend) PresignedGet(ctx context.Context, key string, expiry time.Duration) (string, error) {
+	u, err := b.Client.PresignedGetObject(ctx, b.Bucket, key, expiry, nil)
+	if err != nil {
+		return "", err
+	}
+	return u.String(), nil
+}
+
+func mapMinioErr(err error) error {
+	if err == nil {
+		return nil
+	}
+	switch minio.ToErrorResponse(err).Code {
+	case "NoSuchKey":
+		return ErrNotFound
+	case "NoSuchUpload":
+		return ErrUploadNotFound
+	}
+	return err
+}
+
+func (b *MinioBackend) core() minio.Core {
+	return minio.Core{Client: b.Client}
+}
+
+func (b *MinioBackend) NewMultipartUpload(ctx context.Context, key, contentType string) (string, error) {
+	return b.core().NewMultipartUpload(ctx, b.Bucket, key, minio.PutObjectOptions{ContentType: contentType})
+}
+
+func (b *MinioBackend) PresignedUploadPart(ctx context.Context, key, uploadID string, part int, expiry time.Duration) (string, error) {
+	params := url.Values{}
+	params.Set("uploadId", uploadID)
+	params.Set("partNumber", strconv.Itoa(part))
+
+	u, err := b.Client.Presign(ctx, http.MethodPut, b.Bucket, key, expiry, params)
+	if err != nil {
+		return "", err
+	}
+	return u.String(), nil
+}
+
+func (b *MinioBackend) UploadPart(ctx context.Context, key, uploadID string, part int, r io.Reader, size int64) (*Part, error) {
+	p, err := b.core().PutObjectPart(ctx, b.Bucket, key, uploadID, part, r, size, minio.PutObjectPartOptions{})
+	if err != nil {
+		return nil, mapMinioErr(err)
+	}
+	return &Part{Number: p.PartNumber, ETag: p.ETag, Size: p.Size}, nil
+}
+
+func (b *MinioBackend) ListParts(ctx context.Context, key, uploadID string) ([]Part, error) {
+	var parts []Part
+	marker := 0
+	for {
+		res, err := b.core().ListObjectParts(ctx, b.Bucket, key, uploadID, marker, 1000)
+		if err != nil {
+			return nil, mapMinioErr(err)
+		}
+		for _, p := range res.ObjectParts {
+			parts = append(parts, Part{Number: p.PartNumber, ETag: p.ETag, Size: p.Size})
+		}
+		if !res.IsTruncated {
+			return parts, nil
+		}
+		marker = res.NextPartNumberMarker
+	}
+}
+
+func (b *MinioBackend) CompleteMultipartUpload(ctx context.Context, key, uploadID string, parts []Part) error {
+	complete := make([]minio.CompletePart, len(parts))
+	for i, p := range parts {
+		complete[i] = minio.CompletePart{PartNumber: p.Number, ETag: p.ETag}
+	}
+	_, err := b.core().CompleteMultipartUpload(ctx, b.Bucket, key, uploadID, complete, minio.PutObjectOptions{})
+	return mapMinioErr(err)
+}
+
+func (b *MinioBackend) AbortMultipartUpload(ctx context.Context, key, uploadID string) error {
+	return mapMinioErr(b.core().AbortMultipartUpload(ctx, b.Bucket, key, uploadID))
+}
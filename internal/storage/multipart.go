@@ -0,0 +1,28 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"io"
+	"time"
+)
+
+var ErrUploadNotFound = errors.New("multipart upload not found")
+
+type Part struct {
+	Number int    `json:"part_number"`
+	ETag   string `json:"etag"`
+	Size   int64  `json:"size"`
+}
+
+// MultipartBackend is implemented by backends that support S3-style multipart
+// uploads, where parts are sent independently (usually via presigned URLs) and
+// stitched together on completion.
+type MultipartBackend interface {
+	NewMultipartUpload(ctx context.Context, key, contentType string) (string, error)
+	PresignedUploadPart(ctx context.Context, key, uploadID string, part int, expiry time.Duration) (string, error)
+	UploadPart(ctx context.Context, key, uploadID string, part int, r io.Reader, size int64) (*Part, error)
+	ListParts(ctx context.Context, key, uploadID string) ([]Part, error)
+	CompleteMultipartUpload(ctx context.Context, key, uploadID string, parts []Part) error
+	AbortMultipartUpload(ctx context.Context, key, uploadID string) error
+}
@@ -0,0 +1,35 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"io"
+	"time"
+)
+
+var ErrNotFound = errors.New("object not found")
+
+type ObjectInfo struct {
+	Key          string
+	Size         int64
+	ETag         string
+	ContentType  string
+	LastModified time.Time
+}
+
+// GetOptions selects a byte range of an object. A zero Length reads to the end.
+type GetOptions struct {
+	Offset int64
+	Length int64
+}
+
+// Backend is the object store behind FileService. Keys are slash separated
+// paths; presigned URLs let clients move bytes without going through the API.
+type Backend interface {
+	Put(ctx context.Context, key string, r io.Reader, size int64, contentType string) error
+	Get(ctx context.Context, key string, opts GetOptions) (io.ReadCloser, error)
+	Stat(ctx context.Context, key string) (*ObjectInfo, error)
+	Remove(ctx context.Context, key string) error
+	PresignedPut(ctx context.Context, key string, expiry time.Duration) (string, error)
+	PresignedGet(ctx context.Context, key string, expiry time.Duration) (string, error)
+}
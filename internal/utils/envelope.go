@@ -0,0 +1,51 @@
+package utils
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+)
+
+// KeyWrapAES256GCM wraps a data key as nonce || ciphertext || tag under an
+// AES-256 key encryption key.
+const KeyWrapAES256GCM = "AES-256-GCM"
+
+const DataKeySize = 32
+
+var ErrKeyUnwrap = errors.New("failed to unwrap data key")
+
+func NewDataKey() ([]byte, error) {
+	key := make([]byte, DataKeySize)
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// KeyID names a key encryption key by a short fingerprint, so a wrapped key
+// can be matched with the key that sealed it.
+func KeyID(kek []byte) string {
+	sum := sha256.Sum256(kek)
+	return hex.EncodeToString(sum[:8])
+}
+
+// WrapKey seals key material of any length under kek.
+func WrapKey(kek, dataKey []byte) ([]byte, error) {
+	ciphertext, nonce, err := Encrypt(dataKey, kek)
+	if err != nil {
+		return nil, err
+	}
+	return append(nonce, ciphertext...), nil
+}
+
+func UnwrapKey(kek, wrapped []byte) ([]byte, error) {
+	if len(wrapped) < legacyNonceSize {
+		return nil, ErrKeyUnwrap
+	}
+	key, err := Decrypt(wrapped[legacyNonceSize:], wrapped[:legacyNonceSize], kek)
+	if err != nil {
+		return nil, ErrKeyUnwrap
+	}
+	return key, nil
+}
@@ -1,16 +1,97 @@
 package utils
 
 import (
+    "crypto/rand"
+    "encoding/hex"
+    "errors"
     "time"
 
     "github.com/golang-jwt/jwt/v5"
 )
 
-func GenerateJWT(userID string, secret string, expiry time.Duration) (string, error) {
-    claims := jwt.MapClaims{
-        "user_id": userID,
-        "exp":     time.Now().Add(expiry).Unix(),
+// clock skew tolerated when checking exp, nbf and iat
+const jwtLeeway = 30 * time.Second
+
+var ErrInvalidToken = errors.New("invalid or expired token")
+
+// JWTSettings are what access tokens are signed and verified with.
+type JWTSettings struct {
+    Keys     *SigningKeys
+    Issuer   string
+    Audience string
+}
+
+// AccessClaims are the claims of an access token the services rely on.
+type AccessClaims struct {
+    UserID    string
+    // empty on tokens issued before roles existed
+    Role      string
+    ID        string
+    ExpiresAt time.Time
+}
+
+type accessTokenClaims struct {
+    UserID string `json:"user_id"`
+    Role   string `json:"role,omitempty"`
+    jwt.RegisteredClaims
+}
+
+// GenerateJWT issues an access token for userID acting in role, signed with
+// the active key and carrying a random jti, so it can be revoked on its own.
+func GenerateJWT(userID, role string, settings JWTSettings, expiry time.Duration) (string, error) {
+    jti := make([]byte, 16)
+    if _, err := rand.Read(jti); err != nil {
+        return "", err
+    }
+    now := time.Now()
+    claims := accessTokenClaims{
+        UserID: userID,
+        Role:   role,
+        RegisteredClaims: jwt.RegisteredClaims{
+            ID:        hex.EncodeToString(jti),
+            Subject:   userID,
+            Issuer:    settings.Issuer,
+            Audience:  jwt.ClaimStrings{settings.Audience},
+            IssuedAt:  jwt.NewNumericDate(now),
+            NotBefore: jwt.NewNumericDate(now),
+            ExpiresAt: jwt.NewNumericDate(now.Add(expiry)),
+        },
+    }
+    key := settings.Keys.Active()
+    token := jwt.NewWithClaims(key.Method, claims)
+    token.Header["kid"] = key.ID
+    return token.SignedString(key.key)
+}
+
+// ParseJWT verifies an access token and returns its claims. The token must
+// name one of the signing keys in kid and be signed with that key's algorithm,
+// and carry the expected issuer and audience and every time claim.
+func ParseJWT(tokenStr string, settings JWTSettings) (*AccessClaims, error) {
+    parser := jwt.NewParser(
+        jwt.WithValidMethods(settings.Keys.Algorithms()),
+        jwt.WithIssuer(settings.Issuer),
+        jwt.WithAudience(settings.Audience),
+        jwt.WithIssuedAt(),
+        jwt.WithExpirationRequired(),
+        jwt.WithLeeway(jwtLeeway),
+    )
+    claims := &accessTokenClaims{}
+    token, err := parser.ParseWithClaims(tokenStr, claims, func(token *jwt.Token) (interface{}, error) {
+        kid, _ := token.Header["kid"].(string)
+        key, ok := settings.Keys.Key(kid)
+        if !ok || token.Method.Alg() != key.Method.Alg() {
+            return nil, ErrInvalidToken
+        }
+        return key.key.Public(), nil
+    })
+    if err != nil || !token.Valid {
+        return nil, ErrInvalidToken
+    }
+
+    // jwt only checks nbf and iat when they are present
+    if claims.UserID == "" || claims.Subject != claims.UserID || claims.ID == "" ||
+        claims.IssuedAt == nil || claims.NotBefore == nil {
+        return nil, ErrInvalidToken
     }
-    token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-    return token.SignedString([]byte(secret))
+    return &AccessClaims{UserID: claims.UserID, Role: claims.Role, ID: claims.ID, ExpiresAt: claims.ExpiresAt.Time}, nil
 }
@@ -0,0 +1,125 @@
+package utils
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+var ErrUnknownKeyID = errors.New("unknown key id")
+
+// Keyring holds the key encryption keys by ID. New data keys are wrapped with
+// the active key; the others remain for unwrapping until rotated away. The
+// legacy key, the first one added, opens objects sealed before data keys
+// existed.
+type Keyring struct {
+	keys        map[string][]byte
+	ids         []string
+	fingerprint map[string]string
+	active      string
+	legacy      string
+}
+
+func NewKeyring() *Keyring {
+	return &Keyring{keys: map[string][]byte{}, fingerprint: map[string]string{}}
+}
+
+// Add registers key under id. The first key added is the legacy key and is
+// active until SetActive says otherwise.
+func (k *Keyring) Add(id string, key []byte) error {
+	if id == "" {
+		return errors.New("key id must not be empty")
+	}
+	if len(key) != 32 {
+		return fmt.Errorf("key %s must be exactly 32 bytes", id)
+	}
+	if _, ok := k.keys[id]; ok {
+		return fmt.Errorf("duplicate key id %s", id)
+	}
+	k.keys[id] = key
+	k.ids = append(k.ids, id)
+	k.fingerprint[KeyID(key)] = id
+	if k.active == "" {
+		k.active, k.legacy = id, id
+	}
+	return nil
+}
+
+func (k *Keyring) SetActive(id string) error {
+	if _, ok := k.keys[id]; !ok {
+		return fmt.Errorf("%w: %s", ErrUnknownKeyID, id)
+	}
+	k.active = id
+	return nil
+}
+
+func (k *Keyring) Active() (string, []byte) {
+	return k.active, k.keys[k.active]
+}
+
+func (k *Keyring) Legacy() []byte {
+	return k.keys[k.legacy]
+}
+
+// Key looks a key up by its ID or, for keys wrapped before the keyring had
+// names for them, by fingerprint.
+func (k *Keyring) Key(id string) ([]byte, bool) {
+	if key, ok := k.keys[id]; ok {
+		return key, true
+	}
+	if name, ok := k.fingerprint[id]; ok {
+		return k.keys[name], true
+	}
+	return nil, false
+}
+
+func (k *Keyring) IDs() []string {
+	return append([]string(nil), k.ids...)
+}
+
+// LoadKeyring reads FILE_ENC_KEYS ("id:base64key,..."), activating
+// FILE_ENC_ACTIVE_KEY or else the last entry. A FILE_ENC_KEY on its own is a
+// ring of one, named by its fingerprint; alongside FILE_ENC_KEYS it is added
+// first the same way and so remains the legacy key.
+func LoadKeyring() *Keyring {
+	ring := NewKeyring()
+	if os.Getenv("FILE_ENC_KEY") != "" || os.Getenv("FILE_ENC_KEYS") == "" {
+		key := LoadKey()
+		if err := ring.Add(KeyID(key), key); err != nil {
+			Error.Fatal().Err(err).Msg("invalid FILE_ENC_KEY")
+		}
+	}
+
+	last := ""
+	for _, entry := range strings.Split(os.Getenv("FILE_ENC_KEYS"), ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		id, b64, ok := strings.Cut(entry, ":")
+		if !ok {
+			Error.Fatal().Msg("FILE_ENC_KEYS entries must look like id:base64key")
+		}
+		key, err := base64.StdEncoding.DecodeString(b64)
+		if err != nil {
+			Error.Fatal().Str("key_id", id).Err(err).Msg("invalid base64 key in FILE_ENC_KEYS")
+		}
+		if err := ring.Add(id, key); err != nil {
+			Error.Fatal().Err(err).Msg("invalid FILE_ENC_KEYS")
+		}
+		last = id
+	}
+
+	active := os.Getenv("FILE_ENC_ACTIVE_KEY")
+	if active == "" {
+		active = last
+	}
+	if active != "" {
+		if err := ring.SetActive(active); err != nil {
+			Error.Fatal().Err(err).Msg("invalid FILE_ENC_ACTIVE_KEY")
+		}
+	}
+	return ring
+}
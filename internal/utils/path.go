@@ -0,0 +1,48 @@
+package utils
+
+import (
+	"errors"
+	"strings"
+	"unicode"
+)
+
+const maxPathLength = 1024
+
+var ErrInvalidPath = errors.New("invalid path")
+
+// NormalizePath validates a slash separated file or folder path and returns it
+// without a leading slash. Empty, "." and ".." segments are rejected rather
+// than resolved, so a path always names exactly what it spells out.
+func NormalizePath(p string) (string, error) {
+	p = strings.TrimPrefix(p, "/")
+	if p == "" || len(p) > maxPathLength {
+		return "", ErrInvalidPath
+	}
+	for _, seg := range strings.Split(p, "/") {
+		if err := ValidateName(seg); err != nil {
+			return "", err
+		}
+	}
+	return p, nil
+}
+
+// ValidateName checks a single path segment such as a folder name.
+func ValidateName(name string) error {
+	if name == "" || name == "." || name == ".." || strings.TrimSpace(name) == "" {
+		return ErrInvalidPath
+	}
+	for _, r := range name {
+		if r == '/' || r == '\\' || unicode.IsControl(r) {
+			return ErrInvalidPath
+		}
+	}
+	return nil
+}
+
+// ParentPath returns the folder part of a normalized path, "" for the root.
+func ParentPath(p string) string {
+	if i := strings.LastIndex(p, "/"); i >= 0 {
+		return p[:i]
+	}
+	return ""
+}
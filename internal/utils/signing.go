@@ -0,0 +1,241 @@
+package utils
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// smallest RSA modulus accepted for signing
+const minRSABits = 2048
+
+var ErrUnsupportedSigningKey = errors.New("signing keys must be Ed25519 or RSA of at least 2048 bits")
+
+// SigningKey is a private key access tokens are signed with, known by the
+// kid it is published under.
+type SigningKey struct {
+	ID     string
+	Method jwt.SigningMethod
+	key    crypto.Signer
+}
+
+// SigningKeys holds the keys access tokens are signed and verified with. Only
+// the active key signs; every key verifies and is published in the JWKS, so a
+// key can be published before it becomes active and kept after it retires
+// until the tokens it signed have expired.
+type SigningKeys struct {
+	keys   []*SigningKey
+	active *SigningKey
+}
+
+func NewSigningKeys() *SigningKeys {
+	return &SigningKeys{}
+}
+
+// Add adds a key under id, signing with EdDSA for Ed25519 keys and RS256 for
+// RSA keys.
+func (r *SigningKeys) Add(id string, key crypto.Signer) error {
+	if id == "" {
+		return errors.New("signing key id is empty")
+	}
+	if _, ok := r.Key(id); ok {
+		return fmt.Errorf("duplicate signing key id %s", id)
+	}
+
+	var method jwt.SigningMethod
+	switch k := key.(type) {
+	case ed25519.PrivateKey:
+		method = jwt.SigningMethodEdDSA
+	case *rsa.PrivateKey:
+		if k.N.BitLen() < minRSABits {
+			return fmt.Errorf("%w: %s", ErrUnsupportedSigningKey, id)
+		}
+		method = jwt.SigningMethodRS256
+	default:
+		return fmt.Errorf("%w: %s", ErrUnsupportedSigningKey, id)
+	}
+	r.keys = append(r.keys, &SigningKey{ID: id, Method: method, key: key})
+	return nil
+}
+
+func (r *SigningKeys) SetActive(id string) error {
+	k, ok := r.Key(id)
+	if !ok {
+		return fmt.Errorf("unknown signing key %s", id)
+	}
+	r.active = k
+	return nil
+}
+
+// Active returns the key new tokens are signed with.
+func (r *SigningKeys) Active() *SigningKey {
+	return r.active
+}
+
+func (r *SigningKeys) Key(id string) (*SigningKey, bool) {
+	for _, k := range r.keys {
+		if k.ID == id {
+			return k, true
+		}
+	}
+	return nil, false
+}
+
+// Algorithms lists the JWT algorithms of the keys, the only ones accepted
+// when verifying.
+func (r *SigningKeys) Algorithms() []string {
+	var algs []string
+	seen := map[string]bool{}
+	for _, k := range r.keys {
+		if alg := k.Method.Alg(); !seen[alg] {
+			seen[alg] = true
+			algs = append(algs, alg)
+		}
+	}
+	return algs
+}
+
+// JWK is the public half of a signing key as published in the JWKS.
+type JWK struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Alg string `json:"alg"`
+	Use string `json:"use"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+}
+
+// JWKS returns the public keys in JSON Web Key Set form.
+func (r *SigningKeys) JWKS() map[string][]JWK {
+	keys := make([]JWK, 0, len(r.keys))
+	for _, k := range r.keys {
+		jwk := JWK{Kid: k.ID, Alg: k.Method.Alg(), Use: "sig"}
+		switch pub := k.key.Public().(type) {
+		case ed25519.PublicKey:
+			jwk.Kty, jwk.Crv = "OKP", "Ed25519"
+			jwk.X = base64.RawURLEncoding.EncodeToString(pub)
+		case *rsa.PublicKey:
+			jwk.Kty = "RSA"
+			jwk.N = base64.RawURLEncoding.EncodeToString(pub.N.Bytes())
+			jwk.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes())
+		}
+		keys = append(keys, jwk)
+	}
+	return map[string][]JWK{"keys": keys}
+}
+
+// GenerateSigningKeys returns a ring holding a single new Ed25519 key, for
+// when no keys are configured.
+func GenerateSigningKeys() (*SigningKeys, error) {
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		return nil, err
+	}
+	ring := NewSigningKeys()
+	kid := hex.EncodeToString(id)
+	if err := ring.Add(kid, priv); err != nil {
+		return nil, err
+	}
+	return ring, ring.SetActive(kid)
+}
+
+// signingKeyfile is the JSON layout read by LoadSigningKeyfile.
+type signingKeyfile struct {
+	Active string `json:"active"`
+	Keys   []struct {
+		ID   string `json:"id"`
+		Path string `json:"path"`
+	} `json:"keys"`
+}
+
+// LoadSigningKeyfile reads signing keys from a JSON file such as
+//
+//	{"active": "2025-06", "keys": [{"id": "2025-01", "path": "jwt-2025-01.pem"}, {"id": "2025-06", "path": "jwt-2025-06.pem"}]}
+//
+// naming PEM encoded private keys (PKCS#8, or PKCS#1 for RSA), relative to the
+// keyfile. The active key defaults to the last one listed.
+func LoadSigningKeyfile(path string) (*SigningKeys, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var kf signingKeyfile
+	if err := json.Unmarshal(raw, &kf); err != nil {
+		return nil, fmt.Errorf("invalid signing keyfile %s: %w", path, err)
+	}
+	if len(kf.Keys) == 0 {
+		return nil, fmt.Errorf("signing keyfile %s has no keys", path)
+	}
+
+	ring := NewSigningKeys()
+	for _, k := range kf.Keys {
+		keyPath := k.Path
+		if !filepath.IsAbs(keyPath) {
+			keyPath = filepath.Join(filepath.Dir(path), keyPath)
+		}
+		key, err := loadPrivateKey(keyPath)
+		if err != nil {
+			return nil, fmt.Errorf("signing key %s: %w", k.ID, err)
+		}
+		if err := ring.Add(k.ID, key); err != nil {
+			return nil, err
+		}
+	}
+
+	active := kf.Active
+	if active == "" {
+		active = kf.Keys[len(kf.Keys)-1].ID
+	}
+	if err := ring.SetActive(active); err != nil {
+		return nil, err
+	}
+	return ring, nil
+}
+
+func loadPrivateKey(path string) (crypto.Signer, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(raw)
+	if block == nil {
+		return nil, fmt.Errorf("no PEM data in %s", path)
+	}
+
+	var key any
+	switch block.Type {
+	case "PRIVATE KEY":
+		key, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	case "RSA PRIVATE KEY":
+		key, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	default:
+		return nil, fmt.Errorf("unsupported PEM block %q in %s", block.Type, path)
+	}
+	if err != nil {
+		return nil, err
+	}
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, ErrUnsupportedSigningKey
+	}
+	return signer, nil
+}
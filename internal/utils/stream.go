@@ -0,0 +1,390 @@
+package utils
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Segmented AES-256-GCM stream format:
+//
+//	header: magic "SSTM" | version (1) | chunk size uint32 BE (4) | nonce prefix (7)
+//	body:   sealed chunks of up to chunk size bytes, each followed by its 16 byte tag
+//
+// Chunk i is sealed with nonce = prefix | uint32 BE i | last flag, so chunks
+// cannot be reordered and a stream cut at a chunk boundary fails to open.
+// Version 2 streams also authenticate header || binding with every chunk, where
+// the binding (see ObjectAAD) names the record the object belongs to; version
+// 1 streams authenticate nothing beyond the chunk. Objects without the magic
+// are the legacy single-shot nonce||ciphertext layout.
+const (
+	streamMagic           = "SSTM"
+	StreamVersion1   byte = 1
+	StreamVersion2   byte = 2
+	StreamChunkSize       = 64 * 1024
+	StreamHeaderSize      = 16
+	streamPrefixSize      = 7
+	streamTagSize         = 16
+	legacyNonceSize       = 12
+)
+
+var (
+	ErrStreamTruncated = errors.New("encrypted stream truncated")
+	ErrStreamHeader    = errors.New("invalid encrypted stream header")
+	ErrUnboundObject   = errors.New("encrypted object is not bound to its file")
+)
+
+// ObjectAAD encodes the binding authenticated by a version 2 stream: the ID
+// of the record the object belongs to, its owner, and its version (or, for
+// upload segments, their offset).
+func ObjectAAD(id, ownerID string, version int64) []byte {
+	b := make([]byte, 0, 2+len(id)+2+len(ownerID)+8)
+	for _, f := range []string{id, ownerID} {
+		b = binary.BigEndian.AppendUint16(b, uint16(len(f)))
+		b = append(b, f...)
+	}
+	return binary.BigEndian.AppendUint64(b, uint64(version))
+}
+
+type StreamHeader struct {
+	Version     byte
+	ChunkSize   uint32
+	NoncePrefix [streamPrefixSize]byte
+}
+
+func (h *StreamHeader) marshal() []byte {
+	b := make([]byte, StreamHeaderSize)
+	copy(b, streamMagic)
+	b[4] = h.Version
+	binary.BigEndian.PutUint32(b[5:9], h.ChunkSize)
+	copy(b[9:], h.NoncePrefix[:])
+	return b
+}
+
+func ParseStreamHeader(b []byte) (*StreamHeader, error) {
+	if !IsStreamHeader(b) {
+		return nil, ErrStreamHeader
+	}
+	h := &StreamHeader{
+		Version:   b[4],
+		ChunkSize: binary.BigEndian.Uint32(b[5:9]),
+	}
+	copy(h.NoncePrefix[:], b[9:StreamHeaderSize])
+	if (h.Version != StreamVersion1 && h.Version != StreamVersion2) || h.ChunkSize == 0 {
+		return nil, ErrStreamHeader
+	}
+	return h, nil
+}
+
+// Bound reports whether the stream authenticates a binding.
+func (h *StreamHeader) Bound() bool {
+	return h.Version >= StreamVersion2
+}
+
+// additionalData returns what every chunk of the stream authenticates besides
+// its own bytes.
+func (h *StreamHeader) additionalData(binding []byte) []byte {
+	if !h.Bound() {
+		return nil
+	}
+	return append(h.marshal(), binding...)
+}
+
+func IsStreamHeader(b []byte) bool {
+	return len(b) >= StreamHeaderSize && string(b[:len(streamMagic)]) == streamMagic
+}
+
+func (h *StreamHeader) nonce(counter uint32, last bool) []byte {
+	n := make([]byte, legacyNonceSize)
+	copy(n, h.NoncePrefix[:])
+	binary.BigEndian.PutUint32(n[streamPrefixSize:], counter)
+	if last {
+		n[legacyNonceSize-1] = 1
+	}
+	return n
+}
+
+// PlainSize returns the plaintext length of a stream that is cipherSize bytes long.
+func (h *StreamHeader) PlainSize(cipherSize int64) int64 {
+	chunks := h.chunkCount(cipherSize)
+	return cipherSize - StreamHeaderSize - chunks*streamTagSize
+}
+
+func (h *StreamHeader) chunkCount(cipherSize int64) int64 {
+	sealed := int64(h.ChunkSize) + streamTagSize
+	chunks := (cipherSize - StreamHeaderSize + sealed - 1) / sealed
+	if chunks < 1 {
+		chunks = 1
+	}
+	return chunks
+}
+
+// ChunkRange maps the plaintext range [offset, offset+length) onto the
+// inclusive ciphertext byte range holding the chunks that cover it. skip is the
+// number of plaintext bytes to drop from the first chunk.
+func (h *StreamHeader) ChunkRange(offset, length, cipherSize int64) (start, end int64, first uint32, skip int64) {
+	chunk := int64(h.ChunkSize)
+	sealed := chunk + streamTagSize
+
+	firstChunk := offset / chunk
+	lastChunk := (offset + length - 1) / chunk
+	if length <= 0 {
+		lastChunk = firstChunk
+	}
+
+	start = StreamHeaderSize + firstChunk*sealed
+	end = StreamHeaderSize + (lastChunk+1)*sealed - 1
+	if end > cipherSize-1 {
+		end = cipherSize - 1
+	}
+	return start, end, uint32(firstChunk), offset - firstChunk*chunk
+}
+
+// EncryptedSize returns the exact stream size for plainSize bytes of input.
+func EncryptedSize(plainSize int64) int64 {
+	chunks := (plainSize + StreamChunkSize - 1) / StreamChunkSize
+	if chunks == 0 {
+		chunks = 1
+	}
+	return StreamHeaderSize + plainSize + chunks*streamTagSize
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	if len(key) != 32 {
+		return nil, fmt.Errorf("invalid key size: expected 32 bytes")
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+type encryptWriter struct {
+	w           io.Writer
+	aead        cipher.AEAD
+	header      StreamHeader
+	ad          []byte
+	buf         []byte
+	out         []byte
+	counter     uint32
+	wroteHeader bool
+	closed      bool
+}
+
+// NewEncryptWriter returns a writer that seals everything written to it into w
+// as a version 2 stream bound to binding (see ObjectAAD). Close must be called
+// to emit the final chunk; it does not close w.
+func NewEncryptWriter(w io.Writer, key, binding []byte) (io.WriteCloser, error) {
+	aead, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	h := StreamHeader{Version: StreamVersion2, ChunkSize: StreamChunkSize}
+	if _, err := io.ReadFull(rand.Reader, h.NoncePrefix[:]); err != nil {
+		return nil, err
+	}
+	return &encryptWriter{
+		w:      w,
+		aead:   aead,
+		header: h,
+		ad:     h.additionalData(binding),
+		buf:    make([]byte, 0, StreamChunkSize),
+		out:    make([]byte, 0, StreamChunkSize+streamTagSize),
+	}, nil
+}
+
+func (e *encryptWriter) Write(p []byte) (int, error) {
+	if e.closed {
+		return 0, errors.New("write to closed encrypt writer")
+	}
+	written := 0
+	for len(p) > 0 {
+		// only seal a full chunk once more data arrives, so the last chunk is
+		// always the one flushed by Close
+		if len(e.buf) == cap(e.buf) {
+			if err := e.flush(false); err != nil {
+				return written, err
+			}
+		}
+		n := copy(e.buf[len(e.buf):cap(e.buf)], p)
+		e.buf = e.buf[:len(e.buf)+n]
+		p = p[n:]
+		written += n
+	}
+	return written, nil
+}
+
+func (e *encryptWriter) Close() error {
+	if e.closed {
+		return nil
+	}
+	e.closed = true
+	return e.flush(true)
+}
+
+func (e *encryptWriter) flush(last bool) error {
+	if !e.wroteHeader {
+		if _, err := e.w.Write(e.header.marshal()); err != nil {
+			return err
+		}
+		e.wroteHeader = true
+	}
+	if e.counter == ^uint32(0) && !last {
+		return errors.New("encrypted stream too large")
+	}
+	e.out = e.aead.Seal(e.out[:0], e.header.nonce(e.counter, last), e.buf, e.ad)
+	if _, err := e.w.Write(e.out); err != nil {
+		return err
+	}
+	e.counter++
+	e.buf = e.buf[:0]
+	return nil
+}
+
+type decryptReader struct {
+	r       *bufio.Reader
+	aead    cipher.AEAD
+	header  *StreamHeader
+	ad      []byte
+	buf     []byte
+	plain   []byte
+	counter uint32
+	final   int64
+	done    bool
+}
+
+// NewDecryptReader reads the header from r and returns a reader yielding the
+// plaintext of an object bound to binding. Objects that carry no binding
+// (version 1 streams and legacy single-shot objects, the latter decrypted in
+// memory) fail with ErrUnboundObject unless allowUnbound is set.
+func NewDecryptReader(r io.Reader, key, binding []byte, allowUnbound bool) (io.Reader, error) {
+	aead, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+
+	br := bufio.NewReader(r)
+	head, err := br.Peek(StreamHeaderSize)
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	if !IsStreamHeader(head) {
+		if !allowUnbound {
+			return nil, ErrUnboundObject
+		}
+		return decryptLegacy(br, key)
+	}
+
+	h, err := ParseStreamHeader(head)
+	if err != nil {
+		return nil, err
+	}
+	if !h.Bound() && !allowUnbound {
+		return nil, ErrUnboundObject
+	}
+	if _, err := br.Discard(StreamHeaderSize); err != nil {
+		return nil, err
+	}
+
+	return &decryptReader{
+		r:      br,
+		aead:   aead,
+		header: h,
+		ad:     h.additionalData(binding),
+		buf:    make([]byte, int(h.ChunkSize)+streamTagSize),
+		final:  -1,
+	}, nil
+}
+
+// NewChunkDecryptReader decrypts a slice of a stream that starts at chunk first,
+// as fetched with the range returned by ChunkRange. cipherSize is the length of
+// the whole object, needed to recognise the final chunk. The caller checks
+// h.Bound() against its policy for unbound objects.
+func NewChunkDecryptReader(r io.Reader, key []byte, h *StreamHeader, binding []byte, first uint32, cipherSize int64) (io.Reader, error) {
+	aead, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	return &decryptReader{
+		r:       bufio.NewReader(r),
+		aead:    aead,
+		header:  h,
+		ad:      h.additionalData(binding),
+		buf:     make([]byte, int(h.ChunkSize)+streamTagSize),
+		counter: first,
+		final:   h.chunkCount(cipherSize) - 1,
+	}, nil
+}
+
+func decryptLegacy(r io.Reader, key []byte) (io.Reader, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < legacyNonceSize {
+		return nil, ErrStreamTruncated
+	}
+	plain, err := Decrypt(data[legacyNonceSize:], data[:legacyNonceSize], key)
+	if err != nil {
+		return nil, err
+	}
+	return bytes.NewReader(plain), nil
+}
+
+func (d *decryptReader) Read(p []byte) (int, error) {
+	for len(d.plain) == 0 {
+		if d.done {
+			return 0, io.EOF
+		}
+		if err := d.next(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, d.plain)
+	d.plain = d.plain[n:]
+	return n, nil
+}
+
+func (d *decryptReader) next() error {
+	n, err := io.ReadFull(d.r, d.buf)
+	last := false
+	switch err {
+	case nil:
+		if d.final >= 0 {
+			break
+		}
+		if _, perr := d.r.Peek(1); perr == io.EOF {
+			last = true
+		} else if perr != nil {
+			return perr
+		}
+	case io.ErrUnexpectedEOF:
+		last = true
+	case io.EOF:
+		return ErrStreamTruncated
+	default:
+		return err
+	}
+	if d.final >= 0 {
+		last = int64(d.counter) == d.final
+	}
+	if n < streamTagSize {
+		return ErrStreamTruncated
+	}
+
+	plain, err := d.aead.Open(d.buf[:0], d.header.nonce(d.counter, last), d.buf[:n], d.ad)
+	if err != nil {
+		return fmt.Errorf("decryption failed: %w", err)
+	}
+	d.plain = plain
+	d.counter++
+	d.done = last
+	return nil
+}
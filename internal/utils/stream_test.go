@@ -0,0 +1,281 @@
+package utils
+
+import (
+	"bytes"
+	"crypto/rand"
+	"errors"
+	"io"
+	"testing"
+)
+
+const chunk = StreamChunkSize
+
+func randomBytes(t *testing.T, n int) []byte {
+	t.Helper()
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+// seal encrypts plain as a version 2 stream bound to binding.
+func seal(t *testing.T, key, binding, plain []byte) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	w, err := NewEncryptWriter(&buf, key, binding)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Write(plain); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// sealVersion1 encrypts plain as a version 1 stream, which binds nothing.
+func sealVersion1(t *testing.T, key, plain []byte) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	w, err := NewEncryptWriter(&buf, key, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	e := w.(*encryptWriter)
+	e.header.Version = StreamVersion1
+	e.ad = e.header.additionalData(nil)
+	if _, err := w.Write(plain); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func open(key, binding, sealed []byte, allowUnbound bool) ([]byte, error) {
+	r, err := NewDecryptReader(bytes.NewReader(sealed), key, binding, allowUnbound)
+	if err != nil {
+		return nil, err
+	}
+	return io.ReadAll(r)
+}
+
+func TestStreamRoundTrip(t *testing.T) {
+	key := randomBytes(t, 32)
+	binding := ObjectAAD("file-1", "user-1", 1)
+
+	for _, size := range []int{0, 1, chunk - 1, chunk, chunk + 1, 2*chunk - 1, 2 * chunk, 2*chunk + 1, 3 * chunk} {
+		plain := randomBytes(t, size)
+		sealed := seal(t, key, binding, plain)
+
+		if got := EncryptedSize(int64(size)); got != int64(len(sealed)) {
+			t.Errorf("size %d: EncryptedSize = %d, stream is %d bytes", size, got, len(sealed))
+		}
+		h, err := ParseStreamHeader(sealed)
+		if err != nil {
+			t.Fatalf("size %d: %v", size, err)
+		}
+		if h.Version != StreamVersion2 || h.ChunkSize != chunk || !h.Bound() {
+			t.Errorf("size %d: header %+v", size, h)
+		}
+		if got := h.PlainSize(int64(len(sealed))); got != int64(size) {
+			t.Errorf("size %d: PlainSize = %d", size, got)
+		}
+
+		got, err := open(key, binding, sealed, false)
+		if err != nil {
+			t.Fatalf("size %d: %v", size, err)
+		}
+		if !bytes.Equal(got, plain) {
+			t.Errorf("size %d: plaintext differs", size)
+		}
+	}
+}
+
+func TestStreamTampering(t *testing.T) {
+	key := randomBytes(t, 32)
+	binding := ObjectAAD("file-1", "user-1", 1)
+	plain := randomBytes(t, 3*chunk)
+	sealed := seal(t, key, binding, plain)
+	sealedChunk := chunk + streamTagSize
+
+	swapped := bytes.Clone(sealed)
+	first := swapped[StreamHeaderSize : StreamHeaderSize+sealedChunk]
+	second := swapped[StreamHeaderSize+sealedChunk : StreamHeaderSize+2*sealedChunk]
+	tmp := bytes.Clone(first)
+	copy(first, second)
+	copy(second, tmp)
+
+	flipped := bytes.Clone(sealed)
+	flipped[StreamHeaderSize+10] ^= 1
+
+	header := bytes.Clone(sealed)
+	header[StreamHeaderSize-1] ^= 1 // nonce prefix
+
+	for _, tc := range []struct {
+		name   string
+		stream []byte
+	}{
+		{"truncated at a chunk boundary", sealed[:StreamHeaderSize+sealedChunk]},
+		{"truncated to two chunks", sealed[:StreamHeaderSize+2*sealedChunk]},
+		{"truncated mid chunk", sealed[:StreamHeaderSize+sealedChunk+100]},
+		{"truncated to the header", sealed[:StreamHeaderSize]},
+		{"final chunk cut to a partial tag", sealed[:StreamHeaderSize+2*sealedChunk+streamTagSize-1]},
+		{"chunks swapped", swapped},
+		{"byte flipped", flipped},
+		{"header changed", header},
+		{"trailing bytes", append(bytes.Clone(sealed), 0)},
+	} {
+		if _, err := open(key, binding, tc.stream, false); err == nil {
+			t.Errorf("%s: opened", tc.name)
+		}
+	}
+
+	if _, err := open(randomBytes(t, 32), binding, sealed, false); err == nil {
+		t.Error("opened with another key")
+	}
+}
+
+func TestStreamBinding(t *testing.T) {
+	key := randomBytes(t, 32)
+	plain := randomBytes(t, chunk+1)
+	sealed := seal(t, key, ObjectAAD("file-1", "user-1", 2), plain)
+
+	for _, tc := range []struct {
+		name    string
+		binding []byte
+	}{
+		{"other file", ObjectAAD("file-2", "user-1", 2)},
+		{"other owner", ObjectAAD("file-1", "user-2", 2)},
+		{"other version", ObjectAAD("file-1", "user-1", 1)},
+		{"none", nil},
+	} {
+		// the binding is only checked once a chunk is opened
+		if _, err := open(key, tc.binding, sealed, true); err == nil {
+			t.Errorf("%s: opened", tc.name)
+		}
+	}
+
+	// objects that bind nothing are refused unless allowed
+	v1 := sealVersion1(t, key, plain)
+	if _, err := open(key, ObjectAAD("file-1", "user-1", 2), v1, false); !errors.Is(err, ErrUnboundObject) {
+		t.Errorf("version 1 stream = %v, want ErrUnboundObject", err)
+	}
+	if got, err := open(key, ObjectAAD("file-1", "user-1", 2), v1, true); err != nil || !bytes.Equal(got, plain) {
+		t.Errorf("allowed version 1 stream = %v", err)
+	}
+
+	ciphertext, nonce, err := Encrypt(plain, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	legacy := append(nonce, ciphertext...)
+	if _, err := open(key, nil, legacy, false); !errors.Is(err, ErrUnboundObject) {
+		t.Errorf("legacy object = %v, want ErrUnboundObject", err)
+	}
+	if got, err := open(key, nil, legacy, true); err != nil || !bytes.Equal(got, plain) {
+		t.Errorf("allowed legacy object = %v", err)
+	}
+}
+
+func TestStreamHeaderRejects(t *testing.T) {
+	valid := (&StreamHeader{Version: StreamVersion2, ChunkSize: chunk}).marshal()
+	for _, tc := range []struct {
+		name   string
+		header func([]byte)
+	}{
+		{"bad magic", func(b []byte) { b[0] = 'X' }},
+		{"unknown version", func(b []byte) { b[4] = 3 }},
+		{"version 0", func(b []byte) { b[4] = 0 }},
+		{"zero chunk size", func(b []byte) { copy(b[5:9], []byte{0, 0, 0, 0}) }},
+	} {
+		b := bytes.Clone(valid)
+		tc.header(b)
+		if _, err := ParseStreamHeader(b); !errors.Is(err, ErrStreamHeader) {
+			t.Errorf("%s: %v, want ErrStreamHeader", tc.name, err)
+		}
+	}
+	if _, err := ParseStreamHeader(valid[:StreamHeaderSize-1]); !errors.Is(err, ErrStreamHeader) {
+		t.Errorf("short header: %v, want ErrStreamHeader", err)
+	}
+}
+
+func TestChunkRange(t *testing.T) {
+	key := randomBytes(t, 32)
+	binding := ObjectAAD("file-1", "user-1", 1)
+
+	for _, size := range []int{2*chunk + 100, 2 * chunk} {
+		plain := randomBytes(t, size)
+		sealed := seal(t, key, binding, plain)
+		cipherSize := int64(len(sealed))
+		h, err := ParseStreamHeader(sealed)
+		if err != nil {
+			t.Fatal(err)
+		}
+		n := int64(size)
+
+		for _, tc := range []struct {
+			name           string
+			offset, length int64
+			first          uint32
+		}{
+			{"start", 0, 10, 0},
+			{"first chunk", 0, chunk, 0},
+			{"across a boundary", chunk - 5, 10, 0},
+			{"second chunk", chunk, chunk, 1},
+			{"into the final chunk", chunk + 1, n - chunk - 1, 1},
+			{"final chunk", 2*chunk - 1, n - 2*chunk + 1, 1},
+			{"last byte", n - 1, 1, uint32((n - 1) / chunk)},
+			{"everything", 0, n, 0},
+		} {
+			start, end, first, skip := h.ChunkRange(tc.offset, tc.length, cipherSize)
+			if first != tc.first {
+				t.Errorf("size %d, %s: first chunk %d, want %d", size, tc.name, first, tc.first)
+			}
+			if start < StreamHeaderSize || end >= cipherSize || start > end {
+				t.Fatalf("size %d, %s: range %d-%d of %d", size, tc.name, start, end, cipherSize)
+			}
+
+			// read as FileService does: the range ends with the chunk
+			// holding the last byte wanted, so nothing past it is read
+			r, err := NewChunkDecryptReader(bytes.NewReader(sealed[start:end+1]), key, h, binding, first, cipherSize)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := io.CopyN(io.Discard, r, skip); err != nil {
+				t.Fatalf("size %d, %s: %v", size, tc.name, err)
+			}
+			got, err := io.ReadAll(io.LimitReader(r, tc.length))
+			if err != nil {
+				t.Fatalf("size %d, %s: %v", size, tc.name, err)
+			}
+			if !bytes.Equal(got, plain[tc.offset:tc.offset+tc.length]) {
+				t.Errorf("size %d, %s: plaintext differs", size, tc.name)
+			}
+		}
+
+		// a range of the wrong object, or fetched from the wrong chunk,
+		// does not open
+		start, end, first, _ := h.ChunkRange(chunk, 10, cipherSize)
+		for name, open := range map[string]func() (io.Reader, error){
+			"wrong binding": func() (io.Reader, error) {
+				return NewChunkDecryptReader(bytes.NewReader(sealed[start:end+1]), key, h, ObjectAAD("file-2", "user-1", 1), first, cipherSize)
+			},
+			"wrong chunk": func() (io.Reader, error) {
+				return NewChunkDecryptReader(bytes.NewReader(sealed[start:end+1]), key, h, binding, first+1, cipherSize)
+			},
+		} {
+			r, err := open()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := io.ReadAll(io.LimitReader(r, 10)); err == nil {
+				t.Errorf("size %d, %s: opened", size, name)
+			}
+		}
+	}
+}
@@ -0,0 +1,22 @@
+CREATE TABLE multipart_uploads (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    file_id UUID NOT NULL REFERENCES files(id) ON DELETE CASCADE,
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    upload_id TEXT NOT NULL,
+    storage_key TEXT NOT NULL,
+    size BIGINT NOT NULL,
+    part_size BIGINT NOT NULL,
+    created_at TIMESTAMP DEFAULT NOW(),
+    updated_at TIMESTAMP DEFAULT NOW()
+);
+
+CREATE INDEX idx_multipart_uploads_updated_at ON multipart_uploads(updated_at);
+
+CREATE TABLE multipart_parts (
+    upload_id UUID REFERENCES multipart_uploads(id) ON DELETE CASCADE,
+    part_number INT NOT NULL,
+    etag TEXT NOT NULL,
+    size BIGINT NOT NULL,
+    uploaded_at TIMESTAMP DEFAULT NOW(),
+    PRIMARY KEY (upload_id, part_number)
+);
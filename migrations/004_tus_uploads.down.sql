@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS tus_segments;
+DROP TABLE IF EXISTS tus_uploads;
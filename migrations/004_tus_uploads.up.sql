@@ -0,0 +1,23 @@
+CREATE TABLE tus_uploads (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    file_path TEXT NOT NULL,
+    upload_length BIGINT NOT NULL,
+    upload_offset BIGINT NOT NULL DEFAULT 0,
+    metadata TEXT NOT NULL DEFAULT '',
+    is_encrypted BOOLEAN NOT NULL DEFAULT FALSE,
+    file_id UUID REFERENCES files(id) ON DELETE SET NULL,
+    expires_at TIMESTAMP NOT NULL,
+    created_at TIMESTAMP DEFAULT NOW(),
+    updated_at TIMESTAMP DEFAULT NOW()
+);
+
+CREATE INDEX idx_tus_uploads_expires_at ON tus_uploads(expires_at);
+
+CREATE TABLE tus_segments (
+    upload_id UUID REFERENCES tus_uploads(id) ON DELETE CASCADE,
+    segment_offset BIGINT NOT NULL,
+    size BIGINT NOT NULL,
+    storage_key TEXT NOT NULL,
+    PRIMARY KEY (upload_id, segment_offset)
+);
@@ -0,0 +1,8 @@
+ALTER TABLE files
+DROP COLUMN checksum_crc32c;
+
+ALTER TABLE files
+DROP COLUMN checksum_sha256;
+
+ALTER TABLE files
+DROP COLUMN content_type;
@@ -0,0 +1,8 @@
+ALTER TABLE files
+ADD COLUMN content_type TEXT NOT NULL DEFAULT '';
+
+ALTER TABLE files
+ADD COLUMN checksum_sha256 TEXT NOT NULL DEFAULT '';
+
+ALTER TABLE files
+ADD COLUMN checksum_crc32c TEXT NOT NULL DEFAULT '';
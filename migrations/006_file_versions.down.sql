@@ -0,0 +1,7 @@
+ALTER TABLE multipart_uploads
+DROP COLUMN version;
+
+ALTER TABLE files
+DROP COLUMN current_version;
+
+DROP TABLE IF EXISTS file_versions;
@@ -0,0 +1,30 @@
+CREATE TABLE file_versions (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    file_id UUID NOT NULL REFERENCES files(id) ON DELETE CASCADE,
+    version INT NOT NULL,
+    storage_key TEXT NOT NULL,
+    size BIGINT,
+    is_encrypted BOOLEAN NOT NULL DEFAULT FALSE,
+    content_type TEXT NOT NULL DEFAULT '',
+    checksum_sha256 TEXT NOT NULL DEFAULT '',
+    checksum_crc32c TEXT NOT NULL DEFAULT '',
+    status VARCHAR(20) NOT NULL DEFAULT 'pending',
+    created_at TIMESTAMP DEFAULT NOW(),
+    uploaded_at TIMESTAMP,
+    UNIQUE(file_id, version)
+);
+
+CREATE INDEX idx_file_versions_created_at ON file_versions(created_at);
+
+ALTER TABLE files
+ADD COLUMN current_version INT NOT NULL DEFAULT 1;
+
+-- every existing file becomes version 1 of itself
+INSERT INTO file_versions (file_id, version, storage_key, size, is_encrypted, content_type,
+                           checksum_sha256, checksum_crc32c, status, created_at, uploaded_at)
+SELECT id, 1, storage_key, size, is_encrypted, content_type, checksum_sha256, checksum_crc32c,
+       CASE WHEN status = 'uploaded' THEN 'uploaded' ELSE 'pending' END, created_at, uploaded_at
+FROM files;
+
+ALTER TABLE multipart_uploads
+ADD COLUMN version INT NOT NULL DEFAULT 1;
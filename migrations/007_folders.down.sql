@@ -0,0 +1,4 @@
+ALTER TABLE files
+DROP COLUMN folder_id;
+
+DROP TABLE IF EXISTS folders;
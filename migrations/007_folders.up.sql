@@ -0,0 +1,34 @@
+CREATE TABLE folders (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    parent_id UUID REFERENCES folders(id) ON DELETE CASCADE,
+    name TEXT NOT NULL,
+    path TEXT NOT NULL,
+    created_at TIMESTAMP DEFAULT NOW(),
+    updated_at TIMESTAMP DEFAULT NOW(),
+    UNIQUE(user_id, path)
+);
+
+CREATE INDEX idx_folders_parent_id ON folders(parent_id);
+
+ALTER TABLE files
+ADD COLUMN folder_id UUID REFERENCES folders(id) ON DELETE SET NULL;
+
+CREATE INDEX idx_files_folder_id ON files(folder_id);
+
+-- create a folder for every directory of existing well-formed file paths
+INSERT INTO folders (user_id, name, path)
+SELECT DISTINCT f.user_id, p.parts[n], array_to_string(p.parts[1:n], '/')
+FROM files f
+CROSS JOIN LATERAL (SELECT string_to_array(f.file_path, '/') AS parts) p
+CROSS JOIN LATERAL generate_series(1, array_length(p.parts, 1) - 1) AS n
+WHERE f.file_path ~ '^[^/]+(/[^/]+)+$'
+ON CONFLICT DO NOTHING;
+
+UPDATE folders c SET parent_id = p.id
+FROM folders p
+WHERE p.user_id = c.user_id AND c.path LIKE '%/%' AND p.path = regexp_replace(c.path, '/[^/]*$', '');
+
+UPDATE files f SET folder_id = d.id
+FROM folders d
+WHERE d.user_id = f.user_id AND f.file_path LIKE '%/%' AND d.path = regexp_replace(f.file_path, '/[^/]*$', '');
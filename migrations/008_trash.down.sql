@@ -0,0 +1,4 @@
+UPDATE files SET status = 'deleting' WHERE status = 'trashed';
+
+ALTER TABLE files
+DROP COLUMN trashed_at;
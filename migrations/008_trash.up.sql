@@ -0,0 +1,4 @@
+ALTER TABLE files
+ADD COLUMN trashed_at TIMESTAMP;
+
+CREATE INDEX idx_files_trashed_at ON files(trashed_at) WHERE status = 'trashed';
@@ -0,0 +1,5 @@
+ALTER TABLE file_versions
+DROP COLUMN object_size;
+
+DROP TABLE IF EXISTS user_quotas;
+DROP TABLE IF EXISTS quota_settings;
@@ -0,0 +1,26 @@
+-- single row holding the quota for users without their own
+CREATE TABLE quota_settings (
+    id BOOLEAN PRIMARY KEY DEFAULT TRUE CHECK (id),
+    default_quota_bytes BIGINT NOT NULL
+);
+
+INSERT INTO quota_settings (default_quota_bytes) VALUES (10737418240);
+
+CREATE TABLE user_quotas (
+    user_id UUID PRIMARY KEY REFERENCES users(id) ON DELETE CASCADE,
+    quota_bytes BIGINT,
+    used_bytes BIGINT NOT NULL DEFAULT 0,
+    updated_at TIMESTAMP DEFAULT NOW()
+);
+
+-- bytes each version occupies in storage, the unit usage is counted in
+ALTER TABLE file_versions
+ADD COLUMN object_size BIGINT NOT NULL DEFAULT 0;
+
+UPDATE file_versions SET object_size = COALESCE(size, 0);
+
+INSERT INTO user_quotas (user_id, used_bytes)
+SELECT f.user_id, SUM(v.object_size)
+FROM files f JOIN file_versions v ON v.file_id = f.id
+WHERE f.user_id IS NOT NULL
+GROUP BY f.user_id;
@@ -0,0 +1,14 @@
+ALTER TABLE tus_uploads
+DROP COLUMN wrapped_key,
+DROP COLUMN key_id,
+DROP COLUMN key_algorithm;
+
+ALTER TABLE file_versions
+DROP COLUMN wrapped_key,
+DROP COLUMN key_id,
+DROP COLUMN key_algorithm;
+
+ALTER TABLE files
+DROP COLUMN wrapped_key,
+DROP COLUMN key_id,
+DROP COLUMN key_algorithm;
@@ -0,0 +1,16 @@
+-- per-object data keys, wrapped by a key encryption key; an empty key_id
+-- marks objects sealed directly with the master key
+ALTER TABLE files
+ADD COLUMN wrapped_key BYTEA,
+ADD COLUMN key_id TEXT NOT NULL DEFAULT '',
+ADD COLUMN key_algorithm TEXT NOT NULL DEFAULT '';
+
+ALTER TABLE file_versions
+ADD COLUMN wrapped_key BYTEA,
+ADD COLUMN key_id TEXT NOT NULL DEFAULT '',
+ADD COLUMN key_algorithm TEXT NOT NULL DEFAULT '';
+
+ALTER TABLE tus_uploads
+ADD COLUMN wrapped_key BYTEA,
+ADD COLUMN key_id TEXT NOT NULL DEFAULT '',
+ADD COLUMN key_algorithm TEXT NOT NULL DEFAULT '';
@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS idx_file_versions_key_id;
+DROP TABLE IF EXISTS key_rotations;
@@ -0,0 +1,17 @@
+CREATE TABLE key_rotations (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    from_key_id TEXT NOT NULL,          -- '' rotates legacy objects
+    to_key_id TEXT NOT NULL,
+    status TEXT NOT NULL DEFAULT 'running', -- running, completed, failed
+    total BIGINT NOT NULL DEFAULT 0,
+    processed BIGINT NOT NULL DEFAULT 0,
+    failed BIGINT NOT NULL DEFAULT 0,
+    cursor UUID,                        -- last file version handled
+    error TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMP DEFAULT NOW(),
+    updated_at TIMESTAMP DEFAULT NOW(),
+    completed_at TIMESTAMP
+);
+
+CREATE INDEX idx_key_rotations_status ON key_rotations(status);
+CREATE INDEX idx_file_versions_key_id ON file_versions(key_id) WHERE is_encrypted;
@@ -0,0 +1,5 @@
+ALTER TABLE users
+DROP COLUMN IF EXISTS deleted_at;
+
+DROP INDEX IF EXISTS idx_user_keys_key_id;
+DROP TABLE IF EXISTS user_keys;
@@ -0,0 +1,21 @@
+-- each user's key encryption key, wrapped by the master key (key_id). When
+-- passphrase_salt is set the key is first sealed under an Argon2id key
+-- derived from the user's passphrase with the kdf_* parameters.
+CREATE TABLE user_keys (
+    user_id UUID PRIMARY KEY REFERENCES users(id) ON DELETE CASCADE,
+    wrapped_key BYTEA NOT NULL,
+    key_id TEXT NOT NULL,
+    key_algorithm TEXT NOT NULL,
+    passphrase_salt BYTEA,
+    kdf_time INT NOT NULL DEFAULT 0,
+    kdf_memory INT NOT NULL DEFAULT 0,
+    kdf_threads SMALLINT NOT NULL DEFAULT 0,
+    created_at TIMESTAMP DEFAULT NOW(),
+    updated_at TIMESTAMP DEFAULT NOW()
+);
+
+CREATE INDEX idx_user_keys_key_id ON user_keys(key_id);
+
+-- deleted users keep their row until the cleanup job has removed their files
+ALTER TABLE users
+ADD COLUMN deleted_at TIMESTAMP;
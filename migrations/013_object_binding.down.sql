@@ -0,0 +1,4 @@
+DROP INDEX IF EXISTS idx_file_versions_unbound;
+
+ALTER TABLE file_versions
+DROP COLUMN IF EXISTS aad_bound;
@@ -0,0 +1,12 @@
+-- encrypted objects written from now on authenticate their file ID, owner and
+-- version; existing ones are marked unbound until the binding job rewrites
+-- them. Unencrypted versions have nothing to bind and count as bound.
+ALTER TABLE file_versions
+ADD COLUMN aad_bound BOOLEAN NOT NULL DEFAULT FALSE;
+
+UPDATE file_versions SET aad_bound = TRUE WHERE NOT is_encrypted;
+
+ALTER TABLE file_versions
+ALTER COLUMN aad_bound SET DEFAULT TRUE;
+
+CREATE INDEX idx_file_versions_unbound ON file_versions(id) WHERE is_encrypted AND NOT aad_bound;
@@ -0,0 +1,11 @@
+ALTER TABLE file_versions
+DROP COLUMN IF EXISTS encryption_mode,
+DROP COLUMN IF EXISTS client_wrapped_key,
+DROP COLUMN IF EXISTS client_iv,
+DROP COLUMN IF EXISTS client_algorithm;
+
+ALTER TABLE files
+DROP COLUMN IF EXISTS encryption_mode,
+DROP COLUMN IF EXISTS client_wrapped_key,
+DROP COLUMN IF EXISTS client_iv,
+DROP COLUMN IF EXISTS client_algorithm;
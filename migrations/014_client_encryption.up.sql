@@ -0,0 +1,16 @@
+-- none, server or client. Client encrypted objects are ciphertext the server
+-- cannot open; client_* hold the client's wrapped file key, IV and algorithm.
+ALTER TABLE files
+ADD COLUMN encryption_mode TEXT NOT NULL DEFAULT 'none' CHECK (encryption_mode IN ('none', 'server', 'client')),
+ADD COLUMN client_wrapped_key TEXT NOT NULL DEFAULT '',
+ADD COLUMN client_iv TEXT NOT NULL DEFAULT '',
+ADD COLUMN client_algorithm TEXT NOT NULL DEFAULT '';
+
+ALTER TABLE file_versions
+ADD COLUMN encryption_mode TEXT NOT NULL DEFAULT 'none' CHECK (encryption_mode IN ('none', 'server', 'client')),
+ADD COLUMN client_wrapped_key TEXT NOT NULL DEFAULT '',
+ADD COLUMN client_iv TEXT NOT NULL DEFAULT '',
+ADD COLUMN client_algorithm TEXT NOT NULL DEFAULT '';
+
+UPDATE files SET encryption_mode = 'server' WHERE is_encrypted;
+UPDATE file_versions SET encryption_mode = 'server' WHERE is_encrypted;
@@ -0,0 +1,4 @@
+-- set once a user's file, folder and tus upload names have been encrypted
+-- with NAME_ENC_KEY, so the conversion at startup skips them
+ALTER TABLE users
+ADD COLUMN names_sealed BOOLEAN NOT NULL DEFAULT FALSE;
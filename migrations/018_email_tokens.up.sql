@@ -0,0 +1,19 @@
+-- set once the user follows the link mailed to their address; accounts from
+-- before verification existed start unverified
+ALTER TABLE users
+ADD COLUMN email_verified_at TIMESTAMP;
+
+-- single-use password reset and email verification tokens, stored as
+-- SHA-256 hashes
+CREATE TABLE user_tokens (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    purpose TEXT NOT NULL CHECK (purpose IN ('password_reset', 'email_verify')),
+    token_hash BYTEA NOT NULL UNIQUE,
+    expires_at TIMESTAMP NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    used_at TIMESTAMP
+);
+
+CREATE INDEX idx_user_tokens_user_id ON user_tokens(user_id, purpose);
+CREATE INDEX idx_user_tokens_expires_at ON user_tokens(expires_at);
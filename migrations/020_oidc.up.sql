@@ -0,0 +1,26 @@
+-- pending OpenID Connect logins, keyed by a SHA-256 hash of the state sent
+-- to the provider; each is consumed by its callback
+CREATE TABLE oidc_states (
+    state_hash BYTEA PRIMARY KEY,
+    provider TEXT NOT NULL,
+    nonce TEXT NOT NULL,
+    code_verifier TEXT NOT NULL,
+    expires_at TIMESTAMP NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX idx_oidc_states_expires_at ON oidc_states(expires_at);
+
+-- accounts at identity providers that sign in as a user, by the provider's
+-- subject identifier
+CREATE TABLE user_identities (
+    provider TEXT NOT NULL,
+    subject TEXT NOT NULL,
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    email TEXT NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    last_login_at TIMESTAMP,
+    PRIMARY KEY (provider, subject)
+);
+
+CREATE INDEX idx_user_identities_user_id ON user_identities(user_id);
@@ -0,0 +1,10 @@
+-- what a user may do beyond their own data: admins manage users, files and
+-- share links across the system, auditors may only look
+ALTER TABLE users
+ADD COLUMN role TEXT NOT NULL DEFAULT 'user' CHECK (role IN ('user', 'admin', 'auditor'));
+
+-- suspended users cannot sign in or use their tokens until unsuspended
+ALTER TABLE users
+ADD COLUMN suspended_at TIMESTAMP;
+
+CREATE INDEX idx_users_suspended_at ON users(suspended_at) WHERE suspended_at IS NOT NULL;
@@ -0,0 +1,5 @@
+-- an empty hash matches no password
+UPDATE users SET password_hash = '' WHERE password_hash IS NULL;
+
+ALTER TABLE users
+ALTER COLUMN password_hash SET NOT NULL;
@@ -0,0 +1,6 @@
+-- a login's state is only good in the browser that started it, which holds
+-- the binding in a cookie; logins begun before this have none and are dropped
+DELETE FROM oidc_states;
+
+ALTER TABLE oidc_states
+ADD COLUMN binding_hash BYTEA NOT NULL;
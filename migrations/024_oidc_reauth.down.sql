@@ -0,0 +1,5 @@
+ALTER TABLE users
+DROP COLUMN IF EXISTS reauthenticated_at;
+
+ALTER TABLE oidc_states
+DROP COLUMN IF EXISTS reauth_user_id;
@@ -0,0 +1,9 @@
+-- users without a password prove it is still them before deleting their
+-- account or turning off MFA by signing in again at their identity provider;
+-- such a login's state names the user who started it
+ALTER TABLE oidc_states
+ADD COLUMN reauth_user_id UUID REFERENCES users(id) ON DELETE CASCADE;
+
+-- when the user last did so; each sign-in allows one such action
+ALTER TABLE users
+ADD COLUMN reauthenticated_at TIMESTAMP;